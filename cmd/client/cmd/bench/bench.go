@@ -0,0 +1,241 @@
+package bench
+
+import (
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"custom-in-memory-db/cmd/client/shared"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	distUniform = "uniform"
+	distZipf    = "zipf"
+)
+
+// valueChars are accepted by the server parser
+const valueChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+type options struct {
+	proto       string
+	concurrency int
+	readRatio   float64
+	keys        int
+	valueSize   int
+	dist        string
+	zipfS       float64
+	duration    time.Duration
+	ops         int64
+	output      string
+	prefill     bool
+}
+
+func Init(cfg *conf.Config) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "bench",
+		Short: "Runs a load test against the database",
+		Long: "Runs a mixed GET/SET workload and reports throughput and latency percentiles.\n" +
+			"Runs for --duration unless --ops is set",
+		Args:          cobra.NoArgs,
+		RunE:          run,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	cmd.PersistentFlags().DurationVarP(&cfg.Timeout, "timeout", "t", 1*time.Second, "connection timeout")
+	cmd.Flags().IntP("concurrency", "c", runtime.NumCPU(), "number of concurrent clients")
+	cmd.Flags().Float64("read-ratio", 0.8, "share of GET commands, from 0 to 1")
+	cmd.Flags().Int("keys", 1000, "key space size")
+	cmd.Flags().Int("value-size", 16, "value size in bytes")
+	cmd.Flags().String("dist", distUniform, "key distribution, uniform or zipf")
+	cmd.Flags().Float64("zipf-s", 1.1, "zipf distribution skew, must be greater than 1")
+	cmd.Flags().DurationP("duration", "d", 10*time.Second, "benchmark duration")
+	cmd.Flags().Int64P("ops", "n", 0, "total number of operations, overrides --duration if set")
+	cmd.Flags().StringP("output", "o", "text", "report format, text or json")
+	cmd.Flags().Bool("prefill", true, "set every key once before the benchmark so reads do not miss")
+
+	return cmd
+}
+
+func run(cmd *cobra.Command, _ []string) error {
+	cfg := conf.FromFlags(cmd)
+	opts, err := parseOptions(cmd)
+	if err != nil {
+		return err
+	}
+
+	clients, err := shared.NewClients(cfg, opts.concurrency)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, c := range clients {
+			_ = c.Close()
+		}
+	}()
+
+	if opts.prefill {
+		if err = prefill(clients, opts); err != nil {
+			return fmt.Errorf("prefill failed: %w", err)
+		}
+	}
+
+	start := time.Now()
+	read, write := workload(clients, opts)
+	r := newReport(opts, time.Since(start), read, write)
+
+	if opts.output == "json" {
+		return r.writeJson(os.Stdout)
+	}
+	return r.writeText(os.Stdout)
+}
+
+func parseOptions(cmd *cobra.Command) (options, error) {
+	var opts options
+	opts.proto, _ = cmd.Flags().GetString("proto")
+	opts.concurrency, _ = cmd.Flags().GetInt("concurrency")
+	opts.readRatio, _ = cmd.Flags().GetFloat64("read-ratio")
+	opts.keys, _ = cmd.Flags().GetInt("keys")
+	opts.valueSize, _ = cmd.Flags().GetInt("value-size")
+	opts.dist, _ = cmd.Flags().GetString("dist")
+	opts.zipfS, _ = cmd.Flags().GetFloat64("zipf-s")
+	opts.duration, _ = cmd.Flags().GetDuration("duration")
+	opts.ops, _ = cmd.Flags().GetInt64("ops")
+	opts.output, _ = cmd.Flags().GetString("output")
+	opts.prefill, _ = cmd.Flags().GetBool("prefill")
+
+	switch {
+	case opts.concurrency < 1:
+		return options{}, errors.New("--concurrency expected to be greater than 0")
+	case opts.readRatio < 0 || opts.readRatio > 1:
+		return options{}, errors.New("--read-ratio expected to be from 0 to 1")
+	case opts.keys < 1:
+		return options{}, errors.New("--keys expected to be greater than 0")
+	case opts.valueSize < 1:
+		return options{}, errors.New("--value-size expected to be greater than 0")
+	case opts.dist != distUniform && opts.dist != distZipf:
+		return options{}, errors.New("--dist expected to be uniform or zipf")
+	case opts.dist == distZipf && opts.zipfS <= 1:
+		return options{}, errors.New("--zipf-s expected to be greater than 1")
+	case opts.ops < 0:
+		return options{}, errors.New("--ops expected to be positive")
+	case opts.ops == 0 && opts.duration <= 0:
+		return options{}, errors.New("--duration expected to be positive")
+	case opts.output != "text" && opts.output != "json":
+		return options{}, errors.New("--output expected to be text or json")
+	}
+
+	return opts, nil
+}
+
+// prefill sets every key in the key space once. Each worker uses its own client
+func prefill(clients []*shared.Client, opts options) error {
+	var next atomic.Int64
+	var firstErr atomic.Value
+	var wg sync.WaitGroup
+	for w := 0; w < opts.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rnd := rand.New(rand.NewPCG(uint64(w), uint64(time.Now().UnixNano())))
+			for {
+				k := next.Add(1) - 1
+				if k >= int64(opts.keys) {
+					return
+				}
+				if _, err := clients[w].Exec(setCmd(k, value(rnd, opts.valueSize))); err != nil {
+					firstErr.CompareAndSwap(nil, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err, ok := firstErr.Load().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// workload runs the benchmark and returns stats per operation type. Each worker uses its own client
+func workload(clients []*shared.Client, opts options) (*opStats, *opStats) {
+	deadline := time.Now().Add(opts.duration)
+	var issued atomic.Int64
+	done := func() bool {
+		if opts.ops > 0 {
+			return issued.Add(1) > opts.ops
+		}
+		return time.Now().After(deadline)
+	}
+
+	reads := make([]opStats, opts.concurrency)
+	writes := make([]opStats, opts.concurrency)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rnd := rand.New(rand.NewPCG(uint64(w), uint64(time.Now().UnixNano())))
+			nextKey := keyGen(rnd, opts)
+			for !done() {
+				k := nextKey()
+				if rnd.Float64() < opts.readRatio {
+					start := time.Now()
+					_, err := clients[w].Exec(getCmd(k))
+					reads[w].add(time.Since(start), err)
+					continue
+				}
+				c := setCmd(k, value(rnd, opts.valueSize))
+				start := time.Now()
+				_, err := clients[w].Exec(c)
+				writes[w].add(time.Since(start), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var read, write opStats
+	for w := range reads {
+		read.merge(&reads[w])
+		write.merge(&writes[w])
+	}
+	return &read, &write
+}
+
+// keyGen returns key index generator according to opts.dist
+func keyGen(rnd *rand.Rand, opts options) func() int64 {
+	if opts.dist == distZipf {
+		z := rand.NewZipf(rnd, opts.zipfS, 1, uint64(opts.keys-1))
+		return func() int64 { return int64(z.Uint64()) }
+	}
+	return func() int64 { return rnd.Int64N(int64(opts.keys)) }
+}
+
+func key(k int64) string {
+	return "key" + strconv.FormatInt(k, 10)
+}
+
+func getCmd(k int64) string {
+	return strings.Join([]string{"GET", key(k)}, " ") + "\n"
+}
+
+func setCmd(k int64, v string) string {
+	return strings.Join([]string{"SET", key(k), v}, " ") + "\n"
+}
+
+func value(rnd *rand.Rand, size int) string {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = valueChars[rnd.IntN(len(valueChars))]
+	}
+	return string(b)
+}
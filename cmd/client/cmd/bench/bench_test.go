@@ -0,0 +1,260 @@
+package bench
+
+import (
+	"bufio"
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"custom-in-memory-db/cmd/client/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func ms(v ...int) []time.Duration {
+	result := make([]time.Duration, len(v))
+	for i := range v {
+		result[i] = time.Duration(v[i]) * time.Millisecond
+	}
+	return result
+}
+
+func TestPercentile(t *testing.T) {
+	hundred := make([]time.Duration, 100)
+	for i := range hundred {
+		hundred[i] = time.Duration(i+1) * time.Millisecond
+	}
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		p      int
+		want   time.Duration
+	}{
+		{name: "empty", sorted: nil, p: 50, want: 0},
+		{name: "single p50", sorted: ms(7), p: 50, want: 7 * time.Millisecond},
+		{name: "single p99", sorted: ms(7), p: 99, want: 7 * time.Millisecond},
+		{name: "hundred p50", sorted: hundred, p: 50, want: 50 * time.Millisecond},
+		{name: "hundred p99", sorted: hundred, p: 99, want: 99 * time.Millisecond},
+		{name: "hundred p100", sorted: hundred, p: 100, want: 100 * time.Millisecond},
+		// nearest rank rounds up
+		{name: "ten p50", sorted: ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), p: 50, want: 5 * time.Millisecond},
+		{name: "ten p95", sorted: ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), p: 95, want: 10 * time.Millisecond},
+		{name: "three p0", sorted: ms(1, 2, 3), p: 0, want: time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, percentile(test.sorted, test.p))
+		})
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	us := func(v ...int) []time.Duration {
+		result := make([]time.Duration, len(v))
+		for i := range v {
+			result[i] = time.Duration(v[i]) * time.Microsecond
+		}
+		return result
+	}
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		want   []bucket
+	}{
+		{name: "empty", sorted: nil, want: []bucket{}},
+		{name: "first bucket", sorted: us(1, 50), want: []bucket{{UpperUs: 50, Count: 2}}},
+		{
+			name:   "empty buckets in the middle are kept",
+			sorted: us(10, 51, 100, 300),
+			want:   []bucket{{UpperUs: 50, Count: 1}, {UpperUs: 100, Count: 2}, {UpperUs: 200, Count: 0}, {UpperUs: 400, Count: 1}},
+		},
+		{
+			// 50us << 18 is about 13s, everything above falls into the last bucket
+			name:   "last bucket holds everything left",
+			sorted: append(us(1), time.Minute, time.Hour),
+			want: func() []bucket {
+				result := []bucket{{UpperUs: 50, Count: 1}}
+				for b := 1; b < bucketsNum-1; b++ {
+					result = append(result, bucket{UpperUs: 50 << b})
+				}
+				return append(result, bucket{UpperUs: time.Hour.Microseconds(), Count: 2})
+			}(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, histogram(test.sorted))
+		})
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{args: nil},
+		{args: []string{"--dist", "zipf", "--zipf-s", "1.5", "-n", "10", "-d", "0", "-o", "json"}},
+		{args: []string{"-c", "0"}, err: "--concurrency expected to be greater than 0"},
+		{args: []string{"--read-ratio", "1.1"}, err: "--read-ratio expected to be from 0 to 1"},
+		{args: []string{"--read-ratio", "-0.1"}, err: "--read-ratio expected to be from 0 to 1"},
+		{args: []string{"--keys", "0"}, err: "--keys expected to be greater than 0"},
+		{args: []string{"--value-size", "0"}, err: "--value-size expected to be greater than 0"},
+		{args: []string{"--dist", "normal"}, err: "--dist expected to be uniform or zipf"},
+		{args: []string{"--dist", "zipf", "--zipf-s", "1"}, err: "--zipf-s expected to be greater than 1"},
+		{args: []string{"-n", "-1"}, err: "--ops expected to be positive"},
+		{args: []string{"-d", "0"}, err: "--duration expected to be positive"},
+		{args: []string{"-o", "yaml"}, err: "--output expected to be text or json"},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			cmd := Init(&conf.Config{})
+			require.NoError(t, cmd.ParseFlags(test.args))
+			opts, err := parseOptions(cmd)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, opts.concurrency, 1)
+		})
+	}
+}
+
+func TestKeyGen(t *testing.T) {
+	for _, dist := range []string{distUniform, distZipf} {
+		t.Run(dist, func(t *testing.T) {
+			opts := options{keys: 10, dist: dist, zipfS: 1.1}
+			nextKey := keyGen(rand.New(rand.NewPCG(1, 2)), opts)
+			seen := make(map[int64]int)
+			for range 10000 {
+				k := nextKey()
+				require.GreaterOrEqual(t, k, int64(0))
+				require.Less(t, k, int64(opts.keys))
+				seen[k]++
+			}
+			// the whole key space is used
+			assert.Len(t, seen, opts.keys)
+			if dist == distZipf {
+				assert.Greater(t, seen[0], seen[int64(opts.keys-1)])
+			}
+		})
+	}
+
+	// a single key is the only choice for both distributions
+	for _, dist := range []string{distUniform, distZipf} {
+		nextKey := keyGen(rand.New(rand.NewPCG(1, 2)), options{keys: 1, dist: dist, zipfS: 1.1})
+		assert.Equal(t, int64(0), nextKey())
+	}
+}
+
+func TestCmd(t *testing.T) {
+	assert.Equal(t, "GET key7\n", getCmd(7))
+	assert.Equal(t, "SET key7 abc\n", setCmd(7, "abc"))
+	v := value(rand.New(rand.NewPCG(1, 2)), 32)
+	assert.Len(t, v, 32)
+	assert.Empty(t, strings.Trim(v, valueChars))
+}
+
+// fakeServer answers every tcp command with OK and records the commands received.
+// Returns its address, the commands and the number of connections accepted
+func fakeServer(t *testing.T) (conf.Config, func() ([]string, int)) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	var mu sync.Mutex
+	var cmds []string
+	accepted := 0
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			accepted++
+			mu.Unlock()
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					cmds = append(cmds, line)
+					mu.Unlock()
+					if _, err = conn.Write([]byte("OK\nOK\n\n")); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return conf.Config{Server: host, Port: p, Proto: "tcp", Timeout: time.Second}, func() ([]string, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), cmds...), accepted
+	}
+}
+
+func TestWorkload(t *testing.T) {
+	cfg, received := fakeServer(t)
+	opts := options{concurrency: 4, readRatio: 0.8, keys: 50, valueSize: 8, dist: distUniform, ops: 2000, prefill: true}
+	clients, err := shared.NewClients(cfg, opts.concurrency)
+	require.NoError(t, err)
+	defer func() {
+		for _, c := range clients {
+			_ = c.Close()
+		}
+	}()
+
+	require.NoError(t, prefill(clients, opts))
+	cmds, _ := received()
+	// every key is set once
+	require.Len(t, cmds, opts.keys)
+	set := make(map[string]bool)
+	for _, c := range cmds {
+		fields := strings.Fields(c)
+		require.Len(t, fields, 3)
+		assert.Equal(t, "SET "+fields[1]+" "+fields[2]+"\n", c)
+		assert.Len(t, fields[2], opts.valueSize)
+		set[fields[1]] = true
+	}
+	for i := range opts.keys {
+		assert.True(t, set[key(int64(i))])
+	}
+
+	read, write := workload(clients, opts)
+	assert.Equal(t, int(opts.ops), len(read.latencies)+len(write.latencies))
+	assert.Zero(t, read.errors+write.errors)
+
+	cmds, accepted := received()
+	// every worker keeps its connection
+	assert.LessOrEqual(t, accepted, opts.concurrency)
+	gets := 0
+	for _, c := range cmds[opts.keys:] {
+		fields := strings.Fields(c)
+		require.Contains(t, []string{"GET", "SET"}, fields[0])
+		k, err := strconv.Atoi(strings.TrimPrefix(fields[1], "key"))
+		require.NoError(t, err)
+		assert.Less(t, k, opts.keys)
+		if fields[0] == "GET" {
+			gets++
+			assert.Equal(t, getCmd(int64(k)), c)
+		}
+	}
+	assert.Equal(t, len(read.latencies), gets)
+	assert.InDelta(t, opts.readRatio, float64(gets)/float64(opts.ops), 0.05)
+}
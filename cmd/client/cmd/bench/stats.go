@@ -0,0 +1,176 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// histogram bucket bounds grow exponentially starting at firstBucket
+const firstBucket = 50 * time.Microsecond
+const bucketsNum = 20
+
+// opStats collects latencies of one operation type
+type opStats struct {
+	latencies []time.Duration
+	errors    int
+	// firstErr is kept to give a hint on what went wrong
+	firstErr error
+}
+
+func (s *opStats) add(d time.Duration, err error) {
+	if err != nil {
+		s.errors++
+		if s.firstErr == nil {
+			s.firstErr = err
+		}
+		return
+	}
+	s.latencies = append(s.latencies, d)
+}
+
+func (s *opStats) merge(other *opStats) {
+	s.latencies = append(s.latencies, other.latencies...)
+	s.errors += other.errors
+	if s.firstErr == nil {
+		s.firstErr = other.firstErr
+	}
+}
+
+// bucket is a histogram bucket holding latencies less than or equal to UpperUs
+type bucket struct {
+	UpperUs int64 `json:"upper_us"`
+	Count   int   `json:"count"`
+}
+
+// opReport is the summary of opStats. Latencies are in microseconds
+type opReport struct {
+	Count     int      `json:"count"`
+	Errors    int      `json:"errors"`
+	FirstErr  string   `json:"first_error,omitempty"`
+	P50Us     int64    `json:"p50_us"`
+	P95Us     int64    `json:"p95_us"`
+	P99Us     int64    `json:"p99_us"`
+	MaxUs     int64    `json:"max_us"`
+	Histogram []bucket `json:"histogram"`
+}
+
+func (s *opStats) report() opReport {
+	slices.Sort(s.latencies)
+	r := opReport{
+		Count:     len(s.latencies),
+		Errors:    s.errors,
+		P50Us:     percentile(s.latencies, 50).Microseconds(),
+		P95Us:     percentile(s.latencies, 95).Microseconds(),
+		P99Us:     percentile(s.latencies, 99).Microseconds(),
+		Histogram: histogram(s.latencies),
+	}
+	if len(s.latencies) > 0 {
+		r.MaxUs = s.latencies[len(s.latencies)-1].Microseconds()
+	}
+	if s.firstErr != nil {
+		r.FirstErr = s.firstErr.Error()
+	}
+	return r
+}
+
+// percentile expects sorted input
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	return sorted[max(i, 0)]
+}
+
+// histogram expects sorted input. Empty buckets after the last filled one are omitted
+func histogram(sorted []time.Duration) []bucket {
+	result := make([]bucket, 0, bucketsNum)
+	upper := firstBucket
+	i := 0
+	for b := 0; b < bucketsNum && i < len(sorted); b++ {
+		// the last bucket holds everything left
+		last := b == bucketsNum-1
+		count := 0
+		for i < len(sorted) && (last || sorted[i] <= upper) {
+			count++
+			i++
+		}
+		if last {
+			upper = max(upper, sorted[len(sorted)-1])
+		}
+		result = append(result, bucket{UpperUs: upper.Microseconds(), Count: count})
+		upper *= 2
+	}
+	return result
+}
+
+// report is the benchmark result
+type report struct {
+	Proto       string   `json:"proto"`
+	Concurrency int      `json:"concurrency"`
+	ElapsedMs   int64    `json:"elapsed_ms"`
+	Ops         int      `json:"ops"`
+	Errors      int      `json:"errors"`
+	OpsPerSec   float64  `json:"ops_per_sec"`
+	Read        opReport `json:"read"`
+	Write       opReport `json:"write"`
+}
+
+func newReport(opts options, elapsed time.Duration, read, write *opStats) report {
+	r := report{
+		Proto:       opts.proto,
+		Concurrency: opts.concurrency,
+		ElapsedMs:   elapsed.Milliseconds(),
+		Read:        read.report(),
+		Write:       write.report(),
+	}
+	r.Ops = r.Read.Count + r.Write.Count
+	r.Errors = r.Read.Errors + r.Write.Errors
+	if elapsed > 0 {
+		r.OpsPerSec = float64(r.Ops) / elapsed.Seconds()
+	}
+	return r
+}
+
+func (r report) writeJson(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r report) writeText(w io.Writer) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "proto:       %s\n", r.Proto)
+	fmt.Fprintf(&sb, "concurrency: %d\n", r.Concurrency)
+	fmt.Fprintf(&sb, "elapsed:     %s\n", time.Duration(r.ElapsedMs)*time.Millisecond)
+	fmt.Fprintf(&sb, "ops:         %d (%d errors)\n", r.Ops, r.Errors)
+	fmt.Fprintf(&sb, "throughput:  %.1f ops/sec\n", r.OpsPerSec)
+	writeOpText(&sb, "read", r.Read)
+	writeOpText(&sb, "write", r.Write)
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writeOpText(sb *strings.Builder, name string, r opReport) {
+	us := func(v int64) time.Duration { return time.Duration(v) * time.Microsecond }
+
+	fmt.Fprintf(sb, "\n%s: %d ok, %d errors\n", name, r.Count, r.Errors)
+	if r.FirstErr != "" {
+		fmt.Fprintf(sb, "  first error: %s\n", r.FirstErr)
+	}
+	if r.Count == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "  p50 %s  p95 %s  p99 %s  max %s\n", us(r.P50Us), us(r.P95Us), us(r.P99Us), us(r.MaxUs))
+
+	const barWidth = 40
+	for _, b := range r.Histogram {
+		bar := strings.Repeat("#", b.Count*barWidth/r.Count)
+		fmt.Fprintf(sb, "  <= %-10s %8d %s\n", us(b.UpperUs), b.Count, bar)
+	}
+}
@@ -0,0 +1,12 @@
+package bulk
+
+import (
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+// timeoutFlag adds connection timeout flag to cmd
+func timeoutFlag(cmd *cobra.Command, cfg *conf.Config) {
+	cmd.PersistentFlags().DurationVarP(&cfg.Timeout, "timeout", "t", 1*time.Second, "connection timeout")
+}
@@ -0,0 +1,61 @@
+package bulk
+
+import (
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"custom-in-memory-db/cmd/client/shared"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+func InitExport(cfg *conf.Config) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "export",
+		Short: "Prints all keys with values",
+		Long: "Prints all keys with values to stdout, e.g. ramdb-cli export --format csv > file.csv\n" +
+			"Keys are taken from a consistent snapshot made by the server",
+		Args:          cobra.NoArgs,
+		RunE:          runExport,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	timeoutFlag(cmd, cfg)
+	cmd.Flags().String("format", formatJson, "output format, one of json|csv|ndjson")
+
+	return cmd
+}
+
+func runExport(cmd *cobra.Command, _ []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	enc, err := newEncoder(format, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	resp, err := shared.Exec(conf.FromFlags(cmd), "DUMP\n")
+	if err != nil {
+		return fmt.Errorf("export failed: %w", err)
+	}
+
+	if err = writeDump(enc, resp); err != nil {
+		return fmt.Errorf("export failed: %w", err)
+	}
+
+	return enc.Close()
+}
+
+// writeDump writes every "key value" line of DUMP response to enc
+func writeDump(enc encoder, dump []byte) error {
+	for _, line := range strings.Split(string(dump), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if err := enc.Write(record{Key: key, Value: value}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
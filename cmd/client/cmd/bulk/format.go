@@ -0,0 +1,298 @@
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	formatJson   = "json"
+	formatCsv    = "csv"
+	formatNdjson = "ndjson"
+)
+
+var csvHeader = []string{"Key", "Value"}
+
+// record is a single key value pair. Json field names match Content schema from api/swagger.yaml
+type record struct {
+	Key   string `json:"Key"`
+	Value string `json:"Value"`
+	// line is the line of the source file record starts at
+	line int
+}
+
+// lineError is returned by decoders for malformed records that can be skipped
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.err)
+}
+
+// decoder returns records one by one and io.EOF after the last one
+type decoder interface {
+	Next() (record, error)
+}
+
+// encoder writes records in the corresponding format. Close must be called after the last record
+type encoder interface {
+	Write(r record) error
+	Close() error
+}
+
+// formatFromPath guesses format by the file extension
+func formatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return formatJson
+	case ".csv":
+		return formatCsv
+	default:
+		return formatNdjson
+	}
+}
+
+func newDecoder(format string, r io.Reader) (decoder, error) {
+	switch format {
+	case formatJson:
+		return newJsonDecoder(r)
+	case formatCsv:
+		return newCsvDecoder(r), nil
+	case formatNdjson:
+		return &ndjsonDecoder{sc: bufio.NewScanner(r)}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected one of json|csv|ndjson", format)
+	}
+}
+
+func newEncoder(format string, w io.Writer) (encoder, error) {
+	switch format {
+	case formatJson:
+		return &jsonEncoder{w: w}, nil
+	case formatCsv:
+		cw := csv.NewWriter(w)
+		return &csvEncoder{w: cw}, cw.Write(csvHeader)
+	case formatNdjson:
+		return &ndjsonEncoder{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected one of json|csv|ndjson", format)
+	}
+}
+
+// ndjson
+
+type ndjsonDecoder struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+func (d *ndjsonDecoder) Next() (record, error) {
+	for d.sc.Scan() {
+		d.line++
+		text := bytes.TrimSpace(d.sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(text, &r); err != nil {
+			return record{}, &lineError{line: d.line, err: err}
+		}
+		r.line = d.line
+		return r, nil
+	}
+	if err := d.sc.Err(); err != nil {
+		return record{}, err
+	}
+	return record{}, io.EOF
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) Write(r record) error {
+	return e.enc.Encode(r)
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+// csv
+
+type csvDecoder struct {
+	r     *csv.Reader
+	first bool
+}
+
+func newCsvDecoder(r io.Reader) *csvDecoder {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+	return &csvDecoder{r: cr, first: true}
+}
+
+func (d *csvDecoder) Next() (record, error) {
+	for {
+		fields, err := d.r.Read()
+		if err == io.EOF {
+			return record{}, io.EOF
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return record{}, &lineError{line: parseErr.StartLine, err: parseErr.Err}
+		}
+		if err != nil {
+			return record{}, err
+		}
+
+		line, _ := d.r.FieldPos(0)
+		first := d.first
+		d.first = false
+		// header is optional
+		if first && strings.EqualFold(fields[0], csvHeader[0]) && strings.EqualFold(fields[1], csvHeader[1]) {
+			continue
+		}
+
+		return record{Key: fields[0], Value: fields[1], line: line}, nil
+	}
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) Write(r record) error {
+	return e.w.Write([]string{r.Key, r.Value})
+}
+
+func (e *csvEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// json
+
+// jsonDecoder streams records from a json array.
+// The whole input is kept to map decoder offsets to line numbers
+type jsonDecoder struct {
+	data []byte
+	dec  *json.Decoder
+}
+
+func newJsonDecoder(r io.Reader) (*jsonDecoder, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := jsonDecoder{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	tok, err := d.dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", d.lineAt(d.dec.InputOffset()), err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("line %d: expected json array", d.lineAt(0))
+	}
+
+	return &d, nil
+}
+
+func (d *jsonDecoder) Next() (record, error) {
+	if !d.dec.More() {
+		return record{}, io.EOF
+	}
+
+	line := d.lineAt(d.dec.InputOffset())
+	var r record
+	err := d.dec.Decode(&r)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		// value is consumed, so we can go on with the next one
+		return record{}, &lineError{line: line, err: err}
+	}
+	if err != nil {
+		return record{}, fmt.Errorf("line %d: %w", line, err)
+	}
+
+	r.line = line
+	return r, nil
+}
+
+// lineAt returns line number of the first token after offset
+func (d *jsonDecoder) lineAt(offset int64) int {
+	pos := int(offset)
+	for pos < len(d.data) && strings.IndexByte(" \t\r\n,", d.data[pos]) >= 0 {
+		pos++
+	}
+	return bytes.Count(d.data[:pos], []byte("\n")) + 1
+}
+
+type jsonEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonEncoder) Write(r record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	prefix := ",\n  "
+	if e.count == 0 {
+		prefix = "[\n  "
+	}
+	e.count++
+
+	_, err = io.WriteString(e.w, prefix+string(b))
+	return err
+}
+
+func (e *jsonEncoder) Close() error {
+	if e.count == 0 {
+		_, err := io.WriteString(e.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(e.w, "\n]\n")
+	return err
+}
+
+// Encoder writes key value pairs in a format import reads. Close must be called after the last pair
+type Encoder interface {
+	Write(key, value string) error
+	Close() error
+}
+
+type pairEncoder struct {
+	enc encoder
+}
+
+func (e pairEncoder) Write(key, value string) error {
+	return e.enc.Write(record{Key: key, Value: value})
+}
+
+func (e pairEncoder) Close() error {
+	return e.enc.Close()
+}
+
+// NewEncoder returns an Encoder writing to w in one of json|csv|ndjson formats.
+// An empty format is guessed by the file extension of path
+func NewEncoder(format, path string, w io.Writer) (Encoder, error) {
+	if format == "" {
+		format = formatFromPath(path)
+	}
+	enc, err := newEncoder(format, w)
+	if err != nil {
+		return nil, err
+	}
+	return pairEncoder{enc: enc}, nil
+}
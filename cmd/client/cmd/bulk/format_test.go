@@ -0,0 +1,168 @@
+package bulk
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+// decodeAll returns records and line numbers of skipped ones until io.EOF or a fatal error
+func decodeAll(t *testing.T, dec decoder) ([]record, []int, error) {
+	var records []record
+	var skipped []int
+	for {
+		r, err := dec.Next()
+		if err == io.EOF {
+			return records, skipped, nil
+		}
+		var lineErr *lineError
+		if errors.As(err, &lineErr) {
+			skipped = append(skipped, lineErr.line)
+			continue
+		}
+		if err != nil {
+			return records, skipped, err
+		}
+		records = append(records, r)
+	}
+}
+
+func TestNewDecoder(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		records []record
+		skipped []int
+		err     string
+	}{
+		{
+			name:    "json",
+			format:  formatJson,
+			input:   "[\n  {\"Key\": \"a\", \"Value\": \"1\"},\n\n  {\"Key\": \"b\", \"Value\": \"2\"}\n]\n",
+			records: []record{{Key: "a", Value: "1", line: 2}, {Key: "b", Value: "2", line: 4}},
+		},
+		{
+			name:    "json wrong type",
+			format:  formatJson,
+			input:   "[\n  {\"Key\": \"a\", \"Value\": \"1\"},\n  {\"Key\": 2, \"Value\": \"2\"},\n  {\"Key\": \"c\", \"Value\": \"3\"}\n]",
+			records: []record{{Key: "a", Value: "1", line: 2}, {Key: "c", Value: "3", line: 4}},
+			skipped: []int{3},
+		},
+		{
+			name:    "json syntax error",
+			format:  formatJson,
+			input:   "[\n  {\"Key\": \"a\", \"Value\": \"1\"},\n  {\"Key\" \"b\"}\n]",
+			records: []record{{Key: "a", Value: "1", line: 2}},
+			err:     "line 3: invalid character '\"' after object key",
+		},
+		{name: "json empty array", format: formatJson, input: "[]"},
+		{
+			name:    "csv",
+			format:  formatCsv,
+			input:   "Key,Value\na,1\nb,\"two\nlines\"\nc,3\n",
+			records: []record{{Key: "a", Value: "1", line: 2}, {Key: "b", Value: "two\nlines", line: 3}, {Key: "c", Value: "3", line: 5}},
+		},
+		{
+			name:    "csv without header",
+			format:  formatCsv,
+			input:   "a,1\n",
+			records: []record{{Key: "a", Value: "1", line: 1}},
+		},
+		{
+			name:    "csv bad lines",
+			format:  formatCsv,
+			input:   "key,value\na,1\nb\nc,3,4\nd,\"4\ne,5\n",
+			records: []record{{Key: "a", Value: "1", line: 2}},
+			skipped: []int{3, 4, 5},
+		},
+		{
+			name:    "ndjson",
+			format:  formatNdjson,
+			input:   "{\"Key\":\"a\",\"Value\":\"1\"}\n\n  \n{\"Key\":\"b\",\"Value\":\"2\"}",
+			records: []record{{Key: "a", Value: "1", line: 1}, {Key: "b", Value: "2", line: 4}},
+		},
+		{
+			name:    "ndjson bad lines",
+			format:  formatNdjson,
+			input:   "{\"Key\":\"a\",\"Value\":\"1\"}\nnot json\n{\"Key\":3}\n{\"Key\":\"c\",\"Value\":\"3\"}\n",
+			records: []record{{Key: "a", Value: "1", line: 1}, {Key: "c", Value: "3", line: 4}},
+			skipped: []int{2, 3},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dec, err := newDecoder(test.format, strings.NewReader(test.input))
+			require.NoError(t, err)
+			records, skipped, err := decodeAll(t, dec)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.records, records)
+			assert.Equal(t, test.skipped, skipped)
+		})
+	}
+}
+
+func TestNewDecoder_Negative(t *testing.T) {
+	_, err := newDecoder("yaml", strings.NewReader(""))
+	assert.EqualError(t, err, `unknown format "yaml", expected one of json|csv|ndjson`)
+	_, err = newDecoder(formatJson, strings.NewReader("\n\n{\"Key\":\"a\"}"))
+	assert.EqualError(t, err, "line 3: expected json array")
+	_, err = newDecoder(formatJson, strings.NewReader("\n"))
+	assert.EqualError(t, err, "line 2: EOF")
+}
+
+func TestFormatFromPath(t *testing.T) {
+	assert.Equal(t, formatJson, formatFromPath("dump.JSON"))
+	assert.Equal(t, formatCsv, formatFromPath("/tmp/dump.csv"))
+	assert.Equal(t, formatNdjson, formatFromPath("dump.ndjson"))
+	assert.Equal(t, formatNdjson, formatFromPath("dump"))
+}
+
+func TestWriteDump_RoundTrip(t *testing.T) {
+	// DUMP response lines are "key value", values may contain spaces
+	dump := []byte("a 1\nb/c x y\n\nnospace\nd_e ,\"quoted\"")
+	want := []record{{Key: "a", Value: "1"}, {Key: "b/c", Value: "x y"}, {Key: "d_e", Value: ",\"quoted\""}}
+
+	for _, format := range []string{formatJson, formatCsv, formatNdjson} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc, err := newEncoder(format, &buf)
+			require.NoError(t, err)
+			require.NoError(t, writeDump(enc, dump))
+			require.NoError(t, enc.Close())
+
+			dec, err := newDecoder(format, &buf)
+			require.NoError(t, err)
+			records, skipped, err := decodeAll(t, dec)
+			require.NoError(t, err)
+			assert.Empty(t, skipped)
+			require.Len(t, records, len(want))
+			for i := range records {
+				records[i].line = 0
+			}
+			assert.Equal(t, want, records)
+		})
+	}
+
+	// an empty database is exported as a valid empty file
+	for _, format := range []string{formatJson, formatCsv, formatNdjson} {
+		var buf bytes.Buffer
+		enc, err := newEncoder(format, &buf)
+		require.NoError(t, err)
+		require.NoError(t, writeDump(enc, nil))
+		require.NoError(t, enc.Close())
+		dec, err := newDecoder(format, &buf)
+		require.NoError(t, err)
+		records, _, err := decodeAll(t, dec)
+		require.NoError(t, err)
+		assert.Empty(t, records, format)
+	}
+}
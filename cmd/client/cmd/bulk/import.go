@@ -0,0 +1,197 @@
+package bulk
+
+import (
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"custom-in-memory-db/cmd/client/shared"
+	"custom-in-memory-db/internal/server/db/parser"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var nilLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func InitImport(cfg *conf.Config) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "import <file>",
+		Short: "Loads keys with values from <file>",
+		Long: "Loads keys with values from <file> in json, csv or ndjson format.\n" +
+			"Records are validated against the server rules and sent in batches over --conns connections.\n" +
+			"Invalid records are reported with their line numbers and skipped",
+		Args:          cobra.ExactArgs(1),
+		RunE:          runImport,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	timeoutFlag(cmd, cfg)
+	cmd.Flags().String("format", "", "input format, one of json|csv|ndjson. Guessed by file extension if omitted")
+	cmd.Flags().Bool("dry-run", false, "only validate records, do not send them")
+	cmd.Flags().Int("batch", 100, "number of records sent between progress reports")
+	cmd.Flags().Int("conns", 4, "number of connections records are sent over concurrently")
+
+	return cmd
+}
+
+// importer sends records in batches and keeps the statistics
+type importer struct {
+	clients []*shared.Client
+	pr      parser.Parser
+	dryRun  bool
+
+	batch    []record
+	imported int
+	rejected int
+	failed   int
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	batchSize, _ := cmd.Flags().GetInt("batch")
+	conns, _ := cmd.Flags().GetInt("conns")
+	if batchSize < 1 {
+		return errors.New("--batch expected to be greater than 0")
+	}
+	if conns < 1 {
+		return errors.New("--conns expected to be greater than 0")
+	}
+	if format == "" {
+		format = formatFromPath(args[0])
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec, err := newDecoder(format, f)
+	if err != nil {
+		return err
+	}
+
+	im := importer{pr: parser.New(), dryRun: dryRun, batch: make([]record, 0, batchSize)}
+	if !dryRun {
+		if im.clients, err = shared.NewClients(conf.FromFlags(cmd), min(conns, batchSize)); err != nil {
+			return err
+		}
+		defer im.close()
+	}
+	if err = im.run(dec); err != nil {
+		return err
+	}
+
+	if im.dryRun {
+		fmt.Fprintf(os.Stderr, "%d records valid, %d rejected\n", im.imported, im.rejected)
+	} else {
+		fmt.Fprintf(os.Stderr, "%d records imported, %d rejected, %d failed\n", im.imported, im.rejected, im.failed)
+	}
+	if im.rejected > 0 || im.failed > 0 {
+		return errors.New("import finished with errors")
+	}
+
+	return nil
+}
+
+func (im *importer) run(dec decoder) error {
+	for {
+		r, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		var lineErr *lineError
+		if errors.As(err, &lineErr) {
+			im.reject(lineErr)
+			continue
+		}
+		if err != nil {
+			im.flush()
+			return err
+		}
+
+		if err = im.validate(r); err != nil {
+			im.reject(&lineError{line: r.line, err: err})
+			continue
+		}
+
+		im.batch = append(im.batch, r)
+		if len(im.batch) == cap(im.batch) {
+			im.flush()
+		}
+	}
+
+	im.flush()
+	return nil
+}
+
+// validate ensures the record will be accepted by the server parser
+func (im *importer) validate(r record) error {
+	if strings.ContainsAny(r.Key, "\r\n") || strings.ContainsAny(r.Value, "\r\n") {
+		return errors.New("key and value must not contain line breaks")
+	}
+	_, err := im.pr.Read(strings.NewReader(setCmd(r)), nilLogger)
+	return err
+}
+
+func setCmd(r record) string {
+	return strings.Join([]string{"SET", r.Key, r.Value}, " ") + "\n"
+}
+
+func (im *importer) reject(err *lineError) {
+	im.rejected++
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+}
+
+// flush sends the batch over all the clients concurrently and reports progress
+func (im *importer) flush() {
+	if len(im.batch) == 0 {
+		return
+	}
+	defer func() { im.batch = im.batch[:0] }()
+
+	if im.dryRun {
+		im.imported += len(im.batch)
+		return
+	}
+
+	errs := make([]error, len(im.batch))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for _, c := range im.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(im.batch) {
+					return
+				}
+				_, errs[i] = c.Exec(setCmd(im.batch[i]))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			im.failed++
+			fmt.Fprintf(os.Stderr, "line %d: %s\n", im.batch[i].line, err)
+			continue
+		}
+		im.imported++
+	}
+	fmt.Fprintf(os.Stderr, "progress: %d records imported, %d rejected, %d failed\n", im.imported, im.rejected, im.failed)
+}
+
+func (im *importer) close() {
+	for _, c := range im.clients {
+		_ = c.Close()
+	}
+}
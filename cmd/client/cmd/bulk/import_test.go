@@ -0,0 +1,139 @@
+package bulk
+
+import (
+	"bufio"
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"custom-in-memory-db/cmd/client/shared"
+	"custom-in-memory-db/internal/server/db/parser"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestImporter_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		r    record
+		err  string
+	}{
+		{name: "valid", r: record{Key: "key_1", Value: "some/value_1"}},
+		{name: "key with a line break", r: record{Key: "a\nb", Value: "1"}, err: "key and value must not contain line breaks"},
+		{name: "value with a line break", r: record{Key: "a", Value: "1\r"}, err: "key and value must not contain line breaks"},
+		{name: "empty key", r: record{Key: "", Value: "1"}, err: "expects exactly 2 args"},
+		{name: "empty value", r: record{Key: "a", Value: ""}, err: "expects exactly 2 args"},
+		{name: "key with a space", r: record{Key: "a b", Value: "1"}, err: "expects exactly 2 args"},
+		{name: "forbidden char", r: record{Key: "a", Value: "1!"}, err: `got "1!"`},
+	}
+	im := importer{pr: parser.New()}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := im.validate(test.r)
+			if test.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestImporter_Run_DryRun(t *testing.T) {
+	dec, err := newDecoder(formatNdjson, strings.NewReader("{\"Key\":\"a\",\"Value\":\"1\"}\nbad\n"+
+		"{\"Key\":\"b\",\"Value\":\"x y\"}\n{\"Key\":\"c\",\"Value\":\"3\"}\n"))
+	require.NoError(t, err)
+	im := importer{pr: parser.New(), dryRun: true, batch: make([]record, 0, 2)}
+	require.NoError(t, im.run(dec))
+	assert.Equal(t, 2, im.imported)
+	assert.Equal(t, 2, im.rejected)
+	assert.Zero(t, im.failed)
+}
+
+// fakeServer answers tcp SET commands with OK, and with an error for keys starting with "bad".
+// Returns the config to reach it, the commands and the number of connections accepted
+func fakeServer(t *testing.T) (conf.Config, func() ([]string, int)) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	var mu sync.Mutex
+	var cmds []string
+	accepted := 0
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			accepted++
+			mu.Unlock()
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					cmds = append(cmds, line)
+					mu.Unlock()
+					reply := "OK\nOK\n\n"
+					if strings.HasPrefix(line, "SET bad") {
+						reply = "ERR wal write failed\n\n"
+					}
+					if _, err = conn.Write([]byte(reply)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return conf.Config{Server: host, Port: p, Proto: "tcp", Timeout: time.Second}, func() ([]string, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), cmds...), accepted
+	}
+}
+
+func TestImporter_Run(t *testing.T) {
+	cfg, received := fakeServer(t)
+	var input strings.Builder
+	want := make([]string, 0, 250)
+	for i := range 250 {
+		key := fmt.Sprintf("key_%d", i)
+		if i%50 == 0 {
+			key = fmt.Sprintf("bad_%d", i)
+		}
+		fmt.Fprintf(&input, "%s,%d\n", key, i)
+		want = append(want, fmt.Sprintf("SET %s %d\n", key, i))
+	}
+	dec, err := newDecoder(formatCsv, strings.NewReader(input.String()))
+	require.NoError(t, err)
+
+	const conns = 3
+	clients, err := shared.NewClients(cfg, conns)
+	require.NoError(t, err)
+	im := importer{clients: clients, pr: parser.New(), batch: make([]record, 0, 100)}
+	defer im.close()
+	require.NoError(t, im.run(dec))
+	assert.Equal(t, 245, im.imported)
+	assert.Equal(t, 5, im.failed)
+	assert.Zero(t, im.rejected)
+
+	cmds, accepted := received()
+	assert.ElementsMatch(t, want, cmds)
+	// records are sent over the pool, not a connection per record
+	assert.LessOrEqual(t, accepted, conns)
+}
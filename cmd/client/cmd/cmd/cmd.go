@@ -6,7 +6,6 @@ import (
 	"custom-in-memory-db/cmd/client/cmd/cmd/set"
 	"custom-in-memory-db/cmd/client/cmd/conf"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 func Init(cfg *conf.Config) *cobra.Command {
@@ -16,12 +15,6 @@ func Init(cfg *conf.Config) *cobra.Command {
 		Long:  `Executes db command qq ww ee`,
 	}
 
-	viper.BindPFlag("server", cmd.PersistentFlags().Lookup("server"))
-	cmd.PersistentFlags().StringVarP(&cfg.Server, "server", "s", "127.0.0.1", "database server name")
-
-	viper.BindPFlag("port", cmd.PersistentFlags().Lookup("port"))
-	cmd.PersistentFlags().IntVarP(&cfg.Port, "port", "p", 8080, "database port")
-
 	cmd.AddCommand(get.Init(cfg), set.Init(cfg), del.Init(cfg))
 
 	return &cmd
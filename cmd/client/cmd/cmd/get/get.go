@@ -30,18 +30,9 @@ func Init(cfg *conf.Config) *cobra.Command {
 }
 
 func run(cmd *cobra.Command, args []string) {
-	server, _ := cmd.Flags().GetString("server")
-	port, _ := cmd.Flags().GetInt("port")
-	timeout, _ := cmd.Flags().GetDuration("timeout")
-	verbose, _ := cmd.Flags().GetBool("verbose")
-	cfg := conf.Config{
-		Server:  server,
-		Port:    port,
-		Timeout: timeout,
-		Verbose: verbose,
-	}
+	cfg := conf.FromFlags(cmd)
 
-	fmt.Println(string(shared.InvokeTcp(cfg, strings.Join([]string{"GET ", args[0], "\n"}, ""))))
+	fmt.Println(string(shared.Invoke(cfg, strings.Join([]string{"GET ", args[0], "\n"}, ""))))
 }
 
 func args(cmd *cobra.Command, args []string) error {
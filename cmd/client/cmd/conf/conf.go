@@ -1,22 +1,36 @@
 package conf
 
 import (
+	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"os"
 	"time"
 )
 
 type Config struct {
 	Server  string
 	Port    int
+	Proto   string
 	Timeout time.Duration
 	Verbose bool
+	// Tls enables TLS. CaCert, Cert and Key are optional
+	Tls    bool
+	CaCert string
+	Cert   string
+	Key    string
+	// User and Password are sent with AUTH over tcp, Token is sent as bearer token over http
+	User     string
+	Password string
+	Token    string
 }
 
 func InitConf() {
 	viper.SetDefault("server", "127.0.0.1")
 	viper.SetDefault("port", 8080)
+	viper.SetDefault("proto", "http")
 	viper.SetDefault("timeout", "1s")
 	viper.SetDefault("Verbose", false)
+	viper.SetDefault("tls", false)
 
 	viper.AddConfigPath(".")
 	viper.SetConfigName("conf")
@@ -24,3 +38,43 @@ func InitConf() {
 	viper.SetEnvPrefix("MEMDB_CLT")
 	viper.AutomaticEnv()
 }
+
+// FromFlags collects connection settings from cmd flags
+func FromFlags(cmd *cobra.Command) Config {
+	server, _ := cmd.Flags().GetString("server")
+	port, _ := cmd.Flags().GetInt("port")
+	proto, _ := cmd.Flags().GetString("proto")
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	tls, _ := cmd.Flags().GetBool("tls")
+	caCert, _ := cmd.Flags().GetString("cacert")
+	cert, _ := cmd.Flags().GetString("cert")
+	key, _ := cmd.Flags().GetString("key")
+	user, _ := cmd.Flags().GetString("user")
+	password, _ := cmd.Flags().GetString("password")
+	token, _ := cmd.Flags().GetString("token")
+	// secrets passed as flags are visible to other users of the host, environment is preferred
+	if password == "" {
+		password = os.Getenv("MEMDB_CLT_PASSWORD")
+	}
+	if token == "" {
+		token = os.Getenv("MEMDB_CLT_TOKEN")
+	}
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		timeout = 1 * time.Second
+	}
+	return Config{
+		Server:   server,
+		Port:     port,
+		Proto:    proto,
+		Timeout:  timeout,
+		Verbose:  verbose,
+		Tls:      tls,
+		CaCert:   caCert,
+		Cert:     cert,
+		Key:      key,
+		User:     user,
+		Password: password,
+		Token:    token,
+	}
+}
@@ -0,0 +1,385 @@
+package repl
+
+import (
+	"bufio"
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"custom-in-memory-db/cmd/client/shared"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const prompt = "ramdb> "
+const multiPrompt = "ramdb(multi)> "
+
+// keywords are used for tab completion of the first word in a line
+var keywords = []string{"GET", "SET", "DEL", "PING", "ACL", "INFO", "CONFIG", "SLOWLOG", "BACKUP", "MULTI", "EXEC", "DISCARD", `\connect`, `\timing`, `\help`, `\quit`}
+
+// tcpOnly are commands the http endpoint has no routes for, they are hidden and rejected over http
+var tcpOnly = []string{"ACL", "SLOWLOG"}
+
+const help = `Commands:
+  GET <key>               print value of <key>
+  SET <key> <value>       create or update <key>
+  DEL <key>               delete <key>
+  PING                    check that the server is ready
+  ACL WHOAMI              print the name of the current user
+  ACL LIST                print permissions of all users
+  ACL SETUSER <user> ...  change permissions of <user>, e.g. +read -write ~report_* allkeys resetkeys reset
+  INFO [section]          print server, config, keyspace, clients and wal info
+  CONFIG GET <name>|*     print runtime settings
+  CONFIG SET <name> <v>   change runtime setting, e.g. log_level, wal_batch_timeout, net_max_conn
+  SLOWLOG GET [n]         print n most recent slow commands, all of them by default
+  SLOWLOG LEN             print the number of slow commands recorded
+  SLOWLOG RESET           clear slow commands
+  BACKUP <dir>            write a backup of the server to folder <dir> on the server
+  MULTI                   start queueing commands
+  EXEC                    send queued commands one by one
+  DISCARD                 drop queued commands
+  \connect <host:port>    switch to another server
+  \timing                 toggle printing of command execution time
+  \help                   print this help
+  \quit                   exit
+`
+
+func Init(cfg *conf.Config) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "repl",
+		Short: "Starts an interactive session",
+		Long: "Starts an interactive session reading commands line by line.\n" +
+			"Commands queued with MULTI are sent one by one on EXEC, they are not executed atomically",
+		Args: cobra.NoArgs,
+		RunE: Run,
+	}
+
+	cmd.PersistentFlags().DurationVarP(&cfg.Timeout, "timeout", "t", 1*time.Second, "connection timeout")
+
+	return cmd
+}
+
+// Run starts the interactive session. It serves both repl command and bare ramdb-cli
+func Run(cmd *cobra.Command, _ []string) error {
+	cfg := conf.FromFlags(cmd)
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		// script piped to stdin: no prompt, no line editing
+		s, err := newSession(cfg, os.Stdout)
+		if err != nil {
+			return err
+		}
+		return s.loop(&scanReader{sc: bufio.NewScanner(os.Stdin)})
+	}
+
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("cannot switch terminal to raw mode: %w", err)
+	}
+	defer term.Restore(fd, oldState)
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, prompt)
+
+	s, err := newSession(cfg, t)
+	if err != nil {
+		return err
+	}
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		return complete(s.keywords, line, pos, key)
+	}
+	return s.loop(t)
+}
+
+// lineReader is implemented by term.Terminal and scanReader
+type lineReader interface {
+	ReadLine() (string, error)
+	SetPrompt(string)
+}
+
+// scanReader reads lines from non-interactive input
+type scanReader struct {
+	sc *bufio.Scanner
+}
+
+func (r *scanReader) ReadLine() (string, error) {
+	if r.sc.Scan() {
+		return r.sc.Text(), nil
+	}
+	if err := r.sc.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+func (r *scanReader) SetPrompt(string) {}
+
+type session struct {
+	cfg    conf.Config
+	out    io.Writer
+	timing bool
+	// queue is not nil between MULTI and EXEC or DISCARD
+	queue []string
+	// client keeps the connection between commands
+	client *shared.Client
+	exec   func(c string) ([]byte, error)
+	// keywords and help leave out commands cfg.Proto does not support
+	keywords []string
+	help     string
+}
+
+func newSession(cfg conf.Config, out io.Writer) (*session, error) {
+	client, err := shared.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{cfg: cfg, out: out, client: client, keywords: keywords, help: help}
+	s.exec = func(c string) ([]byte, error) {
+		return s.client.Exec(c)
+	}
+	if cfg.Proto == "http" {
+		s.keywords = slices.DeleteFunc(slices.Clone(keywords), func(kw string) bool { return slices.Contains(tcpOnly, kw) })
+		var sb strings.Builder
+		for _, line := range strings.SplitAfter(help, "\n") {
+			if fields := strings.Fields(line); len(fields) == 0 || !slices.Contains(tcpOnly, fields[0]) {
+				sb.WriteString(line)
+			}
+		}
+		s.help = sb.String()
+	}
+	return s, nil
+}
+
+func (s *session) disconnect() {
+	_ = s.client.Close()
+}
+
+// loop reads and executes commands until EOF or \quit
+func (s *session) loop(r lineReader) error {
+	defer s.disconnect()
+	for {
+		line, err := r.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		quit := s.handleLine(strings.TrimSpace(line))
+		if quit {
+			return nil
+		}
+
+		if s.queue != nil {
+			r.SetPrompt(multiPrompt)
+		} else {
+			r.SetPrompt(prompt)
+		}
+	}
+}
+
+// handleLine executes a single line of input. Returns true if session must end
+func (s *session) handleLine(line string) bool {
+	if line == "" {
+		return false
+	}
+	if strings.HasPrefix(line, `\`) {
+		return s.handleMeta(strings.Fields(line))
+	}
+
+	fields := strings.Fields(line)
+	fields[0] = strings.ToUpper(fields[0])
+	switch fields[0] {
+	case "MULTI":
+		if s.queue != nil {
+			s.printErr(errors.New("MULTI calls can not be nested"))
+			return false
+		}
+		s.queue = make([]string, 0)
+		fmt.Fprintln(s.out, "OK")
+	case "EXEC":
+		if s.queue == nil {
+			s.printErr(errors.New("EXEC without MULTI"))
+			return false
+		}
+		queue := s.queue
+		s.queue = nil
+		for i, c := range queue {
+			fmt.Fprintf(s.out, "%d) ", i+1)
+			s.run(c)
+		}
+	case "DISCARD":
+		if s.queue == nil {
+			s.printErr(errors.New("DISCARD without MULTI"))
+			return false
+		}
+		s.queue = nil
+		fmt.Fprintln(s.out, "OK")
+	default:
+		if s.cfg.Proto == "http" && slices.Contains(tcpOnly, fields[0]) {
+			s.printErr(fmt.Errorf("%s is not supported over http, use --proto tcp", fields[0]))
+			return false
+		}
+		c := strings.Join(fields, " ")
+		if s.queue != nil {
+			s.queue = append(s.queue, c)
+			fmt.Fprintln(s.out, "QUEUED")
+			return false
+		}
+		s.run(c)
+	}
+
+	return false
+}
+
+// handleMeta executes client side commands starting with a backslash
+func (s *session) handleMeta(fields []string) bool {
+	switch fields[0] {
+	case `\quit`, `\q`:
+		return true
+	case `\help`, `\?`:
+		fmt.Fprint(s.out, s.help)
+	case `\timing`:
+		s.timing = !s.timing
+		if s.timing {
+			fmt.Fprintln(s.out, "Timing is on.")
+		} else {
+			fmt.Fprintln(s.out, "Timing is off.")
+		}
+	case `\connect`, `\c`:
+		if len(fields) != 2 {
+			s.printErr(errors.New(`\connect expects exactly 1 arg <host:port>`))
+			return false
+		}
+		if err := s.connect(fields[1]); err != nil {
+			s.printErr(err)
+			return false
+		}
+		fmt.Fprintf(s.out, "Connected to %s:%d\n", s.cfg.Server, s.cfg.Port)
+	default:
+		s.printErr(fmt.Errorf("unknown command %q, try \\help", fields[0]))
+	}
+
+	return false
+}
+
+// connect switches session to the new address after making sure it is reachable
+// with the protocol, TLS and credentials commands are going to use
+func (s *session) connect(addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid port %q: %w", portStr, err)
+	}
+
+	cfg := s.cfg
+	cfg.Server = host
+	cfg.Port = port
+	client, err := shared.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.Proto == "http" {
+		// the server is reachable even if it is not ready yet
+		var srvErr *shared.ServerError
+		if _, err = client.Exec("PING\n"); err != nil && !errors.As(err, &srvErr) {
+			_ = client.Close()
+			return fmt.Errorf("cannot connect to %q: %w", addr, err)
+		}
+	} else if err = client.Connect(); err != nil {
+		return fmt.Errorf("cannot connect to %q: %w", addr, err)
+	}
+	// the probe connection serves the next commands
+	s.disconnect()
+	s.client = client
+	s.cfg = cfg
+	return nil
+}
+
+// run sends c to the server and pretty prints the response
+func (s *session) run(c string) {
+	start := time.Now()
+	resp, err := s.exec(c + "\n")
+	elapsed := time.Since(start)
+
+	if err != nil {
+		s.printErr(err)
+	} else {
+		s.printResult(strings.TrimSpace(string(resp)))
+	}
+
+	if s.timing {
+		fmt.Fprintf(s.out, "Time: %.3f ms\n", float64(elapsed.Microseconds())/1000)
+	}
+}
+
+func (s *session) printResult(r string) {
+	if r == "OK" {
+		fmt.Fprintln(s.out, r)
+		return
+	}
+	if strings.Contains(r, "\n") {
+		for i, line := range strings.Split(r, "\n") {
+			fmt.Fprintf(s.out, "%d) %q\n", i+1, line)
+		}
+		return
+	}
+	fmt.Fprintf(s.out, "%q\n", r)
+}
+
+func (s *session) printErr(err error) {
+	fmt.Fprintf(s.out, "(error) %s\n", err)
+}
+
+// complete completes the first word of the line with keywords on tab
+func complete(keywords []string, line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' || strings.Contains(line[:pos], " ") {
+		return "", 0, false
+	}
+
+	prefix := line[:pos]
+	var matches []string
+	for _, kw := range keywords {
+		if strings.HasPrefix(strings.ToUpper(kw), strings.ToUpper(prefix)) {
+			matches = append(matches, kw)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	completed := commonPrefix(matches)
+	if len(matches) == 1 {
+		completed += " "
+	}
+	if len(completed) <= len(prefix) {
+		return "", 0, false
+	}
+
+	return completed + line[pos:], len(completed), true
+}
+
+func commonPrefix(words []string) string {
+	result := words[0]
+	for _, w := range words[1:] {
+		i := 0
+		for i < len(result) && i < len(w) && result[i] == w[i] {
+			i++
+		}
+		result = result[:i]
+	}
+	return result
+}
@@ -0,0 +1,262 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"custom-in-memory-db/cmd/client/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeExec records commands and answers them with replies by the first word
+type fakeExec struct {
+	sent    []string
+	replies map[string]string
+}
+
+func (f *fakeExec) exec(c string) ([]byte, error) {
+	f.sent = append(f.sent, c)
+	word, _, _ := strings.Cut(strings.TrimSpace(c), " ")
+	reply, ok := f.replies[word]
+	if !ok {
+		return nil, &shared.ServerError{Status: 400, Msg: "unknown command"}
+	}
+	return []byte(reply), nil
+}
+
+func newFakeSession(t *testing.T, proto string) (*session, *fakeExec, *bytes.Buffer) {
+	var out bytes.Buffer
+	f := &fakeExec{replies: map[string]string{"SET": "OK", "GET": "value", "DUMP": "a 1\nb 2", "ACL": "admin"}}
+	s, err := newSession(conf.Config{Proto: proto}, &out)
+	require.NoError(t, err)
+	s.exec = f.exec
+	return s, f, &out
+}
+
+func TestSession_HandleLine(t *testing.T) {
+	tests := []struct {
+		line string
+		sent []string
+		out  string
+	}{
+		{line: "", out: ""},
+		{line: "set k v", sent: []string{"SET k v\n"}, out: "OK\n"},
+		{line: "GET   k", sent: []string{"GET k\n"}, out: "\"value\"\n"},
+		{line: "DUMP", sent: []string{"DUMP\n"}, out: "1) \"a 1\"\n2) \"b 2\"\n"},
+		{line: "BOGUS", sent: []string{"BOGUS\n"}, out: "(error) unknown command\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			s, f, out := newFakeSession(t, "http")
+			assert.False(t, s.handleLine(test.line))
+			assert.Equal(t, test.sent, f.sent)
+			assert.Equal(t, test.out, out.String())
+		})
+	}
+}
+
+func TestSession_TcpOnly(t *testing.T) {
+	// ACL and SLOWLOG have no http routes
+	s, f, out := newFakeSession(t, "http")
+	s.handleLine("acl WHOAMI")
+	s.handleLine("SLOWLOG LEN")
+	s.handleLine("MULTI")
+	s.handleLine("ACL WHOAMI")
+	assert.Empty(t, f.sent)
+	assert.Empty(t, s.queue)
+	assert.Equal(t, "(error) ACL is not supported over http, use --proto tcp\n"+
+		"(error) SLOWLOG is not supported over http, use --proto tcp\nOK\n"+
+		"(error) ACL is not supported over http, use --proto tcp\n", out.String())
+	assert.NotContains(t, s.help, "ACL")
+	assert.NotContains(t, s.help, "SLOWLOG")
+	assert.Contains(t, s.help, "CONFIG GET")
+	assert.NotContains(t, s.keywords, "ACL")
+	assert.NotContains(t, s.keywords, "SLOWLOG")
+
+	s, f, out = newFakeSession(t, "tcp")
+	s.handleLine("ACL WHOAMI")
+	assert.Equal(t, []string{"ACL WHOAMI\n"}, f.sent)
+	assert.Equal(t, "\"admin\"\n", out.String())
+	assert.Equal(t, help, s.help)
+	assert.Equal(t, keywords, s.keywords)
+}
+
+func TestSession_Multi(t *testing.T) {
+	s, f, out := newFakeSession(t, "http")
+	for _, line := range []string{"EXEC", "DISCARD", "MULTI", "MULTI", "SET a 1", "get a"} {
+		s.handleLine(line)
+	}
+	assert.Empty(t, f.sent)
+	assert.Equal(t, []string{"SET a 1", "GET a"}, s.queue)
+	assert.Equal(t, "(error) EXEC without MULTI\n(error) DISCARD without MULTI\nOK\n"+
+		"(error) MULTI calls can not be nested\nQUEUED\nQUEUED\n", out.String())
+
+	// queued commands are sent one by one on EXEC
+	out.Reset()
+	s.handleLine("EXEC")
+	assert.Nil(t, s.queue)
+	assert.Equal(t, []string{"SET a 1\n", "GET a\n"}, f.sent)
+	assert.Equal(t, "1) OK\n2) \"value\"\n", out.String())
+
+	out.Reset()
+	s.handleLine("MULTI")
+	s.handleLine("SET b 2")
+	s.handleLine("DISCARD")
+	assert.Nil(t, s.queue)
+	assert.Len(t, f.sent, 2)
+	assert.Equal(t, "OK\nQUEUED\nOK\n", out.String())
+}
+
+func TestSession_HandleMeta(t *testing.T) {
+	s, f, out := newFakeSession(t, "http")
+	assert.True(t, s.handleLine(`\quit`))
+	assert.True(t, s.handleLine(`\q`))
+
+	assert.False(t, s.handleLine(`\help`))
+	assert.Equal(t, s.help, out.String())
+
+	out.Reset()
+	s.handleLine(`\timing`)
+	s.handleLine("SET a 1")
+	s.handleLine(`\timing`)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "Timing is on.", lines[0])
+	assert.Equal(t, "OK", lines[1])
+	assert.Regexp(t, `^Time: \d+\.\d{3} ms$`, lines[2])
+	assert.Equal(t, "Timing is off.", lines[3])
+
+	out.Reset()
+	s.handleLine(`\bogus`)
+	s.handleLine(`\connect`)
+	s.handleLine(`\connect localhost`)
+	assert.Equal(t, "(error) unknown command \"\\\\bogus\", try \\help\n"+
+		"(error) \\connect expects exactly 1 arg <host:port>\n"+
+		"(error) invalid address \"localhost\": address localhost: missing port in address\n", out.String())
+	assert.Len(t, f.sent, 1)
+}
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		line string
+		pos  int
+		key  rune
+		out  string
+		// outPos is the cursor position after completion
+		outPos int
+		ok     bool
+	}{
+		{line: "ge", pos: 2, key: '\t', out: "GET ", outPos: 4, ok: true},
+		{line: "SE", pos: 2, key: '\t', out: "SET ", outPos: 4, ok: true},
+		{line: "DI", pos: 2, key: '\t', out: "DISCARD ", outPos: 8, ok: true},
+		{line: `\t`, pos: 2, key: '\t', out: `\timing `, outPos: 8, ok: true},
+		// the rest of the line is kept after the cursor
+		{line: "ACk", pos: 2, key: '\t', out: "ACL k", outPos: 4, ok: true},
+		// ambiguous prefixes are not completed
+		{line: "S", pos: 1, key: '\t'},
+		{line: "D", pos: 1, key: '\t'},
+		{line: `\`, pos: 1, key: '\t'},
+		// only the first word is completed and only on tab
+		{line: "GET k", pos: 5, key: '\t'},
+		{line: "ge", pos: 2, key: 'x'},
+		{line: "zz", pos: 2, key: '\t'},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			out, pos, ok := complete(keywords, test.line, test.pos, test.key)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.out, out)
+			assert.Equal(t, test.outPos, pos)
+		})
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	assert.Equal(t, "SLOWLOG", commonPrefix([]string{"SLOWLOG"}))
+	assert.Equal(t, "S", commonPrefix([]string{"SET", "SLOWLOG"}))
+	assert.Equal(t, "", commonPrefix([]string{"GET", "SET"}))
+	assert.Equal(t, `\c`, commonPrefix([]string{`\connect`, `\c`}))
+}
+
+// fakeServer answers every tcp command with OK and closes each connection after limit commands.
+// Returns its address and the number of connections accepted so far
+func fakeServer(t *testing.T, limit int) (string, func() int) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	accepted := make(chan struct{}, 100)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for range limit {
+					if _, err := r.ReadString('\n'); err != nil {
+						return
+					}
+					_, _ = conn.Write([]byte("OK\nOK\n\n"))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), func() int { return len(accepted) }
+}
+
+func tcpConf(t *testing.T, addr string) conf.Config {
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return conf.Config{Server: host, Port: p, Proto: "tcp", Timeout: time.Second}
+}
+
+func TestSession_Tcp(t *testing.T) {
+	addr, accepted := fakeServer(t, 10)
+	s, err := newSession(tcpConf(t, addr), &bytes.Buffer{})
+	require.NoError(t, err)
+	defer s.disconnect()
+
+	// the connection is kept between commands
+	for range 3 {
+		resp, err := s.exec("SET a 1\n")
+		require.NoError(t, err)
+		assert.Equal(t, "OK", string(resp))
+	}
+	assert.Equal(t, 1, accepted())
+}
+
+func TestSession_Connect(t *testing.T) {
+	addr, accepted := fakeServer(t, 10)
+	s, err := newSession(tcpConf(t, "127.0.0.1:1"), &bytes.Buffer{})
+	require.NoError(t, err)
+	defer s.disconnect()
+
+	require.NoError(t, s.connect(addr))
+	assert.Equal(t, tcpConf(t, addr), s.cfg)
+	// the probe connection serves commands
+	_, err = s.exec("SET a 1\n")
+	require.NoError(t, err)
+	assert.Equal(t, 1, accepted())
+
+	// a failed probe keeps the session as it was
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := ln.Addr().String()
+	require.NoError(t, ln.Close())
+	assert.ErrorContains(t, s.connect(closed), "cannot connect to")
+	assert.Equal(t, tcpConf(t, addr), s.cfg)
+	_, err = s.exec("SET a 1\n")
+	require.NoError(t, err)
+	assert.Equal(t, 1, accepted())
+}
@@ -1,8 +1,11 @@
 package root
 
 import (
+	"custom-in-memory-db/cmd/client/cmd/bench"
+	"custom-in-memory-db/cmd/client/cmd/bulk"
 	"custom-in-memory-db/cmd/client/cmd/cmd"
 	"custom-in-memory-db/cmd/client/cmd/conf"
+	"custom-in-memory-db/cmd/client/cmd/repl"
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -12,12 +15,32 @@ import (
 func Execute(cfg *conf.Config) {
 	var rootCmd = &cobra.Command{
 		Use: "ramdb-cli [OPTIONS] [COMMANDS]",
+		// bare ramdb-cli starts an interactive session
+		RunE: repl.Run,
 	}
 
 	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("verbose"))
 	rootCmd.PersistentFlags().BoolVarP(&cfg.Verbose, "verbose", "v", false, "verbose output")
 
-	rootCmd.AddCommand(cmd.Init(cfg))
+	viper.BindPFlag("server", rootCmd.PersistentFlags().Lookup("server"))
+	rootCmd.PersistentFlags().StringVarP(&cfg.Server, "server", "s", "127.0.0.1", "database server name")
+
+	viper.BindPFlag("port", rootCmd.PersistentFlags().Lookup("port"))
+	rootCmd.PersistentFlags().IntVarP(&cfg.Port, "port", "p", 8080, "database port")
+
+	viper.BindPFlag("proto", rootCmd.PersistentFlags().Lookup("proto"))
+	rootCmd.PersistentFlags().StringVar(&cfg.Proto, "proto", "http", "database protocol, http or tcp")
+
+	viper.BindPFlag("tls", rootCmd.PersistentFlags().Lookup("tls"))
+	rootCmd.PersistentFlags().BoolVar(&cfg.Tls, "tls", false, "connect using TLS")
+	rootCmd.PersistentFlags().StringVar(&cfg.CaCert, "cacert", "", "PEM CA bundle to verify the server, system pool is used if omitted")
+	rootCmd.PersistentFlags().StringVar(&cfg.Cert, "cert", "", "PEM client certificate for mutual TLS")
+	rootCmd.PersistentFlags().StringVar(&cfg.Key, "key", "", "PEM private key of --cert")
+	rootCmd.PersistentFlags().StringVar(&cfg.User, "user", "", "user to authenticate as over tcp")
+	rootCmd.PersistentFlags().StringVar(&cfg.Password, "password", "", "password of --user, MEMDB_CLT_PASSWORD is used if omitted")
+	rootCmd.PersistentFlags().StringVar(&cfg.Token, "token", "", "bearer token for http, MEMDB_CLT_TOKEN is used if omitted")
+
+	rootCmd.AddCommand(cmd.Init(cfg), repl.Init(cfg), bulk.InitExport(cfg), bulk.InitImport(cfg), bench.Init(cfg))
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -0,0 +1,104 @@
+package shared
+
+import (
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Client sends commands using cfg.Proto and keeps the connection between them.
+// It is not safe for concurrent use
+type Client struct {
+	cfg  conf.Config
+	conn *Conn
+	http *http.Client
+}
+
+// NewClients returns n clients for concurrent workers.
+// Http clients share one transport keeping up to n idle connections to the server
+func NewClients(cfg conf.Config, n int) ([]*Client, error) {
+	var hc *http.Client
+	switch cfg.Proto {
+	case "http":
+		t, err := pooledTransport(cfg, n)
+		if err != nil {
+			return nil, err
+		}
+		hc = &http.Client{Timeout: cfg.Timeout, Transport: t}
+	case "tcp", "":
+	default:
+		return nil, fmt.Errorf("unknown protocol %q: %w", cfg.Proto, errors.ErrUnsupported)
+	}
+
+	clients := make([]*Client, n)
+	for i := range clients {
+		clients[i] = &Client{cfg: cfg, http: hc}
+	}
+	return clients, nil
+}
+
+// NewClient returns a client for a single worker
+func NewClient(cfg conf.Config) (*Client, error) {
+	clients, err := NewClients(cfg, 1)
+	if err != nil {
+		return nil, err
+	}
+	return clients[0], nil
+}
+
+// Connect dials the tcp server unless the connection is open. Http connections are opened by Exec
+func (c *Client) Connect() error {
+	if c.http != nil || c.conn != nil {
+		return nil
+	}
+	conn, err := Dial(c.cfg)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+// Exec sends c and returns the response. Error responses are returned as *ServerError
+func (c *Client) Exec(cmd string) ([]byte, error) {
+	if c.http != nil {
+		return execHttp(c.http, c.cfg, cmd)
+	}
+	return c.execTcp(cmd)
+}
+
+// execTcp sends cmd over the kept connection. The server drops idle connections, so cmd is sent once more
+// over a new connection if it has not reached the server. Failures after it was sent, e.g. a timeout, are returned
+// as they are: the server may have executed it
+func (c *Client) execTcp(cmd string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.Connect(); err != nil {
+			return nil, err
+		}
+
+		resp, err := c.conn.Exec(cmd)
+		var srvErr *ServerError
+		if err == nil || errors.As(err, &srvErr) {
+			return resp, err
+		}
+		_ = c.Close()
+		var sendErr *sendError
+		if attempt > 0 || !errors.As(err, &sendErr) {
+			return nil, err
+		}
+	}
+}
+
+// Close closes the tcp connection and idle http connections
+func (c *Client) Close() error {
+	if c.http != nil {
+		c.http.CloseIdleConnections()
+	}
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
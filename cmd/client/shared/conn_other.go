@@ -0,0 +1,10 @@
+//go:build !unix
+
+package shared
+
+import "net"
+
+// closedByPeer can not peek at sockets here, a closed connection fails on the read after the command is sent
+func closedByPeer(net.Conn) bool {
+	return false
+}
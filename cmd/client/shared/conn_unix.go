@@ -0,0 +1,30 @@
+//go:build unix
+
+package shared
+
+import (
+	"net"
+	"syscall"
+)
+
+// closedByPeer tells if the server has closed conn. It peeks at the socket without waiting,
+// data the server has sent is left for the next read
+func closedByPeer(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var closed bool
+	buf := make([]byte, 1)
+	err = rc.Read(func(fd uintptr) bool {
+		// go sockets are non-blocking, EAGAIN means the connection is open and idle
+		n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK)
+		closed = (n == 0 && err == nil) || (err != nil && err != syscall.EAGAIN && err != syscall.EWOULDBLOCK)
+		return true
+	})
+	return err == nil && closed
+}
@@ -0,0 +1,226 @@
+package shared
+
+import (
+	"bytes"
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const cmdPath = "/cmd"
+
+// readyPath is requested by PING
+const readyPath = "/readyz"
+
+// admin routes INFO, CONFIG and BACKUP are mapped onto
+const (
+	infoPath   = "/admin/info"
+	configPath = "/admin/config"
+	backupPath = "/admin/backup"
+)
+
+// payload mirrors Content schema from api/swagger.yaml
+type payload struct {
+	Key   string `json:"Key"`
+	Value string `json:"Value"`
+}
+
+// setting is the body of PUT /admin/config
+type setting struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// backupDir is the body of POST /admin/backup
+type backupDir struct {
+	Dir string `json:"dir"`
+}
+
+// errMsg mirrors Err schema from api/swagger.yaml
+type errMsg struct {
+	Error string `json:"error"`
+}
+
+// ExecHttp maps c onto the /cmd and /admin routes and returns the response in the same form tcp server does
+func ExecHttp(cfg conf.Config, c string) ([]byte, error) {
+	transport, err := httpTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return execHttp(&http.Client{Timeout: cfg.Timeout, Transport: transport}, cfg, c)
+}
+
+func execHttp(client *http.Client, cfg conf.Config, c string) ([]byte, error) {
+	fields := strings.Fields(c)
+	if len(fields) == 0 {
+		return nil, errors.New("empty command")
+	}
+
+	req, err := newHttpRequest(cfg, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+	logVerbose(fmt.Sprintf("DEBUG sending request: %s %s\n", req.Method, req.URL), cfg.Verbose)
+	logVerbose(fmt.Sprintf("DEBUG connection timeout: %s\n", cfg.Timeout), cfg.Verbose)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response failed: %w", err)
+	}
+	logVerbose(fmt.Sprintf("DEBUG response: %s %s\n", resp.Status, body), cfg.Verbose)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpError(resp.StatusCode, body)
+	}
+
+	switch fields[0] {
+	case "GET":
+		var p payload
+		if err = json.Unmarshal(body, &p); err != nil {
+			return nil, fmt.Errorf("cannot decode response %q: %w", body, err)
+		}
+		return []byte(p.Value), nil
+	case "PING":
+		return []byte("PONG"), nil
+	case "DUMP":
+		var list []payload
+		if err = json.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("cannot decode response %q: %w", body, err)
+		}
+		lines := make([]string, 0, len(list))
+		for _, p := range list {
+			lines = append(lines, strings.Join([]string{p.Key, p.Value}, " "))
+		}
+		return []byte(strings.Join(lines, "\n")), nil
+	case "INFO":
+		var sections map[string]map[string]string
+		if err = json.Unmarshal(body, &sections); err != nil {
+			return nil, fmt.Errorf("cannot decode response %q: %w", body, err)
+		}
+		lines := make([]string, 0)
+		for _, name := range slices.Sorted(maps.Keys(sections)) {
+			lines = append(lines, "# "+name)
+			lines = append(lines, fieldLines(sections[name])...)
+		}
+		return []byte(strings.Join(lines, "\n")), nil
+	case "CONFIG":
+		if fields[1] == "SET" {
+			return []byte("OK"), nil
+		}
+		var settings map[string]string
+		if err = json.Unmarshal(body, &settings); err != nil {
+			return nil, fmt.Errorf("cannot decode response %q: %w", body, err)
+		}
+		// the route returns every setting
+		if name := fields[2]; name != "*" {
+			value, ok := settings[strings.ToLower(name)]
+			if !ok {
+				return nil, &ServerError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("unknown setting %q", fields[2])}
+			}
+			settings = map[string]string{strings.ToLower(name): value}
+		}
+		return []byte(strings.Join(fieldLines(settings), "\n")), nil
+	case "BACKUP":
+		var manifest map[string]string
+		if err = json.Unmarshal(body, &manifest); err != nil {
+			return nil, fmt.Errorf("cannot decode response %q: %w", body, err)
+		}
+		return []byte(strings.Join(fieldLines(manifest), "\n")), nil
+	default:
+		return []byte("OK"), nil
+	}
+}
+
+// fieldLines converts {"key": "value"} to "key:value" lines sorted by key
+func fieldLines(fields map[string]string) []string {
+	lines := make([]string, 0, len(fields))
+	for _, k := range slices.Sorted(maps.Keys(fields)) {
+		lines = append(lines, k+":"+fields[k])
+	}
+	return lines
+}
+
+// newHttpRequest converts command fields to the request described in api/swagger.yaml
+func newHttpRequest(cfg conf.Config, fields []string) (*http.Request, error) {
+	scheme := "http"
+	if cfg.Tls {
+		scheme = "https"
+	}
+	base := url.URL{
+		Scheme: scheme,
+		Host:   strings.Join([]string{cfg.Server, strconv.Itoa(cfg.Port)}, ":"),
+		Path:   cmdPath,
+	}
+
+	switch {
+	case fields[0] == "GET" && len(fields) == 2:
+		return http.NewRequest(http.MethodGet, base.JoinPath(fields[1]).String(), nil)
+	case fields[0] == "PING" && len(fields) == 1:
+		base.Path = readyPath
+		return http.NewRequest(http.MethodGet, base.String(), nil)
+	case fields[0] == "DUMP" && len(fields) == 1:
+		return http.NewRequest(http.MethodGet, base.String(), nil)
+	case fields[0] == "DEL" && len(fields) == 2:
+		return http.NewRequest(http.MethodDelete, base.JoinPath(fields[1]).String(), nil)
+	case fields[0] == "SET" && len(fields) == 3:
+		return newJsonRequest(http.MethodPut, base.String(), payload{Key: fields[1], Value: fields[2]})
+	case fields[0] == "INFO" && len(fields) <= 2:
+		base.Path = infoPath
+		if len(fields) == 2 {
+			base.RawQuery = url.Values{"section": {fields[1]}}.Encode()
+		}
+		return http.NewRequest(http.MethodGet, base.String(), nil)
+	case fields[0] == "CONFIG" && len(fields) == 3 && fields[1] == "GET":
+		base.Path = configPath
+		return http.NewRequest(http.MethodGet, base.String(), nil)
+	case fields[0] == "CONFIG" && len(fields) == 4 && fields[1] == "SET":
+		base.Path = configPath
+		return newJsonRequest(http.MethodPut, base.String(), setting{Name: fields[2], Value: fields[3]})
+	case fields[0] == "BACKUP" && len(fields) == 2:
+		base.Path = backupPath
+		return newJsonRequest(http.MethodPost, base.String(), backupDir{Dir: fields[1]})
+	default:
+		return nil, &ServerError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("command %q is not supported over http", strings.Join(fields, " "))}
+	}
+}
+
+// newJsonRequest returns the request with body encoded to json
+func newJsonRequest(method, url string, body any) (*http.Request, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode request: %w", err)
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// httpError decodes Err schema from the body, falling back to the status text
+func httpError(status int, body []byte) *ServerError {
+	var e errMsg
+	if err := json.Unmarshal(body, &e); err == nil && e.Error != "" {
+		return &ServerError{Status: status, Msg: e.Error}
+	}
+	return &ServerError{Status: status, Msg: http.StatusText(status)}
+}
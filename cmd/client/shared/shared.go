@@ -4,38 +4,129 @@ import (
 	"custom-in-memory-db/cmd/client/cmd/conf"
 	"errors"
 	"fmt"
-	"net"
+	"net/http"
 	"os"
-	"strconv"
+	"slices"
 	"strings"
-	"time"
 )
 
-const errExit = 1
+const (
+	// errExit is returned when the server could not be reached or is shutting down
+	errExit = 1
+	// errExitRequest is returned when the server rejected the command
+	errExitRequest = 2
+	// errExitServer is returned when the server failed to execute the command
+	errExitServer = 3
+)
+
+// walWriteFailed is the message of wal.ErrWalWriteFailed
+const walWriteFailed = "wal write failed"
+
+// walClosed is the message of wal.ErrClosed
+const walClosed = "wal closed"
+
+// conflicts are messages of wal.ErrReadOnly and backup.ErrRunning
+var conflicts = []string{"wal is read only after recovery until WAL_RECOVER_UNTIL, restart without it to write",
+	"another backup is in progress"}
 
-func InvokeTcp(cfg conf.Config, c string) []byte {
-	addr := strings.Join([]string{cfg.Server, strconv.Itoa(cfg.Port)}, ":")
-	logVerbose(fmt.Sprintf("DEBUG connecting to: %s\n", addr), cfg.Verbose)
-	conn, err := net.Dial("tcp4", addr)
-	logError(fmt.Errorf("cannot dial tcp server: %w", err))
+// authErrors are messages of auth.ErrAuthRequired and auth.ErrAuthFailed
+var authErrors = []string{"authentication required", "authentication failed"}
 
-	err = conn.SetDeadline(time.Now().Add(cfg.Timeout))
-	logError(fmt.Errorf("cannot set deadline: %w", err))
-	logVerbose(fmt.Sprintf("DEBUG connection timeout: %s\n", cfg.Timeout), cfg.Verbose)
+// permissionDenied is the message of acl.ErrPermissionDenied
+const permissionDenied = "permission denied"
 
-	logVerbose(fmt.Sprintf("DEBUG sending command: %q\n", c), cfg.Verbose)
+// ServerError is returned when the server responded with an error
+type ServerError struct {
+	// Status is the http status of the response. Tcp errors are mapped to http statuses
+	Status int
+	Msg    string
+}
 
-	_, err = conn.Write([]byte(c))
-	logError(fmt.Errorf("cannot send command: %w", err))
-	logVerbose(fmt.Sprintf("DEBUG Done\n"), cfg.Verbose)
+func (e *ServerError) Error() string {
+	return e.Msg
+}
 
-	resp := make([]byte, 4096)
-	resp, err = waitForResponse(conn, resp)
-	logError(fmt.Errorf("read response failed: %w", err))
+// Invoke executes c and exits with the corresponding code if it failed
+func Invoke(cfg conf.Config, c string) []byte {
+	resp, err := Exec(cfg, c)
+	var srvErr *ServerError
+	if errors.As(err, &srvErr) {
+		fmt.Println(srvErr)
+		os.Exit(exitCode(srvErr))
+	}
+	logError(err)
 
 	return resp
 }
 
+// exitCode returns the exit code for the error response
+func exitCode(err *ServerError) int {
+	switch {
+	case err.Status == http.StatusServiceUnavailable:
+		return errExit
+	case err.Status >= 500:
+		return errExitServer
+	default:
+		return errExitRequest
+	}
+}
+
+// Exec sends c to the server using cfg.Proto and returns its response.
+// Error responses are returned as *ServerError
+func Exec(cfg conf.Config, c string) ([]byte, error) {
+	switch cfg.Proto {
+	case "http":
+		return ExecHttp(cfg, c)
+	case "tcp", "":
+		return ExecTcp(cfg, c)
+	default:
+		return nil, fmt.Errorf("unknown protocol %q: %w", cfg.Proto, errors.ErrUnsupported)
+	}
+}
+
+// ExecTcp sends c to the server over a new connection and returns its response.
+// Error responses are returned as *ServerError
+func ExecTcp(cfg conf.Config, c string) ([]byte, error) {
+	conn, err := Dial(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.Exec(c)
+}
+
+// Status lines every tcp response starts with. An error message follows statusErr on the same line
+const (
+	statusOk  = "OK"
+	statusErr = "ERR"
+)
+
+// tcpError maps the message of tcp error status to the same statuses http endpoint uses.
+// Known messages may come after the context of the failed command, e.g. "error deleting value: wal closed"
+func tcpError(msg string) *ServerError {
+	msg = strings.TrimSpace(msg)
+	is := func(known string) bool {
+		return msg == known || strings.HasSuffix(msg, ": "+known)
+	}
+	if is(walWriteFailed) {
+		return &ServerError{Status: 500, Msg: msg}
+	}
+	if is(walClosed) {
+		return &ServerError{Status: 503, Msg: msg}
+	}
+	if slices.ContainsFunc(conflicts, is) {
+		return &ServerError{Status: 409, Msg: msg}
+	}
+	if slices.Contains(authErrors, msg) {
+		return &ServerError{Status: 401, Msg: msg}
+	}
+	if msg == permissionDenied {
+		return &ServerError{Status: 403, Msg: msg}
+	}
+	return &ServerError{Status: 400, Msg: msg}
+}
+
 func logVerbose(s string, verbose bool) {
 	if verbose {
 		fmt.Print(s)
@@ -48,21 +139,3 @@ func logError(err error) {
 		os.Exit(errExit)
 	}
 }
-
-func waitForResponse(conn net.Conn, s []byte) ([]byte, error) {
-	for {
-		n, err := conn.Read(s)
-		if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
-			return nil, errors.New("timeout waiting for response")
-
-		}
-		if err != nil {
-			return nil, fmt.Errorf("unexpected error: %w", err)
-		}
-		if n > 0 {
-			break
-		}
-	}
-
-	return s, nil
-}
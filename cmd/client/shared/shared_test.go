@@ -0,0 +1,325 @@
+package shared
+
+import (
+	"bufio"
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"custom-in-memory-db/internal/server/backup"
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTcpError(t *testing.T) {
+	tests := []struct {
+		status int
+		msg    string
+	}{
+		{status: 500, msg: "wal write failed"},
+		{status: 401, msg: "authentication required"},
+		{status: 401, msg: "authentication failed"},
+		{status: 403, msg: "permission denied"},
+		{status: 400, msg: "key k not found"},
+		{status: 503, msg: wal.ErrClosed.Error()},
+		{status: 503, msg: "error deleting value: " + wal.ErrClosed.Error()},
+		{status: 409, msg: wal.ErrReadOnly.Error()},
+		{status: 409, msg: backup.ErrRunning.Error()},
+		{status: 500, msg: "error deleting value: " + wal.ErrWalWriteFailed.Error()},
+		{status: 400, msg: "unknown wal closed"},
+	}
+	for _, test := range tests {
+		t.Run(test.msg, func(t *testing.T) {
+			assert.Equal(t, &ServerError{Status: test.status, Msg: test.msg}, tcpError(test.msg+"\n"))
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		status int
+		code   int
+	}{
+		{status: 400, code: errExitRequest},
+		{status: 401, code: errExitRequest},
+		{status: 409, code: errExitRequest},
+		{status: 500, code: errExitServer},
+		{status: 503, code: errExit},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.code, exitCode(&ServerError{Status: test.status}), test.status)
+	}
+}
+
+// pipe returns Conn connected to a fake server which answers each command line with replies in turn
+func pipe(t *testing.T, replies ...string) *Conn {
+	client, server := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		for _, reply := range replies {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			if _, err := io.WriteString(server, reply); err != nil {
+				return
+			}
+		}
+	}()
+	return &Conn{cfg: conf.Config{Timeout: time.Second}, conn: client, r: bufio.NewReader(client)}
+}
+
+func TestConn_Exec(t *testing.T) {
+	c := pipe(t, "OK\nOK\n\n", "OK\na 1\nb 2\n\n", "OK\nERR 1\nb 2\n\n", "ERR key k not found\n\n", "OK\n\n",
+		"ERROR\n\n", "\n")
+
+	resp, err := c.Exec("SET a 1\n")
+	require.NoError(t, err)
+	assert.Equal(t, "OK", string(resp))
+
+	// lines of a response are read up to the empty one
+	resp, err = c.Exec("DUMP\n")
+	require.NoError(t, err)
+	assert.Equal(t, "a 1\nb 2", string(resp))
+
+	// the result is never taken for the status, even when a key is named ERR
+	resp, err = c.Exec("DUMP\n")
+	require.NoError(t, err)
+	assert.Equal(t, "ERR 1\nb 2", string(resp))
+
+	_, err = c.Exec("GET k\n")
+	assert.Equal(t, &ServerError{Status: 400, Msg: "key k not found"}, err)
+
+	// the connection is usable after an error
+	resp, err = c.Exec("DEL a\n")
+	require.NoError(t, err)
+	assert.Empty(t, resp)
+
+	_, err = c.Exec("GET a\n")
+	assert.EqualError(t, err, `read response failed: unexpected status "ERROR"`)
+	_, err = c.Exec("GET a\n")
+	assert.EqualError(t, err, "read response failed: response without status")
+}
+
+func TestNewHttpRequest(t *testing.T) {
+	cfg := conf.Config{Server: "127.0.0.1", Port: 8080}
+	tests := []struct {
+		cmd    []string
+		method string
+		url    string
+		body   string
+	}{
+		{cmd: []string{"GET", "k"}, method: http.MethodGet, url: "http://127.0.0.1:8080/cmd/k"},
+		{cmd: []string{"DEL", "k"}, method: http.MethodDelete, url: "http://127.0.0.1:8080/cmd/k"},
+		{cmd: []string{"DUMP"}, method: http.MethodGet, url: "http://127.0.0.1:8080/cmd"},
+		{cmd: []string{"PING"}, method: http.MethodGet, url: "http://127.0.0.1:8080/readyz"},
+		{cmd: []string{"SET", "k", "v"}, method: http.MethodPut, url: "http://127.0.0.1:8080/cmd", body: `{"Key":"k","Value":"v"}`},
+		{cmd: []string{"INFO"}, method: http.MethodGet, url: "http://127.0.0.1:8080/admin/info"},
+		{cmd: []string{"INFO", "wal"}, method: http.MethodGet, url: "http://127.0.0.1:8080/admin/info?section=wal"},
+		{cmd: []string{"CONFIG", "GET", "log_level"}, method: http.MethodGet, url: "http://127.0.0.1:8080/admin/config"},
+		{cmd: []string{"CONFIG", "SET", "log_level", "debug"}, method: http.MethodPut, url: "http://127.0.0.1:8080/admin/config",
+			body: `{"name":"log_level","value":"debug"}`},
+		{cmd: []string{"BACKUP", "/var/backups/ramdb"}, method: http.MethodPost, url: "http://127.0.0.1:8080/admin/backup",
+			body: `{"dir":"/var/backups/ramdb"}`},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.cmd, " "), func(t *testing.T) {
+			req, err := newHttpRequest(cfg, test.cmd)
+			require.NoError(t, err)
+			assert.Equal(t, test.method, req.Method)
+			assert.Equal(t, test.url, req.URL.String())
+			if test.body == "" {
+				assert.Nil(t, req.Body)
+				return
+			}
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			assert.JSONEq(t, test.body, string(body))
+			assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		})
+	}
+
+	cfg.Tls = true
+	req, err := newHttpRequest(cfg, []string{"GET", "k"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://127.0.0.1:8080/cmd/k", req.URL.String())
+
+	for _, cmd := range [][]string{{"GET"}, {"SET", "k"}, {"DEL", "a", "b"}, {"INFO", "wal", "keyspace"},
+		{"CONFIG", "GET"}, {"CONFIG", "RESET", "x"}, {"BACKUP"}, {"ACL", "WHOAMI"}, {"SLOWLOG", "LEN"}} {
+		_, err = newHttpRequest(cfg, cmd)
+		var srvErr *ServerError
+		require.ErrorAs(t, err, &srvErr)
+		assert.Equal(t, http.StatusBadRequest, srvErr.Status)
+	}
+}
+
+func TestExecHttp_Admin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/admin/info":
+			_, _ = io.WriteString(w, `{"wal":{"lsn":"7","codec":"none"},"keyspace":{"keys":"2"}}`)
+		case "/admin/config":
+			if r.Method == http.MethodPut {
+				return
+			}
+			_, _ = io.WriteString(w, `{"log_level":"info","net_max_conn":"100"}`)
+		case "/admin/backup":
+			_, _ = io.WriteString(w, `{"dir":"/tmp/b","lsn":"7"}`)
+		}
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+	cfg := conf.Config{Server: u.Hostname(), Port: port, Timeout: time.Second}
+
+	// responses are in the form tcp server returns, sorted by name
+	tests := []struct {
+		cmd  string
+		resp string
+	}{
+		{cmd: "INFO", resp: "# keyspace\nkeys:2\n# wal\ncodec:none\nlsn:7"},
+		{cmd: "CONFIG GET *", resp: "log_level:info\nnet_max_conn:100"},
+		{cmd: "CONFIG GET LOG_LEVEL", resp: "log_level:info"},
+		{cmd: "CONFIG SET log_level debug", resp: "OK"},
+		{cmd: "BACKUP /tmp/b", resp: "dir:/tmp/b\nlsn:7"},
+	}
+	for _, test := range tests {
+		t.Run(test.cmd, func(t *testing.T) {
+			resp, err := ExecHttp(cfg, test.cmd+"\n")
+			require.NoError(t, err)
+			assert.Equal(t, test.resp, string(resp))
+		})
+	}
+
+	_, err = ExecHttp(cfg, "CONFIG GET bogus\n")
+	assert.Equal(t, &ServerError{Status: http.StatusBadRequest, Msg: `unknown setting "bogus"`}, err)
+}
+
+func TestHttpError(t *testing.T) {
+	assert.Equal(t, &ServerError{Status: 403, Msg: "permission denied"},
+		httpError(http.StatusForbidden, []byte(`{"error":"permission denied"}`)))
+	// bodies without Err schema fall back to the status text
+	assert.Equal(t, &ServerError{Status: 502, Msg: "Bad Gateway"}, httpError(http.StatusBadGateway, []byte("<html>")))
+	assert.Equal(t, &ServerError{Status: 500, Msg: "Internal Server Error"}, httpError(http.StatusInternalServerError, []byte(`{}`)))
+}
+
+func TestNewClients(t *testing.T) {
+	clients, err := NewClients(conf.Config{Proto: "http", Timeout: time.Second}, 16)
+	require.NoError(t, err)
+	require.Len(t, clients, 16)
+	// http clients share the transport which keeps a connection per client
+	for _, c := range clients {
+		assert.Same(t, clients[0].http, c.http)
+	}
+	assert.Equal(t, 16, clients[0].http.Transport.(*http.Transport).MaxIdleConnsPerHost)
+
+	clients, err = NewClients(conf.Config{Proto: "tcp"}, 2)
+	require.NoError(t, err)
+	assert.Nil(t, clients[0].http)
+
+	_, err = NewClients(conf.Config{Proto: "udp"}, 2)
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func TestClient_Exec(t *testing.T) {
+	c := &Client{cfg: conf.Config{Timeout: time.Second}, conn: pipe(t, "OK\nOK\n\n")}
+	resp, err := c.Exec("SET a 1\n")
+	require.NoError(t, err)
+	assert.Equal(t, "OK", string(resp))
+
+	// the pipe is closed by the fake server, the client dials the unreachable server once and gives up
+	c.cfg.Server, c.cfg.Port = "127.0.0.1", 1
+	_, err = c.Exec("SET a 1\n")
+	assert.ErrorContains(t, err, "cannot dial tcp server")
+	assert.Nil(t, c.conn)
+}
+
+// tcpServer serves each connection with serve and returns the config to reach it
+// and the commands received by all connections
+func tcpServer(t *testing.T, serve func(conn net.Conn, r *bufio.Reader, received chan<- string)) (conf.Config, chan string) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	received := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn, bufio.NewReader(conn), received)
+			}()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return conf.Config{Server: "127.0.0.1", Port: addr.Port, Proto: "tcp", Timeout: 200 * time.Millisecond}, received
+}
+
+func TestClient_Exec_Redial(t *testing.T) {
+	// the server answers one command and drops the connection as if it was idle
+	cfg, received := tcpServer(t, func(conn net.Conn, r *bufio.Reader, received chan<- string) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		received <- line
+		_, _ = io.WriteString(conn, "OK\nOK\n\n")
+	})
+	c, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer c.Close()
+
+	for i := range 3 {
+		resp, err := c.Exec(fmt.Sprintf("SET a %d\n", i))
+		require.NoError(t, err)
+		assert.Equal(t, "OK", string(resp))
+		// the server closes the connection meanwhile
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(received)
+	var cmds []string
+	for line := range received {
+		cmds = append(cmds, line)
+	}
+	// each command is sent once over a new connection
+	assert.Equal(t, []string{"SET a 0\n", "SET a 1\n", "SET a 2\n"}, cmds)
+}
+
+func TestClient_Exec_Timeout(t *testing.T) {
+	// the server never answers, the command may be executed anyway
+	cfg, received := tcpServer(t, func(conn net.Conn, r *bufio.Reader, received chan<- string) {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			received <- line
+		}
+	})
+	c, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Exec("SET a 1\n")
+	assert.ErrorContains(t, err, "timeout waiting for response")
+	var sendErr *sendError
+	assert.False(t, errors.As(err, &sendErr))
+	assert.Nil(t, c.conn)
+	time.Sleep(50 * time.Millisecond)
+	// the command is not sent again after it reached the server
+	assert.Len(t, received, 1)
+}
@@ -0,0 +1,142 @@
+package shared
+
+import (
+	"bufio"
+	"crypto/tls"
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errConnClosed is returned when the server has closed the connection before the command was sent
+var errConnClosed = errors.New("connection closed by server")
+
+// sendError is returned when the command has not reached the server, so it is safe to send it again
+type sendError struct {
+	err error
+}
+
+func (e *sendError) Error() string {
+	return "cannot send command: " + e.err.Error()
+}
+
+func (e *sendError) Unwrap() error {
+	return e.err
+}
+
+// Conn is a persistent connection to the tcp server.
+// It is not safe for concurrent use
+type Conn struct {
+	cfg  conf.Config
+	conn net.Conn
+	// raw is the tcp connection under tls
+	raw net.Conn
+	r   *bufio.Reader
+}
+
+// Dial connects to the tcp server and authenticates if cfg.User is set
+func Dial(cfg conf.Config) (*Conn, error) {
+	addr := strings.Join([]string{cfg.Server, strconv.Itoa(cfg.Port)}, ":")
+	logVerbose(fmt.Sprintf("DEBUG connecting to: %s\n", addr), cfg.Verbose)
+	tlsConf, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn, raw net.Conn
+	if tlsConf != nil {
+		var tc *tls.Conn
+		if tc, err = tls.DialWithDialer(&net.Dialer{Timeout: cfg.Timeout}, "tcp4", addr, tlsConf); err == nil {
+			conn, raw = tc, tc.NetConn()
+		}
+	} else {
+		conn, err = net.DialTimeout("tcp4", addr, cfg.Timeout)
+		raw = conn
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot dial tcp server: %w", err)
+	}
+	logVerbose(fmt.Sprintf("DEBUG connection timeout: %s\n", cfg.Timeout), cfg.Verbose)
+
+	c := Conn{cfg: cfg, conn: conn, raw: raw, r: bufio.NewReader(conn)}
+	if cfg.User != "" {
+		logVerbose(fmt.Sprintf("DEBUG authenticating as: %s\n", cfg.User), cfg.Verbose)
+		if _, err = c.exec(strings.Join([]string{"AUTH", cfg.User, cfg.Password, "\n"}, " ")); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return &c, nil
+}
+
+// Exec sends c and returns the response. Error responses are returned as *ServerError,
+// failures before the command is sent as *sendError
+func (c *Conn) Exec(cmd string) ([]byte, error) {
+	logVerbose(fmt.Sprintf("DEBUG sending command: %q\n", cmd), c.cfg.Verbose)
+	return c.exec(cmd)
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) exec(cmd string) ([]byte, error) {
+	err := c.conn.SetDeadline(time.Now().Add(c.cfg.Timeout))
+	if err != nil {
+		return nil, fmt.Errorf("cannot set deadline: %w", err)
+	}
+
+	// the server drops idle connections, the command would be written to a closed one
+	if c.raw != nil && closedByPeer(c.raw) {
+		return nil, &sendError{err: errConnClosed}
+	}
+	_, err = c.conn.Write([]byte(cmd))
+	if err != nil {
+		return nil, &sendError{err: err}
+	}
+	logVerbose("DEBUG Done\n", c.cfg.Verbose)
+
+	status, resp, err := c.readResponse()
+	if err != nil {
+		return nil, fmt.Errorf("read response failed: %w", err)
+	}
+	if msg, ok := strings.CutPrefix(status, statusErr+" "); ok {
+		return nil, tcpError(msg)
+	}
+	if status != statusOk {
+		return nil, fmt.Errorf("read response failed: unexpected status %q", status)
+	}
+
+	return resp, nil
+}
+
+// readResponse reads the status line and the result lines until the empty one, which terminates every response.
+// Result lines are returned joined by '\n' without the trailing one
+func (c *Conn) readResponse() (string, []byte, error) {
+	var lines []string
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+			return "", nil, errors.New("timeout waiting for response")
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("unexpected error: %w", err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "", nil, errors.New("response without status")
+	}
+	return lines[0], []byte(strings.Join(lines[1:], "\n")), nil
+}
@@ -0,0 +1,78 @@
+package shared
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"custom-in-memory-db/cmd/client/cmd/conf"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// transports are reused between http requests with the same TLS settings
+var transports sync.Map
+
+// tlsConfig returns nil if TLS is disabled
+func tlsConfig(cfg conf.Config) (*tls.Config, error) {
+	if !cfg.Tls {
+		return nil, nil
+	}
+
+	tlsConf := tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CaCert != "" {
+		pem, err := os.ReadFile(cfg.CaCert)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA: %w", err)
+		}
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("cannot read CA: %w", errors.New("no PEM certificates found"))
+		}
+	}
+	if cfg.Cert != "" || cfg.Key != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	return &tlsConf, nil
+}
+
+// httpTransport returns http.DefaultTransport if TLS is disabled
+func httpTransport(cfg conf.Config) (http.RoundTripper, error) {
+	if !cfg.Tls {
+		return http.DefaultTransport, nil
+	}
+
+	key := [3]string{cfg.CaCert, cfg.Cert, cfg.Key}
+	if t, ok := transports.Load(key); ok {
+		return t.(http.RoundTripper), nil
+	}
+
+	tlsConf, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConf
+	actual, _ := transports.LoadOrStore(key, t)
+
+	return actual.(http.RoundTripper), nil
+}
+
+// pooledTransport returns a new transport keeping up to idle connections to the server open
+func pooledTransport(cfg conf.Config, idle int) (*http.Transport, error) {
+	tlsConf, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConf
+	t.MaxIdleConns = max(t.MaxIdleConns, idle)
+	t.MaxIdleConnsPerHost = idle
+	return t, nil
+}
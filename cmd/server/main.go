@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/health"
 	myinit "custom-in-memory-db/internal/server/init"
 	"errors"
+	"github.com/spf13/pflag"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,21 +16,66 @@ const errExit = 1
 
 func main() {
 	// Init config
-	conf, err := cmd.New()
+	conf, opts, err := cmd.New(os.Args[1:])
+	if errors.Is(err, pflag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		lg := myinit.Logger(conf)
 		lg.Error("config init error", "error", errors.Unwrap(err).Error())
 		os.Exit(errExit)
 	}
 
+	if opts.PrintConfig {
+		if err = conf.Print(os.Stdout); err != nil {
+			os.Exit(errExit)
+		}
+		return
+	}
+
 	lg := myinit.Logger(conf)
 	lg.Info("config init success")
 
-	db := myinit.Database(conf, lg)
-	defer db.Close()
+	if opts.RestoreFrom != "" {
+		if !myinit.Restore(conf, opts.RestoreFrom, lg) {
+			os.Exit(errExit)
+		}
+		return
+	}
+
+	// admin server goes first, so that probes respond while wal is being replayed
+	// deferred first, so that spans of the shutdown are flushed after db is closed
+	defer myinit.Tracing(conf, lg)()
+
+	reg := myinit.Registry(conf)
+	sl := myinit.Slowlog(conf, reg)
+	a := myinit.Auth(conf, lg)
+	l := myinit.Acl(conf, lg)
+	if adm := myinit.Admin(conf, reg, sl, a, l, lg); adm != nil {
+		defer adm.Close()
+		go adm.Listen()
+	}
+
+	certs := myinit.Certs(conf, lg)
+	db := myinit.Database(conf, certs, reg, sl, a, l, lg)
 	go db.ListenClient()
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range quit {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		myinit.Reload(os.Args[1:], reg, certs, lg)
+	}
+	health.SetShuttingDown()
 	lg.Info("Shutdown Server ...")
+
+	// requests in flight are answered and their wal batch is written before segments are closed
+	ctx, cancel := context.WithTimeout(context.Background(), conf.Network.ShutdownTimeout)
+	defer cancel()
+	if err = db.Shutdown(ctx); err != nil {
+		lg.Error("shutdown failed", "error", err.Error())
+		return
+	}
+	lg.Info("shutdown done")
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/db/seg"
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// runServer makes the test binary run main instead of tests
+const runServer = "RAMDB_TEST_RUN_SERVER"
+
+var nilLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runServer) == "1" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// freePort returns a port nobody listens on
+func freePort(t *testing.T) string {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+// set sends SET commands with keys unique to the client until the connection fails
+// and calls ack for each key the server answered OK to
+func set(addr string, client int, ack func(key string)) {
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("c%d_%d", client, i)
+		if _, err = fmt.Fprintf(conn, "SET %s %s\n", key, key); err != nil {
+			return
+		}
+		resp, err := reply(r)
+		if err != nil {
+			return
+		}
+		if resp == "OK\nOK\n" {
+			ack(key)
+		}
+	}
+}
+
+// command sends a single command and returns its reply starting with the status line
+func command(addr, line string) (string, error) {
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err = fmt.Fprintf(conn, "%s\n", line); err != nil {
+		return "", err
+	}
+	return reply(bufio.NewReader(conn))
+}
+
+// reply reads a response without the empty line ending it
+func reply(r *bufio.Reader) (string, error) {
+	var resp strings.Builder
+	for {
+		s, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if s == "\n" {
+			return resp.String(), nil
+		}
+		resp.WriteString(s)
+	}
+}
+
+// startServer runs the server with wal in dir on port until the test ends.
+// The returned channel gets the result of the server process once it exits
+func startServer(t *testing.T, dir, port string) (*exec.Cmd, <-chan error) {
+	srv := exec.Command(os.Args[0])
+	// panics of the server show up in the test output
+	srv.Stderr = os.Stderr
+	srv.Env = append(os.Environ(), runServer+"=1",
+		"RAMDB_NET_PROTO=tcp",
+		"RAMDB_NET_ADDRESS=127.0.0.1",
+		"RAMDB_NET_PORT="+port,
+		"RAMDB_NET_MAX_CONN=0",
+		"RAMDB_NET_SHUTDOWN_TIMEOUT=5s",
+		"RAMDB_WAL_SEG_PATH="+dir,
+		// rotation is out of the scope
+		"RAMDB_WAL_SEG_SIZE=1024",
+		"RAMDB_WAL_BATCH_MAX=4",
+		"RAMDB_WAL_BATCH_TIMEOUT=20ms",
+		"RAMDB_LOG_LEVEL=error",
+	)
+	require.NoError(t, srv.Start())
+	exited := make(chan error, 1)
+	go func() { exited <- srv.Wait() }()
+	t.Cleanup(func() { _ = srv.Process.Kill() })
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp4", "127.0.0.1:"+port)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	return srv, exited
+}
+
+// recovered returns the state wal in dir recovers to up to the target
+func recovered(t *testing.T, dir string, target wal.Target) map[string]string {
+	sg, err := seg.New(cmd.Config{Wal: cmd.Wal{SegPath: dir, SegSize: 1024 * cmd.KB}})
+	require.NoError(t, err)
+	defer sg.Close()
+	state := make(map[string]string)
+	_, err = wal.RecoverUntil(sg, nil, target, wal.RecoveryTolerant, func(k, v string) error {
+		state[k] = v
+		return nil
+	}, func(k string) error {
+		delete(state, k)
+		return nil
+	}, nilLogger)
+	require.NoError(t, err)
+	return state
+}
+
+func TestShutdown_NoAcknowledgedWriteLost(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts the server")
+	}
+	const clients = 8
+	dir := t.TempDir()
+	port := freePort(t)
+	addr := "127.0.0.1:" + port
+	srv, exited := startServer(t, dir, port)
+
+	var mtx sync.Mutex
+	var acked []string
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			set(addr, i, func(key string) {
+				mtx.Lock()
+				acked = append(acked, key)
+				mtx.Unlock()
+			})
+		}()
+	}
+
+	// some commands are waiting for their batch when the signal arrives
+	time.Sleep(300 * time.Millisecond)
+	require.NoError(t, srv.Process.Signal(syscall.SIGTERM))
+	select {
+	case err := <-exited:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not exit")
+	}
+	wg.Wait()
+	require.NotEmpty(t, acked)
+
+	state := recovered(t, dir, wal.Target{})
+	for _, key := range acked {
+		assert.Equal(t, key, state[key], "acknowledged SET %s lost", key)
+	}
+}
+
+func TestBackup_ConcurrentWrites(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts the server")
+	}
+	const clients = 8
+	dir := t.TempDir()
+	port := freePort(t)
+	addr := "127.0.0.1:" + port
+	srv, exited := startServer(t, dir, port)
+
+	var mtx sync.Mutex
+	var acked []string
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			set(addr, i, func(key string) {
+				mtx.Lock()
+				acked = append(acked, key)
+				mtx.Unlock()
+			})
+		}()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	mtx.Lock()
+	// writes acknowledged by now are in the snapshot, those in flight may or may not be
+	before := len(acked)
+	mtx.Unlock()
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	resp, err := command(addr, "BACKUP "+backupDir)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(resp, "OK\n"), resp)
+	require.Contains(t, resp, "dir:"+backupDir+"\n")
+	time.Sleep(200 * time.Millisecond)
+
+	require.NoError(t, srv.Process.Signal(syscall.SIGTERM))
+	select {
+	case err := <-exited:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not exit")
+	}
+	wg.Wait()
+
+	restoreDir := t.TempDir()
+	restore := exec.Command(os.Args[0], "restore", "--from", backupDir)
+	restore.Stderr = os.Stderr
+	restore.Env = append(os.Environ(), runServer+"=1", "RAMDB_WAL_SEG_PATH="+restoreDir, "RAMDB_LOG_LEVEL=error")
+	require.NoError(t, restore.Run())
+
+	fields := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(resp), "\n") {
+		k, v, _ := strings.Cut(line, ":")
+		fields[k] = v
+	}
+	lsn, err := strconv.ParseUint(fields["lsn"], 10, 64)
+	require.NoError(t, err)
+	restored := recovered(t, restoreDir, wal.Target{})
+	// the backup is the state of the server as of its LSN, while writes went on after it
+	assert.Equal(t, recovered(t, dir, wal.Target{LSN: lsn}), restored)
+	assert.Less(t, len(restored), len(recovered(t, dir, wal.Target{})))
+	require.NotZero(t, before)
+	for _, key := range acked[:before] {
+		assert.Equal(t, key, restored[key], "SET %s acknowledged before BACKUP is missing", key)
+	}
+}
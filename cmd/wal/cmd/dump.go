@@ -0,0 +1,99 @@
+package root
+
+import (
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+func initDump() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "dump",
+		Short: "Prints wal records",
+		Long: "Prints wal records one per line as \"<segment> <offset> <lsn> <time> <command>\", corrupt records as\n" +
+			"\"<segment> <offset> corrupt: <reason>\". LSN 0 and time \"-\" mark records written before they were introduced",
+		Args:          cobra.NoArgs,
+		RunE:          runDump,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	cmd.Flags().String("segment", "", "segment to dump, all of them if omitted")
+	cmd.Flags().Bool("json", false, "print records as json objects, one per line")
+
+	return cmd
+}
+
+// dumpRecord is the json form of a record
+type dumpRecord struct {
+	Segment string   `json:"segment"`
+	Offset  int64    `json:"offset"`
+	LSN     uint64   `json:"lsn,omitempty"`
+	Time    string   `json:"time,omitempty"`
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func runDump(cmd *cobra.Command, _ []string) error {
+	segment, _ := cmd.Flags().GetString("segment")
+	asJson, _ := cmd.Flags().GetBool("json")
+	files, err := segments(cmd)
+	if err != nil {
+		return err
+	}
+	keyring, err := keys(cmd)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	enc := json.NewEncoder(out)
+	found := false
+	for _, file := range files {
+		name := segmentName(file)
+		if segment != "" && segment != name {
+			continue
+		}
+		found = true
+		err = scan(file, keyring, func(rec wal.Record, offset int64, err error) error {
+			var corrupt *wal.CorruptError
+			errors.As(err, &corrupt)
+			if asJson {
+				dr := dumpRecord{Segment: name, Offset: offset, LSN: rec.LSN, Command: rec.Command, Args: rec.Args}
+				if !rec.Time.IsZero() {
+					dr.Time = formatTime(rec.Time)
+				}
+				if corrupt != nil {
+					dr.Error = corrupt.Err.Error()
+				}
+				return enc.Encode(dr)
+			}
+			if corrupt != nil {
+				_, err = fmt.Fprintf(out, "%s %d corrupt: %s\n", name, offset, corrupt.Err)
+				return err
+			}
+			ts := "-"
+			if !rec.Time.IsZero() {
+				ts = formatTime(rec.Time)
+			}
+			_, err = fmt.Fprintf(out, "%s %d %d %s %s\n", name, offset, rec.LSN, ts, rec)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if segment != "" && !found {
+		return fmt.Errorf("segment %q not found", segment)
+	}
+	return nil
+}
+
+// formatTime prints t the way WAL_RECOVER_UNTIL and replay --until accept it
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,126 @@
+package root
+
+import (
+	"custom-in-memory-db/cmd/client/cmd/bulk"
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"maps"
+	"os"
+	"slices"
+)
+
+func initReplay() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "replay --out <file>",
+		Short: "Replays wal into an export file",
+		Long: "Replays wal the way the server recovers it, starting with the snapshot if there is one,\n" +
+			"and writes the resulting keys with values to a new file,\n" +
+			"which can be loaded with ramdb-cli import. Corrupt records are skipped and counted.\n" +
+			"--until materializes the state at RFC3339 time or LSN, e.g. --until 2024-05-01T10:00:00Z",
+		Args:          cobra.NoArgs,
+		RunE:          runReplay,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	cmd.Flags().String("out", "", "file to create, must not exist")
+	cmd.Flags().String("format", "", "output format, one of json|csv|ndjson. Guessed by file extension if omitted")
+	cmd.Flags().String("until", "", "RFC3339 time or LSN of the last record to replay, the whole wal if omitted")
+	_ = cmd.MarkFlagRequired("out")
+
+	return cmd
+}
+
+// errStop ends scan once the target is reached
+var errStop = errors.New("stop")
+
+func runReplay(cmd *cobra.Command, _ []string) error {
+	out, _ := cmd.Flags().GetString("out")
+	format, _ := cmd.Flags().GetString("format")
+	until, _ := cmd.Flags().GetString("until")
+	target, err := wal.ParseTarget(until)
+	if err != nil {
+		return fmt.Errorf("--until invalid: %w", err)
+	}
+	files, err := segments(cmd)
+	if err != nil {
+		return err
+	}
+	keyring, err := keys(cmd)
+	if err != nil {
+		return err
+	}
+
+	state := make(map[string]string)
+	h, ok, err := snapshot(cmd, func(k, v string) error {
+		state[k] = v
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if ok && target.Precedes(h) {
+		return fmt.Errorf("--until %s precedes the snapshot at lsn %d", until, h.LSN)
+	}
+	// records before from are in the snapshot
+	var from uint64
+	if ok {
+		from = h.LSN + 1
+	}
+	var records, corrupt int
+	stopped := false
+	for _, file := range files {
+		if stopped {
+			break
+		}
+		err = scan(file, keyring, func(rec wal.Record, _ int64, err error) error {
+			if err != nil {
+				corrupt++
+				return nil
+			}
+			if rec.LSN < from {
+				return nil
+			}
+			if target.Before(rec) {
+				stopped = true
+				return errStop
+			}
+			records++
+			if rec.Command == "SET" {
+				state[rec.Args[0]] = rec.Args[1]
+				return nil
+			}
+			delete(state, rec.Args[0])
+			return nil
+		})
+		if err != nil && err != errStop {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	enc, err := bulk.NewEncoder(format, out, f)
+	if err != nil {
+		return errors.Join(err, f.Close(), os.Remove(out))
+	}
+	for _, key := range slices.Sorted(maps.Keys(state)) {
+		if err = enc.Write(key, state[key]); err != nil {
+			return errors.Join(err, f.Close())
+		}
+	}
+	if err = enc.Close(); err != nil {
+		return errors.Join(err, f.Close())
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(cmd.OutOrStdout(), "%d key(s) from %d record(s) written to %s, %d corrupt record(s) skipped\n",
+		len(state), records, out, corrupt)
+	return err
+}
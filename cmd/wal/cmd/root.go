@@ -0,0 +1,102 @@
+package root
+
+import (
+	"custom-in-memory-db/internal/server/db/seg"
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+func Execute() {
+	if err := newRoot().Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func newRoot() *cobra.Command {
+	var rootCmd = &cobra.Command{
+		Use:   "ramdb-wal [COMMAND]",
+		Short: "Inspects and repairs wal segments of a stopped ramdb-server",
+	}
+
+	dir := os.Getenv("RAMDB_WAL_SEG_PATH")
+	if dir == "" {
+		dir = "."
+	}
+	rootCmd.PersistentFlags().String("dir", dir, "segment folder, RAMDB_WAL_SEG_PATH or the current folder if omitted")
+	rootCmd.PersistentFlags().String("key-file", os.Getenv("RAMDB_WAL_KEY_FILE"),
+		"key file of encrypted wal, RAMDB_WAL_KEY_FILE if omitted")
+	allowPlain, _ := strconv.ParseBool(os.Getenv("RAMDB_WAL_KEY_ALLOW_PLAIN"))
+	rootCmd.PersistentFlags().Bool("allow-plain", allowPlain,
+		"read plain records of wal encrypted with --key-file, RAMDB_WAL_KEY_ALLOW_PLAIN if omitted")
+
+	rootCmd.AddCommand(initSegments(), initDump(), initVerify(), initTruncate(), initReplay())
+	return rootCmd
+}
+
+// segments returns segment files of --dir in wal order
+func segments(cmd *cobra.Command) ([]string, error) {
+	dir, _ := cmd.Flags().GetString("dir")
+	files, err := seg.List(dir)
+	if err != nil {
+		return nil, fmt.Errorf("list segments failed: %w", err)
+	}
+	return files, nil
+}
+
+// keys loads --key-file allowing plain records with --allow-plain, nil if it is not set
+func keys(cmd *cobra.Command) (*wal.Keys, error) {
+	pth, _ := cmd.Flags().GetString("key-file")
+	if pth == "" {
+		return nil, nil
+	}
+	allowPlain, _ := cmd.Flags().GetBool("allow-plain")
+	return wal.LoadKeys(pth, allowPlain)
+}
+
+// snapshot reads the snapshot of --dir calling set for each key, nil set reads its header only.
+// Returns false if there is no snapshot
+func snapshot(cmd *cobra.Command, set func(k, v string) error) (wal.SnapshotHeader, bool, error) {
+	dir, _ := cmd.Flags().GetString("dir")
+	k, err := keys(cmd)
+	if err != nil {
+		return wal.SnapshotHeader{}, false, err
+	}
+	return wal.ReadSnapshotFile(filepath.Join(dir, seg.SnapshotName), k, set)
+}
+
+// scan calls fn for each record of the segment file, corrupt ones included with their error.
+// Records failing decryption with keys stop it
+func scan(file string, keys *wal.Keys, fn func(rec wal.Record, offset int64, err error) error) error {
+	f, err := seg.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := wal.NewReader(f, keys)
+	for {
+		rec, offset, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		var corrupt *wal.CorruptError
+		if err != nil && !errors.As(err, &corrupt) {
+			return fmt.Errorf("read %q failed: %w", file, err)
+		}
+		if err = fn(rec, offset, err); err != nil {
+			return err
+		}
+	}
+}
+
+// segmentName returns the segment number of file as a string
+func segmentName(file string) string {
+	return filepath.Base(file)
+}
@@ -0,0 +1,190 @@
+package root
+
+import (
+	"bytes"
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/db/seg"
+	_map "custom-in-memory-db/internal/server/db/storage/map"
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var nilLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// run executes ramdb-wal with args against dir
+func run(dir string, args ...string) (string, error) {
+	var out bytes.Buffer
+	root := newRoot()
+	root.SetOut(&out)
+	root.SetArgs(append(args, "--dir", dir))
+	err := root.Execute()
+	return out.String(), err
+}
+
+// writeWal writes n SET commands and a DEL of the first key to a new wal in dir
+func writeWal(t *testing.T, dir string, n int) {
+	conf := cmd.Config{Wal: cmd.Wal{BatchMax: 1, BatchTimeout: time.Millisecond, SegSize: 256, SegPath: dir}}
+	s, err := wal.New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		require.NoError(t, s.Set("k"+strconv.Itoa(i), "v"+strconv.Itoa(i)))
+	}
+	require.NoError(t, s.Del("k0"))
+	require.NoError(t, s.Close())
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	writeWal(t, dir, 20)
+
+	out, err := run(dir, "verify")
+	require.NoError(t, err)
+	assert.Equal(t, "ok: 4 segment(s), 21 record(s), last lsn 21\n", out)
+
+	// a torn record at the end of the last segment
+	f, err := os.OpenFile(filepath.Join(dir, "4"), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	info, err := f.Stat()
+	require.NoError(t, err)
+	_, err = f.WriteString("0badf00d 22 1700000000000000000 SET k")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	size := strconv.FormatInt(info.Size(), 10)
+	_, err = run(dir, "verify")
+	assert.EqualError(t, err, "wal verification failed, 1 problem(s), the first one: segment 4 offset "+size+": unexpected EOF")
+
+	_, err = run(dir, "truncate", "4", strconv.FormatInt(info.Size()+1, 10))
+	assert.Error(t, err)
+	_, err = run(dir, "truncate", "4", size)
+	require.NoError(t, err)
+	_, err = run(dir, "verify")
+	assert.NoError(t, err)
+}
+
+func TestVerify_MissingSegment(t *testing.T) {
+	dir := t.TempDir()
+	writeWal(t, dir, 40)
+	require.NoError(t, os.Remove(filepath.Join(dir, "2")))
+
+	_, err := run(dir, "verify")
+	assert.ErrorContains(t, err, "the first one: segments 2 to 2 are missing")
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	writeWal(t, dir, 3)
+	export := filepath.Join(t.TempDir(), "export.csv")
+
+	out, err := run(dir, "replay", "--out", export)
+	require.NoError(t, err)
+	assert.Equal(t, "2 key(s) from 4 record(s) written to "+export+", 0 corrupt record(s) skipped\n", out)
+	data, err := os.ReadFile(export)
+	require.NoError(t, err)
+	assert.Equal(t, "Key,Value\nk1,v1\nk2,v2\n", string(data))
+
+	// the export file is never overwritten
+	_, err = run(dir, "replay", "--out", export)
+	assert.ErrorIs(t, err, os.ErrExist)
+}
+
+func TestReplay_Until(t *testing.T) {
+	dir := t.TempDir()
+	writeWal(t, dir, 3)
+	export := filepath.Join(t.TempDir(), "export.ndjson")
+
+	// the state before DEL k0
+	out, err := run(dir, "replay", "--out", export, "--until", "2")
+	require.NoError(t, err)
+	assert.Equal(t, "2 key(s) from 2 record(s) written to "+export+", 0 corrupt record(s) skipped\n", out)
+	data, err := os.ReadFile(export)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"Key\":\"k0\",\"Value\":\"v0\"}\n{\"Key\":\"k1\",\"Value\":\"v1\"}\n", string(data))
+
+	_, err = run(dir, "replay", "--out", export+".2", "--until", "yesterday")
+	assert.EqualError(t, err, `--until invalid: "yesterday" is neither RFC3339 time nor LSN`)
+}
+
+func TestReplay_Snapshot(t *testing.T) {
+	dir := t.TempDir()
+	writeWal(t, dir, 3)
+	// the snapshot replaces the first 2 records
+	f, err := os.Create(filepath.Join(dir, seg.SnapshotName))
+	require.NoError(t, err)
+	require.NoError(t, wal.WriteSnapshot(f, wal.SnapshotHeader{LSN: 2, Time: time.Now()}, map[string]string{"s": "v"}, nil))
+	require.NoError(t, f.Close())
+	export := filepath.Join(t.TempDir(), "export.csv")
+
+	out, err := run(dir, "replay", "--out", export)
+	require.NoError(t, err)
+	assert.Equal(t, "2 key(s) from 2 record(s) written to "+export+", 0 corrupt record(s) skipped\n", out)
+	data, err := os.ReadFile(export)
+	require.NoError(t, err)
+	assert.Equal(t, "Key,Value\nk2,v2\ns,v\n", string(data))
+
+	_, err = run(dir, "replay", "--out", export+".2", "--until", "1")
+	assert.EqualError(t, err, "--until 1 precedes the snapshot at lsn 2")
+
+	out, err = run(dir, "verify")
+	require.NoError(t, err)
+	assert.Equal(t, "ok: snapshot at lsn 2, 1 key(s)\nok: 1 segment(s), 4 record(s), last lsn 4\n", out)
+}
+
+func TestTruncate_Compressed(t *testing.T) {
+	dir := t.TempDir()
+	writeWal(t, dir, 20)
+	file := filepath.Join(dir, "1")
+	require.NoError(t, seg.CompressFile(file, seg.CompressionGzip))
+	out, err := run(dir, "segments")
+	require.NoError(t, err)
+	assert.Contains(t, out, "1        gzip")
+
+	// the first record is cut, the second one starts at its end
+	f, err := seg.Open(file)
+	require.NoError(t, err)
+	rec, _, err := wal.NewReader(f, nil).Next()
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	offset := strconv.Itoa(len(rec.Bytes()))
+	_, err = run(dir, "truncate", "1", "1")
+	assert.ErrorContains(t, err, "is not a record boundary")
+	out, err = run(dir, "truncate", "1", offset)
+	require.NoError(t, err)
+	assert.Contains(t, out, "segment 1 (gzip) truncated from")
+
+	info, err := seg.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, seg.CompressionNone, info.Codec)
+	assert.Equal(t, int64(len(rec.Bytes())), info.Size)
+}
+
+func TestVerify_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(t.TempDir(), "wal.keys")
+	require.NoError(t, os.WriteFile(keyFile, []byte("k1 "+base64.StdEncoding.EncodeToString(make([]byte, 32))+"\n"), 0600))
+	conf := cmd.Config{Wal: cmd.Wal{BatchMax: 1, BatchTimeout: time.Millisecond, SegSize: 256, SegPath: dir, KeyFile: keyFile}}
+	s, err := wal.New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Set("k"+strconv.Itoa(i), "v"))
+	}
+	require.NoError(t, s.Close())
+
+	_, err = run(dir, "verify")
+	assert.ErrorContains(t, err, "WAL_KEY_FILE is not set")
+	out, err := run(dir, "verify", "--key-file", keyFile)
+	require.NoError(t, err)
+	assert.Contains(t, out, "5 record(s), last lsn 5")
+	out, err = run(dir, "dump", "--key-file", keyFile)
+	require.NoError(t, err)
+	assert.Contains(t, out, "SET k4 v")
+}
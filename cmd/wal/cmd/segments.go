@@ -0,0 +1,71 @@
+package root
+
+import (
+	"custom-in-memory-db/internal/server/db/seg"
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+func initSegments() *cobra.Command {
+	return &cobra.Command{
+		Use:           "segments",
+		Short:         "Lists segments with their sizes, record counts and LSNs, the snapshot goes first with its keys as records",
+		Args:          cobra.NoArgs,
+		RunE:          runSegments,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+}
+
+func runSegments(cmd *cobra.Command, _ []string) error {
+	files, err := segments(cmd)
+	if err != nil {
+		return err
+	}
+	keyring, err := keys(cmd)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SEGMENT\tCODEC\tBYTES\tRAW_BYTES\tRECORDS\tCORRUPT\tFIRST_LSN\tLAST_LSN")
+	if h, ok, err := snapshot(cmd, nil); ok {
+		dir, _ := cmd.Flags().GetString("dir")
+		info, statErr := seg.Stat(filepath.Join(dir, seg.SnapshotName))
+		if err = errors.Join(err, statErr); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			seg.SnapshotName, info.Codec, info.Size, info.RawSize, h.Keys, 0, h.LSN, h.LSN)
+	}
+	for _, file := range files {
+		info, err := seg.Stat(file)
+		if err != nil {
+			return err
+		}
+		var records, corrupt int
+		var first, last uint64
+		err = scan(file, keyring, func(rec wal.Record, _ int64, err error) error {
+			if err != nil {
+				corrupt++
+				return nil
+			}
+			records++
+			if rec.LSN > 0 && first == 0 {
+				first = rec.LSN
+			}
+			last = max(last, rec.LSN)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			segmentName(file), info.Codec, info.Size, info.RawSize, records, corrupt, first, last)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,111 @@
+package root
+
+import (
+	"custom-in-memory-db/internal/fsutil"
+	"custom-in-memory-db/internal/server/db/seg"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+)
+
+func initTruncate() *cobra.Command {
+	return &cobra.Command{
+		Use:   "truncate <segment> <offset>",
+		Short: "Cuts a segment at the offset",
+		Long: "Cuts the segment at the offset, the record starting at the offset and the rest of the segment are lost.\n" +
+			"The offset must be a record boundary as printed by dump or verify. Later segments are kept.\n" +
+			"A compressed segment is rewritten uncompressed, the server compresses it again on start.\n" +
+			"The server must be stopped",
+		Args:          cobra.ExactArgs(2),
+		RunE:          runTruncate,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+}
+
+func runTruncate(cmd *cobra.Command, args []string) error {
+	files, err := segments(cmd)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(files, func(file string) bool { return segmentName(file) == args[0] })
+	if i < 0 {
+		return fmt.Errorf("segment %q not found", args[0])
+	}
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || offset < 0 {
+		return fmt.Errorf("offset %q invalid, non-negative number expected", args[1])
+	}
+
+	info, err := seg.Stat(files[i])
+	if err != nil {
+		return err
+	}
+	if info.Codec != seg.CompressionNone {
+		return truncateCompressed(cmd, files[i], args[0], offset, info)
+	}
+
+	f, err := os.OpenFile(files[i], os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if offset > info.Size {
+		return fmt.Errorf("offset %d is beyond the segment size %d", offset, info.Size)
+	}
+	// records end with '\n', so does the data before a record boundary
+	if offset > 0 {
+		prev := make([]byte, 1)
+		if _, err = f.ReadAt(prev, offset-1); err != nil {
+			return err
+		}
+		if prev[0] != '\n' {
+			return fmt.Errorf("offset %d is not a record boundary", offset)
+		}
+	}
+
+	if err = f.Truncate(offset); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cmd.OutOrStdout(), "segment %s truncated from %d to %d bytes\n", args[0], info.Size, offset)
+	return err
+}
+
+// truncateCompressed atomically replaces the compressed segment file with the first offset bytes of its content
+func truncateCompressed(cmd *cobra.Command, file, name string, offset int64, info seg.FileInfo) error {
+	if offset > info.RawSize {
+		return fmt.Errorf("offset %d is beyond the uncompressed segment size %d", offset, info.RawSize)
+	}
+	f, err := seg.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, offset))
+	if err != nil {
+		return err
+	}
+	// records end with '\n', so does the data before a record boundary
+	if offset > 0 && (int64(len(data)) != offset || data[offset-1] != '\n') {
+		return fmt.Errorf("offset %d is not a record boundary", offset)
+	}
+	err = fsutil.WriteFile(file, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cmd.OutOrStdout(), "segment %s (%s) truncated from %d to %d uncompressed bytes\n",
+		name, info.Codec, info.RawSize, offset)
+	return err
+}
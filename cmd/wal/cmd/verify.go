@@ -0,0 +1,93 @@
+package root
+
+import (
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"strconv"
+)
+
+func initVerify() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "Checks record checksums and wal order",
+		Long: "Checks record checksums, that LSNs increase and that no segment is missing between the first and the last one.\n" +
+			"The snapshot, if any, must be intact as a whole.\n" +
+			"Prints every problem found and fails with the first one, e.g. the segment and offset of the first corrupt record.\n" +
+			"A torn tail can be cut with ramdb-wal truncate <segment> <offset>",
+		Args:          cobra.NoArgs,
+		RunE:          runVerify,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+}
+
+func runVerify(cmd *cobra.Command, _ []string) error {
+	files, err := segments(cmd)
+	if err != nil {
+		return err
+	}
+	keyring, err := keys(cmd)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	var records, problems int
+	var first string
+	report := func(format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		if problems == 0 {
+			first = msg
+		}
+		problems++
+		fmt.Fprintln(out, msg)
+	}
+
+	h, ok, err := snapshot(cmd, func(k, v string) error { return nil })
+	if err != nil {
+		report("%s", err)
+	}
+
+	var lastLSN uint64
+	prev := 0
+	for _, file := range files {
+		name := segmentName(file)
+		n, _ := strconv.Atoi(name)
+		if prev > 0 && n != prev+1 {
+			report("segments %d to %d are missing", prev+1, n-1)
+		}
+		prev = n
+
+		err = scan(file, keyring, func(rec wal.Record, offset int64, err error) error {
+			var corrupt *wal.CorruptError
+			if errors.As(err, &corrupt) {
+				report("segment %s offset %d: %s", name, offset, corrupt.Err)
+				return nil
+			}
+			records++
+			// legacy records have no LSN to check
+			if rec.LSN == 0 {
+				return nil
+			}
+			if rec.LSN <= lastLSN {
+				report("segment %s offset %d: lsn %d follows lsn %d", name, offset, rec.LSN, lastLSN)
+			}
+			lastLSN = max(lastLSN, rec.LSN)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("wal verification failed, %d problem(s), the first one: %s", problems, first)
+	}
+	if ok {
+		fmt.Fprintf(out, "ok: snapshot at lsn %d, %d key(s)\n", h.LSN, h.Keys)
+	}
+	_, err = fmt.Fprintf(out, "ok: %d segment(s), %d record(s), last lsn %d\n", len(files), records, max(lastLSN, h.LSN))
+	return err
+}
@@ -0,0 +1,7 @@
+package main
+
+import root "custom-in-memory-db/cmd/wal/cmd"
+
+func main() {
+	root.Execute()
+}
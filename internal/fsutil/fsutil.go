@@ -0,0 +1,43 @@
+// Package fsutil holds file helpers shared by wal segments, acl, backups and the wal tool
+package fsutil
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+)
+
+// WriteFile atomically replaces the file at pth with the content written by write.
+// The content goes to a temporary file which is fsynced and renamed over pth, then the folder is fsynced
+func WriteFile(pth string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(path.Dir(pth), path.Base(pth)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err = write(tmp); err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		return errors.Join(err, tmp.Close(), os.Remove(tmp.Name()))
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Join(err, os.Remove(tmp.Name()))
+	}
+	if err = os.Rename(tmp.Name(), pth); err != nil {
+		return errors.Join(err, os.Remove(tmp.Name()))
+	}
+	return SyncDir(path.Dir(pth))
+}
+
+// SyncDir fsyncs the folder, so files created or renamed in it survive a crash
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = d.Sync(); err != nil {
+		return errors.Join(err, d.Close())
+	}
+	return d.Close()
+}
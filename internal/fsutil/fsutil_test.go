@@ -0,0 +1,45 @@
+package fsutil
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	pth := filepath.Join(dir, "acl.json")
+	require.NoError(t, os.WriteFile(pth, []byte("old"), 0600))
+
+	// a failed write leaves the file as it was and removes the temporary file
+	errWrite := errors.New("write failed")
+	err := WriteFile(pth, func(w io.Writer) error {
+		_, _ = io.WriteString(w, "partial")
+		return errWrite
+	})
+	assert.ErrorIs(t, err, errWrite)
+	data, err := os.ReadFile(pth)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+
+	require.NoError(t, WriteFile(pth, func(w io.Writer) error {
+		_, err := io.WriteString(w, "new")
+		return err
+	}))
+	data, err = os.ReadFile(pth)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestSyncDir(t *testing.T) {
+	require.NoError(t, SyncDir(t.TempDir()))
+	assert.Error(t, SyncDir(filepath.Join(t.TempDir(), "missing")))
+}
@@ -0,0 +1,278 @@
+package acl
+
+import (
+	"custom-in-memory-db/internal/fsutil"
+	"custom-in-memory-db/internal/server/db/parser"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultUser is used for clients, which did not authenticate, when authentication is disabled
+const DefaultUser = "default"
+
+// Command categories
+const (
+	Read  = "read"
+	Write = "write"
+	Admin = "admin"
+)
+
+var categories = []string{Read, Write, Admin}
+
+// ErrPermissionDenied is returned when the user is not allowed to execute the command
+var ErrPermissionDenied = errors.New("permission denied")
+
+// User describes what a user is allowed to do
+type User struct {
+	Name string `json:"name"`
+	// Commands lists allowed command categories
+	Commands []string `json:"commands"`
+	// Keys lists glob patterns of allowed keys. '*' matches any sequence, '?' matches any single character
+	Keys []string `json:"keys"`
+}
+
+// String formats User as ACL LIST line
+func (u User) String() string {
+	parts := []string{"user", u.Name}
+	for _, c := range u.Commands {
+		parts = append(parts, "+"+c)
+	}
+	for _, k := range u.Keys {
+		parts = append(parts, "~"+k)
+	}
+	return strings.Join(parts, " ")
+}
+
+type file struct {
+	Users []User `json:"users"`
+}
+
+// ACL checks permissions of users and persists changes to the json file it was loaded from
+type ACL struct {
+	path  string
+	mtx   sync.RWMutex
+	users map[string]User
+}
+
+// New loads ACL from the json file at path. Returns nil ACL if path is empty
+func New(path string) (*ACL, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read acl failed: %w", err)
+	}
+	var f file
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("unmarshal acl failed: %w", err)
+	}
+
+	a := ACL{path: path, users: make(map[string]User, len(f.Users))}
+	for _, u := range f.Users {
+		if u.Name == "" {
+			return nil, fmt.Errorf("invalid acl: %w", errors.New("user without name"))
+		}
+		for _, c := range u.Commands {
+			if !slices.Contains(categories, c) {
+				return nil, fmt.Errorf("invalid acl: user %q has unknown command category %q", u.Name, c)
+			}
+		}
+		a.users[u.Name] = u
+	}
+
+	return &a, nil
+}
+
+// Check returns ErrPermissionDenied if user is not allowed to execute cmd.
+// ACL WHOAMI and PING are allowed to anyone
+func (a *ACL) Check(user string, cmd parser.Command) error {
+	category, key, allKeys := classify(cmd)
+	if category == "" {
+		return nil
+	}
+
+	a.mtx.RLock()
+	u, ok := a.users[user]
+	a.mtx.RUnlock()
+	if !ok || !slices.Contains(u.Commands, category) {
+		return ErrPermissionDenied
+	}
+
+	switch {
+	case allKeys:
+		if !slices.Contains(u.Keys, "*") {
+			return ErrPermissionDenied
+		}
+	case key != "":
+		if !slices.ContainsFunc(u.Keys, func(pattern string) bool { return match(pattern, key) }) {
+			return ErrPermissionDenied
+		}
+	}
+
+	return nil
+}
+
+// List returns users sorted by name
+func (a *ACL) List() []User {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	result := make([]User, 0, len(a.users))
+	for _, u := range a.users {
+		result = append(result, u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// SetUser creates or modifies user applying rules in order and saves ACL to the file.
+// Rules are:
+//
+//	+<category>  allow command category (read, write or admin)
+//	-<category>  disallow command category
+//	~<pattern>   allow keys matching pattern
+//	allkeys      same as ~*
+//	resetkeys    disallow all keys
+//	reset        disallow everything
+func (a *ACL) SetUser(name string, rules []string) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	u := a.users[name]
+	u.Name = name
+	u.Commands = slices.Clone(u.Commands)
+	u.Keys = slices.Clone(u.Keys)
+	for _, rule := range rules {
+		if err := apply(&u, rule); err != nil {
+			return err
+		}
+	}
+
+	users := make(map[string]User, len(a.users)+1)
+	for k, v := range a.users {
+		users[k] = v
+	}
+	users[name] = u
+	if err := a.save(users); err != nil {
+		return err
+	}
+	a.users = users
+
+	return nil
+}
+
+// save replaces the ACL file with users atomically, so it is never left half-written or lost on a crash
+func (a *ACL) save(users map[string]User) error {
+	f := file{Users: make([]User, 0, len(users))}
+	for _, u := range users {
+		f.Users = append(f.Users, u)
+	}
+	sort.Slice(f.Users, func(i, j int) bool { return f.Users[i].Name < f.Users[j].Name })
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal acl failed: %w", err)
+	}
+
+	err = fsutil.WriteFile(a.path, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("save acl failed: %w", err)
+	}
+
+	return nil
+}
+
+func apply(u *User, rule string) error {
+	switch {
+	case rule == "reset":
+		u.Commands = nil
+		u.Keys = nil
+	case rule == "resetkeys":
+		u.Keys = nil
+	case rule == "allkeys":
+		if !slices.Contains(u.Keys, "*") {
+			u.Keys = append(u.Keys, "*")
+		}
+	case strings.HasPrefix(rule, "~") && len(rule) > 1:
+		if !slices.Contains(u.Keys, rule[1:]) {
+			u.Keys = append(u.Keys, rule[1:])
+		}
+	case strings.HasPrefix(rule, "+") && slices.Contains(categories, rule[1:]):
+		if !slices.Contains(u.Commands, rule[1:]) {
+			u.Commands = append(u.Commands, rule[1:])
+		}
+	case strings.HasPrefix(rule, "-") && slices.Contains(categories, rule[1:]):
+		u.Commands = slices.DeleteFunc(u.Commands, func(c string) bool { return c == rule[1:] })
+	default:
+		return fmt.Errorf("invalid acl rule %q", rule)
+	}
+	return nil
+}
+
+// Category returns the category of cmd: Read, Write, Admin or empty if cmd is allowed to anyone
+func Category(cmd parser.Command) string {
+	category, _, _ := classify(cmd)
+	return category
+}
+
+// classify returns the category of cmd and the key it touches.
+// allKeys is true if cmd touches every key. Empty category means cmd is allowed to anyone
+func classify(cmd parser.Command) (category, key string, allKeys bool) {
+	switch cmd.Command {
+	case "GET":
+		return Read, cmd.Arg1, false
+	case "DUMP":
+		return Read, "", true
+	case "SET", "DEL":
+		return Write, cmd.Arg1, false
+	case "PING":
+		return "", "", false
+	case "ACL":
+		if cmd.Arg1 == "WHOAMI" {
+			return "", "", false
+		}
+		return Admin, "", false
+	case "INFO", "CONFIG", "SLOWLOG", "BACKUP":
+		return Admin, "", false
+	default:
+		return Admin, "", false
+	}
+}
+
+// match reports whether key matches glob pattern.
+// Unlike path.Match, '*' matches '/' as well, since keys are not paths
+func match(pattern, key string) bool {
+	// star and next remember the position to backtrack to after the last '*'
+	p, k, star, next := 0, 0, -1, 0
+	for k < len(key) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == key[k]):
+			p++
+			k++
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, k
+			p++
+		case star >= 0:
+			next++
+			p, k = star+1, next
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,122 @@
+package acl
+
+import (
+	"custom-in-memory-db/internal/server/db/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testAcl = `{"users": [
+	{"name": "admin", "commands": ["read", "write", "admin"], "keys": ["*"]},
+	{"name": "reporting", "commands": ["read"], "keys": ["report_*"]}
+]}`
+
+func newTestAcl(t *testing.T) *ACL {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	require.NoError(t, os.WriteFile(path, []byte(testAcl), 0600))
+	a, err := New(path)
+	require.NoError(t, err)
+	return a
+}
+
+func TestNew_EmptyPath(t *testing.T) {
+	a, err := New("")
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+}
+
+func TestNew_Negative_UnknownCategory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"users": [{"name": "a", "commands": ["all"]}]}`), 0600))
+
+	_, err := New(path)
+	assert.EqualError(t, err, `invalid acl: user "a" has unknown command category "all"`)
+}
+
+func TestACL_Check(t *testing.T) {
+	a := newTestAcl(t)
+	testCases := []struct {
+		user string
+		cmd  parser.Command
+		err  error
+	}{
+		{user: "admin", cmd: parser.Command{Command: "DEL", Arg1: "k"}},
+		{user: "admin", cmd: parser.Command{Command: "ACL", Arg1: "SETUSER", Arg2: "u"}},
+		{user: "reporting", cmd: parser.Command{Command: "GET", Arg1: "report_1/q"}},
+		{user: "reporting", cmd: parser.Command{Command: "GET", Arg1: "secret"}, err: ErrPermissionDenied},
+		{user: "reporting", cmd: parser.Command{Command: "DEL", Arg1: "report_1"}, err: ErrPermissionDenied},
+		{user: "reporting", cmd: parser.Command{Command: "DUMP"}, err: ErrPermissionDenied},
+		{user: "reporting", cmd: parser.Command{Command: "ACL", Arg1: "LIST"}, err: ErrPermissionDenied},
+		{user: "reporting", cmd: parser.Command{Command: "ACL", Arg1: "WHOAMI"}},
+		{user: "reporting", cmd: parser.Command{Command: "INFO"}, err: ErrPermissionDenied},
+		{user: "admin", cmd: parser.Command{Command: "CONFIG", Arg1: "SET", Arg2: "log_level", Args: []string{"debug"}}},
+		{user: DefaultUser, cmd: parser.Command{Command: "GET", Arg1: "k"}, err: ErrPermissionDenied},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.err, a.Check(testCase.user, testCase.cmd), "%s %+v", testCase.user, testCase.cmd)
+	}
+}
+
+func TestACL_SetUser(t *testing.T) {
+	a := newTestAcl(t)
+
+	require.NoError(t, a.SetUser("reporting", []string{"-read", "+write", "resetkeys", "~tmp_?"}))
+	assert.NoError(t, a.Check("reporting", parser.Command{Command: "SET", Arg1: "tmp_1", Arg2: "v"}))
+	assert.Equal(t, ErrPermissionDenied, a.Check("reporting", parser.Command{Command: "GET", Arg1: "tmp_1"}))
+	assert.Equal(t, ErrPermissionDenied, a.Check("reporting", parser.Command{Command: "SET", Arg1: "tmp_12", Arg2: "v"}))
+
+	// changes survive reload
+	reloaded, err := New(a.path)
+	require.NoError(t, err)
+	assert.Equal(t, a.List(), reloaded.List())
+	assert.Equal(t, "user reporting +write ~tmp_?", reloaded.List()[1].String())
+
+	// no temporary files are left next to the acl file
+	entries, err := os.ReadDir(filepath.Dir(a.path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, filepath.Base(a.path), entries[0].Name())
+}
+
+func TestACL_SetUser_Negative_SaveFailed(t *testing.T) {
+	a := newTestAcl(t)
+	before := a.List()
+	require.NoError(t, os.RemoveAll(filepath.Dir(a.path)))
+
+	assert.ErrorContains(t, a.SetUser("reporting", []string{"+read"}), "save acl failed")
+	assert.Equal(t, before, a.List())
+}
+
+func TestACL_SetUser_Negative_InvalidRule(t *testing.T) {
+	a := newTestAcl(t)
+
+	assert.EqualError(t, a.SetUser("reporting", []string{"+read", "+all"}), `invalid acl rule "+all"`)
+	// nothing is applied
+	assert.Equal(t, "user reporting +read ~report_*", a.List()[1].String())
+}
+
+func TestMatch(t *testing.T) {
+	testCases := []struct {
+		pattern, key string
+		expected     bool
+	}{
+		{"*", "", true},
+		{"*", "a/b", true},
+		{"a*", "abc", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abbbd", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"*b*", "aaba", true},
+		{"abc", "abc", true},
+		{"abc", "abcd", false},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, match(testCase.pattern, testCase.key), "%q %q", testCase.pattern, testCase.key)
+	}
+}
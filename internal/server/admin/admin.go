@@ -0,0 +1,151 @@
+// Package admin serves operational endpoints on a separate address,
+// so that they are available regardless of NET_PROTO
+package admin
+
+import (
+	"context"
+	"custom-in-memory-db/internal/server/acl"
+	"custom-in-memory-db/internal/server/auth"
+	"custom-in-memory-db/internal/server/db/parser"
+	"custom-in-memory-db/internal/server/health"
+	"custom-in-memory-db/internal/server/metrics"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// shutdownTimeout limits the time Close waits for in-flight requests
+const shutdownTimeout = 5 * time.Second
+
+type Server struct {
+	lg     *slog.Logger
+	router *gin.Engine
+	// admin groups /admin routes behind authentication and acl
+	admin    *gin.RouterGroup
+	server   *http.Server
+	listener net.Listener
+}
+
+// New starts listening on addr. Routes are served after Listen is called.
+// /admin routes require a bearer token if a is not nil and the admin category if l is not nil,
+// probes and /metrics are open
+func New(addr string, reg *Registry, a *auth.Auth, l *acl.ACL, lg *slog.Logger) (*Server, error) {
+	const suf = "admin.New()"
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", suf, err)
+	}
+
+	s := Server{lg: lg, listener: listener}
+	s.router = gin.New()
+	s.router.Use(gin.Recovery())
+	_ = s.router.SetTrustedProxies(nil)
+	s.router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	health.Routes(s.router)
+	s.admin = s.router.Group("", s.authorize(a, l))
+	s.admin.GET("/admin/info", infoHandler(reg))
+	s.admin.GET("/admin/config", configHandler(reg))
+
+	s.server = &http.Server{
+		Handler:           s.router,
+		ReadHeaderTimeout: shutdownTimeout,
+	}
+
+	return &s, nil
+}
+
+// Routes allows other packages to add their admin routes before Listen is called.
+// The routes are subject to the same authentication and acl as /admin/info
+func (s *Server) Routes() gin.IRoutes {
+	return s.admin
+}
+
+// Addr returns the address Server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Listen serves requests until Close is called
+func (s *Server) Listen() {
+	err := s.server.Serve(s.listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.lg.Error("admin server failed", "error", err.Error())
+	}
+}
+
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// authorize checks the bearer token and acl the same way the http endpoint does.
+// Every /admin route reads what INFO, CONFIG GET or SLOWLOG GET return, so the admin category is required
+func (s *Server) authorize(a *auth.Auth, l *acl.ACL) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := acl.DefaultUser
+		if a != nil {
+			token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				s.lg.Warn("admin authentication failed", "ClientIP", c.ClientIP(), "error", auth.ErrAuthRequired.Error())
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": auth.ErrAuthRequired.Error()})
+				return
+			}
+			name, err := a.Token(token)
+			if err != nil {
+				s.lg.Warn("admin authentication failed", "ClientIP", c.ClientIP(), "error", err.Error())
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			user = name
+		}
+
+		if l != nil {
+			if err := l.Check(user, parser.Command{Command: "INFO"}); err != nil {
+				s.lg.Warn("admin acl check failed", "ClientIP", c.ClientIP(), "user", user, "path", c.Request.URL.Path)
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// infoHandler replies with INFO sections as {"section": {"key": "value"}}.
+// ?section= limits the reply to one section
+func infoHandler(reg *Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sections, err := reg.Info(c.Query("section"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		result := make(map[string]map[string]string, len(sections))
+		for _, s := range sections {
+			result[s.Name] = fieldsMap(s.Fields)
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// configHandler replies with runtime settings. Changing them requires CONFIG SET,
+// which is subject to auth and acl
+func configHandler(reg *Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fields, _ := reg.ConfigGet("*")
+		c.JSON(http.StatusOK, fieldsMap(fields))
+	}
+}
+
+func fieldsMap(fields []Field) map[string]string {
+	result := make(map[string]string, len(fields))
+	for _, f := range fields {
+		result[f.Key] = f.Value
+	}
+	return result
+}
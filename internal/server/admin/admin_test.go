@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"custom-in-memory-db/internal/server/acl"
+	"custom-in-memory-db/internal/server/auth"
+	"custom-in-memory-db/internal/server/auth/authtest"
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var nilLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestServer_Metrics(t *testing.T) {
+	srv, err := New("127.0.0.1:0", NewRegistry(cmd.Config{}), nil, nil, nilLogger)
+	require.NoError(t, err)
+	go srv.Listen()
+	defer srv.Close()
+
+	metrics.Commands.WithLabelValues("GET", metrics.ResultOk).Inc()
+
+	resp, err := http.Get("http://" + srv.Addr() + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `ramdb_commands_total{command="GET",result="ok"} 1`)
+	assert.Contains(t, string(body), "go_goroutines")
+}
+
+func TestNew_Negative_BusyAddress(t *testing.T) {
+	srv, err := New("127.0.0.1:0", NewRegistry(cmd.Config{}), nil, nil, nilLogger)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	_, err = New(srv.Addr(), NewRegistry(cmd.Config{}), nil, nil, nilLogger)
+	assert.Error(t, err)
+}
+
+func TestServer_Info(t *testing.T) {
+	reg := NewRegistry(cmd.Config{})
+	reg.AddSection("keyspace", func() []Field { return []Field{{Key: "keys", Value: "3"}} })
+	srv, err := New("127.0.0.1:0", reg, nil, nil, nilLogger)
+	require.NoError(t, err)
+	go srv.Listen()
+	defer srv.Close()
+
+	resp, err := http.Get("http://" + srv.Addr() + "/admin/info?section=keyspace")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"keyspace":{"keys":"3"}}`, string(body))
+
+	resp, err = http.Get("http://" + srv.Addr() + "/admin/info?section=unknown")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServer_Authorize(t *testing.T) {
+	a, err := auth.New(authtest.WriteFile(t, "ops", "password", "ops-token"))
+	require.NoError(t, err)
+	aclFile := filepath.Join(t.TempDir(), "acl.json")
+	require.NoError(t, os.WriteFile(aclFile, []byte(`{"users": [{"name": "ops", "commands": ["admin"]}, {"name": "default", "commands": ["read"], "keys": ["*"]}]}`), 0600))
+	l, err := acl.New(aclFile)
+	require.NoError(t, err)
+	readOnly := filepath.Join(t.TempDir(), "acl.json")
+	require.NoError(t, os.WriteFile(readOnly, []byte(`{"users": [{"name": "ops", "commands": ["read"], "keys": ["*"]}]}`), 0600))
+	lr, err := acl.New(readOnly)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		a      *auth.Auth
+		l      *acl.ACL
+		token  string
+		status int
+	}{
+		{name: "auth disabled", status: http.StatusOK},
+		{name: "no token", a: a, status: http.StatusUnauthorized},
+		{name: "wrong token", a: a, token: "wrong", status: http.StatusUnauthorized},
+		{name: "token", a: a, token: "ops-token", status: http.StatusOK},
+		{name: "admin category", a: a, l: l, token: "ops-token", status: http.StatusOK},
+		{name: "no admin category", a: a, l: lr, token: "ops-token", status: http.StatusForbidden},
+		// without auth acl applies to the default user
+		{name: "default user", l: l, status: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, err := New("127.0.0.1:0", NewRegistry(cmd.Config{}), test.a, test.l, nilLogger)
+			require.NoError(t, err)
+			srv.Routes().GET("/admin/slowlog", func(c *gin.Context) { c.Status(http.StatusOK) })
+			go srv.Listen()
+			defer srv.Close()
+
+			get := func(path, token string) int {
+				req, err := http.NewRequest(http.MethodGet, "http://"+srv.Addr()+path, nil)
+				require.NoError(t, err)
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			for _, path := range []string{"/admin/info", "/admin/config", "/admin/slowlog"} {
+				assert.Equal(t, test.status, get(path, test.token), path)
+			}
+			// probes and metrics stay open
+			for _, path := range []string{"/metrics", "/healthz", "/readyz"} {
+				status := get(path, "")
+				assert.NotEqual(t, http.StatusUnauthorized, status, path)
+				assert.NotEqual(t, http.StatusForbidden, status, path)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package admin
+
+import (
+	"custom-in-memory-db/internal/server/cmd"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is set at build time with -ldflags "-X custom-in-memory-db/internal/server/admin.Version=..."
+var Version = "dev"
+
+// ErrUnknownSetting is returned for settings that do not exist or can not be changed at runtime
+var ErrUnknownSetting = errors.New("unknown setting")
+
+// ErrRestartRequired is the error of Change of a config parameter which is not a runtime setting
+var ErrRestartRequired = errors.New("can not be changed at runtime, restart required")
+
+// Field is a single key and value of INFO or CONFIG GET
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Section is a named group of INFO fields
+type Section struct {
+	Name   string
+	Fields []Field
+}
+
+// Setting is a config parameter which can be changed at runtime.
+// Set must validate value and apply it atomically
+type Setting struct {
+	Get func() string
+	Set func(value string) error
+}
+
+// Change is a config parameter changed by Reload. Err is set if the change was rejected
+type Change struct {
+	Name string
+	Old  string
+	New  string
+	Err  error
+}
+
+type section struct {
+	name   string
+	fields func() []Field
+}
+
+// Registry collects INFO sections and runtime settings registered by other layers
+type Registry struct {
+	start time.Time
+
+	mtx sync.RWMutex
+	// config parameters the server was started or last reloaded with
+	params   map[string]string
+	sections []section
+	settings map[string]Setting
+}
+
+// NewRegistry returns Registry with server and config sections
+func NewRegistry(conf cmd.Config) *Registry {
+	a := Registry{start: time.Now(), params: conf.Params(), settings: make(map[string]Setting)}
+	a.AddSection("server", a.serverInfo)
+	a.AddSection("config", a.configInfo)
+	return &a
+}
+
+// AddSection adds INFO section. fields is called on each INFO
+func (a *Registry) AddSection(name string, fields func() []Field) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.sections = append(a.sections, section{name: name, fields: fields})
+}
+
+// AddSetting makes the config parameter name available to CONFIG GET and CONFIG SET
+func (a *Registry) AddSetting(name string, s Setting) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.settings[name] = s
+}
+
+// Info returns sections in the order they were added. Empty name returns all of them
+func (a *Registry) Info(name string) ([]Section, error) {
+	a.mtx.RLock()
+	sections := slices.Clone(a.sections)
+	a.mtx.RUnlock()
+
+	result := make([]Section, 0, len(sections))
+	for _, s := range sections {
+		if name == "" || s.name == name {
+			result = append(result, Section{Name: s.name, Fields: s.fields()})
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("unknown info section %q", name)
+	}
+	return result, nil
+}
+
+// ConfigGet returns the current value of the setting. "*" returns all of them sorted by name
+func (a *Registry) ConfigGet(name string) ([]Field, error) {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	if name == "*" {
+		result := make([]Field, 0, len(a.settings))
+		for k, s := range a.settings {
+			result = append(result, Field{Key: k, Value: s.Get()})
+		}
+		slices.SortFunc(result, func(x, y Field) int { return strings.Compare(x.Key, y.Key) })
+		return result, nil
+	}
+
+	s, ok := a.settings[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownSetting, name)
+	}
+	return []Field{{Key: strings.ToLower(name), Value: s.Get()}}, nil
+}
+
+// ConfigSet changes the setting
+func (a *Registry) ConfigSet(name, value string) error {
+	a.mtx.RLock()
+	s, ok := a.settings[strings.ToLower(name)]
+	a.mtx.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownSetting, name)
+	}
+	if err := s.Set(value); err != nil {
+		return fmt.Errorf("invalid value %q for %q: %w", value, name, err)
+	}
+	return nil
+}
+
+// Reload applies parameters of conf which differ from the ones the server was started or last reloaded with.
+// Parameters which are not runtime settings keep their values until restart. Parameters which
+// did not change keep values set by ConfigSet. Changes are returned sorted by name
+func (a *Registry) Reload(conf cmd.Config) []Change {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var changes []Change
+	for name, value := range conf.Params() {
+		if old := a.params[name]; value != old {
+			changes = append(changes, Change{Name: name, Old: old, New: value})
+		}
+	}
+	slices.SortFunc(changes, func(x, y Change) int { return strings.Compare(x.Name, y.Name) })
+
+	for i, c := range changes {
+		s, ok := a.settings[c.Name]
+		if !ok {
+			changes[i].Err = ErrRestartRequired
+			continue
+		}
+		if err := s.Set(c.New); err != nil {
+			changes[i].Err = err
+			continue
+		}
+		a.params[c.Name] = c.New
+	}
+	return changes
+}
+
+func (a *Registry) serverInfo() []Field {
+	uptime := time.Since(a.start)
+	return []Field{
+		{Key: "version", Value: Version},
+		{Key: "go_version", Value: runtime.Version()},
+		{Key: "pid", Value: strconv.Itoa(os.Getpid())},
+		{Key: "started_at", Value: a.start.UTC().Format(time.RFC3339)},
+		{Key: "uptime_seconds", Value: strconv.FormatInt(int64(uptime.Seconds()), 10)},
+	}
+}
+
+// configInfo returns config the server was started or last reloaded with, runtime settings show their current values
+func (a *Registry) configInfo() []Field {
+	a.mtx.RLock()
+	params := maps.Clone(a.params)
+	for k, s := range a.settings {
+		params[k] = s.Get()
+	}
+	a.mtx.RUnlock()
+
+	result := make([]Field, 0, len(params))
+	for k, v := range params {
+		result = append(result, Field{Key: k, Value: v})
+	}
+	slices.SortFunc(result, func(x, y Field) int { return strings.Compare(x.Key, y.Key) })
+	return result
+}
+
+// FormatInfo formats sections the way INFO command replies:
+// "# name" header followed by "key:value" lines for each section
+func FormatInfo(sections []Section) string {
+	var sb strings.Builder
+	for _, s := range sections {
+		sb.WriteString("# " + s.Name + "\n")
+		for _, f := range s.Fields {
+			sb.WriteString(f.Key + ":" + f.Value + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// FormatFields formats fields as "key:value" lines
+func FormatFields(fields []Field) string {
+	var sb strings.Builder
+	for _, f := range fields {
+		sb.WriteString(f.Key + ":" + f.Value + "\n")
+	}
+	return sb.String()
+}
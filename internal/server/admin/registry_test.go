@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"custom-in-memory-db/internal/server/cmd"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func testSetting(val *string) Setting {
+	return Setting{
+		Get: func() string { return *val },
+		Set: func(value string) error {
+			if value == "" {
+				return errors.New("empty value")
+			}
+			*val = value
+			return nil
+		},
+	}
+}
+
+func TestRegistry_Info(t *testing.T) {
+	reg := NewRegistry(cmd.Config{Network: cmd.Network{Port: 8080}})
+	reg.AddSection("keyspace", func() []Field { return []Field{{Key: "keys", Value: "1"}} })
+
+	sections, err := reg.Info("")
+	require.NoError(t, err)
+	names := make([]string, 0, len(sections))
+	for _, s := range sections {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{"server", "config", "keyspace"}, names)
+	assert.Equal(t, "version", sections[0].Fields[0].Key)
+	assert.Contains(t, sections[1].Fields, Field{Key: "net_port", Value: "8080"})
+
+	sections, err = reg.Info("keyspace")
+	require.NoError(t, err)
+	assert.Equal(t, "# keyspace\nkeys:1\n", FormatInfo(sections))
+
+	_, err = reg.Info("unknown")
+	assert.Error(t, err)
+}
+
+func TestRegistry_Config(t *testing.T) {
+	level, timeout := "info", "1ms"
+	reg := NewRegistry(cmd.Config{})
+	reg.AddSetting("log_level", testSetting(&level))
+	reg.AddSetting("wal_batch_timeout", testSetting(&timeout))
+
+	fields, err := reg.ConfigGet("*")
+	require.NoError(t, err)
+	assert.Equal(t, "log_level:info\nwal_batch_timeout:1ms\n", FormatFields(fields))
+
+	require.NoError(t, reg.ConfigSet("LOG_LEVEL", "debug"))
+	fields, err = reg.ConfigGet("log_level")
+	require.NoError(t, err)
+	assert.Equal(t, []Field{{Key: "log_level", Value: "debug"}}, fields)
+
+	// runtime settings override the startup config in INFO
+	sections, err := reg.Info("config")
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(FormatInfo(sections), "log_level:debug\n"))
+}
+
+func TestRegistry_Config_Negative(t *testing.T) {
+	level := "info"
+	reg := NewRegistry(cmd.Config{})
+	reg.AddSetting("log_level", testSetting(&level))
+
+	_, err := reg.ConfigGet("wal_seg_path")
+	assert.ErrorIs(t, err, ErrUnknownSetting)
+	assert.ErrorIs(t, reg.ConfigSet("wal_seg_path", "/tmp"), ErrUnknownSetting)
+	assert.EqualError(t, reg.ConfigSet("log_level", ""), `invalid value "" for "log_level": empty value`)
+	assert.Equal(t, "info", level)
+}
+
+func TestRegistry_Reload(t *testing.T) {
+	level, format := "info", "text"
+	conf := cmd.Config{Logging: cmd.Logging{Level: "info", Format: "text"}, Network: cmd.Network{Port: 8080}}
+	reg := NewRegistry(conf)
+	reg.AddSetting("log_level", testSetting(&level))
+	reg.AddSetting("log_format", testSetting(&format))
+	// changed at runtime, reload keeps it unless the config changes it
+	require.NoError(t, reg.ConfigSet("log_format", "json"))
+
+	conf.Logging.Level = "debug"
+	conf.Network.Port = 8081
+	changes := reg.Reload(conf)
+	assert.Equal(t, []Change{
+		{Name: "log_level", Old: "info", New: "debug"},
+		{Name: "net_port", Old: "8080", New: "8081", Err: ErrRestartRequired},
+	}, changes)
+	assert.Equal(t, "debug", level)
+	assert.Equal(t, "json", format)
+
+	// rejected change stays pending until restart
+	sections, err := reg.Info("config")
+	require.NoError(t, err)
+	assert.Contains(t, sections[0].Fields, Field{Key: "net_port", Value: "8080"})
+	assert.Len(t, reg.Reload(conf), 1)
+
+	// invalid value of a runtime setting is not applied
+	conf.Logging.Level = ""
+	changes = reg.Reload(conf)
+	require.Len(t, changes, 2)
+	assert.Equal(t, "log_level", changes[0].Name)
+	assert.EqualError(t, changes[0].Err, "empty value")
+	assert.Equal(t, "debug", level)
+}
@@ -0,0 +1,132 @@
+// Package audit appends a JSON line per mutating or admin command to a separate log file.
+// Records are written by a single goroutine, callers never wait for the disk
+package audit
+
+import (
+	"crypto/sha256"
+	"custom-in-memory-db/internal/server/metrics"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// ValuesOmit drops values from records
+	ValuesOmit = "omit"
+	// ValuesHash replaces values by their sha256, so that changes can be compared without revealing data
+	ValuesHash = "hash"
+)
+
+// queueLen is the number of records waiting to be written before new ones are dropped
+const queueLen = 1024
+
+// Record is a single audit log line
+type Record struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	ClientIP  string    `json:"client_ip"`
+	RequestID string    `json:"request_id"`
+	// Command includes the subcommand for ACL, CONFIG and SLOWLOG, e.g. "ACL SETUSER"
+	Command string `json:"command"`
+	// Key is the key of SET and DEL, the user of ACL SETUSER or the setting of CONFIG SET
+	Key       string `json:"key,omitempty"`
+	ValueHash string `json:"value_sha256,omitempty"`
+	Result    string `json:"result"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Config of Log
+type Config struct {
+	// Path of the active file, rotated files get a timestamp suffix
+	Path string
+	// Values is ValuesOmit or ValuesHash
+	Values string
+	// MaxSize in bytes the active file is rotated at
+	MaxSize int64
+	// MaxFiles is the number of rotated files kept, 0 keeps all of them
+	MaxFiles int
+	// MaxAge of rotated files, 0 keeps them forever
+	MaxAge time.Duration
+}
+
+type Log struct {
+	values string
+	queue  chan Record
+	file   *rotator
+	lg     *slog.Logger
+	wg     sync.WaitGroup
+	// mtx guards closed, so that Write after Close drops the record instead of panicking
+	mtx    sync.RWMutex
+	closed bool
+}
+
+// New opens conf.Path for append and starts the writer
+func New(conf Config, lg *slog.Logger) (*Log, error) {
+	const suf = "audit.New()"
+	file, err := newRotator(conf.Path, conf.MaxSize, conf.MaxFiles, conf.MaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", suf, err)
+	}
+
+	l := Log{values: conf.Values, queue: make(chan Record, queueLen), file: file, lg: lg}
+	l.wg.Add(1)
+	go l.writer()
+	return &l, nil
+}
+
+// Value returns what is recorded for value according to Config.Values
+func (l *Log) Value(value string) string {
+	if l.values != ValuesHash || value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// Write queues r. Write never blocks, r is dropped if the queue is full or Log is closed
+func (l *Log) Write(r Record) {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+	if l.closed {
+		metrics.AuditDropped.Inc()
+		return
+	}
+	select {
+	case l.queue <- r:
+	default:
+		metrics.AuditDropped.Inc()
+	}
+}
+
+// Close writes queued records and closes the file
+func (l *Log) Close() error {
+	l.mtx.Lock()
+	if l.closed {
+		l.mtx.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mtx.Unlock()
+
+	l.wg.Wait()
+	return l.file.Close()
+}
+
+func (l *Log) writer() {
+	defer l.wg.Done()
+	for r := range l.queue {
+		line, err := json.Marshal(r)
+		if err != nil {
+			metrics.AuditDropped.Inc()
+			continue
+		}
+		if _, err = l.file.Write(append(line, '\n')); err != nil {
+			metrics.AuditDropped.Inc()
+			l.lg.Error("audit write failed", "error", err.Error())
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var nilLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func readRecords(t *testing.T, path string) []Record {
+	t.Helper()
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var result []Record
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		var r Record
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &r))
+		result = append(result, r)
+	}
+	return result
+}
+
+func TestLog_Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(Config{Path: path, Values: ValuesHash, MaxSize: 1 << 20}, nilLogger)
+	require.NoError(t, err)
+
+	r := Record{Time: time.Now().UTC(), User: "admin", ClientIP: "127.0.0.1", RequestID: "1",
+		Command: "SET", Key: "a", ValueHash: l.Value("1"), Result: "ok"}
+	l.Write(r)
+	require.NoError(t, l.Close())
+	// records after Close are dropped
+	l.Write(r)
+	require.NoError(t, l.Close())
+
+	records := readRecords(t, path)
+	require.Len(t, records, 1)
+	assert.Equal(t, r.Time.UnixNano(), records[0].Time.UnixNano())
+	assert.Equal(t, "6b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4b", records[0].ValueHash)
+	assert.Equal(t, "admin", records[0].User)
+}
+
+func TestLog_Value(t *testing.T) {
+	l := Log{values: ValuesOmit}
+	assert.Equal(t, "", l.Value("secret"))
+	l.values = ValuesHash
+	assert.Len(t, l.Value("secret"), 64)
+	assert.Equal(t, "", l.Value(""))
+}
+
+func TestRotator(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	// an unrelated file is never removed
+	require.NoError(t, os.WriteFile(path+".bak", nil, 0600))
+
+	r, err := newRotator(path, 10, 2, 0)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = r.Write([]byte("0123456789"))
+		require.NoError(t, err)
+		// rotated names have nanoseconds, but let them differ on coarse clocks
+		time.Sleep(time.Millisecond)
+	}
+	require.NoError(t, r.Close())
+
+	rotated, err := filepath.Glob(path + ".2*")
+	require.NoError(t, err)
+	assert.Len(t, rotated, 2)
+	assert.FileExists(t, path+".bak")
+	st, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), st.Size())
+}
+
+func TestRotator_MaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	old := path + "." + time.Now().Add(-2*time.Hour).UTC().Format(rotatedFormat)
+	require.NoError(t, os.WriteFile(old, nil, 0600))
+
+	r, err := newRotator(path, 1, 0, time.Hour)
+	require.NoError(t, err)
+	_, err = r.Write([]byte("1"))
+	require.NoError(t, err)
+	_, err = r.Write([]byte("2"))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	assert.NoFileExists(t, old)
+	rotated, err := filepath.Glob(path + ".2*")
+	require.NoError(t, err)
+	assert.Len(t, rotated, 1)
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// rotatedFormat is appended to the path of rotated files. It sorts in time order
+const rotatedFormat = "20060102T150405.000000000"
+
+// rotator is io.WriteCloser appending to path. It renames path once it reaches maxSize
+// and removes rotated files beyond maxFiles or older than maxAge.
+// rotator is not thread-safe, it is used by the audit writer goroutine only
+type rotator struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	maxAge   time.Duration
+
+	file *os.File
+	size int64
+}
+
+func newRotator(path string, maxSize int64, maxFiles int, maxAge time.Duration) (*rotator, error) {
+	r := rotator{path: path, maxSize: maxSize, maxFiles: maxFiles, maxAge: maxAge}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (r *rotator) Write(p []byte) (int, error) {
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotator) Close() error {
+	return r.file.Close()
+}
+
+func (r *rotator) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("open %q failed: %w", r.path, err)
+	}
+	st, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("stat %q failed: %w", r.path, err)
+	}
+	r.file, r.size = file, st.Size()
+	return nil
+}
+
+func (r *rotator) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("close %q failed: %w", r.path, err)
+	}
+	rotated := r.path + "." + time.Now().UTC().Format(rotatedFormat)
+	if err := os.Rename(r.path, rotated); err != nil {
+		return fmt.Errorf("rename %q failed: %w", r.path, err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	return r.prune()
+}
+
+// prune removes rotated files according to maxFiles and maxAge
+func (r *rotator) prune() error {
+	rotated, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return err
+	}
+	rotated = slices.DeleteFunc(rotated, func(name string) bool {
+		_, err := time.Parse(rotatedFormat, strings.TrimPrefix(name, r.path+"."))
+		return err != nil
+	})
+	// the newest go first
+	slices.Sort(rotated)
+	slices.Reverse(rotated)
+
+	for i, name := range rotated {
+		expired := false
+		if r.maxAge > 0 {
+			ts, _ := time.Parse(rotatedFormat, strings.TrimPrefix(name, r.path+"."))
+			expired = time.Since(ts) > r.maxAge
+		}
+		if r.maxFiles > 0 && i >= r.maxFiles || expired {
+			if err = os.Remove(name); err != nil {
+				return fmt.Errorf("remove %q failed: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
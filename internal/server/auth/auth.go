@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrAuthRequired is returned for commands sent before successful authentication
+var ErrAuthRequired = errors.New("authentication required")
+
+// ErrAuthFailed is returned for wrong credentials. It never tells which part of them is wrong
+var ErrAuthFailed = errors.New("authentication failed")
+
+// dummyHash is compared against when user is unknown, so response time does not reveal existing users
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+// compare checks a password against its bcrypt hash. Tests count the calls
+var compare = bcrypt.CompareHashAndPassword
+
+// User is a single entry of the credentials file.
+// Password holds a bcrypt hash, e.g. generated by htpasswd -nbB user password.
+// Tokens hold sha256 hex digests of random tokens, e.g. generated by printf %s token | sha256sum.
+// Tokens are long and random, so a fast hash is enough and checking one costs a single lookup
+type User struct {
+	Name     string   `mapstructure:"name"`
+	Password string   `mapstructure:"password"`
+	Tokens   []string `mapstructure:"tokens"`
+}
+
+type file struct {
+	Users []User `mapstructure:"users"`
+}
+
+// Auth checks credentials loaded from the credentials file
+type Auth struct {
+	users map[string]User
+	// tokens maps sha256 of tokens to user names
+	tokens map[[sha256.Size]byte]string
+}
+
+// New loads credentials from path. Format is defined by the file extension (yaml, json, toml).
+// Returns nil Auth if path is empty
+func New(path string) (*Auth, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("read credentials failed: %w", err)
+	}
+	var f file
+	if err := v.Unmarshal(&f); err != nil {
+		return nil, fmt.Errorf("unmarshal credentials failed: %w", err)
+	}
+
+	a := Auth{users: make(map[string]User, len(f.Users)), tokens: make(map[[sha256.Size]byte]string)}
+	for _, u := range f.Users {
+		if u.Name == "" {
+			return nil, fmt.Errorf("invalid credentials: %w", errors.New("user without name"))
+		}
+		if _, ok := a.users[u.Name]; ok {
+			return nil, fmt.Errorf("invalid credentials: duplicate user %q", u.Name)
+		}
+		a.users[u.Name] = u
+		for _, token := range u.Tokens {
+			var sum [sha256.Size]byte
+			if n, err := hex.Decode(sum[:], []byte(token)); err != nil || n != sha256.Size {
+				return nil, fmt.Errorf("invalid credentials: token of user %q is not a sha256 hex digest", u.Name)
+			}
+			if _, ok := a.tokens[sum]; ok {
+				return nil, fmt.Errorf("invalid credentials: duplicate token of user %q", u.Name)
+			}
+			a.tokens[sum] = u.Name
+		}
+	}
+
+	return &a, nil
+}
+
+// Password returns ErrAuthFailed if pass does not match the password of user
+func (a *Auth) Password(user, pass string) error {
+	u, ok := a.users[user]
+	if !ok || u.Password == "" {
+		_ = compare(dummyHash, []byte(pass))
+		return ErrAuthFailed
+	}
+	if compare([]byte(u.Password), []byte(pass)) != nil {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// Token returns the name of the user token belongs to or ErrAuthFailed.
+// A wrong token costs a sha256 and a map lookup, it never runs bcrypt
+func (a *Auth) Token(token string) (string, error) {
+	user, ok := a.tokens[sha256.Sum256([]byte(token))]
+	if !ok {
+		return "", ErrAuthFailed
+	}
+	return user, nil
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"custom-in-memory-db/internal/server/auth/authtest"
+	"encoding/hex"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNew_EmptyPath(t *testing.T) {
+	a, err := New("")
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+}
+
+func TestNew_Negative_NoFile(t *testing.T) {
+	_, err := New(filepath.Join(t.TempDir(), "users.yaml"))
+	assert.Error(t, err)
+}
+
+func TestNew_Negative_DuplicateUser(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("users:\n  - name: a\n  - name: a\n"), 0600))
+
+	_, err := New(path)
+	assert.EqualError(t, err, `invalid credentials: duplicate user "a"`)
+}
+
+func TestAuth_Password(t *testing.T) {
+	a, err := New(authtest.WriteFile(t, "admin", "secret", "token"))
+	require.NoError(t, err)
+
+	assert.NoError(t, a.Password("admin", "secret"))
+	assert.ErrorIs(t, a.Password("admin", "token"), ErrAuthFailed)
+	assert.ErrorIs(t, a.Password("nobody", "secret"), ErrAuthFailed)
+}
+
+func TestAuth_Token(t *testing.T) {
+	a, err := New(authtest.WriteFile(t, "admin", "secret", "token"))
+	require.NoError(t, err)
+
+	user, err := a.Token("token")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", user)
+
+	_, err = a.Token("secret")
+	assert.ErrorIs(t, err, ErrAuthFailed)
+}
+
+// countCompares makes compare count its calls until the test ends
+func countCompares(t *testing.T) *int {
+	var n int
+	t.Cleanup(func() { compare = bcrypt.CompareHashAndPassword })
+	compare = func(hash, password []byte) error {
+		n++
+		return bcrypt.CompareHashAndPassword(hash, password)
+	}
+	return &n
+}
+
+func TestAuth_Token_Cost(t *testing.T) {
+	// many users with many tokens must not make a wrong token more expensive
+	var content strings.Builder
+	content.WriteString("users:\n")
+	for i := range 20 {
+		fmt.Fprintf(&content, "  - name: user_%d\n    tokens:\n", i)
+		for j := range 5 {
+			sum := sha256.Sum256([]byte(fmt.Sprintf("token_%d_%d", i, j)))
+			fmt.Fprintf(&content, "      - '%s'\n", hex.EncodeToString(sum[:]))
+		}
+	}
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content.String()), 0600))
+	a, err := New(path)
+	require.NoError(t, err)
+
+	compares := countCompares(t)
+	user, err := a.Token("token_7_3")
+	assert.NoError(t, err)
+	assert.Equal(t, "user_7", user)
+	_, err = a.Token("wrong")
+	assert.ErrorIs(t, err, ErrAuthFailed)
+	assert.Zero(t, *compares)
+
+	// an unknown user costs a single compare like a known one
+	assert.ErrorIs(t, a.Password("nobody", "secret"), ErrAuthFailed)
+	assert.Equal(t, 1, *compares)
+}
+
+func TestNew_Negative_BcryptToken(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("token"), bcrypt.MinCost)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("users:\n  - name: a\n    tokens: ['"+string(hash)+"']\n"), 0600))
+
+	_, err = New(path)
+	assert.EqualError(t, err, `invalid credentials: token of user "a" is not a sha256 hex digest`)
+}
@@ -0,0 +1,34 @@
+// Package authtest provides helpers for tests depending on authentication
+package authtest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// WriteFile writes a credentials file with a single user to a temporary directory and returns its path.
+// The password hash uses bcrypt.MinCost to keep tests fast
+func WriteFile(t testing.TB, user, password, token string) string {
+	t.Helper()
+	hash := func(s string) string {
+		h, err := bcrypt.GenerateFromPassword([]byte(s), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(h)
+	}
+
+	sum := sha256.Sum256([]byte(token))
+
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	content := fmt.Sprintf("users:\n  - name: %s\n    password: '%s'\n    tokens:\n      - '%s'\n", user, hash(password), hex.EncodeToString(sum[:]))
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
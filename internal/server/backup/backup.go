@@ -0,0 +1,238 @@
+// Package backup makes consistent copies of wal storage while the server keeps serving traffic
+// and rebuilds WAL_SEG_PATH from them.
+// A backup is a folder with a snapshot of the storage, the wal records written while the snapshot was being saved
+// and a manifest listing both files with their checksums. The manifest is written last, a folder without it is incomplete
+package backup
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"custom-in-memory-db/internal/fsutil"
+	"custom-in-memory-db/internal/server/db/seg"
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// File names in a backup folder
+const (
+	ManifestName = "manifest.json"
+	SnapshotName = "snapshot"
+	WalName      = "wal"
+)
+
+// version of the backup format
+const version = 1
+
+// ErrRunning is returned if a backup is requested while another one is in progress
+var ErrRunning = errors.New("another backup is in progress")
+
+// Manifest describes a backup
+type Manifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// SnapshotLSN is LSN of the last record included into the snapshot
+	SnapshotLSN uint64 `json:"snapshot_lsn"`
+	// LSN of the last record of the backup, restoring it recovers the state as of this record
+	LSN uint64 `json:"lsn"`
+	// Keys is the number of keys in the snapshot
+	Keys int `json:"keys"`
+	// Records is the number of wal records after the snapshot
+	Records int    `json:"records"`
+	Files   []File `json:"files"`
+}
+
+// File is a file of a backup
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Backup makes backups of a wal storage
+type Backup struct {
+	wl      *wal.Storage
+	segPath string
+	// running allows a single backup at a time
+	running sync.Mutex
+}
+
+// New returns Backup of wl which writes its segments to segPath
+func New(wl *wal.Storage, segPath string) *Backup {
+	return &Backup{wl: wl, segPath: segPath}
+}
+
+// Create writes a backup to dir, which must not exist or be empty.
+// The snapshot is taken between wal batches, then the records written meanwhile are copied from the segments,
+// so commands are only held for the time the storage is copied in memory
+func (b *Backup) Create(ctx context.Context, dir string) (Manifest, error) {
+	const suf = "backup.Create()"
+	if !b.running.TryLock() {
+		return Manifest{}, ErrRunning
+	}
+	defer b.running.Unlock()
+	// compaction must not remove segments before the records after the snapshot are copied from them
+	defer b.wl.LockSegments()()
+
+	if err := makeDir(dir); err != nil {
+		return Manifest{}, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	state, lsn, err := b.wl.Checkpoint()
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	m := Manifest{Version: version, Created: time.Now().UTC(), SnapshotLSN: lsn, LSN: lsn, Keys: len(state)}
+	h := wal.SnapshotHeader{LSN: lsn, Time: m.Created}
+	snapshot, err := writeFile(path.Join(dir, SnapshotName), func(w io.Writer) error {
+		return wal.WriteSnapshot(w, h, state, b.wl.Keys())
+	})
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	// every record up to the current LSN is in the segments already
+	m.LSN = b.wl.LSN()
+	tail, err := writeFile(path.Join(dir, WalName), func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		if m.Records, err = b.copyTail(ctx, bw, lsn, m.LSN); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	m.Files = []File{snapshot, tail}
+
+	err = fsutil.WriteFile(path.Join(dir, ManifestName), func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	})
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	return m, nil
+}
+
+// copyTail writes records with LSN after from up to to including it from the segments to w
+func (b *Backup) copyTail(ctx context.Context, w io.Writer, from, to uint64) (int, error) {
+	if from == to {
+		return 0, nil
+	}
+	// segments are listed after to is known, so none of its records is missed
+	files, err := seg.List(b.segPath)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, file := range files {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+		done, err := copyRecords(file, b.wl.Keys(), w, from, to, &n)
+		if err != nil || done {
+			return n, err
+		}
+	}
+	// the last records belong to a batch which failed to write
+	return n, nil
+}
+
+// copyRecords copies records of the segment file within (from, to] to w adding their number to n.
+// Records are encrypted again with the active key of keys unless they are nil.
+// Corrupt records are skipped the same way recovery skips them. Returns true once the record to is copied
+func copyRecords(file string, keys *wal.Keys, w io.Writer, from, to uint64, n *int) (bool, error) {
+	f, err := seg.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	r := wal.NewReader(f, keys)
+	for {
+		rec, _, err := r.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+		var corrupt *wal.CorruptError
+		if errors.As(err, &corrupt) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("segment %q: %w", file, err)
+		}
+		if rec.LSN <= from {
+			continue
+		}
+		if rec.LSN > to {
+			return true, nil
+		}
+		if _, err = w.Write(keys.Seal(rec.Bytes())); err != nil {
+			return false, err
+		}
+		*n++
+		if rec.LSN == to {
+			return true, nil
+		}
+	}
+}
+
+// makeDir creates dir unless it exists and is empty
+func makeDir(dir string) error {
+	err := os.Mkdir(dir, 0750)
+	if !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("backup folder %q is not empty", dir)
+	}
+	return nil
+}
+
+// writeFile atomically writes the file at pth and returns its description for the manifest
+func writeFile(pth string, write func(w io.Writer) error) (File, error) {
+	var size counter
+	sum := sha256.New()
+	err := fsutil.WriteFile(pth, func(w io.Writer) error {
+		return write(io.MultiWriter(w, sum, &size))
+	})
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: path.Base(pth), Size: int64(size), SHA256: hex.EncodeToString(sum.Sum(nil))}, nil
+}
+
+// counter counts bytes written to it
+type counter int64
+
+func (c *counter) Write(p []byte) (int, error) {
+	*c += counter(len(p))
+	return len(p), nil
+}
+
+// checksum returns size and sha256 of the file at pth
+func checksum(pth string) (int64, string, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	sum := sha256.New()
+	n, err := io.Copy(sum, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(sum.Sum(nil)), nil
+}
@@ -0,0 +1,160 @@
+package backup
+
+import (
+	"context"
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/db/seg"
+	_map "custom-in-memory-db/internal/server/db/storage/map"
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"encoding/base64"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var nilLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func walConf(dir string) cmd.Config {
+	return cmd.Config{Wal: cmd.Wal{SegPath: dir, SegSize: 4 * cmd.KB, BatchMax: 8, BatchTimeout: time.Millisecond,
+		SyncMode: seg.SyncBatch, Recover: true}}
+}
+
+// recovered returns the state wal in dir recovers to
+func recovered(t *testing.T, dir string) (map[string]string, uint64) {
+	st := _map.New()
+	wl, err := wal.New(walConf(dir), st, nilLogger)
+	require.NoError(t, err)
+	defer wl.Close()
+	return st.Snapshot(), wl.LSN()
+}
+
+func TestCreate_Restore(t *testing.T) {
+	conf := walConf(t.TempDir())
+	st := _map.New()
+	wl, err := wal.New(conf, st, nilLogger)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, wl.Set(fmt.Sprintf("old_%d", i), "v"))
+	}
+
+	// writes go on while the backup is made
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				_ = wl.Set(fmt.Sprintf("c%d_%d", i, j), "v")
+				_ = wl.Del(fmt.Sprintf("old_%d", j%100))
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	dir := filepath.Join(t.TempDir(), "backup")
+	m, err := New(wl, conf.Wal.SegPath).Create(context.Background(), dir)
+	require.NoError(t, err)
+	close(stop)
+	wg.Wait()
+	require.NoError(t, wl.Close())
+	assert.GreaterOrEqual(t, m.LSN, m.SnapshotLSN)
+
+	segPath := t.TempDir()
+	restored, err := Restore(dir, segPath, nil)
+	require.NoError(t, err)
+	assert.Equal(t, m, restored)
+
+	// the backup recovers to the same state as the source wal recovered up to the backup LSN
+	sg, err := seg.New(conf)
+	require.NoError(t, err)
+	expected := _map.New()
+	_, err = wal.RecoverUntil(sg, nil, wal.Target{LSN: m.LSN}, wal.RecoveryStrict, expected.Set, expected.Del, nilLogger)
+	require.NoError(t, err)
+	require.NoError(t, sg.Close())
+	state, lsn := recovered(t, segPath)
+	assert.Equal(t, expected.Snapshot(), state)
+	assert.Equal(t, m.LSN, lsn)
+
+	_, err = Restore(dir, segPath, nil)
+	assert.ErrorContains(t, err, "already has wal, move it away first")
+}
+
+func TestCreate_Busy(t *testing.T) {
+	conf := walConf(t.TempDir())
+	wl, err := wal.New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	defer wl.Close()
+	b := New(wl, conf.Wal.SegPath)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), nil, 0644))
+	_, err = b.Create(context.Background(), dir)
+	assert.ErrorContains(t, err, "is not empty")
+
+	b.running.Lock()
+	_, err = b.Create(context.Background(), t.TempDir())
+	assert.ErrorIs(t, err, ErrRunning)
+	b.running.Unlock()
+}
+
+func TestVerify(t *testing.T) {
+	conf := walConf(t.TempDir())
+	wl, err := wal.New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	require.NoError(t, wl.Set("a", "1"))
+	dir := filepath.Join(t.TempDir(), "backup")
+	_, err = New(wl, conf.Wal.SegPath).Create(context.Background(), dir)
+	require.NoError(t, err)
+	require.NoError(t, wl.Close())
+
+	_, err = Verify(dir, nil)
+	require.NoError(t, err)
+
+	snapshot := filepath.Join(dir, SnapshotName)
+	data, err := os.ReadFile(snapshot)
+	require.NoError(t, err)
+	data[len(data)-2] = '2'
+	require.NoError(t, os.WriteFile(snapshot, data, 0644))
+	_, err = Verify(dir, nil)
+	assert.ErrorContains(t, err, `"snapshot" does not match the manifest`)
+
+	_, err = Restore(dir, t.TempDir(), nil)
+	assert.ErrorContains(t, err, "backup.Restore() failed")
+}
+
+func TestCreate_Encrypted(t *testing.T) {
+	conf := walConf(t.TempDir())
+	conf.Wal.KeyFile = filepath.Join(t.TempDir(), "wal.keys")
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	require.NoError(t, os.WriteFile(conf.Wal.KeyFile, []byte("k1 "+key+"\n"), 0600))
+	wl, err := wal.New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	require.NoError(t, wl.Set("secret", "v"))
+	dir := filepath.Join(t.TempDir(), "backup")
+	_, err = New(wl, conf.Wal.SegPath).Create(context.Background(), dir)
+	require.NoError(t, err)
+	require.NoError(t, wl.Set("tail", "v"))
+	require.NoError(t, wl.Close())
+
+	for _, name := range []string{SnapshotName, WalName} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "secret")
+	}
+	_, err = Verify(dir, nil)
+	assert.ErrorContains(t, err, "WAL_KEY_FILE is not set")
+	_, err = Verify(dir, wl.Keys())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,130 @@
+package backup
+
+import (
+	"custom-in-memory-db/internal/fsutil"
+	"custom-in-memory-db/internal/server/db/seg"
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// Restore checks the backup in from and rebuilds segPath out of it: the snapshot becomes the snapshot of segPath
+// and the wal records after it become its first segment. segPath must have neither segments nor a snapshot.
+// An encrypted backup is checked with keys, its files are copied as they are
+func Restore(from, segPath string, keys *wal.Keys) (Manifest, error) {
+	const suf = "backup.Restore()"
+	m, err := Verify(from, keys)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	if err = os.MkdirAll(segPath, 0750); err != nil {
+		return Manifest{}, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	segments, err := seg.List(segPath)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	_, err = os.Stat(path.Join(segPath, seg.SnapshotName))
+	if len(segments) > 0 || err == nil {
+		return Manifest{}, fmt.Errorf("%s failed: %q already has wal, move it away first", suf, segPath)
+	}
+
+	// the snapshot goes last, without it a partial restore does not pass for a complete one
+	if m.Records > 0 {
+		if err = copyFile(path.Join(from, WalName), path.Join(segPath, "1")); err != nil {
+			return Manifest{}, fmt.Errorf("%s failed: %w", suf, err)
+		}
+	}
+	if err = copyFile(path.Join(from, SnapshotName), path.Join(segPath, seg.SnapshotName)); err != nil {
+		return Manifest{}, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	return m, nil
+}
+
+// Verify reads the manifest of the backup in dir and checks the files against it, encrypted files are decrypted with keys
+func Verify(dir string, keys *wal.Keys) (Manifest, error) {
+	data, err := os.ReadFile(path.Join(dir, ManifestName))
+	if err != nil {
+		return Manifest{}, err
+	}
+	var m Manifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("bad manifest: %w", err)
+	}
+	if m.Version != version {
+		return Manifest{}, fmt.Errorf("backup version %d is not supported, %d expected", m.Version, version)
+	}
+	if len(m.Files) != 2 || m.Files[0].Name != SnapshotName || m.Files[1].Name != WalName {
+		return Manifest{}, fmt.Errorf("manifest lists %d files, %q and %q expected", len(m.Files), SnapshotName, WalName)
+	}
+	for _, file := range m.Files {
+		size, sum, err := checksum(path.Join(dir, file.Name))
+		if err != nil {
+			return Manifest{}, err
+		}
+		if size != file.Size || sum != file.SHA256 {
+			return Manifest{}, fmt.Errorf("%q does not match the manifest: size %d sha256 %s, size %d sha256 %s expected",
+				file.Name, size, sum, file.Size, file.SHA256)
+		}
+	}
+
+	h, _, err := wal.ReadSnapshotFile(path.Join(dir, SnapshotName), keys, func(k, v string) error { return nil })
+	if err != nil {
+		return Manifest{}, err
+	}
+	if h.LSN != m.SnapshotLSN || h.Keys != m.Keys {
+		return Manifest{}, fmt.Errorf("snapshot at lsn %d with %d keys, lsn %d with %d keys expected",
+			h.LSN, h.Keys, m.SnapshotLSN, m.Keys)
+	}
+	if err = verifyTail(path.Join(dir, WalName), keys, m); err != nil {
+		return Manifest{}, err
+	}
+	return m, nil
+}
+
+// verifyTail checks that wal records of the backup are intact and go in order after the snapshot
+func verifyTail(file string, keys *wal.Keys, m Manifest) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := wal.NewReader(f, keys)
+	last := m.SnapshotLSN
+	var n int
+	for {
+		rec, offset, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%q: %w", WalName, err)
+		}
+		if rec.LSN <= last || rec.LSN > m.LSN {
+			return fmt.Errorf("%q: offset %d: lsn %d out of order after %d", WalName, offset, rec.LSN, last)
+		}
+		last = rec.LSN
+		n++
+	}
+	if n != m.Records {
+		return fmt.Errorf("%q has %d records, %d expected", WalName, n, m.Records)
+	}
+	return nil
+}
+
+// copyFile atomically copies the file src to dst
+func copyFile(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fsutil.WriteFile(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	})
+}
@@ -4,8 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-playground/validator/v10"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+	"io"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"strconv"
@@ -34,10 +38,27 @@ type Network struct {
 	Host string `mapstructure:"net_address" validate:"ip4_addr"`
 	// port to listen. defaults to 8080
 	Port int `mapstructure:"net_port" validate:"numeric,gt=0,lt=65536"`
-	// maximum accepted connections. Defaults to runtime.NumCPU()
+	// maximum commands and http requests served at once, idle connections do not count. Defaults to runtime.NumCPU()
 	MaxConn int `mapstructure:"net_max_conn" validate:"numeric,gte=0"`
 	// idle connection timeout min 1ms. defaults to 1s
 	Timeout time.Duration `mapstructure:"net_timeout" validate:"min=1ms"`
+	// time requests in flight are given to finish on shutdown, min 1ms. defaults to 10s
+	ShutdownTimeout time.Duration `mapstructure:"net_shutdown_timeout" validate:"min=1ms"`
+	// PEM certificate file. Enables TLS if set. defaults to empty
+	TlsCert string `mapstructure:"net_tls_cert" validate:"required_with=TlsKey,omitempty,file"`
+	// PEM private key file of TlsCert. defaults to empty
+	TlsKey string `mapstructure:"net_tls_key" validate:"required_with=TlsCert,omitempty,file"`
+	// PEM CA bundle to verify client certificates. defaults to empty
+	TlsCA string `mapstructure:"net_tls_ca" validate:"required_unless=TlsClientAuth none,omitempty,file"`
+	// client certificate policy: none, optional (verify if given) or require. defaults to none
+	TlsClientAuth string `mapstructure:"net_tls_client_auth" validate:"oneof=none optional require"`
+}
+
+type Auth struct {
+	// credentials file (yaml, json or toml) with bcrypt hashed passwords and sha256 hashed tokens. Enables authentication if set. defaults to empty
+	File string `mapstructure:"auth_file" validate:"omitempty,file"`
+	// json file with permissions of users. Enables ACL if set, changes made by ACL SETUSER are saved to it. defaults to empty
+	AclFile string `mapstructure:"acl_file" validate:"omitempty,file"`
 }
 
 type Wal struct {
@@ -51,6 +72,60 @@ type Wal struct {
 	SegPath string `mapstructure:"wal_seg_path" validate:"dir"`
 	// recover from wal on db start. defaults to true
 	Recover bool `mapstructure:"wal_replay" validate:"boolean"`
+	// recover wal up to RFC3339 time or LSN including it. The server is read only if wal goes on after it. defaults to empty
+	RecoverUntil string `mapstructure:"wal_recover_until" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00|number"`
+	// what recovery does with corrupt records: strict (refuses to start), tolerant (skips them) or
+	// truncate-tail (cuts them off the end of the last segment, refuses to start on others). defaults to tolerant
+	RecoveryMode string `mapstructure:"wal_recovery_mode" validate:"oneof=strict tolerant truncate-tail"`
+	// when wal is fsynced: always (every command), batch (every batch), everysec (once a second) or none (by OS). defaults to batch
+	SyncMode string `mapstructure:"wal_sync_mode" validate:"oneof=always batch everysec none"`
+	// codec closed segments and snapshots are compressed with: none, snappy, zstd or gzip. defaults to none
+	Compression string `mapstructure:"wal_compression" validate:"oneof=none snappy zstd gzip"`
+	// file with AES keys records and snapshots are encrypted with, a line "<id> <key in base64>" per key,
+	// the last one encrypts. defaults to empty, no encryption
+	KeyFile string `mapstructure:"wal_key_file" validate:"omitempty,file"`
+	// read plain records and snapshot lines along with encrypted ones, to encrypt wal written before KeyFile was set.
+	// defaults to false, plain lines fail recovery when KeyFile is set
+	KeyAllowPlain bool `mapstructure:"wal_key_allow_plain" validate:"boolean"`
+	// closed segments size KB compaction folds them into the snapshot at, 0 disables compaction. defaults to 0
+	CompactMinSize int `mapstructure:"wal_compact_min_size" validate:"numeric,gte=0"`
+	// estimated share of superseded records in closed segments compaction starts at, from 0 to 1. defaults to 0.5
+	CompactRatio float64 `mapstructure:"wal_compact_ratio" validate:"gte=0,lte=1"`
+}
+
+type Admin struct {
+	// host:port to serve /metrics and other admin routes on. Admin server is disabled if empty. defaults to empty
+	Address string `mapstructure:"admin_address" validate:"omitempty,hostname_port"`
+	// commands executed longer are recorded to slowlog, 0 disables it. defaults to 100ms
+	SlowlogThreshold time.Duration `mapstructure:"slowlog_threshold" validate:"min=0"`
+	// number of commands slowlog keeps. defaults to 128
+	SlowlogMaxLen int `mapstructure:"slowlog_max_len" validate:"numeric,gt=0"`
+}
+
+type Audit struct {
+	// file to append audit records to. Enables audit log if set, its folder must exist. defaults to empty
+	LogFile string `mapstructure:"audit_file" validate:"omitempty,filepath"`
+	// how SET and CONFIG SET values are recorded: omit or hash (sha256). defaults to omit
+	Values string `mapstructure:"audit_values" validate:"oneof=omit hash"`
+	// file size KB the audit file is rotated at, min 1. defaults to 10240
+	MaxSize int `mapstructure:"audit_max_size" validate:"numeric,gt=0"`
+	// number of rotated audit files kept, 0 keeps all. defaults to 10
+	MaxFiles int `mapstructure:"audit_max_files" validate:"numeric,gte=0"`
+	// age rotated audit files are removed at, 0 keeps them forever. defaults to 0
+	MaxAge time.Duration `mapstructure:"audit_max_age" validate:"min=0"`
+}
+
+type Tracing struct {
+	// span exporter: none, stdout, file or otlp. defaults to none
+	Exporter string `mapstructure:"trace_exporter" validate:"oneof=none stdout file otlp"`
+	// file spans are appended to by file exporter, its folder must exist. defaults to empty
+	TraceFile string `mapstructure:"trace_file" validate:"required_if=Exporter file,omitempty,filepath"`
+	// host:port of OTLP/HTTP collector used by otlp exporter. defaults to empty
+	OtlpEndpoint string `mapstructure:"trace_otlp_endpoint" validate:"required_if=Exporter otlp,omitempty,hostname_port"`
+	// disables TLS of OtlpEndpoint. defaults to false
+	OtlpInsecure bool `mapstructure:"trace_otlp_insecure" validate:"boolean"`
+	// share of traces started by the server which are sampled, from 0 to 1. defaults to 1
+	SampleRatio float64 `mapstructure:"trace_sample_ratio" validate:"gte=0,lte=1"`
 }
 
 type Config struct {
@@ -58,47 +133,180 @@ type Config struct {
 	Network Network `mapstructure:",squash"`
 	Logging Logging `mapstructure:",squash"`
 	Wal     Wal     `mapstructure:",squash"`
+	Auth    Auth    `mapstructure:",squash"`
+	Admin   Admin   `mapstructure:",squash"`
+	Audit   Audit   `mapstructure:",squash"`
+	Tracing Tracing `mapstructure:",squash"`
+}
+
+// Options are command line options which are not config parameters
+type Options struct {
+	// yaml, toml or json file config parameters are read from
+	ConfigFile string
+	// print effective config and exit
+	PrintConfig bool
+	// RestoreFrom is the backup folder "ramdb-server restore --from" rebuilds WAL_SEG_PATH from before exiting
+	RestoreFrom string
 }
 
-func New() (Config, error) {
+// New loads config from command line flags, RAMDB_* env, config file and defaults,
+// in that order of precedence. args are command line arguments without program name,
+// they may start with "restore" subcommand
+func New(args []string) (Config, Options, error) {
 	c := Config{}
 
-	err := c.loadEnv()
+	opts, err := c.load(args)
 	if err != nil {
-		return Config{}, fmt.Errorf("config unmarshalling error: %w", err)
+		return Config{}, opts, fmt.Errorf("config unmarshalling error: %w", err)
 	}
 
 	err = c.validate()
 	if err != nil {
-		return Config{}, fmt.Errorf("config validation error: %w", errors.New(c.handleValidatorError(err)))
+		return Config{}, opts, fmt.Errorf("config validation error: %w", errors.New(c.handleValidatorError(err)))
 	}
 
 	c.Wal.SegSize *= KB
 
-	return c, nil
+	return c, opts, nil
+}
+
+// Params returns config parameters by their env names without prefix, e.g. "net_port".
+// Values are formatted the same way they are set
+func (c Config) Params() map[string]string {
+	result := make(map[string]string)
+	v := reflect.ValueOf(c)
+	for i := 0; i < v.NumField(); i++ {
+		group := v.Field(i)
+		for j := 0; j < group.NumField(); j++ {
+			name := group.Type().Field(j).Tag.Get("mapstructure")
+			result[name] = fmt.Sprint(group.Field(j).Interface())
+		}
+	}
+	// SegSize is converted to bytes in New
+	result["wal_seg_size"] = strconv.Itoa(c.Wal.SegSize / KB)
+	return result
+}
+
+// Print writes config parameters to w in yaml, which can be loaded back with --config
+func (c Config) Print(w io.Writer) error {
+	params := c.Params()
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, name := range c.names() {
+		doc.Content = append(doc.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: name},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: params[name]})
+	}
+
+	enc := yaml.NewEncoder(w)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
 }
 
-func (c *Config) loadEnv() error {
-	viper.SetEnvPrefix("ramdb")
+func (c *Config) load(args []string) (Options, error) {
+	v := viper.New()
+	opts := Options{}
+
+	flags := pflag.NewFlagSet("ramdb-server", pflag.ContinueOnError)
+	flags.StringVar(&opts.ConfigFile, "config", "", "yaml, toml or json config file")
+	flags.BoolVar(&opts.PrintConfig, "print-config", false, "print effective config and exit")
+	restore := len(args) > 0 && args[0] == "restore"
+	if restore {
+		flags.StringVar(&opts.RestoreFrom, "from", "", "backup folder to rebuild WAL_SEG_PATH from")
+		args = args[1:]
+	}
+	c.setFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+	if restore && opts.RestoreFrom == "" {
+		return opts, errors.New("restore requires --from")
+	}
+	for _, name := range c.names() {
+		if err := v.BindPFlag(name, flags.Lookup(strings.ReplaceAll(name, "_", "-"))); err != nil {
+			return opts, err
+		}
+	}
+
+	v.SetEnvPrefix("ramdb")
+
+	c.setEngineEnv(v)
+	c.setLoggingEnv(v)
+	c.setNetworkEnv(v)
+	c.setWalEnv(v)
+	c.setAuthEnv(v)
+	c.setAdminEnv(v)
+	c.setAuditEnv(v)
+	c.setTracingEnv(v)
 
-	c.setEngineEnv()
-	c.setLoggingEnv()
-	c.setNetworkEnv()
-	c.setWalEnv()
+	v.AutomaticEnv()
+
+	if opts.ConfigFile != "" {
+		switch ext := filepath.Ext(opts.ConfigFile); ext {
+		case ".yaml", ".yml", ".toml", ".json":
+		default:
+			return opts, fmt.Errorf("config file extension '%s' unsupported, yaml, yml, toml or json expected", ext)
+		}
+		v.SetConfigFile(opts.ConfigFile)
+		if err := v.ReadInConfig(); err != nil {
+			return opts, err
+		}
+	}
+
+	// unknown keys of config file are most likely typos
+	return opts, v.UnmarshalExact(c)
+}
+
+// names returns config parameter names in order of declaration
+func (c *Config) names() []string {
+	var names []string
+	ref := reflect.TypeOf(*c)
+	for i := 0; i < ref.NumField(); i++ {
+		group := ref.Field(i).Type
+		for j := 0; j < group.NumField(); j++ {
+			names = append(names, group.Field(j).Tag.Get("mapstructure"))
+		}
+	}
+	return names
+}
 
-	viper.AutomaticEnv()
-	return viper.Unmarshal(c)
+// setFlags adds a flag for every config parameter, named as the parameter with dashes, e.g. --net-port.
+// Flag defaults are not used, viper takes flags only if they are set
+func (c *Config) setFlags(flags *pflag.FlagSet) {
+	ref := reflect.TypeOf(*c)
+	for i := 0; i < ref.NumField(); i++ {
+		group := ref.Field(i).Type
+		for j := 0; j < group.NumField(); j++ {
+			f := group.Field(j)
+			name := f.Tag.Get("mapstructure")
+			flag := strings.ReplaceAll(name, "_", "-")
+			usage := "overrides RAMDB_" + strings.ToUpper(name)
+			switch {
+			case f.Type == reflect.TypeOf(time.Duration(0)):
+				flags.Duration(flag, 0, usage)
+			case f.Type.Kind() == reflect.Bool:
+				flags.Bool(flag, false, usage)
+			case f.Type.Kind() == reflect.Int:
+				flags.Int(flag, 0, usage)
+			case f.Type.Kind() == reflect.Float64:
+				flags.Float64(flag, 0, usage)
+			default:
+				flags.String(flag, "", usage)
+			}
+		}
+	}
 }
 
-func (c *Config) setWalEnv() {
-	viper.SetDefault("wal_batch_max", strconv.Itoa(runtime.NumCPU()))
-	_ = viper.BindEnv("wal_batch_max")
+func (c *Config) setWalEnv(v *viper.Viper) {
+	v.SetDefault("wal_batch_max", strconv.Itoa(runtime.NumCPU()))
+	_ = v.BindEnv("wal_batch_max")
 
-	viper.SetDefault("wal_batch_timeout", "1s")
-	_ = viper.BindEnv("wal_batch_timeout")
+	v.SetDefault("wal_batch_timeout", "1s")
+	_ = v.BindEnv("wal_batch_timeout")
 
-	viper.SetDefault("wal_seg_size", "1")
-	_ = viper.BindEnv("wal_seg_size")
+	v.SetDefault("wal_seg_size", "1")
+	_ = v.BindEnv("wal_seg_size")
 
 	path, err := os.Getwd()
 	if err != nil {
@@ -108,41 +316,133 @@ func (c *Config) setWalEnv() {
 			path = "./"
 		}
 	}
-	viper.SetDefault("wal_seg_path", path)
-	_ = viper.BindEnv("wal_seg_path")
+	v.SetDefault("wal_seg_path", path)
+	_ = v.BindEnv("wal_seg_path")
+
+	v.SetDefault("wal_replay", "true")
+	_ = v.BindEnv("wal_replay")
+
+	v.SetDefault("wal_recover_until", "")
+	_ = v.BindEnv("wal_recover_until")
+
+	v.SetDefault("wal_recovery_mode", "tolerant")
+	_ = v.BindEnv("wal_recovery_mode")
+
+	v.SetDefault("wal_sync_mode", "batch")
+	_ = v.BindEnv("wal_sync_mode")
+
+	v.SetDefault("wal_compression", "none")
+	_ = v.BindEnv("wal_compression")
+
+	v.SetDefault("wal_key_file", "")
+	_ = v.BindEnv("wal_key_file")
+
+	v.SetDefault("wal_key_allow_plain", "false")
+	_ = v.BindEnv("wal_key_allow_plain")
+
+	v.SetDefault("wal_compact_min_size", "0")
+	_ = v.BindEnv("wal_compact_min_size")
+
+	v.SetDefault("wal_compact_ratio", "0.5")
+	_ = v.BindEnv("wal_compact_ratio")
+}
+
+func (c *Config) setNetworkEnv(v *viper.Viper) {
+	v.SetDefault("net_proto", "http")
+	_ = v.BindEnv("net_proto")
+
+	v.SetDefault("net_address", "0.0.0.0")
+	_ = v.BindEnv("net_address")
+
+	v.SetDefault("net_port", "8080")
+	_ = v.BindEnv("net_port")
+
+	v.SetDefault("net_max_conn", strconv.Itoa(runtime.NumCPU()))
+	_ = v.BindEnv("net_max_conn")
+
+	v.SetDefault("net_timeout", "1s")
+	_ = v.BindEnv("net_timeout")
+
+	v.SetDefault("net_shutdown_timeout", "10s")
+	_ = v.BindEnv("net_shutdown_timeout")
+
+	v.SetDefault("net_tls_cert", "")
+	_ = v.BindEnv("net_tls_cert")
+
+	v.SetDefault("net_tls_key", "")
+	_ = v.BindEnv("net_tls_key")
+
+	v.SetDefault("net_tls_ca", "")
+	_ = v.BindEnv("net_tls_ca")
+
+	v.SetDefault("net_tls_client_auth", "none")
+	_ = v.BindEnv("net_tls_client_auth")
+}
+
+func (c *Config) setAuthEnv(v *viper.Viper) {
+	v.SetDefault("auth_file", "")
+	_ = v.BindEnv("auth_file")
+
+	v.SetDefault("acl_file", "")
+	_ = v.BindEnv("acl_file")
+}
+
+func (c *Config) setAdminEnv(v *viper.Viper) {
+	v.SetDefault("admin_address", "")
+	_ = v.BindEnv("admin_address")
+
+	v.SetDefault("slowlog_threshold", "100ms")
+	_ = v.BindEnv("slowlog_threshold")
+
+	v.SetDefault("slowlog_max_len", "128")
+	_ = v.BindEnv("slowlog_max_len")
+}
+
+func (c *Config) setAuditEnv(v *viper.Viper) {
+	v.SetDefault("audit_file", "")
+	_ = v.BindEnv("audit_file")
+
+	v.SetDefault("audit_values", "omit")
+	_ = v.BindEnv("audit_values")
+
+	v.SetDefault("audit_max_size", "10240")
+	_ = v.BindEnv("audit_max_size")
+
+	v.SetDefault("audit_max_files", "10")
+	_ = v.BindEnv("audit_max_files")
 
-	viper.SetDefault("wal_replay", "true")
-	_ = viper.BindEnv("wal_replay")
+	v.SetDefault("audit_max_age", "0")
+	_ = v.BindEnv("audit_max_age")
 }
 
-func (c *Config) setNetworkEnv() {
-	viper.SetDefault("net_proto", "http")
-	_ = viper.BindEnv("net_proto")
+func (c *Config) setTracingEnv(v *viper.Viper) {
+	v.SetDefault("trace_exporter", "none")
+	_ = v.BindEnv("trace_exporter")
 
-	viper.SetDefault("net_address", "0.0.0.0")
-	_ = viper.BindEnv("net_address")
+	v.SetDefault("trace_file", "")
+	_ = v.BindEnv("trace_file")
 
-	viper.SetDefault("net_port", "8080")
-	_ = viper.BindEnv("net_port")
+	v.SetDefault("trace_otlp_endpoint", "")
+	_ = v.BindEnv("trace_otlp_endpoint")
 
-	viper.SetDefault("net_max_conn", strconv.Itoa(runtime.NumCPU()))
-	_ = viper.BindEnv("net_max_conn")
+	v.SetDefault("trace_otlp_insecure", "false")
+	_ = v.BindEnv("trace_otlp_insecure")
 
-	viper.SetDefault("net_timeout", "1s")
-	_ = viper.BindEnv("net_timeout")
+	v.SetDefault("trace_sample_ratio", "1")
+	_ = v.BindEnv("trace_sample_ratio")
 }
 
-func (c *Config) setLoggingEnv() {
-	viper.SetDefault("log_format", "text")
-	_ = viper.BindEnv("format")
+func (c *Config) setLoggingEnv(v *viper.Viper) {
+	v.SetDefault("log_format", "text")
+	_ = v.BindEnv("format")
 
-	viper.SetDefault("log_level", "info")
-	_ = viper.BindEnv("level")
+	v.SetDefault("log_level", "info")
+	_ = v.BindEnv("level")
 }
 
-func (c *Config) setEngineEnv() {
-	viper.SetDefault("storage", "wal")
-	_ = viper.BindEnv("storage")
+func (c *Config) setEngineEnv(v *viper.Viper) {
+	v.SetDefault("storage", "wal")
+	_ = v.BindEnv("storage")
 }
 
 func (c *Config) validate() error {
@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path"
 	"runtime"
 	"strconv"
 	"testing"
@@ -43,19 +46,30 @@ func TestConfig_Positive_AllPresent(t *testing.T) {
 			"RAMDB_NET_PORT":     "8081",
 			"RAMDB_NET_MAX_CONN": "1001",
 			"RAMDB_NET_TIMEOUT":  "601s",
+			// Network.ShutdownTimeout
+			"RAMDB_NET_SHUTDOWN_TIMEOUT": "30s",
 			// type Wal struct
 			"RAMDB_WAL_BATCH_MAX":     "100",
 			"RAMDB_WAL_BATCH_TIMEOUT": "101s",
 			"RAMDB_WAL_SEG_SIZE":      "41",
 			"RAMDB_WAL_SEG_PATH":      "./",
 			"RAMDB_WAL_REPLAY":        "false",
+			// Wal.SyncMode
+			"RAMDB_WAL_SYNC_MODE": "everysec",
+			// Wal compaction
+			"RAMDB_WAL_COMPACT_MIN_SIZE": "1024",
+			"RAMDB_WAL_COMPACT_RATIO":    "0.8",
+			// Wal.Compression
+			"RAMDB_WAL_COMPRESSION": "zstd",
+			// Wal.RecoveryMode
+			"RAMDB_WAL_RECOVERY_MODE": "strict",
 		},
 	}
 
 	setEnv(test.env)
 	defer unsetEnv(test.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.NoError(t, err)
 
 	assert.Equal(t, test.env["RAMDB_STORAGE"], conf.Engine.Type)
@@ -77,6 +91,10 @@ func TestConfig_Positive_AllPresent(t *testing.T) {
 	d, err := time.ParseDuration(test.env["RAMDB_NET_TIMEOUT"])
 	assert.Equal(t, d, conf.Network.Timeout)
 	assert.NoError(t, err)
+
+	d, err = time.ParseDuration(test.env["RAMDB_NET_SHUTDOWN_TIMEOUT"])
+	assert.Equal(t, d, conf.Network.ShutdownTimeout)
+	assert.NoError(t, err)
 	// WAL
 	i, err = strconv.Atoi(os.Getenv("RAMDB_WAL_BATCH_MAX"))
 	assert.Equal(t, i, conf.Wal.BatchMax)
@@ -95,6 +113,12 @@ func TestConfig_Positive_AllPresent(t *testing.T) {
 	b, err := strconv.ParseBool(test.env["RAMDB_WAL_REPLAY"])
 	assert.Equal(t, b, conf.Wal.Recover)
 
+	assert.Equal(t, test.env["RAMDB_WAL_SYNC_MODE"], conf.Wal.SyncMode)
+	assert.Equal(t, 1024, conf.Wal.CompactMinSize)
+	assert.Equal(t, 0.8, conf.Wal.CompactRatio)
+	assert.Equal(t, "zstd", conf.Wal.Compression)
+	assert.Equal(t, "strict", conf.Wal.RecoveryMode)
+
 }
 
 func TestConfig_Positive_AllOptionalMissing(t *testing.T) {
@@ -130,7 +154,7 @@ func TestConfig_Positive_AllOptionalMissing(t *testing.T) {
 	setEnv(test.env)
 	defer unsetEnv(test.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.NoError(t, err)
 
 	assert.Equal(t, "wal", conf.Engine.Type)
@@ -146,6 +170,7 @@ func TestConfig_Positive_AllOptionalMissing(t *testing.T) {
 	d, err := time.ParseDuration("1s")
 	assert.Equal(t, d, conf.Network.Timeout)
 	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, conf.Network.ShutdownTimeout)
 	// WAL
 	assert.Equal(t, runtime.NumCPU(), conf.Wal.BatchMax)
 
@@ -161,6 +186,11 @@ func TestConfig_Positive_AllOptionalMissing(t *testing.T) {
 
 	b, err := strconv.ParseBool("true")
 	assert.Equal(t, b, conf.Wal.Recover)
+	assert.Equal(t, "batch", conf.Wal.SyncMode)
+	assert.Equal(t, 0, conf.Wal.CompactMinSize)
+	assert.Equal(t, 0.5, conf.Wal.CompactRatio)
+	assert.Equal(t, "none", conf.Wal.Compression)
+	assert.Equal(t, "tolerant", conf.Wal.RecoveryMode)
 }
 
 // Engine
@@ -182,7 +212,7 @@ func TestConfig_Positive_RAMDB_STORAGE_AllValid(t *testing.T) {
 
 	for _, test := range tests {
 		setEnv(test.env)
-		conf, err := New()
+		conf, _, err := New(nil)
 		assert.NoError(t, err)
 		assert.Equal(t, test.env["RAMDB_STORAGE"], conf.Engine.Type)
 		unsetEnv(test.env)
@@ -204,7 +234,7 @@ func TestConfig_Negative_BogusArg_RAMDB_STORAGE(t *testing.T) {
 	setEnv(test.env)
 	defer unsetEnv(test.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.EqualError(t, err, test.err)
 	assert.Equal(t, Config{}, conf)
 }
@@ -228,7 +258,7 @@ func TestConfig_Positive_RAMDB_LOG_FORMAT_AllValid(t *testing.T) {
 
 	for _, test := range tests {
 		setEnv(test.env)
-		conf, err := New()
+		conf, _, err := New(nil)
 		assert.NoError(t, err)
 		assert.Equal(t, test.env["RAMDB_LOG_FORMAT"], conf.Logging.Format)
 		unsetEnv(test.env)
@@ -265,7 +295,7 @@ func TestConfig_Positive_RAMDB_LOG_LEVEL_AllValid(t *testing.T) {
 
 	for _, test := range tests {
 		setEnv(test.env)
-		conf, err := New()
+		conf, _, err := New(nil)
 		assert.NoError(t, err)
 		assert.Equal(t, test.env["RAMDB_LOG_LEVEL"], conf.Logging.Level)
 		unsetEnv(test.env)
@@ -286,7 +316,7 @@ func TestConfig_Negative_BogusArg_RAMDB_LOG_FORMAT(t *testing.T) {
 	setEnv(test.env)
 	defer unsetEnv(test.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.EqualError(t, err, expectedError)
 	assert.Equal(t, Config{}, conf)
 }
@@ -305,7 +335,7 @@ func TestConfig_Negative_BogusArg_RAMDB_LOG_LEVEL(t *testing.T) {
 	setEnv(test.env)
 	defer unsetEnv(test.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, expectedError)
 }
@@ -329,7 +359,7 @@ func TestConfig_Positive_RAMDB_NET_PROTO_AllValid(t *testing.T) {
 
 	for _, test := range tests {
 		setEnv(test.env)
-		conf, err := New()
+		conf, _, err := New(nil)
 		assert.NoError(t, err)
 		assert.Equal(t, test.env["RAMDB_NET_PROTO"], conf.Network.Endpoint)
 		unsetEnv(test.env)
@@ -350,7 +380,7 @@ func TestConfig_Negative_BogusArg_RAMDB_NET_PROTO(t *testing.T) {
 	setEnv(test.env)
 	defer unsetEnv(test.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, expectedError)
 }
@@ -369,7 +399,7 @@ func TestConfig_Negative_BogusArg_RAMDB_NET_ADDRESS(t *testing.T) {
 	setEnv(testCase.env)
 	defer unsetEnv(testCase.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, testCase.err)
 }
@@ -388,7 +418,7 @@ func TestConfig_Negative_BogusArg_RAMDB_NET_PORT_More65536(t *testing.T) {
 	setEnv(testCase.env)
 	defer unsetEnv(testCase.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, testCase.err)
 }
@@ -407,7 +437,7 @@ func TestConfig_Negative_BogusArg_RAMDB_NET_PORT_Less_0(t *testing.T) {
 	setEnv(testCase.env)
 	defer unsetEnv(testCase.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, testCase.err)
 }
@@ -426,7 +456,7 @@ func TestConfig_Negative_BogusArg_RAMDB_NET_MAX_CONN(t *testing.T) {
 	setEnv(testCase.env)
 	defer unsetEnv(testCase.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, testCase.err)
 }
@@ -445,7 +475,26 @@ func TestConfig_Negative_BogusArg_RAMDB_NET_TIMEOUT(t *testing.T) {
 	setEnv(testCase.env)
 	defer unsetEnv(testCase.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_NET_SHUTDOWN_TIMEOUT(t *testing.T) {
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_NET_SHUTDOWN_TIMEOUT": "0s",
+		},
+		err: "config validation error: field 'ShutdownTimeout' value '0s' invalid, 'min=1ms' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, testCase.err)
 }
@@ -466,7 +515,7 @@ func TestConfig_Negative_BogusArg_RAMDB_WAL_BATCH_MAX(t *testing.T) {
 	setEnv(testCase.env)
 	defer unsetEnv(testCase.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, testCase.err)
 }
@@ -485,7 +534,7 @@ func TestConfig_Negative_BogusArg_RAMDB_WAL_BATCH_TIMEOUT(t *testing.T) {
 	setEnv(testCase.env)
 	defer unsetEnv(testCase.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, testCase.err)
 }
@@ -504,7 +553,7 @@ func TestConfig_Negative_BogusArg_RAMDB_WAL_BATCH_TIMEOUT_BogusDuration(t *testi
 	setEnv(testCase.env)
 	defer unsetEnv(testCase.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, testCase.err)
 }
@@ -523,7 +572,7 @@ func TestConfig_Negative_BogusArg_RAMDB_WAL_SEG_SIZE(t *testing.T) {
 	setEnv(testCase.env)
 	defer unsetEnv(testCase.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, testCase.err)
 }
@@ -542,7 +591,7 @@ func TestConfig_Negative_BogusArg_RAMDB_WAL_SEG_PATH(t *testing.T) {
 	setEnv(testCase.env)
 	defer unsetEnv(testCase.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, testCase.err)
 }
@@ -561,7 +610,693 @@ func TestConfig_Negative_BogusArg_RAMDB_WAL_REPLAY(t *testing.T) {
 	setEnv(testCase.env)
 	defer unsetEnv(testCase.env)
 
-	conf, err := New()
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+func TestConfig_Positive_RAMDB_WAL_RECOVER_UNTIL(t *testing.T) {
+	for _, until := range []string{"2024-05-01T10:00:00Z", "2024-05-01T10:00:00.5+03:00", "42"} {
+		env := map[string]string{"RAMDB_WAL_RECOVER_UNTIL": until}
+		setEnv(env)
+		conf, _, err := New(nil)
+		unsetEnv(env)
+		assert.NoError(t, err)
+		assert.Equal(t, until, conf.Wal.RecoverUntil)
+	}
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_WAL_RECOVER_UNTIL(t *testing.T) {
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_WAL_RECOVER_UNTIL": "yesterday",
+		},
+		err: "config validation error: field 'RecoverUntil' value 'yesterday' invalid, 'omitempty,datetime=2006-01-02T15:04:05Z07:00|number' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_WAL_SYNC_MODE(t *testing.T) {
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_WAL_SYNC_MODE": "never",
+		},
+		err: "config validation error: field 'SyncMode' value 'never' invalid, 'oneof=always batch everysec none' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_WAL_COMPRESSION(t *testing.T) {
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_WAL_COMPRESSION": "lz4",
+		},
+		err: "config validation error: field 'Compression' value 'lz4' invalid, 'oneof=none snappy zstd gzip' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_WAL_RECOVERY_MODE(t *testing.T) {
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_WAL_RECOVERY_MODE": "skip",
+		},
+		err: "config validation error: field 'RecoveryMode' value 'skip' invalid, 'oneof=strict tolerant truncate-tail' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+func TestConfig_Positive_RAMDB_WAL_KEY_FILE(t *testing.T) {
+	file := path.Join(t.TempDir(), "wal.keys")
+	assert.NoError(t, os.WriteFile(file, []byte{}, 0600))
+	test := testCase{
+		env: map[string]string{
+			"RAMDB_WAL_KEY_FILE": file,
+		},
+	}
+
+	setEnv(test.env)
+	defer unsetEnv(test.env)
+
+	conf, _, err := New(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, file, conf.Wal.KeyFile)
+}
+
+func TestConfig_Positive_RAMDB_WAL_KEY_ALLOW_PLAIN(t *testing.T) {
+	conf, _, err := New(nil)
+	assert.NoError(t, err)
+	assert.False(t, conf.Wal.KeyAllowPlain)
+
+	test := testCase{
+		env: map[string]string{
+			"RAMDB_WAL_KEY_ALLOW_PLAIN": "true",
+		},
+	}
+	setEnv(test.env)
+	defer unsetEnv(test.env)
+
+	conf, _, err = New(nil)
+	assert.NoError(t, err)
+	assert.True(t, conf.Wal.KeyAllowPlain)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_WAL_KEY_FILE(t *testing.T) {
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_WAL_KEY_FILE": "./q.keys",
+		},
+		err: "config validation error: field 'KeyFile' value './q.keys' invalid, 'omitempty,file' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_WAL_COMPACT(t *testing.T) {
+	testCases := []struct {
+		env map[string]string
+		err string
+	}{
+		{
+			env: map[string]string{"RAMDB_WAL_COMPACT_MIN_SIZE": "-1"},
+			err: "config validation error: field 'CompactMinSize' value '%!s(int=-1)' invalid, 'numeric,gte=0' expected;",
+		},
+		{
+			env: map[string]string{"RAMDB_WAL_COMPACT_RATIO": "1.5"},
+			err: "config validation error: field 'CompactRatio' value '%!s(float64=1.5)' invalid, 'gte=0,lte=1' expected;",
+		},
+	}
+
+	for _, testCase := range testCases {
+		setEnv(testCase.env)
+		conf, _, err := New(nil)
+		unsetEnv(testCase.env)
+		assert.Equal(t, Config{}, conf)
+		assert.EqualError(t, err, testCase.err)
+	}
+}
+
+// TLS
+
+func TestConfig_Positive_RAMDB_NET_TLS_AllPresent(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"cert.pem", "key.pem", "ca.pem"} {
+		assert.NoError(t, os.WriteFile(path.Join(dir, name), []byte{}, 0600))
+	}
+	test := testCase{
+		env: map[string]string{
+			"RAMDB_NET_TLS_CERT":        path.Join(dir, "cert.pem"),
+			"RAMDB_NET_TLS_KEY":         path.Join(dir, "key.pem"),
+			"RAMDB_NET_TLS_CA":          path.Join(dir, "ca.pem"),
+			"RAMDB_NET_TLS_CLIENT_AUTH": "require",
+		},
+	}
+
+	setEnv(test.env)
+	defer unsetEnv(test.env)
+
+	conf, _, err := New(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, test.env["RAMDB_NET_TLS_CERT"], conf.Network.TlsCert)
+	assert.Equal(t, test.env["RAMDB_NET_TLS_KEY"], conf.Network.TlsKey)
+	assert.Equal(t, test.env["RAMDB_NET_TLS_CA"], conf.Network.TlsCA)
+	assert.Equal(t, test.env["RAMDB_NET_TLS_CLIENT_AUTH"], conf.Network.TlsClientAuth)
+}
+
+func TestConfig_Positive_RAMDB_NET_TLS_Missing(t *testing.T) {
+	conf, _, err := New(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", conf.Network.TlsCert)
+	assert.Equal(t, "", conf.Network.TlsKey)
+	assert.Equal(t, "", conf.Network.TlsCA)
+	assert.Equal(t, "none", conf.Network.TlsClientAuth)
+}
+
+func TestConfig_Negative_RAMDB_NET_TLS_KEY_Missing(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(path.Join(dir, "cert.pem"), []byte{}, 0600))
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_NET_TLS_CERT": path.Join(dir, "cert.pem"),
+		},
+		err: "config validation error: field 'TlsKey' value '' invalid, 'required_with=TlsCert,omitempty,file' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+func TestConfig_Negative_RAMDB_NET_TLS_CA_Missing(t *testing.T) {
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_NET_TLS_CLIENT_AUTH": "optional",
+		},
+		err: "config validation error: field 'TlsCA' value '' invalid, 'required_unless=TlsClientAuth none,omitempty,file' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_NET_TLS_CLIENT_AUTH(t *testing.T) {
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_NET_TLS_CLIENT_AUTH": "always",
+		},
+		err: "config validation error: field 'TlsCA' value '' invalid, 'required_unless=TlsClientAuth none,omitempty,file' expected; " +
+			"field 'TlsClientAuth' value 'always' invalid, 'oneof=none optional require' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_NET_TLS_CERT(t *testing.T) {
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_NET_TLS_CERT": "./q.pem",
+			"RAMDB_NET_TLS_KEY":  "./cmd.go",
+		},
+		err: "config validation error: field 'TlsCert' value './q.pem' invalid, 'required_with=TlsKey,omitempty,file' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+// Auth
+
+func TestConfig_Positive_RAMDB_AUTH_FILE(t *testing.T) {
+	file := path.Join(t.TempDir(), "users.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte{}, 0600))
+	test := testCase{
+		env: map[string]string{
+			"RAMDB_AUTH_FILE": file,
+		},
+	}
+
+	setEnv(test.env)
+	defer unsetEnv(test.env)
+
+	conf, _, err := New(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, file, conf.Auth.File)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_AUTH_FILE(t *testing.T) {
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_AUTH_FILE": "./q.yaml",
+		},
+		err: "config validation error: field 'File' value './q.yaml' invalid, 'omitempty,file' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+func TestConfig_Positive_RAMDB_ACL_FILE(t *testing.T) {
+	file := path.Join(t.TempDir(), "acl.json")
+	assert.NoError(t, os.WriteFile(file, []byte{}, 0600))
+	test := testCase{
+		env: map[string]string{
+			"RAMDB_ACL_FILE": file,
+		},
+	}
+
+	setEnv(test.env)
+	defer unsetEnv(test.env)
+
+	conf, _, err := New(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, file, conf.Auth.AclFile)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_ACL_FILE(t *testing.T) {
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_ACL_FILE": "./q.json",
+		},
+		err: "config validation error: field 'AclFile' value './q.json' invalid, 'omitempty,file' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
+	assert.Equal(t, Config{}, conf)
+	assert.EqualError(t, err, testCase.err)
+}
+
+// Admin
+
+func TestConfig_Positive_RAMDB_ADMIN_ADDRESS(t *testing.T) {
+	test := testCase{
+		env: map[string]string{
+			"RAMDB_ADMIN_ADDRESS": "127.0.0.1:9090",
+		},
+	}
+
+	setEnv(test.env)
+	defer unsetEnv(test.env)
+
+	conf, _, err := New(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, test.env["RAMDB_ADMIN_ADDRESS"], conf.Admin.Address)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_ADMIN_ADDRESS(t *testing.T) {
+	testCase := struct {
+		env map[string]string
+		err string
+	}{
+		env: map[string]string{
+			"RAMDB_ADMIN_ADDRESS": "9090",
+		},
+		err: "config validation error: field 'Address' value '9090' invalid, 'omitempty,hostname_port' expected;",
+	}
+
+	setEnv(testCase.env)
+	defer unsetEnv(testCase.env)
+
+	conf, _, err := New(nil)
 	assert.Equal(t, Config{}, conf)
 	assert.EqualError(t, err, testCase.err)
 }
+
+func TestConfig_Positive_RAMDB_SLOWLOG(t *testing.T) {
+	test := testCase{
+		env: map[string]string{
+			"RAMDB_SLOWLOG_THRESHOLD": "0",
+			"RAMDB_SLOWLOG_MAX_LEN":   "16",
+		},
+	}
+
+	setEnv(test.env)
+	defer unsetEnv(test.env)
+
+	conf, _, err := New(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), conf.Admin.SlowlogThreshold)
+	assert.Equal(t, 16, conf.Admin.SlowlogMaxLen)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_SLOWLOG(t *testing.T) {
+	testCases := []struct {
+		env map[string]string
+		err string
+	}{
+		{
+			env: map[string]string{"RAMDB_SLOWLOG_THRESHOLD": "-1s"},
+			err: "config validation error: field 'SlowlogThreshold' value '-1s' invalid, 'min=0' expected;",
+		},
+		{
+			env: map[string]string{"RAMDB_SLOWLOG_MAX_LEN": "0"},
+			err: "config validation error: field 'SlowlogMaxLen' value '%!s(int=0)' invalid, 'numeric,gt=0' expected;",
+		},
+	}
+
+	for _, testCase := range testCases {
+		setEnv(testCase.env)
+		conf, _, err := New(nil)
+		unsetEnv(testCase.env)
+
+		assert.Equal(t, Config{}, conf)
+		assert.EqualError(t, err, testCase.err)
+	}
+}
+
+// Audit
+
+func TestConfig_Positive_RAMDB_AUDIT(t *testing.T) {
+	file := path.Join(t.TempDir(), "audit.log")
+	test := testCase{
+		env: map[string]string{
+			"RAMDB_AUDIT_FILE":      file,
+			"RAMDB_AUDIT_VALUES":    "hash",
+			"RAMDB_AUDIT_MAX_SIZE":  "1",
+			"RAMDB_AUDIT_MAX_FILES": "0",
+			"RAMDB_AUDIT_MAX_AGE":   "720h",
+		},
+	}
+
+	setEnv(test.env)
+	defer unsetEnv(test.env)
+
+	conf, _, err := New(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Audit{LogFile: file, Values: "hash", MaxSize: 1, MaxFiles: 0, MaxAge: 720 * time.Hour}, conf.Audit)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_AUDIT(t *testing.T) {
+	testCases := []struct {
+		env map[string]string
+		err string
+	}{
+		{
+			env: map[string]string{"RAMDB_AUDIT_VALUES": "plain"},
+			err: "config validation error: field 'Values' value 'plain' invalid, 'oneof=omit hash' expected;",
+		},
+		{
+			env: map[string]string{"RAMDB_AUDIT_MAX_SIZE": "0"},
+			err: "config validation error: field 'MaxSize' value '%!s(int=0)' invalid, 'numeric,gt=0' expected;",
+		},
+		{
+			env: map[string]string{"RAMDB_AUDIT_MAX_FILES": "-1"},
+			err: "config validation error: field 'MaxFiles' value '%!s(int=-1)' invalid, 'numeric,gte=0' expected;",
+		},
+		{
+			env: map[string]string{"RAMDB_AUDIT_MAX_AGE": "-1h"},
+			err: "config validation error: field 'MaxAge' value '-1h0m0s' invalid, 'min=0' expected;",
+		},
+		{
+			env: map[string]string{"RAMDB_AUDIT_FILE": os.TempDir()},
+			err: fmt.Sprintf("config validation error: field 'LogFile' value '%s' invalid, 'omitempty,filepath' expected;", os.TempDir()),
+		},
+	}
+
+	for _, testCase := range testCases {
+		setEnv(testCase.env)
+		conf, _, err := New(nil)
+		unsetEnv(testCase.env)
+
+		assert.Equal(t, Config{}, conf)
+		assert.EqualError(t, err, testCase.err)
+	}
+}
+
+// Tracing
+
+func TestConfig_Positive_RAMDB_TRACE(t *testing.T) {
+	test := testCase{
+		env: map[string]string{
+			"RAMDB_TRACE_EXPORTER":      "otlp",
+			"RAMDB_TRACE_OTLP_ENDPOINT": "localhost:4318",
+			"RAMDB_TRACE_OTLP_INSECURE": "true",
+			"RAMDB_TRACE_SAMPLE_RATIO":  "0.25",
+		},
+	}
+
+	setEnv(test.env)
+	defer unsetEnv(test.env)
+
+	conf, _, err := New(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Tracing{Exporter: "otlp", OtlpEndpoint: "localhost:4318", OtlpInsecure: true, SampleRatio: 0.25}, conf.Tracing)
+}
+
+func TestConfig_Negative_BogusArg_RAMDB_TRACE(t *testing.T) {
+	testCases := []struct {
+		env map[string]string
+		err string
+	}{
+		{
+			env: map[string]string{"RAMDB_TRACE_EXPORTER": "jaeger"},
+			err: "config validation error: field 'Exporter' value 'jaeger' invalid, 'oneof=none stdout file otlp' expected;",
+		},
+		{
+			env: map[string]string{"RAMDB_TRACE_EXPORTER": "file"},
+			err: "config validation error: field 'TraceFile' value '' invalid, 'required_if=Exporter file,omitempty,filepath' expected;",
+		},
+		{
+			env: map[string]string{"RAMDB_TRACE_EXPORTER": "otlp"},
+			err: "config validation error: field 'OtlpEndpoint' value '' invalid, 'required_if=Exporter otlp,omitempty,hostname_port' expected;",
+		},
+		{
+			env: map[string]string{"RAMDB_TRACE_SAMPLE_RATIO": "2"},
+			err: "config validation error: field 'SampleRatio' value '%!s(float64=2)' invalid, 'gte=0,lte=1' expected;",
+		},
+	}
+
+	for _, testCase := range testCases {
+		setEnv(testCase.env)
+		conf, _, err := New(nil)
+		unsetEnv(testCase.env)
+
+		assert.Equal(t, Config{}, conf)
+		assert.EqualError(t, err, testCase.err)
+	}
+}
+
+// Config file and flags
+
+func writeConfig(t *testing.T, name, data string) string {
+	file := path.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(file, []byte(data), 0600))
+	return file
+}
+
+func TestConfig_Positive_ConfigFile_AllFormats(t *testing.T) {
+	files := []string{
+		writeConfig(t, "ramdb.yaml", "net_port: 9001\nstorage: map\nwal_replay: false\n"),
+		writeConfig(t, "ramdb.toml", "net_port = 9001\nstorage = \"map\"\nwal_replay = false\n"),
+		writeConfig(t, "ramdb.json", `{"net_port": 9001, "storage": "map", "wal_replay": false}`),
+	}
+
+	for _, file := range files {
+		conf, opts, err := New([]string{"--config", file})
+		assert.NoError(t, err)
+		assert.Equal(t, Options{ConfigFile: file}, opts)
+		assert.Equal(t, 9001, conf.Network.Port)
+		assert.Equal(t, "map", conf.Engine.Type)
+		assert.Equal(t, false, conf.Wal.Recover)
+		// defaults fill the rest
+		assert.Equal(t, "http", conf.Network.Endpoint)
+	}
+}
+
+func TestConfig_Positive_Precedence(t *testing.T) {
+	file := writeConfig(t, "ramdb.yaml", "net_port: 9001\nnet_timeout: 2s\nlog_level: debug\n")
+	test := testCase{
+		env: map[string]string{
+			"RAMDB_NET_PORT":    "9002",
+			"RAMDB_NET_TIMEOUT": "3s",
+		},
+	}
+
+	setEnv(test.env)
+	defer unsetEnv(test.env)
+
+	conf, _, err := New([]string{"--config", file, "--net-port", "9003"})
+	assert.NoError(t, err)
+	// flag > env > file > default
+	assert.Equal(t, 9003, conf.Network.Port)
+	assert.Equal(t, 3*time.Second, conf.Network.Timeout)
+	assert.Equal(t, "debug", conf.Logging.Level)
+	assert.Equal(t, "text", conf.Logging.Format)
+}
+
+func TestConfig_Positive_Flags(t *testing.T) {
+	conf, opts, err := New([]string{"--print-config", "--storage", "map", "--wal-batch-timeout", "5ms",
+		"--wal-seg-size", "2", "--trace-sample-ratio", "0.5", "--trace-otlp-insecure"})
+	assert.NoError(t, err)
+	assert.Equal(t, Options{PrintConfig: true}, opts)
+	assert.Equal(t, "map", conf.Engine.Type)
+	assert.Equal(t, 5*time.Millisecond, conf.Wal.BatchTimeout)
+	assert.Equal(t, 2*KB, conf.Wal.SegSize)
+	assert.Equal(t, 0.5, conf.Tracing.SampleRatio)
+	assert.Equal(t, true, conf.Tracing.OtlpInsecure)
+}
+
+func TestConfig_Positive_Restore(t *testing.T) {
+	dir := t.TempDir()
+	conf, opts, err := New([]string{"restore", "--from", "/var/backups/ramdb", "--wal-seg-path", dir})
+	assert.NoError(t, err)
+	assert.Equal(t, Options{RestoreFrom: "/var/backups/ramdb"}, opts)
+	assert.Equal(t, dir, conf.Wal.SegPath)
+}
+
+func TestConfig_Negative_ConfigFileAndFlags(t *testing.T) {
+	testCases := []struct {
+		args []string
+		err  string
+	}{
+		{
+			args: []string{"--config", writeConfig(t, "ramdb.yaml", "net_prot: http\n")},
+			err:  "config unmarshalling error: 1 error(s) decoding:\n\n* '' has invalid keys: net_prot",
+		},
+		{
+			args: []string{"--config", writeConfig(t, "ramdb.yaml", "storage: disk\n")},
+			err:  "config validation error: field 'Type' value 'disk' invalid, 'oneof=map wal' expected;",
+		},
+		{
+			args: []string{"--config", writeConfig(t, "ramdb.ini", "")},
+			err:  "config unmarshalling error: config file extension '.ini' unsupported, yaml, yml, toml or json expected",
+		},
+		{
+			args: []string{"--net-port", "http"},
+			err:  "config unmarshalling error: invalid argument \"http\" for \"--net-port\" flag: strconv.ParseInt: parsing \"http\": invalid syntax",
+		},
+		{
+			args: []string{"--net-proto", "udp"},
+			err:  "config validation error: field 'Endpoint' value 'udp' invalid, 'oneof=tcp http' expected;",
+		},
+		{
+			args: []string{"restore"},
+			err:  "config unmarshalling error: restore requires --from",
+		},
+		{
+			args: []string{"--from", "/var/backups/ramdb"},
+			err:  "config unmarshalling error: unknown flag: --from",
+		},
+	}
+
+	for _, testCase := range testCases {
+		conf, _, err := New(testCase.args)
+
+		assert.Equal(t, Config{}, conf)
+		assert.EqualError(t, err, testCase.err)
+	}
+}
+
+func TestConfig_Print(t *testing.T) {
+	conf, _, err := New([]string{"--net-port", "9001", "--wal-seg-size", "2"})
+	assert.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, conf.Print(buf))
+	assert.Contains(t, buf.String(), "storage: wal\nnet_proto: http\n")
+	assert.Contains(t, buf.String(), "net_port: 9001\n")
+	assert.Contains(t, buf.String(), "wal_seg_size: 2\n")
+
+	// printed config loads back to the same config
+	loaded, _, err := New([]string{"--config", writeConfig(t, "ramdb.yaml", buf.String())})
+	assert.NoError(t, err)
+	assert.Equal(t, conf, loaded)
+}
+
+func TestConfig_Params(t *testing.T) {
+	conf, _, err := New(nil)
+	assert.NoError(t, err)
+
+	params := conf.Params()
+	assert.Equal(t, "8080", params["net_port"])
+	assert.Equal(t, "1s", params["net_timeout"])
+	assert.Equal(t, "1", params["wal_seg_size"])
+	assert.Equal(t, "true", params["wal_replay"])
+	assert.Equal(t, "", params["auth_file"])
+	assert.Equal(t, "100ms", params["slowlog_threshold"])
+	assert.Equal(t, "omit", params["audit_values"])
+}
@@ -1,18 +1,24 @@
 package compute
 
 import (
+	"context"
 	"custom-in-memory-db/internal/server/db/parser"
 	"custom-in-memory-db/internal/server/db/storage"
+	"custom-in-memory-db/internal/server/tracing"
 	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"log/slog"
+	"maps"
+	"slices"
+	"strings"
 )
 
 const defaultOk = "OK\n"
 
 type Compute interface {
-	Exec(cmd parser.Command, lg *slog.Logger) (string, error)
+	Exec(ctx context.Context, cmd parser.Command, lg *slog.Logger) (string, error)
 	Close() error
 }
 
@@ -35,27 +41,63 @@ func (c *Comp) Close() error {
 	return nil
 }
 
-func (c *Comp) Exec(cmd parser.Command, lg *slog.Logger) (string, error) {
+func (c *Comp) Exec(ctx context.Context, cmd parser.Command, lg *slog.Logger) (result string, err error) {
+	ctx, span := tracing.Start(ctx, "compute.Exec", attribute.String("db.operation", cmd.Command))
+	defer func() { tracing.End(span, err) }()
+
 	switch cmd.Command {
 	case "GET":
+		_, stSpan := tracing.Start(ctx, "storage.Get")
 		r, err := c.st.Get(cmd.Arg1)
+		tracing.End(stSpan, err)
 		if err != nil {
 			return "", fmt.Errorf("error getting value: %v", err)
 		}
 		return r, nil
 	case "SET":
-		err := c.st.Set(cmd.Arg1, cmd.Arg2)
+		stCtx, stSpan := tracing.Start(ctx, "storage.Set")
+		if cs, ok := c.st.(storage.ContextStorage); ok {
+			err = cs.SetContext(stCtx, cmd.Arg1, cmd.Arg2)
+		} else {
+			err = c.st.Set(cmd.Arg1, cmd.Arg2)
+		}
+		tracing.End(stSpan, err)
 		if err != nil {
 			return "", err
 		}
 		return defaultOk, nil
 	case "DEL":
-		err := c.st.Del(cmd.Arg1)
+		stCtx, stSpan := tracing.Start(ctx, "storage.Del")
+		if cs, ok := c.st.(storage.ContextStorage); ok {
+			err = cs.DelContext(stCtx, cmd.Arg1)
+		} else {
+			err = c.st.Del(cmd.Arg1)
+		}
+		tracing.End(stSpan, err)
 		if err != nil {
-			return "", fmt.Errorf("error deleting value: %v", err)
+			return "", fmt.Errorf("error deleting value: %w", err)
 		}
 		return defaultOk, nil
+	case "DUMP":
+		_, stSpan := tracing.Start(ctx, "storage.Snapshot")
+		snapshot := c.st.Snapshot()
+		stSpan.End()
+		return dump(snapshot), nil
 	default:
 		return "", errors.New("unknown command")
 	}
 }
+
+// dump renders snapshot as "key value" lines sorted by key
+func dump(snapshot map[string]string) string {
+	keys := slices.Sorted(maps.Keys(snapshot))
+
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteString(" ")
+		sb.WriteString(snapshot[k])
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
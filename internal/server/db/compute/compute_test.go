@@ -1,6 +1,7 @@
 package compute
 
 import (
+	"context"
 	"custom-in-memory-db/internal/server/db/parser"
 	"custom-in-memory-db/mocks/storage"
 	"errors"
@@ -50,7 +51,7 @@ func TestComp_GetPositive(t *testing.T) {
 
 	comp := New(st)
 
-	result, err := comp.Exec(testCase.input, nilLogger)
+	result, err := comp.Exec(context.Background(), testCase.input, nilLogger)
 
 	assert.Equal(t, getValue, result)
 	assert.Nil(t, err)
@@ -73,7 +74,7 @@ func TestComp_GetNegative(t *testing.T) {
 
 	comp := New(st)
 
-	result, err := comp.Exec(testCase.input, nilLogger)
+	result, err := comp.Exec(context.Background(), testCase.input, nilLogger)
 
 	assert.EqualError(t, err, testCase.err)
 	assert.Equal(t, nilResult, result)
@@ -95,7 +96,7 @@ func TestComp_SetPositive(t *testing.T) {
 
 	comp := New(st)
 
-	result, err := comp.Exec(testCase.input, nilLogger)
+	result, err := comp.Exec(context.Background(), testCase.input, nilLogger)
 
 	assert.Equal(t, success, result)
 	assert.Nil(t, err)
@@ -116,7 +117,7 @@ func TestComp_DelPositive(t *testing.T) {
 
 	comp := New(st)
 
-	result, err := comp.Exec(testCase.input, nilLogger)
+	result, err := comp.Exec(context.Background(), testCase.input, nilLogger)
 
 	assert.Equal(t, success, result)
 	assert.Nil(t, err)
@@ -139,12 +140,51 @@ func TestComp_DelNegative(t *testing.T) {
 
 	comp := New(st)
 
-	result, err := comp.Exec(testCase.input, nilLogger)
+	result, err := comp.Exec(context.Background(), testCase.input, nilLogger)
 
 	assert.EqualError(t, err, testCase.err)
 	assert.Equal(t, nilResult, result)
 }
 
+func TestComp_DumpPositive(t *testing.T) {
+	testCase := struct {
+		input    parser.Command
+		snapshot map[string]string
+		result   string
+	}{
+		input: parser.Command{
+			Command: "DUMP",
+		},
+		snapshot: map[string]string{
+			"b": "2",
+			"a": "1",
+		},
+		result: "a 1\nb 2\n",
+	}
+
+	st := storage.NewMockStorage(t)
+	st.EXPECT().Snapshot().Return(testCase.snapshot)
+
+	comp := New(st)
+
+	result, err := comp.Exec(context.Background(), testCase.input, nilLogger)
+
+	assert.Equal(t, testCase.result, result)
+	assert.Nil(t, err)
+}
+
+func TestComp_DumpPositive_Empty(t *testing.T) {
+	st := storage.NewMockStorage(t)
+	st.EXPECT().Snapshot().Return(map[string]string{})
+
+	comp := New(st)
+
+	result, err := comp.Exec(context.Background(), parser.Command{Command: "DUMP"}, nilLogger)
+
+	assert.Equal(t, nilResult, result)
+	assert.Nil(t, err)
+}
+
 func TestComp_BogusCommand(t *testing.T) {
 	testCase := struct {
 		input parser.Command
@@ -161,7 +201,7 @@ func TestComp_BogusCommand(t *testing.T) {
 
 	comp := New(st)
 
-	result, err := comp.Exec(testCase.input, nilLogger)
+	result, err := comp.Exec(context.Background(), testCase.input, nilLogger)
 
 	assert.EqualError(t, err, testCase.err)
 	assert.Equal(t, nilResult, result)
@@ -1,13 +1,28 @@
 package db
 
 import (
+	"context"
+	"custom-in-memory-db/internal/server/acl"
+	"custom-in-memory-db/internal/server/admin"
+	"custom-in-memory-db/internal/server/audit"
+	"custom-in-memory-db/internal/server/auth"
+	"custom-in-memory-db/internal/server/backup"
 	"custom-in-memory-db/internal/server/db/compute"
 	"custom-in-memory-db/internal/server/db/parser"
+	"custom-in-memory-db/internal/server/health"
+	"custom-in-memory-db/internal/server/metrics"
 	"custom-in-memory-db/internal/server/network"
+	"custom-in-memory-db/internal/server/slowlog"
+	"custom-in-memory-db/internal/server/tracing"
 	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Database struct {
@@ -15,14 +30,75 @@ type Database struct {
 	pr          parser.Parser
 	netEndpoint network.Endpoint
 	lg          *slog.Logger
+	// auth is nil if authentication is disabled
+	auth *auth.Auth
+	// acl is nil if permissions are not checked
+	acl *acl.ACL
+	// admin is nil if INFO and CONFIG are not supported
+	admin *admin.Registry
+	// slowlog is nil if slow commands are not recorded
+	slowlog *slowlog.Log
+	// audit is nil if audit log is disabled
+	audit *audit.Log
+	// backup is nil if storage does not support BACKUP
+	backup *backup.Backup
 }
 
-func New(comp compute.Compute, netEndpoint network.Endpoint, pr parser.Parser, lg *slog.Logger) Database {
-	return Database{comp: comp, netEndpoint: netEndpoint, pr: pr, lg: lg}
+// Option configures optional Database features
+type Option func(d *Database)
+
+// WithAuth makes Database require authentication before any other command
+func WithAuth(a *auth.Auth) Option {
+	return func(d *Database) {
+		d.auth = a
+	}
+}
+
+// WithACL makes Database check permissions of the user before executing commands
+func WithACL(a *acl.ACL) Option {
+	return func(d *Database) {
+		d.acl = a
+	}
+}
+
+// WithAdmin enables INFO and CONFIG commands
+func WithAdmin(reg *admin.Registry) Option {
+	return func(d *Database) {
+		d.admin = reg
+	}
+}
+
+// WithSlowlog records slow commands and enables SLOWLOG command
+func WithSlowlog(l *slowlog.Log) Option {
+	return func(d *Database) {
+		d.slowlog = l
+	}
+}
+
+// WithAudit records mutating and admin commands to the audit log. Database closes it on Close
+func WithAudit(l *audit.Log) Option {
+	return func(d *Database) {
+		d.audit = l
+	}
+}
+
+// WithBackup enables BACKUP command
+func WithBackup(b *backup.Backup) Option {
+	return func(d *Database) {
+		d.backup = b
+	}
+}
+
+func New(comp compute.Compute, netEndpoint network.Endpoint, pr parser.Parser, lg *slog.Logger, opts ...Option) Database {
+	d := Database{comp: comp, netEndpoint: netEndpoint, pr: pr, lg: lg}
+	for _, opt := range opts {
+		opt(&d)
+	}
+	return d
 }
 
 func (d *Database) Close() error {
-	var err1, err2 error
+	var err1 error
 	closer, ok := d.netEndpoint.(io.Closer)
 	if ok {
 		err1 = closer.Close()
@@ -31,11 +107,39 @@ func (d *Database) Close() error {
 		}
 	}
 
-	err2 = d.comp.Close()
+	return d.closeStorage(err1)
+}
+
+// Shutdown stops the endpoint letting requests in flight finish until ctx is done,
+// then closes compute, which writes commands left in wal batch.
+// Endpoints which can not wait for requests are closed at once
+func (d *Database) Shutdown(ctx context.Context) error {
+	sh, ok := d.netEndpoint.(network.Shutdowner)
+	if !ok {
+		return d.Close()
+	}
+
+	err1 := sh.Shutdown(ctx)
+	if err1 != nil {
+		d.lg.Error("Database.Shutdown().netEndpoint.Shutdown() failed", "error", err1.Error())
+	}
+
+	return d.closeStorage(err1)
+}
+
+// closeStorage closes compute and audit log once the endpoint is closed with err1
+func (d *Database) closeStorage(err1 error) error {
+	err2 := d.comp.Close()
 	if err2 != nil {
 		d.lg.Error("Database.Close().Compute.Close() failed", "error", errors.Unwrap(err2).Error())
 	}
 
+	if d.audit != nil {
+		if err := d.audit.Close(); err != nil {
+			d.lg.Error("Database.Close().audit.Close() failed", "error", err.Error())
+		}
+	}
+
 	if err1 == nil && err2 == nil {
 		return nil
 	}
@@ -43,31 +147,307 @@ func (d *Database) Close() error {
 }
 
 func (d *Database) ListenClient() {
-	handler := func(r io.Reader, lg *slog.Logger) (string, error) {
-		result, err := d.HandleRequest(r, lg)
+	handler := func(ctx context.Context, r io.Reader, lg *slog.Logger) (string, error) {
+		result, err := d.HandleRequest(ctx, r, lg)
 		return result, err
 	}
 	d.lg.Info("listening")
 	d.netEndpoint.Listen(handler)
 }
 
-func (d *Database) HandleRequest(r io.Reader, lg *slog.Logger) (string, error) {
+// HandleRequest reads a single command from r and executes it.
+// ctx carries network.Client of the connection, which keeps authentication state between commands
+func (d *Database) HandleRequest(ctx context.Context, r io.Reader, lg *slog.Logger) (string, error) {
 	const suf = "database.HandleRequest()"
+	received := time.Now()
+	_, span := tracing.Start(ctx, "parser.Read")
 	cmd, err := d.pr.Read(r, lg)
+	span.SetAttributes(attribute.String("db.operation", cmd.Command))
+	tracing.End(span, err)
 	if err != nil {
 		lg.Error(fmt.Sprintf("%s.parser.Read()", suf), "error", err.Error())
+		metrics.Errors.WithLabelValues(metrics.StageParse).Inc()
 		return "", err
 	}
 
+	start := time.Now()
+	result, stage, err := d.execute(ctx, cmd, lg)
+	observe(cmd.Command, stage, err, time.Since(start))
+	if d.slowlog != nil {
+		d.recordSlow(ctx, cmd, received)
+	}
+	if d.audit != nil {
+		d.recordAudit(ctx, cmd, received, err)
+	}
+
+	return result, err
+}
+
+// execute checks permissions and executes cmd. Returns the stage cmd failed at along with the error
+func (d *Database) execute(ctx context.Context, cmd parser.Command, lg *slog.Logger) (string, string, error) {
+	const suf = "database.HandleRequest()"
+	if cmd.Command == "AUTH" {
+		result, err := d.authenticate(ctx, cmd, lg)
+		return result, metrics.StageAuth, err
+	}
+	// PING serves as a readiness probe, so it does not require authentication
+	if cmd.Command == "PING" {
+		if err := health.Ready(); err != nil {
+			return "", metrics.StageExec, err
+		}
+		return "PONG", metrics.StageExec, nil
+	}
+	if d.auth != nil {
+		if client := network.ClientFrom(ctx); client == nil || client.User == "" {
+			lg.Warn(fmt.Sprintf("%s unauthenticated command", suf), "command", cmd.Command)
+			return "", metrics.StageAuth, auth.ErrAuthRequired
+		}
+	}
+
 	lg.Debug(fmt.Sprintf("%s.parser", suf), "result", fmt.Sprintf("%+v", cmd))
 
-	result, err := d.comp.Exec(cmd, lg)
+	user := userFrom(ctx)
+	if d.acl != nil {
+		if err := d.acl.Check(user, cmd); err != nil {
+			lg.Warn(fmt.Sprintf("%s.acl.Check()", suf), "user", user, "command", cmd.Command, "arg", cmd.Arg1)
+			return "", metrics.StageAcl, err
+		}
+	}
+
+	if cmd.Command == "ACL" {
+		result, err := d.aclCommand(user, cmd, lg)
+		return result, metrics.StageExec, err
+	}
+	if cmd.Command == "SLOWLOG" {
+		result, err := d.slowlogCommand(cmd)
+		return result, metrics.StageExec, err
+	}
+	if cmd.Command == "BACKUP" {
+		result, err := d.backupCommand(ctx, cmd, lg)
+		return result, metrics.StageExec, err
+	}
+	if cmd.Command == "INFO" || cmd.Command == "CONFIG" {
+		result, err := d.adminCommand(user, cmd, lg)
+		return result, metrics.StageExec, err
+	}
+
+	result, err := d.comp.Exec(ctx, cmd, lg)
 	if err != nil {
 		lg.Error(fmt.Sprintf("%s.compute.Exec()", suf), "error", err.Error())
-		return "", err
+		return "", metrics.StageExec, err
 	}
 
 	lg.Debug(fmt.Sprintf("%s.compute", suf), "result", fmt.Sprintf("%+v", result))
 
-	return result, nil
+	return result, metrics.StageExec, nil
+}
+
+// observe updates command metrics
+func observe(command, stage string, err error, elapsed time.Duration) {
+	result := metrics.ResultOk
+	if err != nil {
+		result = metrics.ResultError
+		metrics.Errors.WithLabelValues(stage).Inc()
+	}
+	metrics.Commands.WithLabelValues(command, result).Inc()
+	metrics.CommandDuration.WithLabelValues(command, result).Observe(elapsed.Seconds())
+}
+
+// authenticate checks AUTH credentials and binds the user to the connection
+func (d *Database) authenticate(ctx context.Context, cmd parser.Command, lg *slog.Logger) (string, error) {
+	const suf = "database.HandleRequest().authenticate()"
+	if d.auth == nil {
+		return "", errors.New("authentication is disabled")
+	}
+	client := network.ClientFrom(ctx)
+	if client == nil {
+		return "", errors.New("authentication is not supported by the endpoint")
+	}
+
+	if err := d.auth.Password(cmd.Arg1, cmd.Arg2); err != nil {
+		lg.Warn(fmt.Sprintf("%s failed", suf), "user", cmd.Arg1, "error", err.Error())
+		return "", err
+	}
+
+	client.User = cmd.Arg1
+	lg.Info(fmt.Sprintf("%s done", suf), "user", cmd.Arg1)
+	return "OK\n", nil
+}
+
+// userFrom returns the name of the authenticated user or acl.DefaultUser
+func userFrom(ctx context.Context) string {
+	if client := network.ClientFrom(ctx); client != nil && client.User != "" {
+		return client.User
+	}
+	return acl.DefaultUser
+}
+
+// aclCommand executes ACL subcommands. Permissions are already checked
+func (d *Database) aclCommand(user string, cmd parser.Command, lg *slog.Logger) (string, error) {
+	const suf = "database.HandleRequest().aclCommand()"
+	if cmd.Arg1 == "WHOAMI" {
+		return user + "\n", nil
+	}
+	if d.acl == nil {
+		return "", errors.New("acl is disabled")
+	}
+
+	switch cmd.Arg1 {
+	case "LIST":
+		var sb strings.Builder
+		for _, u := range d.acl.List() {
+			sb.WriteString(u.String())
+			sb.WriteString("\n")
+		}
+		return sb.String(), nil
+	case "SETUSER":
+		if err := d.acl.SetUser(cmd.Arg2, cmd.Args); err != nil {
+			lg.Error(fmt.Sprintf("%s failed", suf), "error", err.Error())
+			return "", err
+		}
+		lg.Info(fmt.Sprintf("%s done", suf), "by", user, "user", cmd.Arg2, "rules", strings.Join(cmd.Args, " "))
+		return "OK\n", nil
+	default:
+		return "", fmt.Errorf("%s failed: unexpected subcommand %q", suf, cmd.Arg1)
+	}
+}
+
+// adminCommand executes INFO and CONFIG. Permissions are already checked
+func (d *Database) adminCommand(user string, cmd parser.Command, lg *slog.Logger) (string, error) {
+	const suf = "database.HandleRequest().adminCommand()"
+	if d.admin == nil {
+		return "", errors.New("admin commands are disabled")
+	}
+
+	switch {
+	case cmd.Command == "INFO":
+		sections, err := d.admin.Info(strings.ToLower(cmd.Arg1))
+		if err != nil {
+			return "", err
+		}
+		return admin.FormatInfo(sections), nil
+	case cmd.Arg1 == "GET":
+		fields, err := d.admin.ConfigGet(cmd.Arg2)
+		if err != nil {
+			return "", err
+		}
+		return admin.FormatFields(fields), nil
+	case cmd.Arg1 == "SET":
+		if err := d.admin.ConfigSet(cmd.Arg2, cmd.Args[0]); err != nil {
+			lg.Warn(fmt.Sprintf("%s failed", suf), "error", err.Error())
+			return "", err
+		}
+		lg.Info(fmt.Sprintf("%s done", suf), "by", user, "setting", cmd.Arg2, "value", cmd.Args[0])
+		return "OK\n", nil
+	default:
+		return "", fmt.Errorf("%s failed: unexpected subcommand %q", suf, cmd.Arg1)
+	}
+}
+
+// recordSlow adds cmd to slowlog if it took too long since it was received
+func (d *Database) recordSlow(ctx context.Context, cmd parser.Command, received time.Time) {
+	var args []string
+	// never keep credentials
+	if cmd.Command != "AUTH" {
+		for _, arg := range append([]string{cmd.Arg1, cmd.Arg2}, cmd.Args...) {
+			if arg != "" {
+				args = append(args, arg)
+			}
+		}
+	}
+	var addr, id string
+	if client := network.ClientFrom(ctx); client != nil {
+		addr, id = client.Addr, client.ID
+	}
+	d.slowlog.Record(cmd.Command, args, addr, id, received, time.Since(received))
+}
+
+// slowlogCommand executes SLOWLOG subcommands. Permissions are already checked
+func (d *Database) slowlogCommand(cmd parser.Command) (string, error) {
+	const suf = "database.HandleRequest().slowlogCommand()"
+	if d.slowlog == nil {
+		return "", errors.New("slowlog is disabled")
+	}
+
+	switch cmd.Arg1 {
+	case "GET":
+		n := -1
+		if cmd.Arg2 != "" {
+			var err error
+			if n, err = strconv.Atoi(cmd.Arg2); err != nil || n < 0 {
+				return "", fmt.Errorf("%s failed: invalid count %q", suf, cmd.Arg2)
+			}
+		}
+		var sb strings.Builder
+		for _, e := range d.slowlog.Get(n) {
+			sb.WriteString(e.String())
+			sb.WriteString("\n")
+		}
+		return sb.String(), nil
+	case "LEN":
+		return strconv.Itoa(d.slowlog.Len()), nil
+	case "RESET":
+		d.slowlog.Reset()
+		return "OK\n", nil
+	default:
+		return "", fmt.Errorf("%s failed: unexpected subcommand %q", suf, cmd.Arg1)
+	}
+}
+
+// backupCommand executes BACKUP. Permissions are already checked
+func (d *Database) backupCommand(ctx context.Context, cmd parser.Command, lg *slog.Logger) (string, error) {
+	const suf = "database.HandleRequest().backupCommand()"
+	if d.backup == nil {
+		return "", errors.New("backup is disabled, it needs STORAGE=wal")
+	}
+	start := time.Now()
+	m, err := d.backup.Create(ctx, cmd.Arg1)
+	if err != nil {
+		lg.Error(fmt.Sprintf("%s failed", suf), "dir", cmd.Arg1, "error", err.Error())
+		return "", err
+	}
+	lg.Info("backup done", "dir", cmd.Arg1, "lsn", m.LSN, "keys", m.Keys, "records", m.Records,
+		"duration", time.Since(start).String())
+	return admin.FormatFields([]admin.Field{
+		{Key: "dir", Value: cmd.Arg1},
+		{Key: "lsn", Value: strconv.FormatUint(m.LSN, 10)},
+		{Key: "snapshot_lsn", Value: strconv.FormatUint(m.SnapshotLSN, 10)},
+		{Key: "keys", Value: strconv.Itoa(m.Keys)},
+		{Key: "records", Value: strconv.Itoa(m.Records)},
+		{Key: "duration", Value: time.Since(start).String()},
+	}), nil
+}
+
+// recordAudit adds SET, DEL and admin commands to the audit log, whether they succeeded or not
+func (d *Database) recordAudit(ctx context.Context, cmd parser.Command, received time.Time, err error) {
+	category := acl.Category(cmd)
+	if category != acl.Write && category != acl.Admin || cmd.Command == "AUTH" {
+		return
+	}
+
+	r := audit.Record{Time: received, User: userFrom(ctx), Command: cmd.Command, Result: metrics.ResultOk}
+	if client := network.ClientFrom(ctx); client != nil {
+		r.ClientIP, r.RequestID = client.Addr, client.ID
+		if host, _, err := net.SplitHostPort(client.Addr); err == nil {
+			r.ClientIP = host
+		}
+	}
+	if err != nil {
+		r.Result, r.Error = metrics.ResultError, err.Error()
+	}
+
+	switch cmd.Command {
+	case "SET":
+		r.Key, r.ValueHash = cmd.Arg1, d.audit.Value(cmd.Arg2)
+	case "DEL":
+		r.Key = cmd.Arg1
+	case "BACKUP":
+		// the folder is on the server, so it is worth knowing who wrote there
+		r.Key = cmd.Arg1
+	case "ACL", "CONFIG", "SLOWLOG":
+		r.Command = cmd.Command + " " + cmd.Arg1
+		r.Key = cmd.Arg2
+		r.ValueHash = d.audit.Value(strings.Join(cmd.Args, " "))
+	}
+	d.audit.Write(r)
 }
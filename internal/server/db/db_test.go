@@ -2,7 +2,17 @@ package db
 
 import (
 	"bytes"
+	"context"
+	"custom-in-memory-db/internal/server/acl"
+	"custom-in-memory-db/internal/server/admin"
+	"custom-in-memory-db/internal/server/audit"
+	"custom-in-memory-db/internal/server/auth"
+	"custom-in-memory-db/internal/server/auth/authtest"
+	"custom-in-memory-db/internal/server/cmd"
 	"custom-in-memory-db/internal/server/db/parser"
+	"custom-in-memory-db/internal/server/health"
+	net "custom-in-memory-db/internal/server/network"
+	"custom-in-memory-db/internal/server/slowlog"
 	"custom-in-memory-db/mocks/compute"
 	"custom-in-memory-db/mocks/network"
 	mockParser "custom-in-memory-db/mocks/parser"
@@ -12,7 +22,11 @@ import (
 	"github.com/stretchr/testify/mock"
 	"io"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 var nilLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
@@ -42,7 +56,7 @@ func TestDatabase_HandleRequest_Positive(t *testing.T) {
 	r := bytes.NewBuffer([]byte(testCase.in))
 
 	comp := compute.NewMockCompute(t)
-	comp.EXPECT().Exec(testCase.cmd, nilLogger).Return(testCase.res, nil)
+	comp.EXPECT().Exec(mock.Anything, testCase.cmd, nilLogger).Return(testCase.res, nil)
 
 	netEndpoint := network.NewMockEndpoint(t)
 
@@ -51,7 +65,7 @@ func TestDatabase_HandleRequest_Positive(t *testing.T) {
 
 	db := New(comp, netEndpoint, pr, nilLogger)
 
-	result, err := db.HandleRequest(r, nilLogger)
+	result, err := db.HandleRequest(context.Background(), r, nilLogger)
 	assert.NoError(t, err)
 	assert.Equal(t, testCase.res, result)
 }
@@ -79,7 +93,7 @@ func TestDatabase_HandleRequest_Negative_Parser(t *testing.T) {
 
 	db := New(comp, netEndpoint, pr, nilLogger)
 
-	result, err := db.HandleRequest(r, nilLogger)
+	result, err := db.HandleRequest(context.Background(), r, nilLogger)
 	assert.Empty(t, result)
 	assert.EqualError(t, err, testCase.err)
 }
@@ -100,7 +114,7 @@ func TestDatabase_HandleRequest_Negative_Compute(t *testing.T) {
 	r := bytes.NewBuffer([]byte(testCase.in))
 
 	comp := compute.NewMockCompute(t)
-	comp.EXPECT().Exec(testCase.cmd, nilLogger).Return("", errors.New("test error"))
+	comp.EXPECT().Exec(mock.Anything, testCase.cmd, nilLogger).Return("", errors.New("test error"))
 
 	netEndpoint := network.NewMockEndpoint(t)
 
@@ -109,7 +123,7 @@ func TestDatabase_HandleRequest_Negative_Compute(t *testing.T) {
 
 	db := New(comp, netEndpoint, pr, nilLogger)
 
-	result, err := db.HandleRequest(r, nilLogger)
+	result, err := db.HandleRequest(context.Background(), r, nilLogger)
 	assert.Empty(t, result)
 	assert.EqualError(t, err, testCase.err)
 }
@@ -156,3 +170,239 @@ func TestDatabase_Close_Negative(t *testing.T) {
 	err := db.Close()
 	assert.EqualError(t, err, "Database.Close() failed")
 }
+
+// drainingEndpoint is an endpoint which lets requests in flight finish on Shutdown
+type drainingEndpoint struct {
+	*network.MockEndpoint
+	err error
+}
+
+func (e drainingEndpoint) Shutdown(ctx context.Context) error {
+	return e.err
+}
+
+func TestDatabase_Shutdown(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected string
+	}{
+		{err: nil},
+		{err: context.DeadlineExceeded, expected: "Database.Close() failed"},
+	}
+
+	for _, testCase := range testCases {
+		// storage is closed even if requests did not finish in time
+		comp := compute.NewMockCompute(t)
+		comp.EXPECT().Close().Return(nil)
+
+		db := New(comp, drainingEndpoint{MockEndpoint: network.NewMockEndpoint(t), err: testCase.err}, mockParser.NewMockParser(t), nilLogger)
+
+		err := db.Shutdown(context.Background())
+		if testCase.expected == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, testCase.expected)
+		}
+	}
+}
+
+func TestDatabase_Shutdown_NotDraining(t *testing.T) {
+	comp := compute.NewMockCompute(t)
+	comp.EXPECT().Close().Return(nil)
+
+	netEndpoint := network.NewMockEndpoint(t)
+	netEndpoint.EXPECT().Close().Return(nil)
+
+	db := New(comp, netEndpoint, mockParser.NewMockParser(t), nilLogger)
+
+	assert.NoError(t, db.Shutdown(context.Background()))
+}
+
+func TestDatabase_HandleRequest_Auth(t *testing.T) {
+	a, err := auth.New(authtest.WriteFile(t, "admin", "secret", "token"))
+	assert.NoError(t, err)
+
+	get := parser.Command{Command: "GET", Arg1: "1"}
+	comp := compute.NewMockCompute(t)
+	comp.EXPECT().Exec(mock.Anything, get, nilLogger).Return("2", nil).Once()
+
+	pr := parser.New()
+	db := New(comp, network.NewMockEndpoint(t), pr, nilLogger, WithAuth(a))
+	ctx := net.WithClient(context.Background(), &net.Client{ID: "1"})
+
+	_, err = db.HandleRequest(ctx, bytes.NewBufferString("GET 1\n"), nilLogger)
+	assert.ErrorIs(t, err, auth.ErrAuthRequired)
+
+	_, err = db.HandleRequest(ctx, bytes.NewBufferString("AUTH admin wrong\n"), nilLogger)
+	assert.ErrorIs(t, err, auth.ErrAuthFailed)
+
+	result, err := db.HandleRequest(ctx, bytes.NewBufferString("AUTH admin secret\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", result)
+	assert.Equal(t, "admin", net.ClientFrom(ctx).User)
+
+	result, err = db.HandleRequest(ctx, bytes.NewBufferString("GET 1\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", result)
+
+	result, err = db.HandleRequest(ctx, bytes.NewBufferString("ACL WHOAMI\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin\n", result)
+}
+
+func TestDatabase_HandleRequest_Auth_Disabled(t *testing.T) {
+	db := New(compute.NewMockCompute(t), network.NewMockEndpoint(t), parser.New(), nilLogger)
+	ctx := net.WithClient(context.Background(), &net.Client{ID: "1"})
+
+	_, err := db.HandleRequest(ctx, bytes.NewBufferString("AUTH admin secret\n"), nilLogger)
+	assert.EqualError(t, err, "authentication is disabled")
+}
+
+func TestDatabase_HandleRequest_Acl(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"users": [{"name": "default", "commands": ["read", "admin"], "keys": ["a*"]}]}`), 0600))
+	a, err := acl.New(path)
+	assert.NoError(t, err)
+
+	get := parser.Command{Command: "GET", Arg1: "ab"}
+	comp := compute.NewMockCompute(t)
+	comp.EXPECT().Exec(mock.Anything, get, nilLogger).Return("1", nil).Once()
+
+	db := New(comp, network.NewMockEndpoint(t), parser.New(), nilLogger, WithACL(a))
+	ctx := context.Background()
+
+	result, err := db.HandleRequest(ctx, bytes.NewBufferString("GET ab\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", result)
+
+	_, err = db.HandleRequest(ctx, bytes.NewBufferString("DEL ab\n"), nilLogger)
+	assert.ErrorIs(t, err, acl.ErrPermissionDenied)
+
+	result, err = db.HandleRequest(ctx, bytes.NewBufferString("ACL WHOAMI\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "default\n", result)
+
+	result, err = db.HandleRequest(ctx, bytes.NewBufferString("ACL SETUSER default -admin\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", result)
+
+	_, err = db.HandleRequest(ctx, bytes.NewBufferString("ACL LIST\n"), nilLogger)
+	assert.ErrorIs(t, err, acl.ErrPermissionDenied)
+}
+
+func TestDatabase_HandleRequest_Ping(t *testing.T) {
+	a, err := auth.New(authtest.WriteFile(t, "admin", "secret", "token"))
+	assert.NoError(t, err)
+	// PING requires neither authentication nor compute
+	db := New(compute.NewMockCompute(t), network.NewMockEndpoint(t), parser.New(), nilLogger, WithAuth(a))
+	ctx := net.WithClient(context.Background(), &net.Client{ID: "1"})
+
+	_, err = db.HandleRequest(ctx, bytes.NewBufferString("PING\n"), nilLogger)
+	assert.ErrorIs(t, err, health.ErrNotReady)
+
+	health.SetRecovered()
+	health.SetListening(true)
+	defer health.SetListening(false)
+	result, err := db.HandleRequest(ctx, bytes.NewBufferString("PING\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "PONG", result)
+}
+
+func TestDatabase_HandleRequest_Admin(t *testing.T) {
+	level := "info"
+	reg := admin.NewRegistry(cmd.Config{})
+	reg.AddSection("keyspace", func() []admin.Field { return []admin.Field{{Key: "keys", Value: "2"}} })
+	reg.AddSetting("log_level", admin.Setting{
+		Get: func() string { return level },
+		Set: func(value string) error { level = value; return nil },
+	})
+	db := New(compute.NewMockCompute(t), network.NewMockEndpoint(t), parser.New(), nilLogger, WithAdmin(reg))
+	ctx := context.Background()
+
+	result, err := db.HandleRequest(ctx, bytes.NewBufferString("INFO keyspace\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "# keyspace\nkeys:2\n", result)
+
+	result, err = db.HandleRequest(ctx, bytes.NewBufferString("CONFIG SET log_level debug\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", result)
+
+	result, err = db.HandleRequest(ctx, bytes.NewBufferString("CONFIG GET log_level\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "log_level:debug\n", result)
+
+	_, err = db.HandleRequest(ctx, bytes.NewBufferString("CONFIG SET wal_seg_path /tmp\n"), nilLogger)
+	assert.ErrorIs(t, err, admin.ErrUnknownSetting)
+
+	// map storage has no wal to back up
+	_, err = db.HandleRequest(ctx, bytes.NewBufferString("BACKUP /tmp/backup\n"), nilLogger)
+	assert.EqualError(t, err, "backup is disabled, it needs STORAGE=wal")
+}
+
+func TestDatabase_HandleRequest_Slowlog(t *testing.T) {
+	set := parser.Command{Command: "SET", Arg1: "a", Arg2: "1"}
+	comp := compute.NewMockCompute(t)
+	comp.EXPECT().Exec(mock.Anything, set, nilLogger).Return("OK", nil).Once()
+
+	a, err := auth.New(authtest.WriteFile(t, "admin", "secret", "token"))
+	assert.NoError(t, err)
+	// every command is slow
+	sl := slowlog.New(time.Nanosecond, 8)
+	db := New(comp, network.NewMockEndpoint(t), parser.New(), nilLogger, WithAuth(a), WithSlowlog(sl))
+	ctx := net.WithClient(context.Background(), &net.Client{ID: "1", Addr: "127.0.0.1:5000"})
+
+	_, err = db.HandleRequest(ctx, bytes.NewBufferString("AUTH admin secret\n"), nilLogger)
+	assert.NoError(t, err)
+	_, err = db.HandleRequest(ctx, bytes.NewBufferString("SET a 1\n"), nilLogger)
+	assert.NoError(t, err)
+
+	result, err := db.HandleRequest(ctx, bytes.NewBufferString("SLOWLOG LEN\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", result)
+
+	entries := sl.Get(-1)
+	assert.Equal(t, "SLOWLOG", entries[0].Command)
+	assert.Equal(t, []string{"a", "1"}, entries[1].Args)
+	assert.Equal(t, "127.0.0.1:5000", entries[1].ClientAddr)
+	// credentials are never recorded
+	assert.Equal(t, "AUTH", entries[2].Command)
+	assert.Empty(t, entries[2].Args)
+
+	result, err = db.HandleRequest(ctx, bytes.NewBufferString("SLOWLOG GET 1\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Contains(t, result, `cmd="SLOWLOG LEN"`)
+
+	result, err = db.HandleRequest(ctx, bytes.NewBufferString("SLOWLOG RESET\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", result)
+	assert.Equal(t, 1, sl.Len())
+}
+
+func TestDatabase_HandleRequest_Audit(t *testing.T) {
+	set := parser.Command{Command: "SET", Arg1: "a", Arg2: "1"}
+	get := parser.Command{Command: "GET", Arg1: "a"}
+	comp := compute.NewMockCompute(t)
+	comp.EXPECT().Exec(mock.Anything, set, nilLogger).Return("OK", nil).Once()
+	comp.EXPECT().Exec(mock.Anything, get, nilLogger).Return("1", nil).Once()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.New(audit.Config{Path: path, Values: audit.ValuesHash, MaxSize: 1 << 20}, nilLogger)
+	assert.NoError(t, err)
+	db := New(comp, network.NewMockEndpoint(t), parser.New(), nilLogger, WithAudit(al), WithAdmin(admin.NewRegistry(cmd.Config{})))
+	ctx := net.WithClient(context.Background(), &net.Client{ID: "req-1", Addr: "10.0.0.1:5000"})
+
+	for _, c := range []string{"SET a 1\n", "GET a\n", "CONFIG SET log_level debug\n"} {
+		_, _ = db.HandleRequest(ctx, bytes.NewBufferString(c), nilLogger)
+	}
+	assert.NoError(t, al.Close())
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	// GET is not recorded
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"user":"default","client_ip":"10.0.0.1","request_id":"req-1","command":"SET","key":"a","value_sha256":"6b86b273`)
+	assert.Contains(t, lines[0], `"result":"ok"`)
+	assert.Contains(t, lines[1], `"command":"CONFIG SET","key":"log_level"`)
+	assert.Contains(t, lines[1], `"result":"error","error":"unknown setting \"log_level\""`)
+}
@@ -17,10 +17,15 @@ const sep = " "
 const ToReplaceBySep = "\t"
 const tag = "alphanum|numeric|alpha|containsany=*_/,excludesall=!\"#$%&'()+0x2C-.:;<=>?@[]^`{}0x7C~,printascii"
 
+// authTag is used for AUTH, ACL and BACKUP args, passwords, key patterns and paths may contain any printable characters
+const authTag = "printascii"
+
 type Command struct {
 	Command string
 	Arg1    string
 	Arg2    string
+	// Args holds the rest of the args. Only ACL and CONFIG accept more than 2 of them
+	Args []string
 }
 
 type Parser interface {
@@ -61,7 +66,7 @@ func (p *Parse) Read(r io.Reader, lg *slog.Logger) (Command, error) {
 		lg.Error(fmt.Sprintf("%s failed", suf), "error", err.Error())
 		return Command{}, fmt.Errorf("%s failed: %w", suf, err)
 	}
-	lg.Debug(suf, "input", str)
+	lg.Debug(suf, "input", redact(str))
 
 	cmnd, err := p.composeCommand(strings.Trim(str, p.trim))
 	if err != nil {
@@ -103,6 +108,10 @@ func (p *Parse) trimArgs(s string) Command {
 		return Command{Command: arr[0], Arg1: arr[1], Arg2: arr[2]}
 	}
 
+	if len(arr) > 3 && (arr[0] == "ACL" || arr[0] == "CONFIG") {
+		return Command{Command: arr[0], Arg1: arr[1], Arg2: arr[2], Args: arr[3:]}
+	}
+
 	return Command{Command: arr[0], Arg1: "", Arg2: ""}
 }
 
@@ -138,7 +147,129 @@ func (p *Parse) validateArgs(c Command) error {
 			return err
 		}
 		return validate(c.Arg2)
+	case "AUTH":
+		if c.Arg1 == "" || c.Arg2 == "" {
+			return fmt.Errorf("%s failed: %q expects exactly 2 args", suf, c.Command)
+		}
+		for _, arg := range []string{c.Arg1, c.Arg2} {
+			if val.Var(arg, authTag) != nil {
+				// do not echo credentials back
+				return fmt.Errorf("%s failed: %q args expected to be %q", suf, c.Command, authTag)
+			}
+		}
+		return nil
+	case "ACL":
+		return p.validateAcl(c)
+	case "CONFIG":
+		return p.validateConfig(c)
+	case "SLOWLOG":
+		return p.validateSlowlog(c)
+	case "INFO":
+		if c.Arg2 != "" {
+			return fmt.Errorf("%s failed: %q expects at most 1 arg", suf, c.Command)
+		}
+		if c.Arg1 != "" {
+			return validate(c.Arg1)
+		}
+		return nil
+	case "BACKUP":
+		if c.Arg1 == "" || c.Arg2 != "" {
+			return fmt.Errorf("%s failed: %q expects exactly 1 arg", suf, c.Command)
+		}
+		if err := val.Var(c.Arg1, authTag); err != nil {
+			return fmt.Errorf("%s failed: got %q, expected %q", suf, c.Arg1, authTag)
+		}
+		return nil
+	case "DUMP", "PING":
+		if c.Arg1 != "" || c.Arg2 != "" {
+			return fmt.Errorf("%s failed: %q expects no args", suf, c.Command)
+		}
+		return nil
 	default:
 		return fmt.Errorf("%s failed: got empty or unexpected command %q", suf, c.Command)
 	}
 }
+
+// redact hides AUTH args, so that passwords never get to the logs
+func redact(s string) string {
+	if strings.HasPrefix(strings.TrimLeft(s, trim), "AUTH") {
+		return "AUTH <redacted>"
+	}
+	return s
+}
+
+// validateAcl checks ACL subcommands:
+// ACL WHOAMI, ACL LIST and ACL SETUSER user [rule ...]
+func (p *Parse) validateAcl(c Command) error {
+	const suf = "parser.Read().composeCommand().validateArgs()"
+	switch c.Arg1 {
+	case "WHOAMI", "LIST":
+		if c.Arg2 != "" {
+			return fmt.Errorf("%s failed: \"%s %s\" expects no args", suf, c.Command, c.Arg1)
+		}
+	case "SETUSER":
+		if c.Arg2 == "" {
+			return fmt.Errorf("%s failed: \"%s %s\" expects at least 1 arg", suf, c.Command, c.Arg1)
+		}
+		val := validator.New(validator.WithRequiredStructEnabled())
+		for _, arg := range append([]string{c.Arg2}, c.Args...) {
+			if err := val.Var(arg, authTag); err != nil {
+				return fmt.Errorf("%s failed: got %q, expected %q", suf, arg, authTag)
+			}
+		}
+	default:
+		return fmt.Errorf("%s failed: got empty or unexpected ACL subcommand %q", suf, c.Arg1)
+	}
+	return nil
+}
+
+// validateConfig checks CONFIG subcommands:
+// CONFIG GET name|* and CONFIG SET name value
+func (p *Parse) validateConfig(c Command) error {
+	const suf = "parser.Read().composeCommand().validateArgs()"
+	var args []string
+	switch c.Arg1 {
+	case "GET":
+		if c.Arg2 == "" || len(c.Args) != 0 {
+			return fmt.Errorf("%s failed: \"%s %s\" expects exactly 1 arg", suf, c.Command, c.Arg1)
+		}
+		args = []string{c.Arg2}
+	case "SET":
+		if c.Arg2 == "" || len(c.Args) != 1 {
+			return fmt.Errorf("%s failed: \"%s %s\" expects exactly 2 args", suf, c.Command, c.Arg1)
+		}
+		args = []string{c.Arg2, c.Args[0]}
+	default:
+		return fmt.Errorf("%s failed: got empty or unexpected CONFIG subcommand %q", suf, c.Arg1)
+	}
+	val := validator.New(validator.WithRequiredStructEnabled())
+	for _, arg := range args {
+		if err := val.Var(arg, authTag); err != nil {
+			return fmt.Errorf("%s failed: got %q, expected %q", suf, arg, authTag)
+		}
+	}
+	return nil
+}
+
+// validateSlowlog checks SLOWLOG subcommands:
+// SLOWLOG GET [n], SLOWLOG LEN and SLOWLOG RESET
+func (p *Parse) validateSlowlog(c Command) error {
+	const suf = "parser.Read().composeCommand().validateArgs()"
+	switch c.Arg1 {
+	case "GET":
+		if c.Arg2 == "" {
+			return nil
+		}
+		val := validator.New(validator.WithRequiredStructEnabled())
+		if err := val.Var(c.Arg2, "number"); err != nil {
+			return fmt.Errorf("%s failed: got %q, expected %q", suf, c.Arg2, "number")
+		}
+	case "LEN", "RESET":
+		if c.Arg2 != "" {
+			return fmt.Errorf("%s failed: \"%s %s\" expects no args", suf, c.Command, c.Arg1)
+		}
+	default:
+		return fmt.Errorf("%s failed: got empty or unexpected SLOWLOG subcommand %q", suf, c.Arg1)
+	}
+	return nil
+}
@@ -288,6 +288,326 @@ func TestRead_Del_Negative_NoEndline(t *testing.T) {
 	assert.Equal(t, testCase.expected, val)
 }
 
+// dump
+
+func TestRead_Dump_Positive(t *testing.T) {
+	testCase := struct {
+		ioInput  string
+		expected Command
+	}{
+		ioInput: "DUMP\n",
+		expected: Command{
+			Command: "DUMP",
+		},
+	}
+
+	pr := New()
+	r := bytes.NewReader([]byte(testCase.ioInput))
+
+	val, err := pr.Read(r, nilLogger)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testCase.expected, val)
+}
+
+func TestRead_Dump_Negative_ExcessiveArgs(t *testing.T) {
+	testCase := struct {
+		ioInput  string
+		expected Command
+		err      string
+	}{
+		ioInput:  "DUMP 1\n",
+		expected: Command{},
+		err:      "parser.Read().composeCommand().validateArgs() failed: \"DUMP\" expects no args",
+	}
+
+	pr := New()
+	r := bytes.NewReader([]byte(testCase.ioInput))
+
+	val, err := pr.Read(r, nilLogger)
+
+	assert.EqualError(t, err, testCase.err)
+	assert.Equal(t, testCase.expected, val)
+}
+
+// ping
+
+func TestRead_Ping(t *testing.T) {
+	pr := New()
+
+	val, err := pr.Read(strings.NewReader("PING\n"), nilLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, Command{Command: "PING"}, val)
+
+	_, err = pr.Read(strings.NewReader("PING 1\n"), nilLogger)
+	assert.EqualError(t, err, "parser.Read().composeCommand().validateArgs() failed: \"PING\" expects no args")
+}
+
+// auth
+
+func TestRead_Auth_Positive(t *testing.T) {
+	testCase := struct {
+		ioInput  string
+		expected Command
+	}{
+		ioInput: "AUTH admin p@ss:w0rd!\n",
+		expected: Command{
+			Command: "AUTH",
+			Arg1:    "admin",
+			Arg2:    "p@ss:w0rd!",
+		},
+	}
+
+	pr := New()
+	r := bytes.NewReader([]byte(testCase.ioInput))
+
+	val, err := pr.Read(r, nilLogger)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testCase.expected, val)
+}
+
+func TestRead_Auth_Negative_InsufficientArgs(t *testing.T) {
+	testCase := struct {
+		ioInput  string
+		expected Command
+		err      string
+	}{
+		ioInput:  "AUTH admin\n",
+		expected: Command{},
+		err:      "parser.Read().composeCommand().validateArgs() failed: \"AUTH\" expects exactly 2 args",
+	}
+
+	pr := New()
+	r := bytes.NewReader([]byte(testCase.ioInput))
+
+	val, err := pr.Read(r, nilLogger)
+
+	assert.EqualError(t, err, testCase.err)
+	assert.Equal(t, testCase.expected, val)
+}
+
+func TestRead_Auth_Redacted(t *testing.T) {
+	var buf bytes.Buffer
+	lg := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	pr := New()
+	_, err := pr.Read(strings.NewReader("AUTH admin secret\n"), lg)
+
+	assert.NoError(t, err)
+	assert.NotContains(t, buf.String(), "secret")
+}
+
+// acl
+
+func TestRead_Acl_Positive(t *testing.T) {
+	testCases := []struct {
+		ioInput  string
+		expected Command
+	}{
+		{
+			ioInput:  "ACL WHOAMI\n",
+			expected: Command{Command: "ACL", Arg1: "WHOAMI"},
+		},
+		{
+			ioInput:  "ACL LIST\n",
+			expected: Command{Command: "ACL", Arg1: "LIST"},
+		},
+		{
+			ioInput:  "ACL SETUSER reporting\n",
+			expected: Command{Command: "ACL", Arg1: "SETUSER", Arg2: "reporting"},
+		},
+		{
+			ioInput:  "ACL SETUSER reporting reset +read ~report_*\n",
+			expected: Command{Command: "ACL", Arg1: "SETUSER", Arg2: "reporting", Args: []string{"reset", "+read", "~report_*"}},
+		},
+	}
+
+	pr := New()
+	for _, testCase := range testCases {
+		val, err := pr.Read(strings.NewReader(testCase.ioInput), nilLogger)
+
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expected, val)
+	}
+}
+
+func TestRead_Acl_Negative(t *testing.T) {
+	testCases := []struct {
+		ioInput string
+		err     string
+	}{
+		{
+			ioInput: "ACL\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: got empty or unexpected ACL subcommand \"\"",
+		},
+		{
+			ioInput: "ACL WHOAMI 1\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: \"ACL WHOAMI\" expects no args",
+		},
+		{
+			ioInput: "ACL SETUSER\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: \"ACL SETUSER\" expects at least 1 arg",
+		},
+		{
+			ioInput: "ACL DELUSER reporting\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: got empty or unexpected ACL subcommand \"DELUSER\"",
+		},
+	}
+
+	pr := New()
+	for _, testCase := range testCases {
+		val, err := pr.Read(strings.NewReader(testCase.ioInput), nilLogger)
+
+		assert.EqualError(t, err, testCase.err)
+		assert.Equal(t, Command{}, val)
+	}
+}
+
+// info and config
+
+func TestRead_Admin_Positive(t *testing.T) {
+	testCases := []struct {
+		ioInput  string
+		expected Command
+	}{
+		{
+			ioInput:  "INFO\n",
+			expected: Command{Command: "INFO"},
+		},
+		{
+			ioInput:  "INFO keyspace\n",
+			expected: Command{Command: "INFO", Arg1: "keyspace"},
+		},
+		{
+			ioInput:  "CONFIG GET *\n",
+			expected: Command{Command: "CONFIG", Arg1: "GET", Arg2: "*"},
+		},
+		{
+			ioInput:  "CONFIG SET wal_batch_timeout 500ms\n",
+			expected: Command{Command: "CONFIG", Arg1: "SET", Arg2: "wal_batch_timeout", Args: []string{"500ms"}},
+		},
+		{
+			ioInput:  "BACKUP /var/backups/ramdb-2024.01.02\n",
+			expected: Command{Command: "BACKUP", Arg1: "/var/backups/ramdb-2024.01.02"},
+		},
+	}
+
+	pr := New()
+	for _, testCase := range testCases {
+		val, err := pr.Read(strings.NewReader(testCase.ioInput), nilLogger)
+
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expected, val)
+	}
+}
+
+func TestRead_Admin_Negative(t *testing.T) {
+	testCases := []struct {
+		ioInput string
+		err     string
+	}{
+		{
+			ioInput: "INFO keyspace clients\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: \"INFO\" expects at most 1 arg",
+		},
+		{
+			ioInput: "CONFIG\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: got empty or unexpected CONFIG subcommand \"\"",
+		},
+		{
+			ioInput: "CONFIG GET log_level wal_batch_timeout\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: \"CONFIG GET\" expects exactly 1 arg",
+		},
+		{
+			ioInput: "CONFIG SET log_level\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: \"CONFIG SET\" expects exactly 2 args",
+		},
+		{
+			ioInput: "CONFIG RESETSTAT\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: got empty or unexpected CONFIG subcommand \"RESETSTAT\"",
+		},
+		{
+			ioInput: "BACKUP\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: \"BACKUP\" expects exactly 1 arg",
+		},
+		{
+			ioInput: "BACKUP /tmp/a /tmp/b\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: \"BACKUP\" expects exactly 1 arg",
+		},
+	}
+
+	pr := New()
+	for _, testCase := range testCases {
+		val, err := pr.Read(strings.NewReader(testCase.ioInput), nilLogger)
+
+		assert.EqualError(t, err, testCase.err)
+		assert.Equal(t, Command{}, val)
+	}
+}
+
+// slowlog
+
+func TestRead_Slowlog_Positive(t *testing.T) {
+	testCases := []struct {
+		ioInput  string
+		expected Command
+	}{
+		{
+			ioInput:  "SLOWLOG GET\n",
+			expected: Command{Command: "SLOWLOG", Arg1: "GET"},
+		},
+		{
+			ioInput:  "SLOWLOG GET 10\n",
+			expected: Command{Command: "SLOWLOG", Arg1: "GET", Arg2: "10"},
+		},
+		{
+			ioInput:  "SLOWLOG LEN\n",
+			expected: Command{Command: "SLOWLOG", Arg1: "LEN"},
+		},
+		{
+			ioInput:  "SLOWLOG RESET\n",
+			expected: Command{Command: "SLOWLOG", Arg1: "RESET"},
+		},
+	}
+
+	pr := New()
+	for _, testCase := range testCases {
+		val, err := pr.Read(strings.NewReader(testCase.ioInput), nilLogger)
+
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expected, val)
+	}
+}
+
+func TestRead_Slowlog_Negative(t *testing.T) {
+	testCases := []struct {
+		ioInput string
+		err     string
+	}{
+		{
+			ioInput: "SLOWLOG\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: got empty or unexpected SLOWLOG subcommand \"\"",
+		},
+		{
+			ioInput: "SLOWLOG GET -1\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: got \"-1\", expected \"number\"",
+		},
+		{
+			ioInput: "SLOWLOG LEN 1\n",
+			err:     "parser.Read().composeCommand().validateArgs() failed: \"SLOWLOG LEN\" expects no args",
+		},
+	}
+
+	pr := New()
+	for _, testCase := range testCases {
+		val, err := pr.Read(strings.NewReader(testCase.ioInput), nilLogger)
+
+		assert.EqualError(t, err, testCase.err)
+		assert.Equal(t, Command{}, val)
+	}
+}
+
 // Misc
 
 func TestRead_BogusCommand_WithoutArgs(t *testing.T) {
@@ -0,0 +1,219 @@
+package seg
+
+import (
+	"bufio"
+	"compress/gzip"
+	"custom-in-memory-db/internal/fsutil"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// WAL_COMPRESSION values
+const (
+	// CompressionNone leaves files as they are
+	CompressionNone = "none"
+	// CompressionSnappy compresses with snappy framing format
+	CompressionSnappy = "snappy"
+	// CompressionZstd compresses with zstd
+	CompressionZstd = "zstd"
+	// CompressionGzip compresses with gzip
+	CompressionGzip = "gzip"
+)
+
+// codecMagic starts the header line of a compressed file: "RAMDB-CODEC <codec> <size>",
+// size is the size of the file before compression. Files without the header are plain text
+const codecMagic = "RAMDB-CODEC"
+
+// FileInfo describes how a segment or snapshot file is stored
+type FileInfo struct {
+	// Codec is one of WAL_COMPRESSION values
+	Codec string
+	// Size is the size of the file on disk, RawSize is the size of its content before compression
+	Size    int64
+	RawSize int64
+}
+
+// Stat reads the header of the file at pth
+func Stat(pth string) (FileInfo, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	codec, size, err := readHeader(bufio.NewReader(f))
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("%q: %w", pth, err)
+	}
+	if codec == CompressionNone {
+		size = st.Size()
+	}
+	return FileInfo{Codec: codec, Size: st.Size(), RawSize: size}, nil
+}
+
+// Open opens the file at pth for reading, the content of a compressed file is decompressed
+func Open(pth string) (io.ReadCloser, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	codec, _, err := readHeader(br)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%q: %w", pth, err), f.Close())
+	}
+	r, err := decompress(br, codec)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%q: %w", pth, err), f.Close())
+	}
+	return &reader{Reader: r, closers: []io.Closer{r, f}}, nil
+}
+
+// CompressFile atomically replaces the plain file at pth with its content compressed by codec.
+// Files already compressed and CompressionNone are left as they are
+func CompressFile(pth, codec string) error {
+	if codec == CompressionNone {
+		return nil
+	}
+	f, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(f)
+	if current, _, err := readHeader(br); err != nil || current != CompressionNone {
+		return err
+	}
+	return fsutil.WriteFile(pth, func(w io.Writer) error {
+		if _, err := fmt.Fprintf(w, "%s %s %d\n", codecMagic, codec, st.Size()); err != nil {
+			return err
+		}
+		cw, err := compress(w, codec)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(cw, br); err != nil {
+			return errors.Join(err, cw.Close())
+		}
+		return cw.Close()
+	})
+}
+
+// TruncateFile atomically replaces the file at pth with the first size bytes of its content.
+// A compressed file stays compressed by the same codec
+func TruncateFile(pth string, size int64) error {
+	info, err := Stat(pth)
+	if err != nil {
+		return err
+	}
+	if size > info.RawSize {
+		return fmt.Errorf("%q has %d bytes, can not truncate it to %d", pth, info.RawSize, size)
+	}
+	r, err := Open(pth)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return fsutil.WriteFile(pth, func(w io.Writer) error {
+		if info.Codec == CompressionNone {
+			_, err := io.CopyN(w, r, size)
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s %s %d\n", codecMagic, info.Codec, size); err != nil {
+			return err
+		}
+		cw, err := compress(w, info.Codec)
+		if err != nil {
+			return err
+		}
+		if _, err = io.CopyN(cw, r, size); err != nil {
+			return errors.Join(err, cw.Close())
+		}
+		return cw.Close()
+	})
+}
+
+// readHeader reads the codec header from r if there is one.
+// The header is consumed, r is left at the start of a plain file otherwise
+func readHeader(r *bufio.Reader) (string, int64, error) {
+	prefix, err := r.Peek(len(codecMagic) + 1)
+	if err != nil || string(prefix) != codecMagic+" " {
+		// a file shorter than the header is plain
+		return CompressionNone, 0, nil
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", 0, fmt.Errorf("bad codec header: %w", err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return "", 0, fmt.Errorf("bad codec header %q", strings.TrimSpace(line))
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, fmt.Errorf("bad codec header %q", strings.TrimSpace(line))
+	}
+	switch fields[1] {
+	case CompressionSnappy, CompressionZstd, CompressionGzip:
+		return fields[1], size, nil
+	}
+	return "", 0, fmt.Errorf("codec %q is not supported", fields[1])
+}
+
+func compress(w io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case CompressionSnappy:
+		return snappy.NewBufferedWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("codec %q is not supported", codec)
+}
+
+func decompress(r io.Reader, codec string) (io.ReadCloser, error) {
+	switch codec {
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionSnappy:
+		return io.NopCloser(snappy.NewReader(r)), nil
+	case CompressionZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	}
+	return nil, fmt.Errorf("codec %q is not supported", codec)
+}
+
+// reader closes the decompressor and the file
+type reader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *reader) Close() error {
+	var errs []error
+	for _, c := range r.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
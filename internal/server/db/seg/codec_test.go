@@ -0,0 +1,192 @@
+package seg
+
+import (
+	"custom-in-memory-db/internal/server/cmd"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var codecs = []string{CompressionSnappy, CompressionZstd, CompressionGzip}
+
+// content returns n records looking like those of a wal segment
+func content(n int) string {
+	var b strings.Builder
+	for i := range n {
+		fmt.Fprintf(&b, "0badc0de %d 1700000000 SET key_%d value_%d\n", i+1, i, i)
+	}
+	return b.String()
+}
+
+// writeTemp writes data to a new file in a temp folder and returns its path
+func writeTemp(t *testing.T, data string) string {
+	pth := filepath.Join(t.TempDir(), "1")
+	require.NoError(t, os.WriteFile(pth, []byte(data), 0644))
+	return pth
+}
+
+// readAll returns the content of the file at pth decompressed
+func readAll(t *testing.T, pth string) string {
+	r, err := Open(pth)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCompressFile(t *testing.T) {
+	data := content(100)
+	for _, codec := range append([]string{CompressionNone}, codecs...) {
+		t.Run(codec, func(t *testing.T) {
+			pth := writeTemp(t, data)
+			require.NoError(t, CompressFile(pth, codec))
+
+			info, err := Stat(pth)
+			require.NoError(t, err)
+			assert.Equal(t, codec, info.Codec)
+			assert.Equal(t, int64(len(data)), info.RawSize)
+			if codec == CompressionNone {
+				assert.Equal(t, info.RawSize, info.Size)
+			} else {
+				assert.Less(t, info.Size, info.RawSize)
+			}
+			assert.Equal(t, data, readAll(t, pth))
+
+			// a compressed file is left as it is, even for another codec
+			before, err := os.ReadFile(pth)
+			require.NoError(t, err)
+			for _, other := range codecs {
+				if codec == CompressionNone {
+					break
+				}
+				require.NoError(t, CompressFile(pth, other))
+				after, err := os.ReadFile(pth)
+				require.NoError(t, err)
+				assert.Equal(t, before, after, other)
+			}
+		})
+	}
+}
+
+func TestTruncateFile(t *testing.T) {
+	data := content(100)
+	for _, codec := range append([]string{CompressionNone}, codecs...) {
+		t.Run(codec, func(t *testing.T) {
+			pth := writeTemp(t, data)
+			require.NoError(t, CompressFile(pth, codec))
+
+			tests := []struct {
+				size int64
+				err  string
+			}{
+				{size: int64(len(data)) + 1, err: fmt.Sprintf("%q has %d bytes, can not truncate it to %d", pth, len(data), len(data)+1)},
+				{size: int64(len(data))},
+				{size: 1000},
+				{size: 0},
+			}
+			for _, test := range tests {
+				err := TruncateFile(pth, test.size)
+				if test.err != "" {
+					assert.EqualError(t, err, test.err)
+					continue
+				}
+				require.NoError(t, err)
+				info, err := Stat(pth)
+				require.NoError(t, err)
+				// the file stays compressed and its header has the new size
+				assert.Equal(t, codec, info.Codec)
+				assert.Equal(t, test.size, info.RawSize)
+				assert.Equal(t, data[:test.size], readAll(t, pth))
+			}
+		})
+	}
+}
+
+func TestOpen_Header(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{name: "plain", data: "SET a 1\n"},
+		{name: "shorter than header", data: "RAMDB"},
+		{name: "magic without space", data: "RAMDB-CODEC\n"},
+		{name: "no fields", data: "RAMDB-CODEC snappy\n", err: `bad codec header "RAMDB-CODEC snappy"`},
+		{name: "bad size", data: "RAMDB-CODEC snappy ten\n", err: `bad codec header "RAMDB-CODEC snappy ten"`},
+		{name: "negative size", data: "RAMDB-CODEC snappy -1\n", err: `bad codec header "RAMDB-CODEC snappy -1"`},
+		{name: "torn header", data: "RAMDB-CODEC snappy 10", err: "bad codec header: EOF"},
+		{name: "unknown codec", data: "RAMDB-CODEC lz4 10\n", err: `codec "lz4" is not supported`},
+		{name: "none is not a codec", data: "RAMDB-CODEC none 10\n", err: `codec "none" is not supported`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pth := writeTemp(t, test.data)
+			_, statErr := Stat(pth)
+			r, err := Open(pth)
+			if test.err == "" {
+				require.NoError(t, statErr)
+				require.NoError(t, err)
+				defer r.Close()
+				data, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.Equal(t, test.data, string(data))
+				return
+			}
+			assert.EqualError(t, err, fmt.Sprintf("%q: %s", pth, test.err))
+			assert.EqualError(t, statErr, fmt.Sprintf("%q: %s", pth, test.err))
+			// files with a bad header are not compressed again
+			assert.Error(t, CompressFile(pth, CompressionZstd))
+		})
+	}
+}
+
+func TestSegments_Truncate(t *testing.T) {
+	for _, codec := range codecs {
+		t.Run(codec, func(t *testing.T) {
+			dir := t.TempDir()
+			data := content(20)
+			for _, name := range []string{"1", "2"} {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+			}
+			last := filepath.Join(dir, "2")
+			require.NoError(t, CompressFile(last, codec))
+
+			var conf cmd.Config
+			conf.Wal.SegPath = dir
+			conf.Wal.SegSize = 4 * cmd.KB
+			conf.Wal.SyncMode = "always"
+			s, err := New(conf)
+			require.NoError(t, err)
+			defer s.Close()
+			// records are not appended to the compressed segment
+			current, _ := s.Position()
+			assert.Equal(t, 3, current)
+
+			// the compressed last segment is truncated, older ones are not
+			assert.EqualError(t, s.Truncate(filepath.Join(dir, "1"), 10),
+				fmt.Sprintf("%q is not the current segment", filepath.Join(dir, "1")))
+			require.NoError(t, s.Truncate(last, 100))
+			info, err := Stat(last)
+			require.NoError(t, err)
+			assert.Equal(t, codec, info.Codec)
+			assert.Equal(t, int64(100), info.RawSize)
+			assert.Equal(t, data[:100], readAll(t, last))
+
+			// the current segment is truncated in place and writes go on from the new end
+			_, err = s.Write([]byte("SET a 1\nSET b 2\n"))
+			require.NoError(t, err)
+			require.NoError(t, s.Truncate(filepath.Join(dir, "3"), 8))
+			_, offset := s.Position()
+			assert.Equal(t, int64(8), offset)
+			_, err = s.Write([]byte("SET c 3\n"))
+			require.NoError(t, err)
+			assert.Equal(t, "SET a 1\nSET c 3\n", readAll(t, filepath.Join(dir, "3")))
+		})
+	}
+}
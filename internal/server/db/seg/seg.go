@@ -1,19 +1,45 @@
 package seg
 
 import (
+	"context"
 	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/metrics"
+	"custom-in-memory-db/internal/server/tracing"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // errMargin represents the number of bytes we add to our calculations
 // to avoid segment files grow more than WAL_SEG_SIZE
 const errMargin = 10
 
+// WAL_SYNC_MODE values
+const (
+	// SyncAlways fsyncs every command on its own
+	SyncAlways = "always"
+	// SyncBatch fsyncs every batch of commands
+	SyncBatch = "batch"
+	// SyncEverysec writes commands without fsync and fsyncs once a second
+	SyncEverysec = "everysec"
+	// SyncNone leaves flushing to the OS
+	SyncNone = "none"
+)
+
+// compressQueue is the number of closed segments waiting for compression
+const compressQueue = 16
+
+// SnapshotName is the name of the snapshot file in WAL_SEG_PATH. Recovery loads it before the segments,
+// its name is not a number, so it is never taken for a segment
+const SnapshotName = "snapshot"
+
 // Segments implements io.Writer interface.
 // It is responsible for rotating wal segments.
 // Segments treats any file with natural number as its filename as a wal segment file.
@@ -24,6 +50,16 @@ type Segments struct {
 	segFiles    []os.DirEntry
 	currSegName int
 	currSegFile *os.File
+	syncMode    string
+	compression string
+	// files is held while closed segments are compressed, removed by compaction or read by a backup
+	files sync.Mutex
+	// closed gets segments to compress once they are closed, compressed is closed once the compressor has returned
+	closed     chan string
+	compressed chan struct{}
+	// position is read by INFO concurrently with Write
+	posSeg    atomic.Int64
+	posOffset atomic.Int64
 }
 
 func New(conf cmd.Config) (*Segments, error) {
@@ -31,10 +67,39 @@ func New(conf cmd.Config) (*Segments, error) {
 	seg := Segments{}
 	seg.segPath = conf.Wal.SegPath
 	seg.segMaxSize = int64(conf.Wal.SegSize)
+	seg.syncMode = conf.Wal.SyncMode
+	seg.compression = conf.Wal.Compression
+	if seg.compression == "" {
+		seg.compression = CompressionNone
+	}
 	seg.segFiles, err = seg.getFiles()
 	if err != nil {
 		return nil, fmt.Errorf("get segment files failed: %w", err)
 	}
+	// writes go on in the last segment, appending to an older one would break wal order
+	if len(seg.segFiles) > 0 {
+		last, _ := strconv.Atoi(seg.segFiles[len(seg.segFiles)-1].Name())
+		seg.currSegName = last - 1
+		// plain records can not be appended to a compressed segment
+		info, err := Stat(path.Join(seg.segPath, seg.segFiles[len(seg.segFiles)-1].Name()))
+		if err != nil {
+			return nil, fmt.Errorf("stat the last segment failed: %w", err)
+		}
+		if info.Codec != CompressionNone {
+			seg.currSegName = last
+		}
+	}
+	// segments closed before WAL_COMPRESSION was set or whose compression failed
+	for _, file := range seg.ExportSegNames()[:max(len(seg.segFiles)-1, 0)] {
+		if err = CompressFile(file, seg.compression); err != nil {
+			return nil, fmt.Errorf("compress segment failed: %w", err)
+		}
+	}
+	if seg.compression != CompressionNone {
+		seg.closed = make(chan string, compressQueue)
+		seg.compressed = make(chan struct{})
+		go seg.compressor()
+	}
 	if err := seg.newSegment(); err != nil {
 		return nil, fmt.Errorf("newSegment failed: %w", err)
 	}
@@ -49,30 +114,142 @@ func (s *Segments) ExportSegNames() []string {
 	return result
 }
 
+// SnapshotPath returns the path of the snapshot file, it may not exist
+func (s *Segments) SnapshotPath() string {
+	return path.Join(s.segPath, SnapshotName)
+}
+
+// Stats returns the number of segment files and their total size.
+// It reads the segment folder, so it is not meant to be called often
+func (s *Segments) Stats() (int, int64) {
+	files, err := s.getFiles()
+	if err != nil {
+		return 0, 0
+	}
+	var size int64
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		size += info.Size()
+	}
+	return len(files), size
+}
+
+// Position returns the current segment and the offset next write goes to
+func (s *Segments) Position() (int, int64) {
+	return int(s.posSeg.Load()), s.posOffset.Load()
+}
+
 func (s *Segments) Write(n []byte) (int, error) {
+	return s.WriteContext(context.Background(), n)
+}
+
+// WriteContext is Write traced as a part of the request in ctx
+func (s *Segments) WriteContext(ctx context.Context, n []byte) (int, error) {
 	batchLen := int64(len(n))
 	if !s.isRotate(batchLen) {
-		return s.write(n)
+		return s.write(ctx, n)
 	}
 
 	if !s.isOverflow(batchLen) {
-		nn, err := s.write(n)
+		nn, err := s.write(ctx, n)
 		return s.rotate(nn, err)
 	}
 
 	index := s.getRotationIndex(n)
-	nn, err := s.write(n[:index])
+	nn, err := s.write(ctx, n[:index])
 	nn, err = s.rotate(nn, err)
 	if err != nil {
 		return -1, err
 	}
-	return s.write(n[index:])
+	// the rest goes to the new segment, n counts both parts
+	rest, err := s.write(ctx, n[index:])
+	if err != nil {
+		return nn, err
+	}
+	return nn + rest, nil
 }
 
+// Sync fsyncs the current segment. Needed when WAL_SYNC_MODE leaves writes unsynced
+func (s *Segments) Sync() error {
+	return s.sync(context.Background())
+}
+
+// Compression returns WAL_COMPRESSION
+func (s *Segments) Compression() string {
+	return s.compression
+}
+
+// LockFiles stops closed segments from being compressed until unlock is called
+func (s *Segments) LockFiles() (unlock func()) {
+	s.files.Lock()
+	return s.files.Unlock
+}
+
+// Truncate cuts the current segment at pth to size, writes go on from size.
+// Other closed segments are never truncated, their records are followed by others. The only exception is
+// the last segment found by New when it is compressed: New starts a new one after it, so it is rewritten to size
+// bytes of its content compressed by the same codec
+func (s *Segments) Truncate(pth string, size int64) error {
+	if pth != s.currSegFile.Name() {
+		if len(s.segFiles) == 0 || pth != path.Join(s.segPath, s.segFiles[len(s.segFiles)-1].Name()) {
+			return fmt.Errorf("%q is not the current segment", pth)
+		}
+		if err := TruncateFile(pth, size); err != nil {
+			return fmt.Errorf("file %q truncate failed: %w", pth, err)
+		}
+		return nil
+	}
+	if err := s.currSegFile.Truncate(size); err != nil {
+		return fmt.Errorf("file %q truncate failed: %w", pth, err)
+	}
+	// a segment created by the server is not opened for appending, the next write would leave a hole
+	if _, err := s.currSegFile.Seek(size, io.SeekStart); err != nil {
+		return fmt.Errorf("file %q seek failed: %w", pth, err)
+	}
+	if err := s.currSegFile.Sync(); err != nil {
+		return fmt.Errorf("file %q fsync failed: %w", pth, err)
+	}
+	s.posOffset.Store(size)
+	return nil
+}
+
+// Close syncs and closes the current segment
 func (s *Segments) Close() error {
+	if s.closed != nil {
+		close(s.closed)
+		<-s.compressed
+	}
+	if err := s.currSegFile.Sync(); err != nil {
+		return errors.Join(err, s.currSegFile.Close())
+	}
 	return s.currSegFile.Close()
 }
 
+// compress queues the closed segment for the compressor. The records are in wal already,
+// so a segment left plain when the queue is full is compressed on the next start
+func (s *Segments) compress(pth string) {
+	select {
+	case s.closed <- pth:
+	default:
+	}
+}
+
+// compressor compresses closed segments until closed is closed
+func (s *Segments) compressor() {
+	defer close(s.compressed)
+	for pth := range s.closed {
+		s.files.Lock()
+		// compaction may have removed the segment meanwhile
+		if _, err := os.Stat(pth); err == nil {
+			_ = CompressFile(pth, s.compression)
+		}
+		s.files.Unlock()
+	}
+}
+
 // getRotationIndex finds out how much we can write to currFile
 // without exceeding WAL_SEG_SIZE and all commands intact
 func (s *Segments) getRotationIndex(n []byte) int {
@@ -81,8 +258,9 @@ func (s *Segments) getRotationIndex(n []byte) int {
 		// we will fail later
 		return 1
 	}
-	// move backwards to rotate without breaking commands
-	leftInFile := s.segMaxSize - st.Size()
+	// move backwards to rotate without breaking commands.
+	// The file may be over WAL_SEG_SIZE already if a batch was larger than a segment
+	leftInFile := min(s.segMaxSize-st.Size(), int64(len(n)-1))
 	for leftInFile > -1 {
 		if n[leftInFile] == '\n' {
 			break
@@ -90,7 +268,7 @@ func (s *Segments) getRotationIndex(n []byte) int {
 		leftInFile--
 	}
 
-	return int(leftInFile + 1)
+	return int(max(leftInFile+1, 0))
 }
 
 // rotate decorates tryRotate
@@ -114,6 +292,12 @@ func (s *Segments) tryRotate() error {
 	if err != nil {
 		return fmt.Errorf("file %q check failed: %w", pth, err)
 	}
+	// writes not synced yet must not be lost with the closed file
+	if s.syncMode == SyncEverysec {
+		if err = s.sync(context.Background()); err != nil {
+			return err
+		}
+	}
 	// close current seg file
 	err = s.currSegFile.Close()
 	if err != nil {
@@ -124,6 +308,9 @@ func (s *Segments) tryRotate() error {
 	if err != nil {
 		return fmt.Errorf("newSegment failed: %w", err)
 	}
+	if s.closed != nil {
+		s.compress(pth)
+	}
 
 	return nil
 }
@@ -139,6 +326,8 @@ func (s *Segments) newSegment() error {
 			return fmt.Errorf("os.Create %q failed: %v", pth, err)
 		}
 		s.currSegFile = file
+		s.posSeg.Store(int64(s.currSegName))
+		s.posOffset.Store(0)
 		return nil
 	}
 	// open an existing file
@@ -147,11 +336,15 @@ func (s *Segments) newSegment() error {
 		return fmt.Errorf("os.Open failed: %v", err)
 	}
 	s.currSegFile = file
+	s.posSeg.Store(int64(s.currSegName))
+	if st, err := file.Stat(); err == nil {
+		s.posOffset.Store(st.Size())
+	}
 	return nil
 }
 
-// write writes n bytes to currFile and calls fsync
-func (s *Segments) write(n []byte) (int, error) {
+// write writes n bytes to currFile and calls fsync unless WAL_SYNC_MODE defers it
+func (s *Segments) write(ctx context.Context, n []byte) (int, error) {
 	// ensure file exists
 	pth := path.Join(s.segPath, strconv.Itoa(s.currSegName))
 	_, err := os.Stat(pth)
@@ -163,15 +356,30 @@ func (s *Segments) write(n []byte) (int, error) {
 	if err != nil {
 		return -1, fmt.Errorf("file %q write failed: %w", pth, err)
 	}
-	// fsync
-	err = s.currSegFile.Sync()
-	if err != nil {
-		return -1, fmt.Errorf("file %q fsync failed: %w", pth, err)
+	s.posOffset.Add(int64(nn))
+	if s.syncMode == SyncEverysec || s.syncMode == SyncNone {
+		return nn, nil
+	}
+	if err = s.sync(ctx); err != nil {
+		return -1, err
 	}
 
 	return nn, nil
 }
 
+// sync calls fsync on currFile
+func (s *Segments) sync(ctx context.Context) error {
+	_, span := tracing.Start(ctx, "seg.fsync")
+	start := time.Now()
+	err := s.currSegFile.Sync()
+	metrics.WalFsyncDuration.Observe(time.Since(start).Seconds())
+	tracing.End(span, err)
+	if err != nil {
+		return fmt.Errorf("file %q fsync failed: %w", s.currSegFile.Name(), err)
+	}
+	return nil
+}
+
 // isOverflow defines if seg file will exceed WAL_SEG_SIZE after writing s bytes.
 func (s *Segments) isOverflow(num int64) bool {
 	st, err := os.Stat(path.Join(s.segPath, strconv.Itoa(s.currSegName)))
@@ -193,9 +401,27 @@ func (s *Segments) isRotate(num int64) bool {
 	return st.Size()+num+errMargin >= s.segMaxSize
 }
 
+// List returns paths of the segment files in dir in wal order
+func List(dir string) ([]string, error) {
+	files, err := readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		result = append(result, path.Join(dir, file.Name()))
+	}
+	return result, nil
+}
+
 func (s *Segments) getFiles() ([]os.DirEntry, error) {
+	return readDir(s.segPath)
+}
+
+// readDir returns segment files of dir sorted by their numbers
+func readDir(dir string) ([]os.DirEntry, error) {
 	// list files in folder
-	tmpFiles, err := os.ReadDir(s.segPath)
+	tmpFiles, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -1,7 +1,32 @@
 package storage
 
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is wrapped by errors of Get and Del of keys which are not stored
+var ErrNotFound = errors.New("not found")
+
 type Storage interface {
 	Get(key string) (string, error)
 	Set(key, value string) error
 	Del(key string) error
+	// Snapshot returns a consistent copy of all the stored keys and values
+	Snapshot() map[string]string
+	// Stats returns the number of keys and the size of stored data
+	Stats() Stats
+}
+
+// ContextStorage is implemented by storages which trace Set and Del as a part of the request in ctx
+type ContextStorage interface {
+	SetContext(ctx context.Context, key, value string) error
+	DelContext(ctx context.Context, key string) error
+}
+
+// Stats describes the stored data
+type Stats struct {
+	Keys int
+	// Bytes is the sum of key and value lengths, it does not include map overhead
+	Bytes int
 }
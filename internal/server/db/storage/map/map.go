@@ -1,13 +1,17 @@
 package _map
 
 import (
+	"custom-in-memory-db/internal/server/db/storage"
 	"fmt"
+	"maps"
 	"sync"
 )
 
 type Storage struct {
 	mtx sync.Mutex
 	m   map[string]string
+	// bytes is the sum of key and value lengths
+	bytes int
 }
 
 // New used to initialize Storage.
@@ -23,7 +27,7 @@ func (s *Storage) Get(key string) (string, error) {
 	val, ok := s.m[key]
 	s.mtx.Unlock()
 	if !ok {
-		return "", fmt.Errorf("key %s not found", key)
+		return "", fmt.Errorf("key %s %w", key, storage.ErrNotFound)
 	}
 
 	return val, nil
@@ -31,6 +35,12 @@ func (s *Storage) Get(key string) (string, error) {
 
 func (s *Storage) Set(key, value string) error {
 	s.mtx.Lock()
+	if old, ok := s.m[key]; ok {
+		s.bytes -= len(old)
+	} else {
+		s.bytes += len(key)
+	}
+	s.bytes += len(value)
 	s.m[key] = value
 	s.mtx.Unlock()
 
@@ -40,12 +50,27 @@ func (s *Storage) Set(key, value string) error {
 func (s *Storage) Del(key string) error {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
-	_, ok := s.m[key]
+	val, ok := s.m[key]
 	if !ok {
-		return fmt.Errorf("key %s not found", key)
+		return fmt.Errorf("key %s %w", key, storage.ErrNotFound)
 	}
 
 	delete(s.m, key)
+	s.bytes -= len(key) + len(val)
 
 	return nil
 }
+
+func (s *Storage) Snapshot() map[string]string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return maps.Clone(s.m)
+}
+
+func (s *Storage) Stats() storage.Stats {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return storage.Stats{Keys: len(s.m), Bytes: s.bytes}
+}
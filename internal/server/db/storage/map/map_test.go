@@ -1,6 +1,7 @@
 package _map
 
 import (
+	"custom-in-memory-db/internal/server/db/storage"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -69,3 +70,32 @@ func TestMapStorage_DelNegative(t *testing.T) {
 	err := st.Del(firstErrKey)
 	assert.EqualError(t, err, fmt.Sprintf("key %s not found", firstErrKey))
 }
+
+func TestMapStorage_Snapshot(t *testing.T) {
+	var st = Storage{
+		m: map[string]string{
+			firstKey: firstVal,
+		},
+	}
+
+	snapshot := st.Snapshot()
+	assert.Equal(t, map[string]string{firstKey: firstVal}, snapshot)
+
+	// snapshot must not change after storage does
+	assert.NoError(t, st.Set(firstKey, firstSetVal))
+	assert.Equal(t, firstVal, snapshot[firstKey])
+}
+
+func TestMapStorage_Stats(t *testing.T) {
+	st := New()
+
+	assert.NoError(t, st.Set("key", "value"))
+	assert.NoError(t, st.Set("k", "v"))
+	assert.Equal(t, storage.Stats{Keys: 2, Bytes: 10}, st.Stats())
+
+	assert.NoError(t, st.Set("key", "v"))
+	assert.Equal(t, storage.Stats{Keys: 2, Bytes: 6}, st.Stats())
+
+	assert.NoError(t, st.Del("key"))
+	assert.Equal(t, storage.Stats{Keys: 1, Bytes: 2}, st.Stats())
+}
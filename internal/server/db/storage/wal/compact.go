@@ -0,0 +1,235 @@
+package wal
+
+import (
+	"custom-in-memory-db/internal/fsutil"
+	"custom-in-memory-db/internal/server/db/seg"
+	"custom-in-memory-db/internal/server/metrics"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+)
+
+// compactInterval is how often the compactor checks WAL_COMPACT_MIN_SIZE and WAL_COMPACT_RATIO. Tests shorten it
+var compactInterval = 10 * time.Second
+
+// recordOverhead is the approximate size of a record without its key and value: checksum, LSN, time and command
+const recordOverhead = 40
+
+// Compaction describes what Compact did
+type Compaction struct {
+	// Segments is the number of segments folded into the snapshot
+	Segments int
+	// Records is the number of records read from the segments, Corrupt of them were skipped
+	Records int
+	Corrupt int
+	// Before is the size of the segments and the previous snapshot, After is the size of the new snapshot
+	Before int64
+	After  int64
+	// LSN of the last record of the snapshot
+	LSN  uint64
+	Keys int
+}
+
+// Compact folds closed segments, all of them but the last one, into the snapshot of WAL_SEG_PATH and removes them.
+// The snapshot holds the latest value of each key, so superseded SETs and DELs are gone.
+// It is written to a temporary file which is fsynced and renamed over the previous snapshot, segments are removed
+// after that. A crash in between leaves segments whose records the snapshot already has, recovery skips them.
+// Corrupt records are skipped the same way recovery skips them in WAL_RECOVERY_MODE=tolerant and fail it in other modes
+func (s *Storage) Compact() (Compaction, error) {
+	const suf = "wal.Compact()"
+	// records after WAL_RECOVER_UNTIL are in wal, but not in the storage, so they must not be touched
+	if s.readOnly {
+		return Compaction{}, ErrReadOnly
+	}
+	defer s.seg.LockFiles()()
+
+	files, err := seg.List(s.segPath)
+	if err != nil || len(files) < 2 {
+		return Compaction{}, err
+	}
+	closed, current := files[:len(files)-1], files[len(files)-1]
+	// records without LSN can not be told from the records of the snapshot, they must all be in it
+	if first, err := firstLSN(current, s.keys); err != nil || first == 0 {
+		return Compaction{}, err
+	}
+
+	state := make(map[string]string)
+	snapshot := path.Join(s.segPath, seg.SnapshotName)
+	h, ok, err := ReadSnapshotFile(snapshot, s.keys, func(k, v string) error {
+		state[k] = v
+		return nil
+	})
+	if err != nil {
+		return Compaction{}, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	c := Compaction{Segments: len(closed), LSN: h.LSN}
+	var from uint64
+	if ok {
+		from = h.LSN + 1
+		c.Before, _ = fileSize(snapshot)
+	}
+	for _, file := range closed {
+		size, _ := fileSize(file)
+		c.Before += size
+		if err = c.fold(file, s.keys, from, s.recovery.Mode, state); err != nil {
+			return Compaction{}, fmt.Errorf("%s failed: %w", suf, err)
+		}
+	}
+
+	c.Keys = len(state)
+	err = fsutil.WriteFile(snapshot, func(w io.Writer) error {
+		return WriteSnapshot(w, SnapshotHeader{LSN: c.LSN, Time: time.Now()}, state, s.keys)
+	})
+	if err == nil {
+		err = seg.CompressFile(snapshot, s.seg.Compression())
+	}
+	if err != nil {
+		return Compaction{}, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	c.After, _ = fileSize(snapshot)
+	for _, file := range closed {
+		if err = os.Remove(file); err != nil {
+			return c, fmt.Errorf("%s failed: %w", suf, err)
+		}
+	}
+	if err = fsutil.SyncDir(s.segPath); err != nil {
+		return c, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	return c, nil
+}
+
+// fold applies records of the segment file with LSN from onwards to state.
+// Corrupt records are skipped in RecoveryTolerant mode and fail it in others
+func (c *Compaction) fold(file string, keys *Keys, from uint64, mode string, state map[string]string) error {
+	f, err := seg.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := NewReader(f, keys)
+	for {
+		rec, _, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		var corrupt *CorruptError
+		if errors.As(err, &corrupt) {
+			if mode != RecoveryTolerant {
+				return fmt.Errorf("%q: corrupt record at %w, WAL_RECOVERY_MODE is %s", file, err, mode)
+			}
+			c.Corrupt++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.Records++
+		if rec.LSN < from {
+			continue
+		}
+		c.LSN = max(c.LSN, rec.LSN)
+		if rec.Command == "SET" {
+			state[rec.Args[0]] = rec.Args[1]
+			continue
+		}
+		delete(state, rec.Args[0])
+	}
+}
+
+// compactDue tells if closed segments are over WAL_COMPACT_MIN_SIZE and superseded records are
+// at least WAL_COMPACT_RATIO of wal. Sizes are taken before compression.
+// Live records are estimated by the size of the keys and values in the storage
+func (s *Storage) compactDue() bool {
+	files, err := seg.List(s.segPath)
+	if err != nil || len(files) < 2 {
+		return false
+	}
+	var closed, total int64
+	for i, file := range files {
+		info, _ := seg.Stat(file)
+		if i < len(files)-1 {
+			closed += info.RawSize
+		}
+		total += info.RawSize
+	}
+	if closed < s.compactMinSize {
+		return false
+	}
+	snapshot, _ := seg.Stat(path.Join(s.segPath, seg.SnapshotName))
+	total += snapshot.RawSize
+
+	stats := s.st.Stats()
+	live := int64(stats.Bytes + stats.Keys*recordOverhead)
+	return 1-float64(live)/float64(total) >= s.compactRatio
+}
+
+// compactor runs Compact once it is due until stop is closed
+func (s *Storage) compactor(lg *slog.Logger) {
+	defer close(s.compactorStopped)
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		if !s.compactDue() {
+			continue
+		}
+		start := time.Now()
+		c, err := s.Compact()
+		metrics.WalCompactionDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			lg.Error("wal compaction failed", "error", err.Error())
+			continue
+		}
+		metrics.WalCompactions.Inc()
+		lg.Info("wal compaction done", "segments", c.Segments, "records", c.Records, "corrupt", c.Corrupt,
+			"keys", c.Keys, "lsn", c.LSN, "bytes_before", c.Before, "bytes_after", c.After,
+			"duration", time.Since(start).String())
+		if c.Corrupt > 0 {
+			lg.Warn("wal compaction skipped corrupt records", "corrupt", c.Corrupt)
+		}
+	}
+}
+
+// LockSegments stops compaction from removing segments and compression from replacing them until unlock is called
+func (s *Storage) LockSegments() (unlock func()) {
+	return s.seg.LockFiles()
+}
+
+// firstLSN returns LSN of the first intact record of the segment file, 1 if it is empty
+func firstLSN(file string, keys *Keys) (uint64, error) {
+	f, err := seg.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := NewReader(f, keys)
+	for {
+		rec, _, err := r.Next()
+		if err == io.EOF {
+			return 1, nil
+		}
+		var corrupt *CorruptError
+		if errors.As(err, &corrupt) {
+			continue
+		}
+		return rec.LSN, err
+	}
+}
+
+func fileSize(pth string) (int64, error) {
+	info, err := os.Stat(pth)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
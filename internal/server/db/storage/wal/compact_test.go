@@ -0,0 +1,187 @@
+package wal
+
+import (
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/db/seg"
+	_map "custom-in-memory-db/internal/server/db/storage/map"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func compactConf(t *testing.T) cmd.Config {
+	conf := walConf(1, time.Millisecond)
+	conf.Wal.SegPath = t.TempDir()
+	conf.Wal.SegSize = 1024
+	conf.Wal.Recover = true
+	return conf
+}
+
+// overwrite sets each of keys n times
+func overwrite(t *testing.T, s *Storage, n int, keys ...string) {
+	for i := 0; i < n; i++ {
+		for _, key := range keys {
+			require.NoError(t, s.Set(key, fmt.Sprintf("v%d", i)))
+		}
+	}
+}
+
+func TestStorage_Compact(t *testing.T) {
+	conf := compactConf(t)
+	st := _map.New()
+	s, err := New(conf, st, nilLogger)
+	require.NoError(t, err)
+	overwrite(t, s, 50, "a", "b", "c")
+	require.NoError(t, s.Del("c"))
+	files, err := seg.List(conf.Wal.SegPath)
+	require.NoError(t, err)
+	require.Greater(t, len(files), 2)
+	// a crash after the snapshot is renamed leaves a segment it already has
+	leftover, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+
+	c, err := s.Compact()
+	require.NoError(t, err)
+	assert.Equal(t, len(files)-1, c.Segments)
+	// DEL is in the current segment yet
+	assert.Equal(t, 3, c.Keys)
+	assert.Less(t, c.After, c.Before)
+	compacted, err := seg.List(conf.Wal.SegPath)
+	require.NoError(t, err)
+	assert.Equal(t, files[len(files)-1:], compacted)
+
+	// records written after compaction follow the snapshot
+	overwrite(t, s, 1, "d")
+	expected, lsn := st.Snapshot(), s.LSN()
+	require.NoError(t, s.Close())
+	require.NoError(t, os.WriteFile(files[0], leftover, 0644))
+
+	st = _map.New()
+	s, err = New(conf, st, nilLogger)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, expected, st.Snapshot())
+	assert.Equal(t, lsn, s.LSN())
+	h, _, err := ReadSnapshotFile(filepath.Join(conf.Wal.SegPath, seg.SnapshotName), nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, c.LSN, h.LSN)
+	assert.Equal(t, 3, h.Keys)
+}
+
+func TestStorage_Compact_Skipped(t *testing.T) {
+	conf := compactConf(t)
+	s, err := New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	overwrite(t, s, 1, "a", "b")
+
+	// a single segment is never compacted
+	c, err := s.Compact()
+	require.NoError(t, err)
+	assert.Zero(t, c.Segments)
+	require.NoError(t, s.Close())
+
+	conf.Wal.RecoverUntil = "1"
+	s, err = New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = s.Compact()
+	assert.ErrorIs(t, err, ErrReadOnly)
+}
+
+func TestStorage_Compactor(t *testing.T) {
+	compactInterval = time.Millisecond
+	defer func() { compactInterval = 10 * time.Second }()
+	conf := compactConf(t)
+	conf.Wal.CompactMinSize = 1
+	conf.Wal.CompactRatio = 0.5
+	st := _map.New()
+	s, err := New(conf, st, nilLogger)
+	require.NoError(t, err)
+	defer s.Close()
+
+	overwrite(t, s, 100, "a")
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(conf.Wal.SegPath, seg.SnapshotName))
+		return err == nil
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, s.Close())
+	assert.Len(t, st.Snapshot(), 1)
+}
+
+func TestStorage_CompactDue(t *testing.T) {
+	conf := compactConf(t)
+	s, err := New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	defer s.Close()
+	s.compactMinSize = 1024
+	s.compactRatio = 0.5
+
+	// unique keys are not garbage, there is nothing to gain
+	for i := 0; i < 100; i++ {
+		require.NoError(t, s.Set(fmt.Sprintf("key_%d", i), "value"))
+	}
+	assert.False(t, s.compactDue())
+
+	overwrite(t, s, 300, "key_0")
+	assert.True(t, s.compactDue())
+
+	s.compactMinSize = 1024 * 1024
+	assert.False(t, s.compactDue())
+}
+
+func TestStorage_Compact_Compression(t *testing.T) {
+	conf := compactConf(t)
+	conf.Wal.Compression = seg.CompressionZstd
+	st := _map.New()
+	s, err := New(conf, st, nilLogger)
+	require.NoError(t, err)
+	overwrite(t, s, 50, "a", "b")
+	_, err = s.Compact()
+	require.NoError(t, err)
+	expected := st.Snapshot()
+	require.NoError(t, s.Close())
+
+	info, err := seg.Stat(filepath.Join(conf.Wal.SegPath, seg.SnapshotName))
+	require.NoError(t, err)
+	assert.Equal(t, seg.CompressionZstd, info.Codec)
+
+	st = _map.New()
+	s, err = New(conf, st, nilLogger)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, expected, st.Snapshot())
+}
+
+func TestStorage_Compact_Strict(t *testing.T) {
+	conf := compactConf(t)
+	conf.Wal.RecoveryMode = RecoveryStrict
+	s, err := New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	defer s.Close()
+	overwrite(t, s, 50, "a")
+	files, err := seg.List(conf.Wal.SegPath)
+	require.NoError(t, err)
+	require.Greater(t, len(files), 2)
+
+	// a record damaged after recovery must not be dropped silently by compaction
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	data[0] = 'z'
+	require.NoError(t, os.WriteFile(files[0], data, 0644))
+	_, err = s.Compact()
+	assert.ErrorContains(t, err, fmt.Sprintf("%q: corrupt record at offset 0", files[0]))
+	assert.ErrorContains(t, err, "WAL_RECOVERY_MODE is strict")
+	compacted, err := seg.List(conf.Wal.SegPath)
+	require.NoError(t, err)
+	assert.Equal(t, files, compacted)
+
+	s.recovery.Mode = RecoveryTolerant
+	c, err := s.Compact()
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Corrupt)
+}
@@ -0,0 +1,168 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// encPrefix starts an encrypted line "ENC <key id> <nonce and ciphertext in base64>".
+// It is neither a checksum nor a command, so plain lines are never taken for encrypted ones
+const encPrefix = "ENC "
+
+// ErrAuth is reported for encrypted lines which fail AES-GCM authentication,
+// the key is wrong or the line was changed on disk
+var ErrAuth = errors.New("authentication failed")
+
+// ErrPlain is reported for plain lines read with keys which do not allow them,
+// otherwise a line written in place of encrypted ones would be replayed without authentication
+var ErrPlain = errors.New("line is not encrypted, WAL_KEY_ALLOW_PLAIN is not set")
+
+// keyID restricts key ids to characters which do not break the line format
+var keyID = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// DecryptError describes an encrypted line which can not be decrypted.
+// Unlike CorruptError it stops reading: a torn line is never complete, so a complete line failing
+// authentication means a wrong key or tampering
+type DecryptError struct {
+	// Offset of the line in the file
+	Offset int64
+	Err    error
+}
+
+func (e *DecryptError) Error() string {
+	return fmt.Sprintf("offset %d: decrypt failed: %s", e.Offset, e.Err)
+}
+
+func (e *DecryptError) Unwrap() error {
+	return e.Err
+}
+
+// Keys encrypts wal records and snapshot lines with AES-GCM.
+// Every line carries the id of its key, so old keys stay in the key file to read what they encrypted
+// while the last one encrypts new lines
+type Keys struct {
+	active     string
+	aeads      map[string]cipher.AEAD
+	allowPlain bool
+}
+
+// LoadKeys reads the key file at pth: a line "<id> <key in base64>" per key, empty lines and lines starting with #
+// are ignored. Keys are 16, 24 or 32 bytes long for AES-128, AES-192 or AES-256, the last one encrypts.
+// Plain lines are read only if allowPlain is set, so that wal written before encryption can be migrated
+func LoadKeys(pth string, allowPlain bool) (*Keys, error) {
+	const suf = "wal.LoadKeys()"
+	data, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	k := Keys{aeads: make(map[string]cipher.AEAD), allowPlain: allowPlain}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !keyID.MatchString(fields[0]) {
+			return nil, fmt.Errorf("%s failed: line %d: \"<id> <key in base64>\" expected", suf, n)
+		}
+		if _, ok := k.aeads[fields[0]]; ok {
+			return nil, fmt.Errorf("%s failed: line %d: key %q is repeated", suf, n, fields[0])
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s failed: line %d: %w", suf, n, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s failed: line %d: %w", suf, n, err)
+		}
+		if k.aeads[fields[0]], err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("%s failed: line %d: %w", suf, n, err)
+		}
+		k.active = fields[0]
+	}
+	if err = sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w", suf, err)
+	}
+	if k.active == "" {
+		return nil, fmt.Errorf("%s failed: no keys in %q", suf, pth)
+	}
+	return &k, nil
+}
+
+// Active returns the id of the key new lines are encrypted with, empty for nil Keys
+func (k *Keys) Active() string {
+	if k == nil {
+		return ""
+	}
+	return k.active
+}
+
+// Seal encrypts the line including its trailing '\n' with the active key. Nil Keys return the line as it is
+func (k *Keys) Seal(line []byte) []byte {
+	if k == nil {
+		return line
+	}
+	aead := k.aeads[k.active]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(line)+aead.Overhead())
+	// crypto/rand fails only if the OS has no randomness, a line must not be written with a predictable nonce
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	// the key id is authenticated, so a line can not be moved under another key
+	sealed := aead.Seal(nonce, nonce, line, []byte(k.active))
+	out := make([]byte, 0, len(encPrefix)+len(k.active)+1+base64.StdEncoding.EncodedLen(len(sealed))+1)
+	out = append(out, encPrefix...)
+	out = append(out, k.active...)
+	out = append(out, ' ')
+	out = base64.StdEncoding.AppendEncode(out, sealed)
+	return append(out, '\n')
+}
+
+// open decrypts the encrypted line, the result ends with '\n' like the line sealed
+func (k *Keys) open(line []byte) ([]byte, error) {
+	id, data, ok := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(string(line), encPrefix), "\n"), " ")
+	if !ok {
+		return nil, errors.New("bad encrypted line")
+	}
+	if k == nil {
+		return nil, fmt.Errorf("line is encrypted with key %q, WAL_KEY_FILE is not set", id)
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("key %q is not in WAL_KEY_FILE", id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("key %q: %w", id, ErrAuth)
+	}
+	nonce := sealed[:aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, sealed[aead.NonceSize():], []byte(id))
+	if err != nil || !bytes.HasSuffix(plain, []byte{'\n'}) {
+		return nil, fmt.Errorf("key %q: %w", id, ErrAuth)
+	}
+	return plain, nil
+}
+
+// plain returns ErrPlain unless plain lines can be read: keys are nil or allow them
+func (k *Keys) plain() error {
+	if k == nil || k.allowPlain {
+		return nil
+	}
+	return ErrPlain
+}
+
+// encrypted tells if the line is an encrypted one
+func encrypted(line []byte) bool {
+	return bytes.HasPrefix(line, []byte(encPrefix))
+}
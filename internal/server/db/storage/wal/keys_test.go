@@ -0,0 +1,163 @@
+package wal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeKeys writes a key file with a 32 byte key derived from each id to dir, the last id encrypts
+func writeKeys(t *testing.T, dir string, ids ...string) string {
+	var buf bytes.Buffer
+	buf.WriteString("# wal keys\n\n")
+	for _, id := range ids {
+		key := sha256.Sum256([]byte(id))
+		buf.WriteString(id + " " + base64.StdEncoding.EncodeToString(key[:]) + "\n")
+	}
+	pth := filepath.Join(dir, "wal.keys")
+	require.NoError(t, os.WriteFile(pth, buf.Bytes(), 0600))
+	return pth
+}
+
+func TestLoadKeys(t *testing.T) {
+	keys, err := LoadKeys(writeKeys(t, t.TempDir(), "2024-01", "2024-02"), false)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-02", keys.Active())
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, 16))
+	tests := []struct {
+		name string
+		file string
+		err  string
+	}{
+		{name: "empty", file: "# no keys\n", err: "no keys in"},
+		{name: "no key", file: "k1\n", err: `line 1: "<id> <key in base64>" expected`},
+		{name: "bad id", file: "k/1 " + key + "\n", err: `line 1: "<id> <key in base64>" expected`},
+		{name: "repeated", file: "k1 " + key + "\nk1 " + key + "\n", err: `line 2: key "k1" is repeated`},
+		{name: "not base64", file: "k1 !!!\n", err: "line 1: illegal base64 data"},
+		{name: "bad size", file: "k1 " + base64.StdEncoding.EncodeToString(make([]byte, 10)) + "\n", err: "line 1: crypto/aes: invalid key size 10"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pth := filepath.Join(t.TempDir(), "wal.keys")
+			require.NoError(t, os.WriteFile(pth, []byte(test.file), 0600))
+			_, err := LoadKeys(pth, false)
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestReader_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	old, err := LoadKeys(writeKeys(t, dir, "k1"), false)
+	require.NoError(t, err)
+	keys, err := LoadKeys(writeKeys(t, dir, "k1", "k2"), false)
+	require.NoError(t, err)
+	migrating, err := LoadKeys(writeKeys(t, dir, "k1", "k2"), true)
+	require.NoError(t, err)
+
+	ts := time.Unix(1700000000, 5)
+	first := old.Seal(encode(1, ts, "SET k v"))
+	second := keys.Seal(encode(2, ts, "DEL k"))
+	plain := encode(3, ts, "SET k w")
+	assert.False(t, bytes.Contains(first, []byte("SET k v")))
+	assert.True(t, bytes.HasPrefix(first, []byte("ENC k1 ")))
+	assert.True(t, bytes.HasPrefix(second, []byte("ENC k2 ")))
+
+	// records encrypted with old keys and plain ones allowed by keys are read, offsets are those in the file
+	segment := string(first) + string(second) + string(plain)
+	r := NewReader(strings.NewReader(segment), migrating)
+	for i, offset := range []int{0, len(first), len(first) + len(second)} {
+		rec, off, err := r.Next()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i+1), rec.LSN)
+		assert.Equal(t, int64(offset), off)
+	}
+	_, _, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// a torn encrypted record is skipped like a plain one
+	_, _, err = NewReader(strings.NewReader(string(second[:20])), keys).Next()
+	var corrupt *CorruptError
+	assert.ErrorAs(t, err, &corrupt)
+
+	tampered := bytes.Clone(second)
+	tampered[len(tampered)-5] ^= 1
+	tests := []struct {
+		name    string
+		segment string
+		keys    *Keys
+		err     string
+	}{
+		{name: "tampered", segment: string(first) + string(tampered), keys: keys,
+			err: "decrypt failed: key \"k2\": authentication failed"},
+		{name: "moved under another key", segment: string(first) + strings.Replace(string(second), "ENC k2", "ENC k1", 1), keys: keys,
+			err: "decrypt failed: key \"k1\": authentication failed"},
+		{name: "unknown key", segment: string(first) + string(second), keys: old,
+			err: "decrypt failed: key \"k2\" is not in WAL_KEY_FILE"},
+		{name: "no keys", segment: string(first) + string(second), keys: nil,
+			err: "decrypt failed: line is encrypted with key \"k1\", WAL_KEY_FILE is not set"},
+		{name: "plain", segment: string(first) + string(second) + string(plain), keys: keys,
+			err: "decrypt failed: line is not encrypted, WAL_KEY_ALLOW_PLAIN is not set"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(test.segment), test.keys)
+			var err error
+			for err == nil {
+				_, _, err = r.Next()
+			}
+			var decrypt *DecryptError
+			require.True(t, errors.As(err, &decrypt), err)
+			assert.ErrorContains(t, err, test.err)
+			assert.False(t, errors.As(err, &corrupt))
+		})
+	}
+}
+
+func TestSnapshot_Encrypted(t *testing.T) {
+	keys, err := LoadKeys(writeKeys(t, t.TempDir(), "k1"), false)
+	require.NoError(t, err)
+	state := map[string]string{"a": "1", "b": "2"}
+	var buf bytes.Buffer
+	require.NoError(t, WriteSnapshot(&buf, SnapshotHeader{LSN: 5, Time: time.Now()}, state, keys))
+	assert.NotContains(t, buf.String(), "SET")
+	assert.NotContains(t, buf.String(), snapshotMagic)
+
+	read := make(map[string]string)
+	h, err := ReadSnapshot(bytes.NewReader(buf.Bytes()), keys, func(k, v string) error {
+		read[k] = v
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), h.LSN)
+	assert.Equal(t, state, read)
+
+	_, err = ReadSnapshot(bytes.NewReader(buf.Bytes()), nil, nil)
+	assert.ErrorContains(t, err, "WAL_KEY_FILE is not set")
+
+	// a plain snapshot is read with keys only if they allow it
+	var plain bytes.Buffer
+	require.NoError(t, WriteSnapshot(&plain, SnapshotHeader{LSN: 5, Time: time.Now()}, state, nil))
+	_, err = ReadSnapshot(bytes.NewReader(plain.Bytes()), keys, nil)
+	assert.ErrorIs(t, err, ErrPlain)
+	migrating, err := LoadKeys(writeKeys(t, t.TempDir(), "k1"), true)
+	require.NoError(t, err)
+	h, err = ReadSnapshot(bytes.NewReader(plain.Bytes()), migrating, func(k, v string) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, 2, h.Keys)
+
+	data := buf.Bytes()
+	data[len(data)-5] ^= 1
+	_, err = ReadSnapshot(bytes.NewReader(data), keys, func(k, v string) error { return nil })
+	assert.ErrorIs(t, err, ErrAuth)
+}
@@ -0,0 +1,170 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is a single command of wal.
+// A record is a line "<crc> <lsn> <time> <command> <args...>", crc is crc32c in hex of the rest of the line,
+// time is unix nanoseconds of the batch write. Lines "<crc> <lsn> <command> <args...>" written before timestamps
+// were introduced are read with zero Time, lines "<command> <args...>" written before checksums with zero LSN too
+type Record struct {
+	// LSN numbers records in wal order starting with 1
+	LSN uint64
+	// Time the record was written at
+	Time    time.Time
+	Command string
+	Args    []string
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksum is reported for records whose content does not match their checksum
+var ErrChecksum = errors.New("checksum mismatch")
+
+// CorruptError describes a record which can not be read.
+// Reader skips it and goes on with the next line
+type CorruptError struct {
+	// Offset of the record in the segment
+	Offset int64
+	Err    error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// encode returns the record line with its checksum. Zero ts leaves the time out like records written before timestamps
+func encode(lsn uint64, ts time.Time, command string) []byte {
+	body := strconv.FormatUint(lsn, 10) + " " + command
+	if !ts.IsZero() {
+		body = strconv.FormatUint(lsn, 10) + " " + strconv.FormatInt(ts.UnixNano(), 10) + " " + command
+	}
+	return fmt.Appendf(nil, "%08x %s\n", crc32.Checksum([]byte(body), crcTable), body)
+}
+
+// Bytes returns the record as a wal line, records read from legacy lines without LSN are written back as they were
+func (r Record) Bytes() []byte {
+	if r.LSN == 0 {
+		return []byte(r.String() + "\n")
+	}
+	return encode(r.LSN, r.Time, r.String())
+}
+
+// String returns the command of the record as it is sent to the server
+func (r Record) String() string {
+	return strings.Join(append([]string{r.Command}, r.Args...), " ")
+}
+
+// Reader reads records of a single segment
+type Reader struct {
+	r      *bufio.Reader
+	keys   *Keys
+	offset int64
+}
+
+// NewReader returns a Reader reading the segment from r. Encrypted records are decrypted with keys,
+// they can not be read with nil keys. Plain records are read with keys only if they allow them
+func NewReader(r io.Reader, keys *Keys) *Reader {
+	return &Reader{r: bufio.NewReader(r), keys: keys}
+}
+
+// Next returns the next record and its offset in the segment, io.EOF after the last one.
+// Records which can not be read are returned as *CorruptError, the next call returns the following record.
+// Encrypted records which can not be decrypted and plain records keys do not allow are returned as *DecryptError
+func (r *Reader) Next() (Record, int64, error) {
+	offset := r.offset
+	line, err := r.r.ReadBytes('\n')
+	r.offset += int64(len(line))
+	if err == io.EOF && len(line) == 0 {
+		return Record{}, offset, io.EOF
+	}
+	if err == io.EOF {
+		return Record{}, offset, &CorruptError{Offset: offset, Err: io.ErrUnexpectedEOF}
+	}
+	if err != nil {
+		return Record{}, offset, err
+	}
+	if encrypted(line) {
+		line, err = r.keys.open(line)
+	} else {
+		err = r.keys.plain()
+	}
+	if err != nil {
+		return Record{}, offset, &DecryptError{Offset: offset, Err: err}
+	}
+
+	rec, err := decode(line)
+	if err != nil {
+		return Record{}, offset, &CorruptError{Offset: offset, Err: err}
+	}
+	return rec, offset, nil
+}
+
+// Offset returns the offset the next record is read from
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// decode parses a record line including its trailing '\n'
+func decode(line []byte) (Record, error) {
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return Record{}, errors.New("empty record")
+	}
+	// legacy record
+	if fields[0] == "SET" || fields[0] == "DEL" {
+		return newRecord(0, time.Time{}, fields)
+	}
+
+	if len(fields) < 3 {
+		return Record{}, fmt.Errorf("%d fields, at least 3 expected", len(fields))
+	}
+	sum, err := strconv.ParseUint(fields[0], 16, 32)
+	if err != nil {
+		return Record{}, fmt.Errorf("bad checksum: %w", err)
+	}
+	body, _ := bytes.CutPrefix(bytes.TrimRight(line, "\n"), []byte(fields[0]+" "))
+	if crc32.Checksum(body, crcTable) != uint32(sum) {
+		return Record{}, ErrChecksum
+	}
+	lsn, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil || lsn == 0 {
+		return Record{}, fmt.Errorf("bad lsn %q", fields[1])
+	}
+	// record without timestamp
+	if fields[2] == "SET" || fields[2] == "DEL" {
+		return newRecord(lsn, time.Time{}, fields[2:])
+	}
+	if len(fields) < 4 {
+		return Record{}, fmt.Errorf("%d fields, at least 4 expected", len(fields))
+	}
+	ns, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || ns <= 0 {
+		return Record{}, fmt.Errorf("bad time %q", fields[2])
+	}
+	return newRecord(lsn, time.Unix(0, ns), fields[3:])
+}
+
+func newRecord(lsn uint64, ts time.Time, fields []string) (Record, error) {
+	rec := Record{LSN: lsn, Time: ts, Command: fields[0], Args: fields[1:]}
+	switch {
+	case rec.Command == "SET" && len(rec.Args) == 2:
+	case rec.Command == "DEL" && len(rec.Args) == 1:
+	default:
+		return Record{}, fmt.Errorf("bad command %q", rec.String())
+	}
+	return rec, nil
+}
@@ -0,0 +1,126 @@
+package wal
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	ts := time.Unix(1700000000, 5)
+	set := string(encode(7, ts, "SET k v"))
+	del := string(encode(8, ts, "DEL k"))
+	corrupted := strings.Replace(set, "SET k v", "SET k w", 1)
+	wrongCommand := string(encode(9, ts, "GET k"))
+	noTime := "44705ad2 6 SET k v\n"
+	// offsets of the second record
+	afterSet, afterCommand := itoa(int64(len(set))), itoa(int64(len(wrongCommand)))
+
+	tests := []struct {
+		name    string
+		segment string
+		// records expected as "offset lsn time command" or "offset error"
+		expected []string
+	}{
+		{name: "records", segment: set + del,
+			expected: []string{"0 7 1700000000000000005 SET k v", afterSet + " 8 1700000000000000005 DEL k"}},
+		{name: "records without time", segment: noTime + del,
+			expected: []string{"0 6 0 SET k v", "19 8 1700000000000000005 DEL k"}},
+		{name: "legacy records", segment: "SET k v \nDEL k \n",
+			expected: []string{"0 0 0 SET k v", "9 0 0 DEL k"}},
+		{name: "checksum mismatch", segment: corrupted + del,
+			expected: []string{"0 offset 0: checksum mismatch", afterSet + " 8 1700000000000000005 DEL k"}},
+		{name: "unknown command", segment: wrongCommand + del,
+			expected: []string{"0 offset 0: bad command \"GET k\"", afterCommand + " 8 1700000000000000005 DEL k"}},
+		{name: "torn tail", segment: set + del[:10],
+			expected: []string{"0 7 1700000000000000005 SET k v", afterSet + " offset " + afterSet + ": unexpected EOF"}},
+		{name: "garbage", segment: "\x00\x00\n" + del,
+			expected: []string{"0 offset 0: 1 fields, at least 3 expected", "3 8 1700000000000000005 DEL k"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(test.segment), nil)
+			var got []string
+			for {
+				rec, offset, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				var corrupt *CorruptError
+				if errors.As(err, &corrupt) {
+					assert.Equal(t, offset, corrupt.Offset)
+					got = append(got, strings.Join([]string{itoa(offset), err.Error()}, " "))
+					continue
+				}
+				require.NoError(t, err)
+				var ns int64
+				if !rec.Time.IsZero() {
+					ns = rec.Time.UnixNano()
+				}
+				got = append(got, strings.Join([]string{itoa(offset), utoa(rec.LSN), itoa(ns), rec.String()}, " "))
+			}
+			assert.Equal(t, test.expected, got)
+			assert.Equal(t, int64(len(test.segment)), r.Offset())
+		})
+	}
+}
+
+func TestReader_ErrChecksum(t *testing.T) {
+	line := strings.Replace(string(encode(1, time.Now(), "DEL key")), "key", "kex", 1)
+	_, _, err := NewReader(strings.NewReader(line), nil).Next()
+	assert.ErrorIs(t, err, ErrChecksum)
+}
+
+func TestRecord_Bytes(t *testing.T) {
+	ts := time.Unix(1700000000, 5)
+	for _, line := range []string{string(encode(7, ts, "SET k v")), "44705ad2 6 SET k v\n", "DEL k\n"} {
+		rec, _, err := NewReader(strings.NewReader(line), nil).Next()
+		require.NoError(t, err)
+		assert.Equal(t, line, string(rec.Bytes()))
+	}
+}
+
+func itoa(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
+
+func utoa(u uint64) string {
+	return strconv.FormatUint(u, 10)
+}
+
+func TestParseTarget(t *testing.T) {
+	target, err := ParseTarget("42")
+	require.NoError(t, err)
+	assert.Equal(t, Target{LSN: 42}, target)
+	assert.False(t, target.Before(Record{LSN: 42}))
+	assert.True(t, target.Before(Record{LSN: 43}))
+	assert.False(t, target.Before(Record{}))
+
+	target, err = ParseTarget("2024-05-01T10:00:00Z")
+	require.NoError(t, err)
+	until := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	assert.True(t, until.Equal(target.Time))
+	assert.False(t, target.Before(Record{LSN: 1, Time: until}))
+	assert.True(t, target.Before(Record{LSN: 1, Time: until.Add(time.Nanosecond)}))
+	// records without time precede any time
+	assert.False(t, target.Before(Record{LSN: 1}))
+
+	target, err = ParseTarget("")
+	require.NoError(t, err)
+	assert.True(t, target.IsZero())
+	assert.False(t, target.Before(Record{LSN: 1, Time: time.Now()}))
+
+	snapshot := SnapshotHeader{LSN: 10, Time: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+	assert.False(t, target.Precedes(snapshot))
+	assert.True(t, Target{LSN: 9}.Precedes(snapshot))
+	assert.False(t, Target{LSN: 10}.Precedes(snapshot))
+	assert.True(t, Target{Time: snapshot.Time.Add(-time.Second)}.Precedes(snapshot))
+
+	_, err = ParseTarget("yesterday")
+	assert.EqualError(t, err, `"yesterday" is neither RFC3339 time nor LSN`)
+}
@@ -1,43 +1,287 @@
 package wal
 
 import (
-	"bufio"
-	"custom-in-memory-db/internal/server/db/parser"
 	"custom-in-memory-db/internal/server/db/seg"
+	"custom-in-memory-db/internal/server/db/storage"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
-	"os"
+	"strconv"
+	"time"
 )
 
-// Recover loads wal to the running storage.Storage
-func Recover(seg *seg.Segments, setFunc func(k, v string) error, delFunc func(k string) error, lg *slog.Logger) error {
-	for _, file := range seg.ExportSegNames() {
-		f, err := os.Open(file)
-		if err != nil {
-			return fmt.Errorf("failed to load %q: %w", file, err)
+// Target is the point in wal recovery stops at, zero Target recovers the whole wal
+type Target struct {
+	// LSN of the last record to recover
+	LSN uint64
+	// Time of the last record to recover
+	Time time.Time
+}
+
+// ParseTarget parses WAL_RECOVER_UNTIL, either RFC3339 time or LSN. Empty string is zero Target
+func ParseTarget(s string) (Target, error) {
+	if s == "" {
+		return Target{}, nil
+	}
+	if lsn, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return Target{LSN: lsn}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return Target{}, fmt.Errorf("%q is neither RFC3339 time nor LSN", s)
+	}
+	return Target{Time: t}, nil
+}
+
+// IsZero tells if the target is the end of wal
+func (t Target) IsZero() bool {
+	return t.LSN == 0 && t.Time.IsZero()
+}
+
+// Before tells if rec goes after the target and must not be recovered.
+// Records written before LSNs and timestamps were introduced precede any target
+func (t Target) Before(rec Record) bool {
+	if t.LSN > 0 {
+		return rec.LSN > t.LSN
+	}
+	return !t.Time.IsZero() && rec.Time.After(t.Time)
+}
+
+// Precedes tells if the target is before the snapshot, its records are gone from wal, so it can not be recovered
+func (t Target) Precedes(h SnapshotHeader) bool {
+	if t.LSN > 0 {
+		return t.LSN < h.LSN
+	}
+	return !t.Time.IsZero() && t.Time.Before(h.Time)
+}
+
+func (t Target) String() string {
+	if t.LSN > 0 {
+		return "lsn " + strconv.FormatUint(t.LSN, 10)
+	}
+	return t.Time.Format(time.RFC3339Nano)
+}
+
+// WAL_RECOVERY_MODE values
+const (
+	// RecoveryStrict fails recovery on any corrupt record
+	RecoveryStrict = "strict"
+	// RecoveryTolerant skips corrupt records
+	RecoveryTolerant = "tolerant"
+	// RecoveryTruncateTail cuts corrupt records off the end of the last segment and fails recovery on any other
+	RecoveryTruncateTail = "truncate-tail"
+)
+
+// Recovery describes what recovery did
+type Recovery struct {
+	// Mode is one of WAL_RECOVERY_MODE values
+	Mode string
+	// Stopped is set if replay stopped at the target before the end of wal
+	Stopped bool
+	// Segments lists the segments read in wal order
+	Segments []SegmentRecovery
+	// Truncated is the number of bytes cut off the end of the last segment
+	Truncated int64
+}
+
+// SegmentRecovery describes what recovery did with a segment
+type SegmentRecovery struct {
+	// Path of the segment file
+	Path string
+	// Applied is the number of records committed to the storage, Skipped is the number of corrupt records.
+	// Records the snapshot has are counted in neither
+	Applied int
+	Skipped int
+	// FirstBad is the offset of the first corrupt record, -1 if there are none
+	FirstBad int64
+	// intact is set if an intact record follows the first corrupt one
+	intact bool
+}
+
+// Applied returns the number of records committed to the storage
+func (r Recovery) Applied() int {
+	var n int
+	for _, s := range r.Segments {
+		n += s.Applied
+	}
+	return n
+}
+
+// Skipped returns the number of corrupt records
+func (r Recovery) Skipped() int {
+	var n int
+	for _, s := range r.Segments {
+		n += s.Skipped
+	}
+	return n
+}
+
+// FirstBad returns the segment and the offset of the first corrupt record, false if there are none
+func (r Recovery) FirstBad() (SegmentRecovery, bool) {
+	for _, s := range r.Segments {
+		if s.Skipped > 0 {
+			return s, true
 		}
+	}
+	return SegmentRecovery{}, false
+}
 
-		loadFile(f, setFunc, delFunc, lg)
+// Recover loads wal to the running storage.Storage, encrypted records are decrypted with keys
+func Recover(seg *seg.Segments, keys *Keys, mode string, setFunc func(k, v string) error, delFunc func(k string) error, lg *slog.Logger) (Recovery, error) {
+	return RecoverUntil(seg, keys, Target{}, mode, setFunc, delFunc, lg)
+}
+
+// RecoverUntil loads wal to the running storage.Storage up to the target including it.
+// The snapshot of WAL_SEG_PATH, if any, is loaded first and the records it includes are skipped.
+// Corrupt records are handled as mode tells, empty mode is RecoveryTolerant.
+// Errors of the storage and records failing decryption fail it whatever the mode
+func RecoverUntil(seg *seg.Segments, keys *Keys, target Target, mode string, setFunc func(k, v string) error, delFunc func(k string) error, lg *slog.Logger) (Recovery, error) {
+	if mode == "" {
+		mode = RecoveryTolerant
+	}
+	r := Recovery{Mode: mode}
+	h, ok, err := ReadSnapshotFile(seg.SnapshotPath(), keys, setFunc)
+	if err != nil {
+		return r, err
+	}
+	if ok && target.Precedes(h) {
+		return r, fmt.Errorf("target %s precedes the snapshot at lsn %d taken at %s",
+			target.String(), h.LSN, h.Time.Format(time.RFC3339Nano))
+	}
+	// records before from are in the snapshot
+	var from uint64
+	if ok {
+		from = h.LSN + 1
+		lg.Info("wal snapshot loaded", "lsn", h.LSN, "keys", h.Keys)
+	}
+	files := seg.ExportSegNames()
+	for i, file := range files {
+		sr, err := r.loadFile(file, keys, from, target, setFunc, delFunc, lg)
+		r.Segments = append(r.Segments, sr)
+		if err != nil {
+			return r, fmt.Errorf("failed to load %q: %w", file, err)
+		}
+		if sr.Skipped > 0 && mode != RecoveryTolerant {
+			// a crash leaves torn records at the end of the last segment only
+			if mode == RecoveryStrict || i < len(files)-1 || sr.intact {
+				return r, fmt.Errorf("failed to load %q: corrupt record at offset %d, WAL_RECOVERY_MODE is %s",
+					file, sr.FirstBad, mode)
+			}
+			if err = r.truncate(seg, sr, lg); err != nil {
+				return r, err
+			}
+		}
+		if r.Stopped {
+			return r, nil
+		}
 	}
 
-	return nil
+	return r, nil
 }
 
-// loadFile reads commands from a provided file and commits them to the running storage.Storage
-func loadFile(f *os.File, set func(k, v string) error, del func(k string) error, lg *slog.Logger) {
-	pr := parser.New()
-	reader := bufio.NewReader(f)
-	var err error = nil
-	for ; err == nil; _, err = reader.Peek(1) {
-		c, e := pr.Read(reader, lg)
-		if e != nil {
-			// skip incorrect line in a file
+// loadFile reads commands from a provided file and commits them to the running storage.Storage.
+// Records with LSN less than from are skipped. Sets Stopped once a record after target is met.
+// Corrupt records are counted and skipped, records failing decryption fail it
+func (r *Recovery) loadFile(file string, keys *Keys, from uint64, target Target, set func(k, v string) error, del func(k string) error, lg *slog.Logger) (SegmentRecovery, error) {
+	sr := SegmentRecovery{Path: file, FirstBad: -1}
+	f, err := seg.Open(file)
+	if err != nil {
+		return sr, err
+	}
+	defer f.Close()
+
+	rd := NewReader(f, keys)
+	for {
+		rec, offset, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		var corrupt *CorruptError
+		if errors.As(err, &corrupt) {
+			if sr.Skipped == 0 {
+				sr.FirstBad = corrupt.Offset
+			}
+			sr.Skipped++
+			lg.Error("wal record skipped", "segment", file, "error", err.Error())
 			continue
 		}
-		if c.Command == "SET" {
-			_ = set(c.Arg1, c.Arg2)
+		if err != nil {
+			return sr, err
+		}
+		if sr.Skipped > 0 {
+			sr.intact = true
+		}
+		if rec.LSN < from {
 			continue
 		}
-		_ = del(c.Arg1)
+		if target.Before(rec) {
+			lg.Warn("wal recovery stopped at the target", "target", target.String(),
+				"segment", file, "offset", offset, "lsn", rec.LSN)
+			r.Stopped = true
+			break
+		}
+		if rec.Command == "SET" {
+			err = set(rec.Args[0], rec.Args[1])
+		} else {
+			err = del(rec.Args[0])
+		}
+		// DEL is written to wal before the key is looked up, so it may remove nothing
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return sr, fmt.Errorf("offset %d: %w", offset, err)
+		}
+		sr.Applied++
+	}
+	if sr.Skipped > 0 {
+		lg.Warn("wal segment has corrupt records", "segment", file, "skipped", sr.Skipped,
+			"first_bad_offset", sr.FirstBad, "applied", sr.Applied)
+	}
+	return sr, nil
+}
+
+// truncate cuts the corrupt records off the end of the last segment.
+// Offsets are in the decompressed content, so a compressed segment is rewritten without the tail
+func (r *Recovery) truncate(sg *seg.Segments, sr SegmentRecovery, lg *slog.Logger) error {
+	info, err := seg.Stat(sr.Path)
+	if err != nil {
+		return err
+	}
+	if err = sg.Truncate(sr.Path, sr.FirstBad); err != nil {
+		return fmt.Errorf("failed to truncate the tail of %q: %w", sr.Path, err)
+	}
+	r.Truncated = info.RawSize - sr.FirstBad
+	lg.Warn("wal tail truncated", "segment", sr.Path, "codec", info.Codec, "offset", sr.FirstBad, "bytes", r.Truncated)
+	return nil
+}
+
+// lastLSN returns LSN of the last record in files, 0 if none of them has LSNs
+func lastLSN(files []string, keys *Keys) (uint64, error) {
+	for i := len(files) - 1; i >= 0; i-- {
+		f, err := seg.Open(files[i])
+		if err != nil {
+			return 0, err
+		}
+		var last uint64
+		r := NewReader(f, keys)
+		for {
+			rec, _, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			var corrupt *CorruptError
+			if errors.As(err, &corrupt) {
+				continue
+			}
+			if err != nil {
+				_ = f.Close()
+				return 0, err
+			}
+			last = max(last, rec.LSN)
+		}
+		_ = f.Close()
+		if last > 0 {
+			return last, nil
+		}
 	}
+	return 0, nil
 }
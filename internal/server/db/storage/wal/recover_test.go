@@ -0,0 +1,242 @@
+package wal
+
+import (
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/db/seg"
+	_map "custom-in-memory-db/internal/server/db/storage/map"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// damagedWal writes 30 keys to a wal in a new folder and damages it with damage,
+// which returns the segment and the offset of the first corrupt record
+func damagedWal(t *testing.T, damage func(t *testing.T, files []string) (string, int64)) (cmd.Config, string, int64) {
+	conf := walConf(1, time.Millisecond)
+	conf.Wal.SegPath = t.TempDir()
+	conf.Wal.SegSize = 256
+	conf.Wal.Recover = true
+	s, err := New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		require.NoError(t, s.Set(fmt.Sprintf("key_%d", i), "value"))
+	}
+	require.NoError(t, s.Close())
+	files, err := seg.List(conf.Wal.SegPath)
+	require.NoError(t, err)
+	require.Greater(t, len(files), 2)
+	file, offset := damage(t, files)
+	return conf, file, offset
+}
+
+// appendTo appends data to the file and returns its size before
+func appendTo(t *testing.T, file string, data string) int64 {
+	size, err := fileSize(file)
+	require.NoError(t, err)
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, errors.Join(err, f.Close()))
+	return size
+}
+
+func TestStorage_RecoveryMode(t *testing.T) {
+	damages := map[string]func(t *testing.T, files []string) (string, int64){
+		// a crash in the middle of a write
+		"torn tail": func(t *testing.T, files []string) (string, int64) {
+			last := files[len(files)-1]
+			return last, appendTo(t, last, string(encode(31, time.Now(), "SET torn value")[:20]))
+		},
+		"corrupt last segment": func(t *testing.T, files []string) (string, int64) {
+			last := files[len(files)-1]
+			return last, appendTo(t, last, "garbage\n"+string(encode(31, time.Now(), "SET late value")))
+		},
+		"corrupt closed segment": func(t *testing.T, files []string) (string, int64) {
+			data, err := os.ReadFile(files[0])
+			require.NoError(t, err)
+			data[0] = 'z'
+			require.NoError(t, os.WriteFile(files[0], data, 0644))
+			return files[0], 0
+		},
+	}
+	tests := []struct {
+		mode   string
+		damage string
+		// failed is set if the server must not start
+		failed bool
+	}{
+		{mode: RecoveryTolerant, damage: "torn tail"},
+		{mode: RecoveryTolerant, damage: "corrupt last segment"},
+		{mode: RecoveryTolerant, damage: "corrupt closed segment"},
+		{mode: RecoveryStrict, damage: "torn tail", failed: true},
+		{mode: RecoveryStrict, damage: "corrupt last segment", failed: true},
+		{mode: RecoveryStrict, damage: "corrupt closed segment", failed: true},
+		{mode: RecoveryTruncateTail, damage: "torn tail"},
+		{mode: RecoveryTruncateTail, damage: "corrupt last segment", failed: true},
+		{mode: RecoveryTruncateTail, damage: "corrupt closed segment", failed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.mode+" "+test.damage, func(t *testing.T) {
+			conf, file, offset := damagedWal(t, damages[test.damage])
+			conf.Wal.RecoveryMode = test.mode
+			size, err := fileSize(file)
+			require.NoError(t, err)
+
+			st := _map.New()
+			s, err := New(conf, st, nilLogger)
+			if test.failed {
+				assert.EqualError(t, err, fmt.Sprintf("wal recovery failed: failed to load %q: corrupt record at offset %d, WAL_RECOVERY_MODE is %s",
+					file, offset, test.mode))
+				return
+			}
+			require.NoError(t, err)
+			defer s.Close()
+
+			r := s.Recovery()
+			assert.Equal(t, test.mode, r.Mode)
+			assert.Equal(t, 1, r.Skipped())
+			bad, ok := r.FirstBad()
+			require.True(t, ok)
+			assert.Equal(t, file, bad.Path)
+			assert.Equal(t, offset, bad.FirstBad)
+			assert.Equal(t, st.Stats().Keys, r.Applied())
+			if test.mode == RecoveryTruncateTail {
+				assert.Equal(t, size-offset, r.Truncated)
+				size = offset
+			}
+			current, err := fileSize(file)
+			require.NoError(t, err)
+			assert.Equal(t, size, current)
+		})
+	}
+}
+
+func TestStorage_RecoveryMode_TruncateTail(t *testing.T) {
+	conf, file, offset := damagedWal(t, func(t *testing.T, files []string) (string, int64) {
+		last := files[len(files)-1]
+		return last, appendTo(t, last, "0badc0de 31 SET torn")
+	})
+	conf.Wal.RecoveryMode = RecoveryTruncateTail
+	s, err := New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	segment, position := s.Position()
+	assert.Equal(t, filepath.Base(file), fmt.Sprint(segment))
+	assert.Equal(t, offset, position)
+
+	// records written after the tail is cut are not glued to it
+	require.NoError(t, s.Set("after", "crash"))
+	require.NoError(t, s.Close())
+	conf.Wal.RecoveryMode = RecoveryStrict
+	st := _map.New()
+	s, err = New(conf, st, nilLogger)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Zero(t, s.Recovery().Skipped())
+	assert.Equal(t, 31, s.Recovery().Applied())
+	v, err := st.Get("after")
+	require.NoError(t, err)
+	assert.Equal(t, "crash", v)
+}
+
+func TestStorage_RecoveryMode_TruncateTail_Compressed(t *testing.T) {
+	for _, codec := range []string{seg.CompressionSnappy, seg.CompressionZstd, seg.CompressionGzip} {
+		t.Run(codec, func(t *testing.T) {
+			// the segment is compressed after the crash, e.g. by ramdb-wal, so the server starts a new one after it
+			conf, file, offset := damagedWal(t, func(t *testing.T, files []string) (string, int64) {
+				last := files[len(files)-1]
+				offset := appendTo(t, last, "0badc0de 31 SET torn")
+				require.NoError(t, seg.CompressFile(last, codec))
+				return last, offset
+			})
+			before, err := seg.Stat(file)
+			require.NoError(t, err)
+			require.Equal(t, codec, before.Codec)
+
+			conf.Wal.RecoveryMode = RecoveryTruncateTail
+			s, err := New(conf, _map.New(), nilLogger)
+			require.NoError(t, err)
+			assert.Equal(t, before.RawSize-offset, s.Recovery().Truncated)
+			require.NoError(t, s.Set("after", "crash"))
+			require.NoError(t, s.Close())
+
+			// the segment is rewritten without the tail and stays compressed
+			after, err := seg.Stat(file)
+			require.NoError(t, err)
+			assert.Equal(t, codec, after.Codec)
+			assert.Equal(t, offset, after.RawSize)
+
+			conf.Wal.RecoveryMode = RecoveryStrict
+			st := _map.New()
+			s, err = New(conf, st, nilLogger)
+			require.NoError(t, err)
+			defer s.Close()
+			assert.Zero(t, s.Recovery().Skipped())
+			assert.Equal(t, 31, s.Recovery().Applied())
+			v, err := st.Get("after")
+			require.NoError(t, err)
+			assert.Equal(t, "crash", v)
+		})
+	}
+}
+
+func TestRecoverUntil_StorageError(t *testing.T) {
+	conf, _, _ := damagedWal(t, func(t *testing.T, files []string) (string, int64) { return "", 0 })
+	sg, err := seg.New(conf)
+	require.NoError(t, err)
+	defer sg.Close()
+
+	failed := errors.New("out of memory")
+	r, err := RecoverUntil(sg, nil, Target{}, RecoveryStrict, func(k, v string) error {
+		if k == "key_3" {
+			return failed
+		}
+		return nil
+	}, func(k string) error { return nil }, nilLogger)
+	assert.ErrorIs(t, err, failed)
+	assert.Equal(t, 3, r.Applied())
+}
+
+func TestStorage_Recovery_PlainInEncrypted(t *testing.T) {
+	conf, _, _ := damagedWal(t, func(t *testing.T, files []string) (string, int64) { return "", 0 })
+	conf.Wal.KeyFile = writeKeys(t, t.TempDir(), "k1")
+
+	// wal written before encryption is read only with the opt-in
+	_, err := New(conf, _map.New(), nilLogger)
+	assert.ErrorIs(t, err, ErrPlain)
+	conf.Wal.KeyAllowPlain = true
+	st := _map.New()
+	s, err := New(conf, st, nilLogger)
+	require.NoError(t, err)
+	assert.Equal(t, 30, st.Stats().Keys)
+	// compaction folds plain records into an encrypted snapshot, after that the opt-in is not needed
+	overwrite(t, s, 10, "encrypted")
+	_, err = s.Compact()
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	conf.Wal.KeyAllowPlain = false
+	s, err = New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// a plain record written in place of encrypted ones fails recovery in every mode
+	files, err := seg.List(conf.Wal.SegPath)
+	require.NoError(t, err)
+	last := files[len(files)-1]
+	offset := appendTo(t, last, string(encode(100, time.Now(), "SET injected value")))
+	for _, mode := range []string{RecoveryTolerant, RecoveryStrict, RecoveryTruncateTail} {
+		t.Run(mode, func(t *testing.T) {
+			conf.Wal.RecoveryMode = mode
+			_, err := New(conf, _map.New(), nilLogger)
+			var decrypt *DecryptError
+			require.ErrorAs(t, err, &decrypt)
+			assert.ErrorIs(t, err, ErrPlain)
+			assert.Equal(t, offset, decrypt.Offset)
+			assert.ErrorContains(t, err, fmt.Sprintf("failed to load %q", last))
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package wal
+
+import (
+	"bufio"
+	"custom-in-memory-db/internal/server/db/seg"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotMagic starts the header line of a snapshot file
+const snapshotMagic = "RAMDB-SNAPSHOT"
+
+// SnapshotHeader describes the state a snapshot holds.
+// A snapshot is a header line "RAMDB-SNAPSHOT <lsn> <time> <keys>" followed by a line "<crc> SET <key> <value>" per key,
+// crc is crc32c in hex of the rest of the line
+type SnapshotHeader struct {
+	// LSN of the last record included into the snapshot, wal records up to it are not needed to recover
+	LSN uint64
+	// Time the snapshot was taken at
+	Time time.Time
+	// Keys is the number of keys in the snapshot
+	Keys int
+}
+
+// WriteSnapshot writes state as of h.LSN to w, keys go in sorted order. Each line is encrypted with keys unless they are nil
+func WriteSnapshot(w io.Writer, h SnapshotHeader, state map[string]string, keys *Keys) error {
+	bw := bufio.NewWriter(w)
+	header := fmt.Appendf(nil, "%s %d %d %d\n", snapshotMagic, h.LSN, h.Time.UnixNano(), len(state))
+	if _, err := bw.Write(keys.Seal(header)); err != nil {
+		return err
+	}
+	sorted := make([]string, 0, len(state))
+	for k := range state {
+		sorted = append(sorted, k)
+	}
+	slices.Sort(sorted)
+	for _, k := range sorted {
+		body := "SET " + k + " " + state[k]
+		line := fmt.Appendf(nil, "%08x %s\n", crc32.Checksum([]byte(body), crcTable), body)
+		if _, err := bw.Write(keys.Seal(line)); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadSnapshot reads the snapshot from r calling set for each key. Nil set reads the header only.
+// Encrypted lines are decrypted with keys. Unlike wal segments a snapshot is never read partially, any damage fails it
+func ReadSnapshot(r io.Reader, keys *Keys, set func(k, v string) error) (SnapshotHeader, error) {
+	br := bufio.NewReader(r)
+	raw, line, err := readLine(br, keys)
+	if err != nil {
+		return SnapshotHeader{}, fmt.Errorf("bad snapshot header: %w", err)
+	}
+	h, err := parseSnapshotHeader(line)
+	if err != nil || set == nil {
+		return h, err
+	}
+
+	var n int
+	var offset int64
+	for {
+		offset += int64(raw)
+		raw, line, err = readLine(br, keys)
+		if err == io.EOF && raw == 0 {
+			break
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		var decrypt *DecryptError
+		if errors.As(err, &decrypt) {
+			decrypt.Offset = offset
+			return h, decrypt
+		}
+		if err != nil {
+			return h, &CorruptError{Offset: offset, Err: err}
+		}
+		rec, err := decodeEntry(line)
+		if err != nil {
+			return h, &CorruptError{Offset: offset, Err: err}
+		}
+		if err = set(rec.Args[0], rec.Args[1]); err != nil {
+			return h, err
+		}
+		n++
+	}
+	if n != h.Keys {
+		return h, fmt.Errorf("snapshot has %d keys, %d expected", n, h.Keys)
+	}
+	return h, nil
+}
+
+// readLine reads a line of a snapshot decrypting it with keys if it is encrypted.
+// Returns the size of the line in the file and the line
+func readLine(br *bufio.Reader, keys *Keys) (int, string, error) {
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return len(line), string(line), err
+	}
+	if !encrypted(line) {
+		if err = keys.plain(); err != nil {
+			return len(line), "", &DecryptError{Err: err}
+		}
+		return len(line), string(line), nil
+	}
+	plain, err := keys.open(line)
+	if err != nil {
+		return len(line), "", &DecryptError{Err: err}
+	}
+	return len(line), string(plain), nil
+}
+
+// ReadSnapshotFile is ReadSnapshot of the file at pth. Returns false if there is no such file
+func ReadSnapshotFile(pth string, keys *Keys, set func(k, v string) error) (SnapshotHeader, bool, error) {
+	f, err := seg.Open(pth)
+	if errors.Is(err, os.ErrNotExist) {
+		return SnapshotHeader{}, false, nil
+	}
+	if err != nil {
+		return SnapshotHeader{}, false, err
+	}
+	defer f.Close()
+	h, err := ReadSnapshot(f, keys, set)
+	if err != nil {
+		return h, true, fmt.Errorf("snapshot %q: %w", pth, err)
+	}
+	return h, true, nil
+}
+
+func parseSnapshotHeader(line string) (SnapshotHeader, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 4 || fields[0] != snapshotMagic {
+		return SnapshotHeader{}, fmt.Errorf("bad snapshot header %q", strings.TrimSpace(line))
+	}
+	lsn, err1 := strconv.ParseUint(fields[1], 10, 64)
+	ns, err2 := strconv.ParseInt(fields[2], 10, 64)
+	keys, err3 := strconv.Atoi(fields[3])
+	if err := errors.Join(err1, err2, err3); err != nil || keys < 0 {
+		return SnapshotHeader{}, fmt.Errorf("bad snapshot header %q", strings.TrimSpace(line))
+	}
+	return SnapshotHeader{LSN: lsn, Time: time.Unix(0, ns), Keys: keys}, nil
+}
+
+// decodeEntry parses a snapshot line "<crc> SET <key> <value>"
+func decodeEntry(line string) (Record, error) {
+	sum, body, ok := strings.Cut(strings.TrimRight(line, "\n"), " ")
+	if !ok {
+		return Record{}, errors.New("no checksum")
+	}
+	crc, err := strconv.ParseUint(sum, 16, 32)
+	if err != nil {
+		return Record{}, fmt.Errorf("bad checksum: %w", err)
+	}
+	if crc32.Checksum([]byte(body), crcTable) != uint32(crc) {
+		return Record{}, ErrChecksum
+	}
+	fields := strings.Fields(body)
+	if len(fields) == 0 || fields[0] != "SET" {
+		return Record{}, fmt.Errorf("bad snapshot entry %q", body)
+	}
+	return newRecord(0, time.Time{}, fields)
+}
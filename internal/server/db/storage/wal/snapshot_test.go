@@ -0,0 +1,63 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	h := SnapshotHeader{LSN: 42, Time: time.Unix(1700000000, 5)}
+	state := map[string]string{"b": "2", "a": "1"}
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteSnapshot(buf, h, state, nil))
+	snapshot := buf.String()
+	header := "RAMDB-SNAPSHOT 42 1700000000000000005 2\n"
+	require.True(t, strings.HasPrefix(snapshot, header))
+	// offset of the second entry
+	second := len(header) + strings.Index(snapshot[len(header):], "\n") + 1
+
+	got := make(map[string]string)
+	read, err := ReadSnapshot(strings.NewReader(snapshot), nil, func(k, v string) error {
+		got[k] = v
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, state, got)
+	assert.Equal(t, 42, int(read.LSN))
+	assert.Equal(t, 2, read.Keys)
+	assert.True(t, h.Time.Equal(read.Time))
+
+	// header only
+	read, err = ReadSnapshot(strings.NewReader(snapshot), nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, read.Keys)
+
+	tests := []struct {
+		name     string
+		snapshot string
+		err      string
+	}{
+		{name: "checksum mismatch", snapshot: strings.Replace(snapshot, "SET a 1", "SET a 3", 1),
+			err: "offset " + itoa(int64(len(header))) + ": checksum mismatch"},
+		{name: "missing keys", snapshot: snapshot[:second],
+			err: "snapshot has 1 keys, 2 expected"},
+		{name: "torn tail", snapshot: strings.TrimSuffix(snapshot, "\n"),
+			err: "offset " + itoa(int64(second)) + ": unexpected EOF"},
+		{name: "bad header", snapshot: "RAMDB-SNAPSHOT 42\n",
+			err: `bad snapshot header "RAMDB-SNAPSHOT 42"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReadSnapshot(strings.NewReader(test.snapshot), nil, func(k, v string) error { return nil })
+			assert.EqualError(t, err, test.err)
+		})
+	}
+
+	_, err = ReadSnapshot(strings.NewReader(snapshot), nil, func(k, v string) error { return errors.New("full") })
+	assert.EqualError(t, err, "full")
+}
@@ -1,82 +1,207 @@
 package wal
 
 import (
+	"context"
 	"custom-in-memory-db/internal/server/cmd"
 	"custom-in-memory-db/internal/server/db/seg"
 	"custom-in-memory-db/internal/server/db/storage"
+	"custom-in-memory-db/internal/server/health"
+	"custom-in-memory-db/internal/server/metrics"
+	"custom-in-memory-db/internal/server/tracing"
 	"errors"
-	atomicUber "go.uber.org/atomic"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"log/slog"
-	"runtime"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
-// ErrWalWriteFailed is the error returned by w.write when batch failed to write to wal.
-// (w.write must return ErrWalWriteFailed itself, not an error wrapping ErrWalWriteFailed, because callers will test for EOF using ==.)
+// ErrWalWriteFailed is the error returned to each command of a batch which failed to write to wal.
+// (ErrWalWriteFailed is returned itself, not wrapped, because callers test for it using ==.)
 var ErrWalWriteFailed = errors.New("wal write failed")
-var writeOk = errors.New("ok")
+
+// ErrClosed is returned for commands sent after Close
+var ErrClosed = errors.New("wal closed")
+
+// ErrReadOnly is returned for commands sent after recovery stopped at WAL_RECOVER_UNTIL before the end of wal
+var ErrReadOnly = errors.New("wal is read only after recovery until WAL_RECOVER_UNTIL, restart without it to write")
+
+// contextWriter is implemented by writers which trace writes as a part of the request in ctx
+type contextWriter interface {
+	WriteContext(ctx context.Context, p []byte) (int, error)
+}
+
+// syncer is implemented by writers which leave fsync to the caller in WAL_SYNC_MODE=everysec
+type syncer interface {
+	Sync() error
+}
+
+// syncInterval is how often wal is fsynced in WAL_SYNC_MODE=everysec. Tests shorten it
+var syncInterval = time.Second
+
+// record is a command waiting for its batch to be written
+type record struct {
+	ctx  context.Context
+	wait trace.Span
+	line string
+	// apply commits the command to the underlying storage once it is in wal
+	apply func() error
+	done  chan error
+}
+
+// checkpoint is a copy of the underlying storage which includes records up to lsn and nothing else
+type checkpoint struct {
+	state map[string]string
+	lsn   uint64
+}
 
 // Storage is the same as map.Storage and adds wal implementation.
-// Expect files to be written to a WAL_SEG_PATH
+// Expect files to be written to a WAL_SEG_PATH.
+// Commands are written by a single goroutine in batches of up to WAL_BATCH_MAX commands,
+// each batch is fsynced once. A batch is written once it is full or WAL_BATCH_TIMEOUT after its first command.
+// WAL_SYNC_MODE=always writes and fsyncs commands one by one, everysec and none write commands as soon
+// as they arrive and leave fsync to a timer or to the OS
 type Storage struct {
-	st storage.Storage
+	st       storage.Storage
+	syncMode string
+	// readOnly is set if records after WAL_RECOVER_UNTIL were not recovered.
+	// Writing after them would bring them back on the next recovery
+	readOnly bool
+
+	batchMax     atomic.Int32
+	batchTimeout atomic.Int64
+	// lsn is the LSN of the last record written, only run changes it once the record is in wal
+	lsn atomic.Uint64
+
+	// records is closed by Close, mtx guards sending to it and to checkpoints
+	mtx         sync.RWMutex
+	closed      bool
+	records     chan record
+	checkpoints chan chan checkpoint
+	stopped     chan struct{}
 
-	batch     atomicUber.String
-	batchMax  int32
-	batchSize atomic.Int32
-	// Timer implementation.
-	// We're flipping a coin every flipTimer duration
-	flipTimer time.Duration
-	coin      atomic.Bool
-	closer    chan struct{}
+	writer io.WriteCloser
+	seg    *seg.Segments
+	// keys encrypt records, nil keys leave them plain
+	keys *Keys
+	// recovery is what recovery did on start, compaction skips corrupt records in RecoveryTolerant mode only
+	recovery Recovery
 
-	writeHappens atomic.Bool
-	writer       io.WriteCloser
+	segPath        string
+	compactMinSize int64
+	compactRatio   float64
+	// stop is closed by Close to stop the compactor, compactorStopped is closed once it has returned
+	stop             chan struct{}
+	compactorStopped chan struct{}
 }
 
 // New used to initialize Storage.
 // Any initializations after the first one won't take effect
 func New(conf cmd.Config, st storage.Storage, lg *slog.Logger) (*Storage, error) {
-	s := Storage{}
-	s.st = st
-	s.batchMax = int32(conf.Wal.BatchMax)
-	s.flipTimer = conf.Wal.BatchTimeout
-	s.writeHappens.Store(false)
-	s.closer = make(chan struct{})
-	go s.coinFlipper(s.closer)
+	var keys *Keys
+	if conf.Wal.KeyFile != "" {
+		var err error
+		if keys, err = LoadKeys(conf.Wal.KeyFile, conf.Wal.KeyAllowPlain); err != nil {
+			return nil, err
+		}
+	}
 	sg, err := seg.New(conf)
 	if err != nil {
 		return nil, err
 	}
+	target, err := ParseTarget(conf.Wal.RecoverUntil)
+	if err != nil {
+		return nil, errors.Join(err, sg.Close())
+	}
+	recovery := Recovery{Mode: conf.Wal.RecoveryMode}
+	if recovery.Mode == "" {
+		recovery.Mode = RecoveryTolerant
+	}
 	if conf.Wal.Recover {
-		err = Recover(sg, st.Set, st.Del, lg)
+		start := time.Now()
+		recovery, err = RecoverUntil(sg, keys, target, recovery.Mode, st.Set, st.Del, lg)
+		metrics.WalRecoveryDuration.Set(time.Since(start).Seconds())
+		// records which fail decryption were written with another key or changed, going on would lose them
+		if err != nil {
+			lg.Error("wal recovery failed", "mode", recovery.Mode, "applied", recovery.Applied(),
+				"skipped", recovery.Skipped(), "error", err.Error())
+			return nil, errors.Join(fmt.Errorf("wal recovery failed: %w", err), sg.Close())
+		}
+		lg.Info("wal recovery done", "mode", recovery.Mode, "segments", len(recovery.Segments),
+			"applied", recovery.Applied(), "skipped", recovery.Skipped(), "truncated", recovery.Truncated,
+			"duration", time.Since(start).String())
 	}
-	s.writer = sg
+	metrics.RegisterSegments(func() float64 {
+		count, _ := sg.Stats()
+		return float64(count)
+	}, func() float64 {
+		_, size := sg.Stats()
+		return float64(size)
+	})
 
-	return &s, nil
+	lsn, err := lastLSN(sg.ExportSegNames(), keys)
+	if err != nil {
+		return nil, errors.Join(err, sg.Close())
+	}
+	h, _, err := ReadSnapshotFile(sg.SnapshotPath(), keys, nil)
+	if err != nil {
+		return nil, errors.Join(err, sg.Close())
+	}
+	lsn = max(lsn, h.LSN)
+	s := newStorage(conf, st, sg, lsn)
+	s.seg = sg
+	s.keys = keys
+	s.readOnly = recovery.Stopped
+	s.recovery = recovery
+	s.segPath = conf.Wal.SegPath
+	s.compactMinSize = int64(conf.Wal.CompactMinSize) * cmd.KB
+	s.compactRatio = conf.Wal.CompactRatio
+	if conf.Wal.CompactMinSize > 0 && !s.readOnly {
+		s.stop = make(chan struct{})
+		s.compactorStopped = make(chan struct{})
+		go s.compactor(lg)
+	}
+	return s, nil
+}
+
+// newStorage starts the goroutine writing batches to w. Records get LSNs following lsn
+func newStorage(conf cmd.Config, st storage.Storage, w io.WriteCloser, lsn uint64) *Storage {
+	s := Storage{st: st, syncMode: conf.Wal.SyncMode, writer: w}
+	s.lsn.Store(lsn)
+	s.batchMax.Store(int32(conf.Wal.BatchMax))
+	s.batchTimeout.Store(int64(conf.Wal.BatchTimeout))
+	// a full batch can be collected while the previous one is being written
+	s.records = make(chan record, conf.Wal.BatchMax)
+	s.checkpoints = make(chan chan checkpoint)
+	s.stopped = make(chan struct{})
+	go s.run()
+	return &s
 }
 
 // Set sets provided value for the provided key.
 // Set is thread-safe
 func (s *Storage) Set(key, value string) error {
-	err := s.lockOrWrite("SET", key, value)
-	if err != nil && err != writeOk {
-		return err
-	}
-	return s.st.Set(key, value)
+	return s.SetContext(context.Background(), key, value)
+}
+
+// SetContext is Set traced as a part of the request in ctx
+func (s *Storage) SetContext(ctx context.Context, key, value string) error {
+	return s.commit(ctx, func() error { return s.st.Set(key, value) }, "SET", key, value)
 }
 
 // Del removes provided key and it's value.
 // Del is thread-safe
 func (s *Storage) Del(key string) error {
-	err := s.lockOrWrite("DEL", key)
-	if err != nil && err != writeOk {
-		return err
-	}
-	return s.st.Del(key)
+	return s.DelContext(context.Background(), key)
+}
+
+// DelContext is Del traced as a part of the request in ctx
+func (s *Storage) DelContext(ctx context.Context, key string) error {
+	return s.commit(ctx, func() error { return s.st.Del(key) }, "DEL", key)
 }
 
 // Get returns a value of the provided key.
@@ -84,109 +209,261 @@ func (s *Storage) Get(key string) (string, error) {
 	return s.st.Get(key)
 }
 
-// Close gracefully stops the Storage
+// Snapshot returns a consistent copy of the underlying storage.
+// Commands waiting for wal are not included
+func (s *Storage) Snapshot() map[string]string {
+	return s.st.Snapshot()
+}
+
+// Checkpoint returns a copy of the underlying storage and LSN of the last record it includes.
+// The copy is taken between batches, so it includes every record up to the LSN and nothing after it
+func (s *Storage) Checkpoint() (map[string]string, uint64, error) {
+	req := make(chan checkpoint, 1)
+	s.mtx.RLock()
+	if s.closed {
+		s.mtx.RUnlock()
+		return nil, 0, ErrClosed
+	}
+	s.checkpoints <- req
+	s.mtx.RUnlock()
+
+	cp := <-req
+	return cp.state, cp.lsn, nil
+}
+
+// Stats returns stats of the underlying storage
+func (s *Storage) Stats() storage.Stats {
+	return s.st.Stats()
+}
+
+// BatchMax returns the current WAL_BATCH_MAX
+func (s *Storage) BatchMax() int {
+	return int(s.batchMax.Load())
+}
+
+// SetBatchMax changes WAL_BATCH_MAX at runtime.
+// The batch being collected is written once it reaches the new size
+func (s *Storage) SetBatchMax(n int) {
+	s.batchMax.Store(int32(n))
+}
+
+// BatchTimeout returns the current WAL_BATCH_TIMEOUT
+func (s *Storage) BatchTimeout() time.Duration {
+	return time.Duration(s.batchTimeout.Load())
+}
+
+// SetBatchTimeout changes WAL_BATCH_TIMEOUT at runtime. It applies to the batches started afterwards
+func (s *Storage) SetBatchTimeout(d time.Duration) {
+	s.batchTimeout.Store(int64(d))
+}
+
+// SyncMode returns WAL_SYNC_MODE
+func (s *Storage) SyncMode() string {
+	return s.syncMode
+}
+
+// ReadOnly tells if the Storage rejects commands because recovery stopped at WAL_RECOVER_UNTIL
+func (s *Storage) ReadOnly() bool {
+	return s.readOnly
+}
+
+// Recovery returns what recovery did on start
+func (s *Storage) Recovery() Recovery {
+	return s.recovery
+}
+
+// Keys returns the keys records are encrypted with, nil if they are not
+func (s *Storage) Keys() *Keys {
+	return s.keys
+}
+
+// LSN returns LSN of the last record written to wal
+func (s *Storage) LSN() uint64 {
+	return s.lsn.Load()
+}
+
+// Position returns the current wal segment and the offset next batch is written at
+func (s *Storage) Position() (int, int64) {
+	return s.seg.Position()
+}
+
+// Close gracefully stops the Storage.
+// Commands sent before Close are written and synced before the segment is closed
 func (s *Storage) Close() error {
-	// stop ticker and its goroutine
-	s.closer <- struct{}{}
-	<-s.closer
-	// wait for wal to be written
-	for s.batch.Load() != "" {
-		runtime.Gosched()
+	const suf = "wal.Close()"
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.records)
+	s.mtx.Unlock()
+
+	if s.stop != nil {
+		close(s.stop)
+		<-s.compactorStopped
+	}
+	<-s.stopped
+	if err := s.writer.Close(); err != nil {
+		return fmt.Errorf("%s failed: %w", suf, err)
 	}
-	return s.writer.Close()
+	return nil
 }
 
-// addToBuff loads command to batch and increments batchSize.
-func (s *Storage) addToBuff(args ...string) {
-	cmnd := strings.Join(append(args[:], "\n"), " ")
+// commit sends the command to the writer and waits until it is written and applied
+func (s *Storage) commit(ctx context.Context, apply func() error, args ...string) error {
+	_, span := tracing.Start(ctx, "wal.wait")
+	r := record{
+		ctx:   ctx,
+		wait:  span,
+		line:  strings.Join(args, " "),
+		apply: apply,
+		done:  make(chan error, 1),
+	}
 
-	for {
-		oldVal := s.batch.Load()
-		if !s.writeHappens.Load() && s.batchSize.Load() < s.batchMax && s.batch.CompareAndSwap(oldVal, strings.Join([]string{oldVal, cmnd}, "")) {
-			// This doesn't ensure strict batch size. Overflow might happen
-			s.batchSize.Add(1)
-			return
-		}
+	if s.readOnly {
+		span.End()
+		return ErrReadOnly
+	}
+	s.mtx.RLock()
+	if s.closed {
+		s.mtx.RUnlock()
+		span.End()
+		return ErrClosed
 	}
+	s.records <- r
+	s.mtx.RUnlock()
+
+	return <-r.done
 }
 
-// waitForWrite holds goroutine until batchSize reaches batchMax or until coin flips
-func (s *Storage) waitForWrite() error {
-	heads := s.coin.Load()
+// run collects records into batches and writes them until records is closed
+func (s *Storage) run() {
+	defer close(s.stopped)
+
+	// stopped and reset timers never deliver stale values since go 1.23
+	timer := time.NewTimer(s.BatchTimeout())
+	timer.Stop()
+	// tick stays nil unless wal is fsynced by the timer
+	var tick <-chan time.Time
+	if s.syncMode == seg.SyncEverysec {
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var batch []record
 	for {
-		if s.coin.Load() != heads {
-			err := s.write()
-			return err
+		if len(batch) == 0 {
+			select {
+			case r, ok := <-s.records:
+				if !ok {
+					return
+				}
+				batch = append(batch, r)
+				timer.Reset(s.BatchTimeout())
+			case <-tick:
+				s.sync()
+				continue
+			case req := <-s.checkpoints:
+				req <- checkpoint{state: s.st.Snapshot(), lsn: s.LSN()}
+				continue
+			}
+		}
+		if s.full(batch) {
+			timer.Stop()
+			batch = s.write(batch)
+			continue
 		}
-		if s.batchSize.Load() >= s.batchMax {
-			err := s.write()
-			return err
+
+		select {
+		case r, ok := <-s.records:
+			if !ok {
+				timer.Stop()
+				s.write(batch)
+				return
+			}
+			batch = append(batch, r)
+		case <-timer.C:
+			batch = s.write(batch)
+		case <-tick:
+			s.sync()
+		case req := <-s.checkpoints:
+			// the batch being collected is not applied yet, so it is not in the copy either
+			req <- checkpoint{state: s.st.Snapshot(), lsn: s.LSN()}
 		}
 	}
 }
 
-// write actually writes batch to a file.
-func (s *Storage) write() error {
-	// ensures only one goroutine will be writing to a file
-	for {
-		old := s.writeHappens.Load()
-		if old {
-			return nil
-		}
-		if s.writeHappens.CompareAndSwap(old, true) {
-			break
-		}
+// full tells if batch is to be written without waiting for more commands
+func (s *Storage) full(batch []record) bool {
+	switch s.syncMode {
+	case seg.SyncAlways:
+		return true
+	case seg.SyncEverysec, seg.SyncNone:
+		// unsynced writes are cheap, waiting would only add latency
+		return len(s.records) == 0 || len(batch) >= s.BatchMax()
 	}
-	// I'm the only one here
-	defer s.writeHappens.Store(false)
-	defer s.batchSize.Store(0)
-	batch := []byte(s.batch.Load())
-	batchLen := len(batch)
-	n, err := s.writer.Write(batch)
-	if err != nil {
-		return ErrWalWriteFailed
+	return len(batch) >= s.BatchMax()
+}
+
+// sync fsyncs commands written since the last sync.
+// Their commands are already acknowledged, so a failure is only reported by metrics and health
+func (s *Storage) sync() {
+	sc, ok := s.writer.(syncer)
+	if !ok {
+		return
 	}
-	// wipe only n written bytes
-	if n != batchLen {
-		s.batch.Store(string(batch[batchLen+1:]))
-	} else {
-		s.batch.Store("")
+	err := sc.Sync()
+	health.SetWalFailing(err != nil)
+	if err != nil {
+		metrics.WalWriteErrors.Inc()
 	}
-	return writeOk
 }
 
-// lockOrWrite stores args to a batch and then either writes them to a file
-// or waits until someone else does
-func (s *Storage) lockOrWrite(args ...string) error {
-	s.addToBuff(args...)
-	return s.waitForWrite()
-}
+// write writes batch to wal with a single fsync (if WAL_SYNC_MODE asks for one), then applies it to the underlying storage in the same order.
+// Each command gets its own result. Returns batch emptied for reuse
+func (s *Storage) write(batch []record) []record {
+	var buf []byte
+	// LSNs of a failed batch are not reused, some of its records may be on disk
+	lsn := s.lsn.Load()
+	now := time.Now()
+	for _, r := range batch {
+		r.wait.End()
+		lsn++
+		buf = append(buf, s.keys.Seal(encode(lsn, now, r.line))...)
+	}
 
-// coinFlipper flips a coin every flipTimer interval.
-func (s *Storage) coinFlipper(closer chan struct{}) {
-	t := time.NewTicker(s.flipTimer)
-	defer t.Stop()
-	for {
-		select {
-		case <-closer:
-			t.Stop()
-			closer <- struct{}{}
-			return
-		default:
-		}
+	metrics.WalBatchSize.Observe(float64(len(batch)))
+	// the batch is traced as a part of the request of its first command
+	ctx, span := tracing.Start(batch[0].ctx, "wal.write",
+		attribute.Int("wal.batch.commands", len(batch)), attribute.Int("wal.batch.bytes", len(buf)))
+	start := time.Now()
+	var n int
+	var err error
+	if cw, ok := s.writer.(contextWriter); ok {
+		n, err = cw.WriteContext(ctx, buf)
+	} else {
+		n, err = s.writer.Write(buf)
+	}
+	if err == nil && n != len(buf) {
+		err = io.ErrShortWrite
+	}
+	// readers of LSN expect its record to be in wal
+	s.lsn.Store(lsn)
+	metrics.WalFlushDuration.Observe(time.Since(start).Seconds())
+	tracing.End(span, err)
+	health.SetWalFailing(err != nil)
+	if err != nil {
+		metrics.WalWriteErrors.Inc()
+	}
 
-		select {
-		case <-t.C:
-			for {
-				old := s.coin.Load()
-				if s.coin.CompareAndSwap(old, !old) {
-					break
-				}
-			}
-		case <-closer:
-			t.Stop()
-			closer <- struct{}{}
-			return
+	for _, r := range batch {
+		if err != nil {
+			r.done <- ErrWalWriteFailed
+			continue
 		}
+		r.done <- r.apply()
 	}
+	return batch[:0]
 }
@@ -0,0 +1,558 @@
+package wal
+
+import (
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/db/seg"
+	_map "custom-in-memory-db/internal/server/db/storage/map"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/metrics"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var nilLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// batchWriter records batches written to it
+type batchWriter struct {
+	mtx     sync.Mutex
+	batches []string
+	err     error
+	closed  bool
+	syncs   int
+}
+
+func (w *batchWriter) Write(p []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.batches = append(w.batches, string(p))
+	return len(p), nil
+}
+
+func (w *batchWriter) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.closed = true
+	return nil
+}
+
+func (w *batchWriter) Sync() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.syncs++
+	return nil
+}
+
+func (w *batchWriter) Syncs() int {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.syncs
+}
+
+func (w *batchWriter) Batches() []string {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return slices.Clone(w.batches)
+}
+
+// Commands returns commands of each batch decoded, LSN first
+func (w *batchWriter) Commands() []string {
+	var result []string
+	for _, batch := range w.Batches() {
+		r := NewReader(strings.NewReader(batch), nil)
+		var commands []string
+		for {
+			rec, _, err := r.Next()
+			if err != nil {
+				break
+			}
+			commands = append(commands, fmt.Sprintf("%d %s", rec.LSN, rec))
+		}
+		result = append(result, strings.Join(commands, "; "))
+	}
+	return result
+}
+
+func walConf(batchMax int, batchTimeout time.Duration) cmd.Config {
+	return cmd.Config{Wal: cmd.Wal{BatchMax: batchMax, BatchTimeout: batchTimeout, SyncMode: seg.SyncBatch}}
+}
+
+// setAll sets keys concurrently and returns their results
+func setAll(s *Storage, keys ...string) []error {
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.Set(key, "v")
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestStorage_BatchMax(t *testing.T) {
+	w := &batchWriter{}
+	st := _map.New()
+	s := newStorage(walConf(4, time.Hour), st, w, 0)
+	defer s.Close()
+
+	// a full batch is written at once, no matter the timeout
+	assert.Equal(t, []error{nil, nil, nil, nil}, setAll(s, "1", "2", "3", "4"))
+	require.Len(t, w.Batches(), 1)
+	assert.Equal(t, 4, strings.Count(w.Batches()[0], "\n"))
+	assert.Equal(t, 4, st.Stats().Keys)
+}
+
+func TestStorage_BatchTimeout(t *testing.T) {
+	w := &batchWriter{}
+	s := newStorage(walConf(100, 20*time.Millisecond), _map.New(), w, 0)
+	defer s.Close()
+
+	start := time.Now()
+	assert.NoError(t, s.Set("1", "v"))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	assert.Equal(t, []string{"1 SET 1 v"}, w.Commands())
+
+	// timeout change applies to the next batch
+	s.SetBatchTimeout(time.Millisecond)
+	assert.Equal(t, time.Millisecond, s.BatchTimeout())
+	start = time.Now()
+	assert.NoError(t, s.Del("1"))
+	assert.Less(t, time.Since(start), 20*time.Millisecond)
+	assert.Equal(t, []string{"1 SET 1 v", "2 DEL 1"}, w.Commands())
+}
+
+func TestStorage_WriteFailed(t *testing.T) {
+	w := &batchWriter{err: errors.New("disk full")}
+	st := _map.New()
+	s := newStorage(walConf(2, time.Hour), st, w, 0)
+	defer s.Close()
+
+	// every command of the batch fails and none is applied
+	assert.Equal(t, []error{ErrWalWriteFailed, ErrWalWriteFailed}, setAll(s, "1", "2"))
+	assert.Equal(t, 0, st.Stats().Keys)
+
+	// the next batch is not affected
+	w.mtx.Lock()
+	w.err = nil
+	w.mtx.Unlock()
+	assert.Equal(t, []error{nil, nil}, setAll(s, "1", "2"))
+	assert.Equal(t, 2, st.Stats().Keys)
+}
+
+func TestStorage_Close(t *testing.T) {
+	w := &batchWriter{}
+	s := newStorage(walConf(100, time.Hour), _map.New(), w, 0)
+
+	done := make(chan error)
+	go func() { done <- s.Set("1", "v") }()
+	assert.Eventually(t, func() bool { return len(s.records) == 0 }, time.Second, time.Millisecond)
+
+	// the pending batch is written on Close instead of waiting for the timeout
+	assert.NoError(t, s.Close())
+	assert.NoError(t, <-done)
+	assert.Equal(t, []string{"1 SET 1 v"}, w.Commands())
+	assert.True(t, w.closed)
+
+	assert.ErrorIs(t, s.Set("2", "v"), ErrClosed)
+	assert.NoError(t, s.Close())
+}
+
+func TestStorage_SyncAlways(t *testing.T) {
+	conf := walConf(4, time.Hour)
+	conf.Wal.SyncMode = seg.SyncAlways
+	w := &batchWriter{}
+	s := newStorage(conf, _map.New(), w, 0)
+	defer s.Close()
+
+	// every command is written on its own, no waiting for the batch
+	assert.Equal(t, []error{nil, nil, nil}, setAll(s, "1", "2", "3"))
+	assert.Len(t, w.Batches(), 3)
+}
+
+func TestStorage_SyncEverysec(t *testing.T) {
+	defer func(d time.Duration) { syncInterval = d }(syncInterval)
+	syncInterval = 10 * time.Millisecond
+	conf := walConf(4, time.Hour)
+	conf.Wal.SyncMode = seg.SyncEverysec
+	w := &batchWriter{}
+	s := newStorage(conf, _map.New(), w, 0)
+	defer s.Close()
+
+	// commands are acknowledged once written, fsync follows on its own
+	assert.NoError(t, s.Set("1", "v"))
+	assert.Equal(t, []string{"1 SET 1 v"}, w.Commands())
+	assert.Eventually(t, func() bool { return w.Syncs() > 1 }, time.Second, time.Millisecond)
+}
+
+func TestStorage_SyncNone(t *testing.T) {
+	conf := walConf(4, time.Hour)
+	conf.Wal.SyncMode = seg.SyncNone
+	w := &batchWriter{}
+	s := newStorage(conf, _map.New(), w, 0)
+	defer s.Close()
+
+	assert.NoError(t, s.Set("1", "v"))
+	assert.Equal(t, []string{"1 SET 1 v"}, w.Commands())
+	assert.Zero(t, w.Syncs())
+}
+
+func TestStorage_Recover(t *testing.T) {
+	for _, mode := range []string{seg.SyncAlways, seg.SyncBatch, seg.SyncEverysec, seg.SyncNone} {
+		t.Run(mode, func(t *testing.T) {
+			testRecover(t, mode)
+		})
+	}
+}
+
+func testRecover(t *testing.T, syncMode string) {
+	conf := walConf(8, time.Millisecond)
+	conf.Wal.SegPath = t.TempDir()
+	conf.Wal.SegSize = 256
+	conf.Wal.Recover = true
+	conf.Wal.SyncMode = syncMode
+	s, err := New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+
+	keys := make([]string, 200)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for _, err := range setAll(s, keys...) {
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Del("0"))
+	require.NoError(t, s.Close())
+
+	// batches are split between segments without breaking commands
+	segments, _ := s.seg.Stats()
+	assert.Greater(t, segments, 1)
+
+	st := _map.New()
+	s, err = New(conf, st, nilLogger)
+	require.NoError(t, err)
+	assert.Equal(t, len(keys)-1, st.Stats().Keys)
+	_, err = st.Get("0")
+	assert.Error(t, err)
+
+	// LSNs go on after restart and new records follow the old ones
+	assert.Equal(t, uint64(len(keys)+1), s.LSN())
+	require.NoError(t, s.Set("0", "again"))
+	require.NoError(t, s.Close())
+	st = _map.New()
+	s, err = New(conf, st, nilLogger)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, uint64(len(keys)+2), s.LSN())
+	v, err := st.Get("0")
+	assert.NoError(t, err)
+	assert.Equal(t, "again", v)
+}
+
+func TestStorage_Compression(t *testing.T) {
+	conf := walConf(1, time.Millisecond)
+	conf.Wal.SegPath = t.TempDir()
+	conf.Wal.SegSize = 256
+	conf.Wal.Recover = true
+	expected := make(map[string]string)
+	// every restart switches the codec, so the folder ends up with segments of all of them
+	codecs := []string{seg.CompressionNone, seg.CompressionSnappy, seg.CompressionZstd, seg.CompressionGzip, seg.CompressionNone}
+	for i, codec := range codecs {
+		conf.Wal.Compression = codec
+		st := _map.New()
+		s, err := New(conf, st, nilLogger)
+		require.NoError(t, err)
+		assert.Equal(t, expected, st.Snapshot(), codec)
+		for j := 0; j < 20; j++ {
+			key := fmt.Sprintf("%d_%d", i, j)
+			require.NoError(t, s.Set(key, codec))
+			expected[key] = codec
+		}
+		require.NoError(t, s.Close())
+	}
+
+	// closed segments are compressed by the codec they were closed with, the rest on the next start
+	used := make(map[string]bool)
+	files, err := seg.List(conf.Wal.SegPath)
+	require.NoError(t, err)
+	for _, file := range files {
+		info, err := seg.Stat(file)
+		require.NoError(t, err)
+		used[info.Codec] = true
+	}
+	assert.Equal(t, map[string]bool{seg.CompressionNone: true, seg.CompressionSnappy: true,
+		seg.CompressionZstd: true, seg.CompressionGzip: true}, used)
+
+	st := _map.New()
+	s, err := New(conf, st, nilLogger)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, expected, st.Snapshot())
+	assert.Equal(t, uint64(len(codecs)*20), s.LSN())
+}
+
+func TestStorage_Encryption(t *testing.T) {
+	conf := walConf(1, time.Millisecond)
+	conf.Wal.SegPath = t.TempDir()
+	conf.Wal.SegSize = 256
+	conf.Wal.Recover = true
+	keyDir := t.TempDir()
+	conf.Wal.KeyFile = writeKeys(t, keyDir, "k1")
+	st := _map.New()
+	s, err := New(conf, st, nilLogger)
+	require.NoError(t, err)
+	overwrite(t, s, 10, "secret")
+	require.NoError(t, s.Close())
+
+	// the key is rotated, records of the old one are still read
+	conf.Wal.KeyFile = writeKeys(t, keyDir, "k1", "k2")
+	s, err = New(conf, st, nilLogger)
+	require.NoError(t, err)
+	overwrite(t, s, 10, "other")
+	require.NoError(t, s.Close())
+
+	conf.Wal.KeyFile = writeKeys(t, keyDir, "k2")
+	_, err = New(conf, _map.New(), nilLogger)
+	assert.ErrorContains(t, err, `wal recovery failed: failed to load`)
+	assert.ErrorContains(t, err, `key "k1" is not in WAL_KEY_FILE`)
+
+	conf.Wal.KeyFile = writeKeys(t, keyDir, "k1", "k2")
+	s, err = New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	_, err = s.Compact()
+	require.NoError(t, err)
+	overwrite(t, s, 1, "last")
+	require.NoError(t, s.Close())
+	expected := st.Snapshot()
+	expected["last"] = "v0"
+
+	files, err := seg.List(conf.Wal.SegPath)
+	require.NoError(t, err)
+	for _, file := range append(files, filepath.Join(conf.Wal.SegPath, seg.SnapshotName)) {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "secret", file)
+	}
+	st = _map.New()
+	s, err = New(conf, st, nilLogger)
+	require.NoError(t, err)
+	assert.Equal(t, expected, st.Snapshot())
+	require.NoError(t, s.Close())
+
+	// a torn record at the end is skipped
+	last := files[len(files)-1]
+	f, err := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("ENC k2 AAAA")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	st = _map.New()
+	s, err = New(conf, st, nilLogger)
+	require.NoError(t, err)
+	assert.Equal(t, expected, st.Snapshot())
+	require.NoError(t, s.Close())
+
+	// a changed record fails recovery instead of being skipped
+	data, err := os.ReadFile(last)
+	require.NoError(t, err)
+	data[20] ^= 1
+	require.NoError(t, os.WriteFile(last, data, 0644))
+	_, err = New(conf, _map.New(), nilLogger)
+	assert.ErrorIs(t, err, ErrAuth)
+}
+
+// cpuTime returns CPU time the process spent running Go code, GC included
+func cpuTime() float64 {
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/user:cpu-seconds"},
+		{Name: "/cpu/classes/gc/total:cpu-seconds"},
+	}
+	metrics.Read(samples)
+	return samples[0].Value.Float64() + samples[1].Value.Float64()
+}
+
+func benchmarkSet(b *testing.B, clients, batchMax int, syncMode string) {
+	conf := cmd.Config{Wal: cmd.Wal{
+		BatchMax:     batchMax,
+		BatchTimeout: time.Millisecond,
+		SegSize:      1 << 30,
+		SegPath:      b.TempDir(),
+		SyncMode:     syncMode,
+	}}
+	s, err := New(conf, _map.New(), nilLogger)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+
+	var ids atomic.Int64
+	// concurrent clients are what batches are made of
+	b.SetParallelism(clients)
+	b.ResetTimer()
+	start := cpuTime()
+	b.RunParallel(func(pb *testing.PB) {
+		client := strconv.FormatInt(ids.Add(1), 10)
+		for i := 0; pb.Next(); i++ {
+			if err := s.Set(client+"_"+strconv.Itoa(i), "value"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric((cpuTime()-start)*1e9/float64(b.N), "cpu-ns/op")
+}
+
+func BenchmarkStorage_Set(b *testing.B) {
+	for _, batchMax := range []int{1, 16, 256} {
+		b.Run(fmt.Sprintf("batch_max=%d", batchMax), func(b *testing.B) {
+			benchmarkSet(b, 16, batchMax, seg.SyncBatch)
+		})
+	}
+}
+
+// BenchmarkStorage_SyncMode shows what each WAL_SYNC_MODE costs per command.
+// A single client shows latency, it never fills a batch and waits for WAL_BATCH_TIMEOUT in batch mode
+func BenchmarkStorage_SyncMode(b *testing.B) {
+	for _, clients := range []int{1, 16} {
+		for _, mode := range []string{seg.SyncAlways, seg.SyncBatch, seg.SyncEverysec, seg.SyncNone} {
+			b.Run(fmt.Sprintf("clients=%d/wal_sync_mode=%s", clients, mode), func(b *testing.B) {
+				benchmarkSet(b, clients, 16, mode)
+			})
+		}
+	}
+}
+
+func TestStorage_RecoverUntil(t *testing.T) {
+	conf := walConf(1, time.Millisecond)
+	conf.Wal.SegPath = t.TempDir()
+	conf.Wal.SegSize = 1024
+	conf.Wal.Recover = true
+	s, err := New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, s.Set(strconv.Itoa(i), "v"))
+	}
+	time.Sleep(time.Millisecond)
+	mark := time.Now()
+	time.Sleep(time.Millisecond)
+	for i := 4; i <= 6; i++ {
+		require.NoError(t, s.Set(strconv.Itoa(i), "v"))
+	}
+	require.NoError(t, s.Close())
+
+	tests := []struct {
+		until    string
+		keys     int
+		readOnly bool
+	}{
+		{until: "5", keys: 5, readOnly: true},
+		{until: mark.Format(time.RFC3339Nano), keys: 3, readOnly: true},
+		{until: "6", keys: 6, readOnly: false},
+		{until: "", keys: 6, readOnly: false},
+	}
+	for _, test := range tests {
+		t.Run(test.until, func(t *testing.T) {
+			conf.Wal.RecoverUntil = test.until
+			st := _map.New()
+			s, err := New(conf, st, nilLogger)
+			require.NoError(t, err)
+			defer s.Close()
+
+			assert.Equal(t, test.keys, st.Stats().Keys)
+			assert.Equal(t, test.readOnly, s.ReadOnly())
+			if test.readOnly {
+				// records after the target must not be followed by new ones
+				assert.ErrorIs(t, s.Set("7", "v"), ErrReadOnly)
+				assert.ErrorIs(t, s.Del("1"), ErrReadOnly)
+			}
+		})
+	}
+}
+
+func TestStorage_Checkpoint(t *testing.T) {
+	w := &batchWriter{}
+	st := _map.New()
+	s := newStorage(walConf(4, time.Millisecond), st, w, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_ = s.Set(fmt.Sprintf("%d_%d", i, j), "v")
+			}
+		}()
+	}
+	state, lsn, err := s.Checkpoint()
+	require.NoError(t, err)
+	wg.Wait()
+	require.NoError(t, s.Close())
+
+	// the copy holds exactly the records up to its LSN
+	expected := make(map[string]string)
+	for _, batch := range w.Commands() {
+		for _, command := range strings.Split(batch, "; ") {
+			var n uint64
+			var key string
+			_, err = fmt.Sscanf(command, "%d SET %s v", &n, &key)
+			require.NoError(t, err)
+			if n <= lsn {
+				expected[key] = "v"
+			}
+		}
+	}
+	assert.Equal(t, expected, state)
+
+	_, _, err = s.Checkpoint()
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestStorage_RecoverSnapshot(t *testing.T) {
+	conf := walConf(1, time.Millisecond)
+	conf.Wal.SegPath = t.TempDir()
+	conf.Wal.SegSize = 1024
+	conf.Wal.Recover = true
+	s, err := New(conf, _map.New(), nilLogger)
+	require.NoError(t, err)
+	for i := 1; i <= 4; i++ {
+		require.NoError(t, s.Set(strconv.Itoa(i), "v"))
+	}
+	require.NoError(t, s.Close())
+
+	// the snapshot replaces the first 2 records
+	snapshot := filepath.Join(conf.Wal.SegPath, seg.SnapshotName)
+	f, err := os.Create(snapshot)
+	require.NoError(t, err)
+	require.NoError(t, WriteSnapshot(f, SnapshotHeader{LSN: 2, Time: time.Now()}, map[string]string{"snap": "v"}, nil))
+	require.NoError(t, f.Close())
+
+	st := _map.New()
+	s, err = New(conf, st, nilLogger)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"snap": "v", "3": "v", "4": "v"}, st.Snapshot())
+	assert.Equal(t, uint64(4), s.LSN())
+	require.NoError(t, s.Close())
+
+	sg, err := seg.New(conf)
+	require.NoError(t, err)
+	defer sg.Close()
+	_, err = RecoverUntil(sg, nil, Target{LSN: 1}, RecoveryTolerant, st.Set, st.Del, nilLogger)
+	assert.ErrorContains(t, err, "target lsn 1 precedes the snapshot at lsn 2")
+}
@@ -0,0 +1,78 @@
+// Package health tracks readiness of the server.
+// State is package-level, so that storage, endpoints and main can report their progress without knowing about each other
+package health
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrNotReady is wrapped by errors returned from Ready
+var ErrNotReady = errors.New("not ready")
+
+type state struct {
+	recovered    atomic.Bool
+	listening    atomic.Bool
+	shuttingDown atomic.Bool
+	walFailing   atomic.Bool
+}
+
+var st state
+
+// SetRecovered is called once storage is initialized and wal is replayed
+func SetRecovered() {
+	st.recovered.Store(true)
+}
+
+// SetListening is called by network endpoints when they start and stop accepting connections
+func SetListening(v bool) {
+	st.listening.Store(v)
+}
+
+// SetShuttingDown is called once graceful shutdown begins
+func SetShuttingDown() {
+	st.shuttingDown.Store(true)
+}
+
+// SetWalFailing is called by wal after each write with its outcome
+func SetWalFailing(v bool) {
+	st.walFailing.Store(v)
+}
+
+// Ready returns nil if the server is ready to serve requests
+func Ready() error {
+	switch {
+	case st.shuttingDown.Load():
+		return fmt.Errorf("%w: shutting down", ErrNotReady)
+	case !st.recovered.Load():
+		return fmt.Errorf("%w: storage is recovering", ErrNotReady)
+	case !st.listening.Load():
+		return fmt.Errorf("%w: endpoint is not listening", ErrNotReady)
+	case st.walFailing.Load():
+		return fmt.Errorf("%w: wal write failed", ErrNotReady)
+	}
+	return nil
+}
+
+// reset brings the state back to the one of a starting server. Used by tests
+func reset() {
+	st = state{}
+}
+
+// Routes registers /healthz and /readyz on r.
+// /healthz responds 200 as long as the process serves http, /readyz responds 503 until Ready returns nil
+func Routes(r gin.IRoutes) {
+	r.GET("/healthz", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	r.GET("/readyz", func(c *gin.Context) {
+		if err := Ready(); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	})
+}
@@ -0,0 +1,54 @@
+package health
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReady(t *testing.T) {
+	reset()
+	defer reset()
+
+	assert.EqualError(t, Ready(), "not ready: storage is recovering")
+	SetRecovered()
+	assert.EqualError(t, Ready(), "not ready: endpoint is not listening")
+	SetListening(true)
+	assert.NoError(t, Ready())
+
+	SetWalFailing(true)
+	assert.EqualError(t, Ready(), "not ready: wal write failed")
+	SetWalFailing(false)
+	assert.NoError(t, Ready())
+
+	SetShuttingDown()
+	assert.ErrorIs(t, Ready(), ErrNotReady)
+	assert.EqualError(t, Ready(), "not ready: shutting down")
+}
+
+func TestRoutes(t *testing.T) {
+	reset()
+	defer reset()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	Routes(r)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, get("/healthz").Code)
+	w := get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"error": "not ready: storage is recovering"}`, w.Body.String())
+
+	SetRecovered()
+	SetListening(true)
+	w = get("/readyz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status": "ready"}`, w.Body.String())
+}
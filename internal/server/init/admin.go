@@ -0,0 +1,61 @@
+package init
+
+import (
+	"custom-in-memory-db/internal/server/acl"
+	"custom-in-memory-db/internal/server/admin"
+	"custom-in-memory-db/internal/server/auth"
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/slowlog"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Registry inits the registry of INFO sections and runtime settings.
+// Other layers add theirs while being initialized
+func Registry(conf cmd.Config) *admin.Registry {
+	reg := admin.NewRegistry(conf)
+	reg.AddSetting("log_level", logLevelSetting())
+	reg.AddSetting("log_format", logFormatSetting())
+	return reg
+}
+
+// Slowlog inits the log of slow commands and registers slowlog_threshold setting
+func Slowlog(conf cmd.Config, reg *admin.Registry) *slowlog.Log {
+	sl := slowlog.New(conf.Admin.SlowlogThreshold, conf.Admin.SlowlogMaxLen)
+	reg.AddSetting("slowlog_threshold", admin.Setting{
+		Get: func() string { return sl.Threshold().String() },
+		Set: func(value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			if d < 0 {
+				return errors.New("non-negative duration expected")
+			}
+			sl.SetThreshold(d)
+			return nil
+		},
+	})
+	return sl
+}
+
+// Admin inits the server of /metrics and other admin routes. /admin routes are protected by a and l,
+// which are the ones the database uses. Returns nil if ADMIN_ADDRESS is empty
+func Admin(conf cmd.Config, reg *admin.Registry, sl *slowlog.Log, a *auth.Auth, l *acl.ACL, lg *slog.Logger) *admin.Server {
+	if conf.Admin.Address == "" {
+		lg.Info("admin server disabled")
+		return nil
+	}
+
+	srv, err := admin.New(conf.Admin.Address, reg, a, l, lg)
+	if err != nil {
+		lg.Error("admin server init failed", "error", err.Error())
+		os.Exit(errExit)
+	}
+	slowlog.Routes(srv.Routes(), sl)
+	lg.Info("admin server init done", "Address", srv.Addr())
+
+	return srv
+}
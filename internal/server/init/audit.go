@@ -0,0 +1,33 @@
+package init
+
+import (
+	"custom-in-memory-db/internal/server/audit"
+	"custom-in-memory-db/internal/server/cmd"
+	"log/slog"
+	"os"
+)
+
+// Audit opens the audit log. Returns nil if AUDIT_FILE is empty
+func Audit(conf cmd.Config, lg *slog.Logger) *audit.Log {
+	if conf.Audit.LogFile == "" {
+		lg.Info("audit disabled")
+		return nil
+	}
+
+	l, err := audit.New(audit.Config{
+		Path:     conf.Audit.LogFile,
+		Values:   conf.Audit.Values,
+		MaxSize:  int64(conf.Audit.MaxSize) * cmd.KB,
+		MaxFiles: conf.Audit.MaxFiles,
+		MaxAge:   conf.Audit.MaxAge,
+	}, lg)
+	if err != nil {
+		lg.Error("audit init failed", "error", err.Error())
+		os.Exit(errExit)
+	}
+	lg.Info("audit init done")
+	lg.Debug("audit params", "File", conf.Audit.LogFile, "Values", conf.Audit.Values,
+		"MaxSize", conf.Audit.MaxSize, "MaxFiles", conf.Audit.MaxFiles, "MaxAge", conf.Audit.MaxAge)
+
+	return l
+}
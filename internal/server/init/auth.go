@@ -0,0 +1,43 @@
+package init
+
+import (
+	"custom-in-memory-db/internal/server/acl"
+	"custom-in-memory-db/internal/server/auth"
+	"custom-in-memory-db/internal/server/cmd"
+	"log/slog"
+	"os"
+)
+
+// Auth loads credentials. Returns nil if authentication is disabled
+func Auth(conf cmd.Config, lg *slog.Logger) *auth.Auth {
+	a, err := auth.New(conf.Auth.File)
+	if err != nil {
+		lg.Error("auth init failed", "error", err.Error())
+		os.Exit(errExit)
+	}
+	if a == nil {
+		lg.Info("auth disabled")
+		return nil
+	}
+	lg.Info("auth init done")
+	lg.Debug("auth params", "File", conf.Auth.File)
+
+	return a
+}
+
+// Acl loads permissions of users. Returns nil if ACL is disabled
+func Acl(conf cmd.Config, lg *slog.Logger) *acl.ACL {
+	a, err := acl.New(conf.Auth.AclFile)
+	if err != nil {
+		lg.Error("acl init failed", "error", err.Error())
+		os.Exit(errExit)
+	}
+	if a == nil {
+		lg.Info("acl disabled")
+		return nil
+	}
+	lg.Info("acl init done")
+	lg.Debug("acl params", "File", conf.Auth.AclFile)
+
+	return a
+}
@@ -0,0 +1,38 @@
+package init
+
+import (
+	"custom-in-memory-db/internal/server/backup"
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/db/storage"
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"log/slog"
+)
+
+// Backup enables BACKUP for wal storage. Returns nil for other storages
+func Backup(conf cmd.Config, st storage.Storage) *backup.Backup {
+	wl, ok := st.(*wal.Storage)
+	if !ok {
+		return nil
+	}
+	return backup.New(wl, conf.Wal.SegPath)
+}
+
+// Restore rebuilds WAL_SEG_PATH from the backup in from. Returns false if it failed
+func Restore(conf cmd.Config, from string, lg *slog.Logger) bool {
+	var keys *wal.Keys
+	var err error
+	if conf.Wal.KeyFile != "" {
+		if keys, err = wal.LoadKeys(conf.Wal.KeyFile, conf.Wal.KeyAllowPlain); err != nil {
+			lg.Error("restore failed", "from", from, "error", err.Error())
+			return false
+		}
+	}
+	m, err := backup.Restore(from, conf.Wal.SegPath, keys)
+	if err != nil {
+		lg.Error("restore failed", "from", from, "error", err.Error())
+		return false
+	}
+	lg.Info("restore done", "from", from, "wal_seg_path", conf.Wal.SegPath, "lsn", m.LSN,
+		"keys", m.Keys, "records", m.Records, "created", m.Created.String())
+	return true
+}
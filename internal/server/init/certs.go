@@ -0,0 +1,34 @@
+package init
+
+import (
+	"crypto/tls"
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/network/tlsconf"
+	"log/slog"
+	"os"
+)
+
+// Certs loads certificates for network endpoints. Returns nil if TLS is disabled
+func Certs(conf cmd.Config, lg *slog.Logger) *tlsconf.Loader {
+	certs, err := tlsconf.New(conf)
+	if err != nil {
+		lg.Error("tls init failed", "error", err.Error())
+		os.Exit(errExit)
+	}
+	if certs == nil {
+		lg.Info("tls disabled")
+		return nil
+	}
+	lg.Info("tls init done")
+	lg.Debug("tls params", "Cert", conf.Network.TlsCert, "Key", conf.Network.TlsKey,
+		"CA", conf.Network.TlsCA, "ClientAuth", conf.Network.TlsClientAuth)
+
+	return certs
+}
+
+func tlsConfig(certs *tlsconf.Loader) *tls.Config {
+	if certs == nil {
+		return nil
+	}
+	return certs.Config()
+}
@@ -1,47 +1,174 @@
 package init
 
 import (
+	"custom-in-memory-db/internal/server/acl"
+	"custom-in-memory-db/internal/server/admin"
+	"custom-in-memory-db/internal/server/auth"
 	"custom-in-memory-db/internal/server/cmd"
 	"custom-in-memory-db/internal/server/db"
+	"custom-in-memory-db/internal/server/db/storage"
+	"custom-in-memory-db/internal/server/db/storage/wal"
 	"custom-in-memory-db/internal/server/network"
+	"custom-in-memory-db/internal/server/network/tlsconf"
+	"custom-in-memory-db/internal/server/slowlog"
 	"errors"
 	"log/slog"
 	"os"
+	"path"
+	"strconv"
+	"time"
 )
 
 const errExit = 1
 
-func Database(conf cmd.Config, lg *slog.Logger) *db.Database {
+func Database(conf cmd.Config, certs *tlsconf.Loader, reg *admin.Registry, sl *slowlog.Log, a *auth.Auth, l *acl.ACL, lg *slog.Logger) *db.Database {
 
 	st, err := Storage(conf, lg)
 	if err != nil {
-		lg.Error("storage init failed", "error", errors.Unwrap(err).Error())
+		lg.Error("storage init failed", "error", err.Error())
 		os.Exit(errExit)
 	}
+	registerStorage(reg, st)
 
 	comp := Compute(st, lg)
 
 	pr := Parser(lg)
 
-	net := initNetworkEndpoint(conf, lg)
+	cm := network.NewConnMeter(conf.Network.MaxConn)
+	registerConnMeter(reg, cm)
+
+	net := initNetworkEndpoint(conf, certs, a, cm, lg)
 	if net == nil {
 		lg.Error("network init failed: unknown network type")
 		os.Exit(errExit)
 	}
+	registerEndpoint(reg, net)
 
-	database := db.New(comp, net, pr, lg)
+	database := db.New(comp, net, pr, lg, db.WithAuth(a), db.WithACL(l), db.WithAdmin(reg), db.WithSlowlog(sl), db.WithAudit(Audit(conf, lg)), db.WithBackup(Backup(conf, st)))
 	lg.Info("db init done")
 
 	return &database
 }
 
-func initNetworkEndpoint(conf cmd.Config, lg *slog.Logger) network.Endpoint {
+func initNetworkEndpoint(conf cmd.Config, certs *tlsconf.Loader, a *auth.Auth, cm *network.ConnMeter, lg *slog.Logger) network.Endpoint {
 	switch conf.Network.Endpoint {
 	case "tcp":
-		return TcpServer(conf, lg)
+		return TcpServer(conf, tlsConfig(certs), cm, lg)
 	case "http":
-		return HttpServer(conf, lg)
+		return HttpServer(conf, tlsConfig(certs), a, cm, lg)
 	default:
 		return nil
 	}
 }
+
+// registerStorage adds keyspace and wal sections to INFO, wal_batch_max and wal_batch_timeout settings
+func registerStorage(reg *admin.Registry, st storage.Storage) {
+	reg.AddSection("keyspace", func() []admin.Field {
+		stats := st.Stats()
+		return []admin.Field{
+			{Key: "keys", Value: strconv.Itoa(stats.Keys)},
+			{Key: "bytes", Value: strconv.Itoa(stats.Bytes)},
+		}
+	})
+
+	wl, ok := st.(*wal.Storage)
+	if !ok {
+		return
+	}
+	reg.AddSection("wal", func() []admin.Field {
+		segment, offset := wl.Position()
+		recovery := wl.Recovery()
+		// the segment and the offset of the first corrupt record recovery met
+		var firstBad string
+		if sr, ok := recovery.FirstBad(); ok {
+			firstBad = path.Base(sr.Path) + ":" + strconv.FormatInt(sr.FirstBad, 10)
+		}
+		return []admin.Field{
+			{Key: "segment", Value: strconv.Itoa(segment)},
+			{Key: "offset", Value: strconv.FormatInt(offset, 10)},
+			{Key: "lsn", Value: strconv.FormatUint(wl.LSN(), 10)},
+			{Key: "read_only", Value: strconv.FormatBool(wl.ReadOnly())},
+			{Key: "sync_mode", Value: wl.SyncMode()},
+			{Key: "key_id", Value: wl.Keys().Active()},
+			{Key: "recovery_mode", Value: recovery.Mode},
+			{Key: "recovery_applied", Value: strconv.Itoa(recovery.Applied())},
+			{Key: "recovery_skipped", Value: strconv.Itoa(recovery.Skipped())},
+			{Key: "recovery_truncated", Value: strconv.FormatInt(recovery.Truncated, 10)},
+			{Key: "recovery_first_bad", Value: firstBad},
+		}
+	})
+	reg.AddSetting("wal_batch_max", admin.Setting{
+		Get: func() string { return strconv.Itoa(wl.BatchMax()) },
+		Set: func(value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			if n <= 0 {
+				return errors.New("positive number expected")
+			}
+			wl.SetBatchMax(n)
+			return nil
+		},
+	})
+	reg.AddSetting("wal_batch_timeout", admin.Setting{
+		Get: func() string { return wl.BatchTimeout().String() },
+		Set: func(value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			if d < time.Millisecond {
+				return errors.New("min 1ms expected")
+			}
+			wl.SetBatchTimeout(d)
+			return nil
+		},
+	})
+}
+
+// registerConnMeter adds clients section to INFO and net_max_conn setting
+func registerConnMeter(reg *admin.Registry, cm *network.ConnMeter) {
+	reg.AddSection("clients", func() []admin.Field {
+		return []admin.Field{
+			{Key: "connected_clients", Value: strconv.Itoa(cm.Count())},
+			{Key: "max_clients", Value: strconv.Itoa(cm.Max())},
+		}
+	})
+	reg.AddSetting("net_max_conn", admin.Setting{
+		Get: func() string { return strconv.Itoa(cm.Max()) },
+		Set: func(value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			if n < 0 {
+				return errors.New("non-negative number expected")
+			}
+			cm.SetMax(n)
+			return nil
+		},
+	})
+}
+
+// registerEndpoint adds net_timeout setting if the endpoint supports it
+func registerEndpoint(reg *admin.Registry, net network.Endpoint) {
+	ts, ok := net.(network.TimeoutSetter)
+	if !ok {
+		return
+	}
+	reg.AddSetting("net_timeout", admin.Setting{
+		Get: func() string { return ts.Timeout().String() },
+		Set: func(value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			if d < time.Millisecond {
+				return errors.New("min 1ms expected")
+			}
+			ts.SetTimeout(d)
+			return nil
+		},
+	})
+}
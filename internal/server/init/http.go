@@ -1,14 +1,16 @@
 package init
 
 import (
+	"crypto/tls"
+	"custom-in-memory-db/internal/server/auth"
 	"custom-in-memory-db/internal/server/cmd"
 	"custom-in-memory-db/internal/server/network"
 	http2 "custom-in-memory-db/internal/server/network/http"
 	"log/slog"
 )
 
-func HttpServer(conf cmd.Config, lg *slog.Logger) network.Endpoint {
+func HttpServer(conf cmd.Config, tlsConf *tls.Config, a *auth.Auth, cm *network.ConnMeter, lg *slog.Logger) network.Endpoint {
 	http := http2.Server{}
-	http.New(conf, lg)
+	http.New(conf, tlsConf, a, cm, lg)
 	return &http
 }
@@ -1,9 +1,13 @@
 package init
 
 import (
+	"context"
+	"custom-in-memory-db/internal/server/admin"
 	"custom-in-memory-db/internal/server/cmd"
+	"errors"
 	"log/slog"
 	"os"
+	"sync/atomic"
 )
 
 var logLevelMap = map[string]slog.Level{
@@ -13,6 +17,12 @@ var logLevelMap = map[string]slog.Level{
 	"error": 8,
 }
 
+// logLevel is shared by all loggers, so that CONFIG SET log_level takes effect at once
+var logLevel = new(slog.LevelVar)
+
+// logJSON is shared by all loggers the same way logLevel is, CONFIG SET log_format switches it
+var logJSON atomic.Bool
+
 func Logger(conf cmd.Config) *slog.Logger {
 	if validateLoggingConf(conf) {
 		return loggerWithConf(conf)
@@ -36,19 +46,94 @@ func validateLoggingConf(conf cmd.Config) bool {
 }
 
 func loggerWithConf(conf cmd.Config) *slog.Logger {
-	var logLevel = new(slog.LevelVar)
 	logLevel.Set(logLevelMap[conf.Logging.Level])
+	logJSON.Store(conf.Logging.Format == "json")
 
-	if conf.Logging.Format == "text" {
-		return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
-	}
-
-	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+	return slog.New(newFormatHandler())
 }
 
 func defaultLogger() *slog.Logger {
-	var logLevel = new(slog.LevelVar)
 	logLevel.Set(logLevelMap["error"])
+	logJSON.Store(false)
+
+	return slog.New(newFormatHandler())
+}
+
+// formatHandler writes records with text or json handler depending on logJSON.
+// Both handlers get the same attrs and groups, so loggers derived with With keep them after the switch
+type formatHandler struct {
+	text slog.Handler
+	json slog.Handler
+}
+
+func newFormatHandler() formatHandler {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	return formatHandler{
+		text: slog.NewTextHandler(os.Stdout, opts),
+		json: slog.NewJSONHandler(os.Stdout, opts),
+	}
+}
+
+func (h formatHandler) current() slog.Handler {
+	if logJSON.Load() {
+		return h.json
+	}
+	return h.text
+}
+
+func (h formatHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.current().Enabled(ctx, level)
+}
+
+func (h formatHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.current().Handle(ctx, r)
+}
+
+func (h formatHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return formatHandler{text: h.text.WithAttrs(attrs), json: h.json.WithAttrs(attrs)}
+}
 
-	return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+func (h formatHandler) WithGroup(name string) slog.Handler {
+	return formatHandler{text: h.text.WithGroup(name), json: h.json.WithGroup(name)}
+}
+
+func logLevelSetting() admin.Setting {
+	return admin.Setting{
+		Get: func() string {
+			for name, level := range logLevelMap {
+				if level == logLevel.Level() {
+					return name
+				}
+			}
+			return logLevel.Level().String()
+		},
+		Set: func(value string) error {
+			level, ok := logLevelMap[value]
+			if !ok {
+				return errors.New("one of 'debug info warn error' expected")
+			}
+			logLevel.Set(level)
+			return nil
+		},
+	}
+}
+
+func logFormatSetting() admin.Setting {
+	return admin.Setting{
+		Get: func() string {
+			if logJSON.Load() {
+				return "json"
+			}
+			return "text"
+		},
+		Set: func(value string) error {
+			switch value {
+			case "text", "json":
+				logJSON.Store(value == "json")
+				return nil
+			default:
+				return errors.New("one of 'text json' expected")
+			}
+		},
+	}
 }
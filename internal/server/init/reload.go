@@ -0,0 +1,39 @@
+package init
+
+import (
+	"custom-in-memory-db/internal/server/admin"
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/network/tlsconf"
+	"errors"
+	"log/slog"
+)
+
+// Reload re-reads config with the same command line args the server was started with.
+// Changed runtime settings are applied, changes of the others are logged and rejected until restart.
+// TLS certificates are reloaded from the same files. Nothing is applied if the config is invalid
+func Reload(args []string, reg *admin.Registry, certs *tlsconf.Loader, lg *slog.Logger) {
+	conf, _, err := cmd.New(args)
+	if err != nil {
+		lg.Error("config reload failed", "error", errors.Unwrap(err).Error())
+		return
+	}
+
+	for _, c := range reg.Reload(conf) {
+		if c.Err != nil {
+			lg.Warn("config parameter change rejected", "name", c.Name, "value", c.New, "error", c.Err.Error())
+			continue
+		}
+		lg.Info("config parameter changed", "name", c.Name, "old", c.Old, "new", c.New)
+	}
+	lg.Info("config reloaded")
+
+	if certs == nil {
+		return
+	}
+	// rotate certificates
+	if err = certs.Reload(); err != nil {
+		lg.Error("tls certificates reload failed", "error", err.Error())
+		return
+	}
+	lg.Info("tls certificates reloaded")
+}
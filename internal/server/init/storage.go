@@ -5,6 +5,8 @@ import (
 	"custom-in-memory-db/internal/server/db/storage"
 	_map "custom-in-memory-db/internal/server/db/storage/map"
 	"custom-in-memory-db/internal/server/db/storage/wal"
+	"custom-in-memory-db/internal/server/health"
+	"custom-in-memory-db/internal/server/metrics"
 	"fmt"
 	"log/slog"
 )
@@ -25,13 +27,26 @@ func initWalStorage(conf cmd.Config, st storage.Storage, lg *slog.Logger) (stora
 
 func Storage(conf cmd.Config, lg *slog.Logger) (storage.Storage, error) {
 	const suf = "init.Storage()"
+	var st storage.Storage
+	var err error
 	switch conf.Engine.Type {
 	case "map":
-		return initMapStorage(lg)
+		st, err = initMapStorage(lg)
 	case "wal":
-		st, _ := initMapStorage(lg)
-		return initWalStorage(conf, st, lg)
+		st, _ = initMapStorage(lg)
+		st, err = initWalStorage(conf, st, lg)
 	default:
 		return nil, fmt.Errorf("%s failed: unknown engine type %s", suf, conf.Engine.Type)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.RegisterStorage(func() float64 {
+		return float64(st.Stats().Keys)
+	}, func() float64 {
+		return float64(st.Stats().Bytes)
+	})
+	health.SetRecovered()
+	return st, nil
 }
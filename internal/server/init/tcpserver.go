@@ -1,6 +1,7 @@
 package init
 
 import (
+	"crypto/tls"
 	"custom-in-memory-db/internal/server/cmd"
 	"custom-in-memory-db/internal/server/network"
 	"custom-in-memory-db/internal/server/network/tcp"
@@ -10,8 +11,8 @@ import (
 	"strconv"
 )
 
-func TcpServer(conf cmd.Config, lg *slog.Logger) network.Endpoint {
-	srv, err := tcp.New(conf.Network.Host, strconv.Itoa(conf.Network.Port), conf.Network.Timeout, conf.Network.MaxConn, lg)
+func TcpServer(conf cmd.Config, tlsConf *tls.Config, cm *network.ConnMeter, lg *slog.Logger) network.Endpoint {
+	srv, err := tcp.New(conf.Network.Host, strconv.Itoa(conf.Network.Port), conf.Network.Timeout, cm, tlsConf, lg)
 	if err != nil {
 		lg.Error("failed to init tcp server", "error", errors.Unwrap(err).Error())
 		os.Exit(errExit)
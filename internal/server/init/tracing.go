@@ -0,0 +1,39 @@
+package init
+
+import (
+	"context"
+	"custom-in-memory-db/internal/server/admin"
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/tracing"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// flushTimeout limits the time spent exporting remaining spans on shutdown
+const flushTimeout = 5 * time.Second
+
+// Tracing sets up span exporter. The returned func flushes spans and must be called on shutdown
+func Tracing(conf cmd.Config, lg *slog.Logger) func() {
+	shutdown, err := tracing.Init(context.Background(), tracing.Config{
+		Exporter:     conf.Tracing.Exporter,
+		File:         conf.Tracing.TraceFile,
+		OtlpEndpoint: conf.Tracing.OtlpEndpoint,
+		OtlpInsecure: conf.Tracing.OtlpInsecure,
+		SampleRatio:  conf.Tracing.SampleRatio,
+		Version:      admin.Version,
+	})
+	if err != nil {
+		lg.Error("tracing init failed", "error", err.Error())
+		os.Exit(errExit)
+	}
+	lg.Info("tracing init done", "Exporter", conf.Tracing.Exporter)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			lg.Error("tracing shutdown failed", "error", err.Error())
+		}
+	}
+}
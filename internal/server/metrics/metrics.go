@@ -0,0 +1,178 @@
+// Package metrics holds Prometheus collectors of the server.
+// Collectors are package-level, so that any layer can update them without threading them through constructors
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "ramdb"
+
+// Command results
+const (
+	ResultOk    = "ok"
+	ResultError = "error"
+)
+
+// Error stages
+const (
+	StageParse = "parse"
+	StageAuth  = "auth"
+	StageAcl   = "acl"
+	StageExec  = "exec"
+)
+
+// Registry holds all the collectors. The default prometheus registry is not used,
+// so that only ramdb, go and process metrics are exposed
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	Commands = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "Number of executed commands by command and result.",
+	}, []string{"command", "result"})
+
+	CommandDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "command_duration_seconds",
+		Help:      "Command latency by command and result.",
+		Buckets:   prometheus.ExponentialBuckets(0.00005, 4, 10),
+	}, []string{"command", "result"})
+
+	Errors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Number of failed requests by the stage they failed at: parse, auth, acl or exec.",
+	}, []string{"stage"})
+
+	Connections = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connections_active",
+		Help:      "Number of connections being served by endpoint.",
+	}, []string{"endpoint"})
+
+	WalBatchSize = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "wal",
+		Name:      "batch_size_commands",
+		Help:      "Number of commands written to wal at once.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	WalFlushDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "wal",
+		Name:      "flush_duration_seconds",
+		Help:      "Time spent writing a batch to wal including fsync.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+
+	WalFsyncDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "wal",
+		Name:      "fsync_duration_seconds",
+		Help:      "Time spent in fsync of wal segments.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+
+	WalWriteErrors = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "wal",
+		Name:      "write_errors_total",
+		Help:      "Number of batches failed to write to wal.",
+	})
+
+	WalRecoveryDuration = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "wal",
+		Name:      "recovery_duration_seconds",
+		Help:      "Time spent replaying wal on start.",
+	})
+
+	WalCompactions = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "wal",
+		Name:      "compactions_total",
+		Help:      "Number of times closed wal segments were folded into the snapshot.",
+	})
+
+	WalCompactionDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "wal",
+		Name:      "compaction_duration_seconds",
+		Help:      "Time spent folding closed wal segments into the snapshot.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	})
+
+	AuditDropped = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "audit",
+		Name:      "dropped_total",
+		Help:      "Number of audit records dropped because the audit log could not keep up or failed to write.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// RegisterStorage exposes key count and approximate memory of the stored data.
+// Functions are called on each scrape
+func RegisterStorage(keys, bytes func() float64) {
+	register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "keys",
+		Help:      "Number of stored keys.",
+	}, keys))
+	register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "data_bytes",
+		Help:      "Approximate memory used by stored keys and values, map overhead is not included.",
+	}, bytes))
+}
+
+// RegisterSegments exposes number and total size of wal segments.
+// Functions are called on each scrape
+func RegisterSegments(count, bytes func() float64) {
+	register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "wal",
+		Name:      "segments",
+		Help:      "Number of wal segment files.",
+	}, count))
+	register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "wal",
+		Name:      "segments_bytes",
+		Help:      "Total size of wal segment files.",
+	}, bytes))
+}
+
+// register replaces collector registered earlier under the same name
+func register(c prometheus.Collector) {
+	if err := Registry.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			Registry.Unregister(are.ExistingCollector)
+			Registry.MustRegister(c)
+			return
+		}
+		panic(err)
+	}
+}
+
+// Handler serves metrics in Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// scrape returns what Handler serves on /metrics
+func scrape(t *testing.T) string {
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	return string(body)
+}
+
+func TestHandler(t *testing.T) {
+	Connections.WithLabelValues("tcp").Inc()
+	defer Connections.WithLabelValues("tcp").Dec()
+	WalCompactions.Inc()
+
+	body := scrape(t)
+	assert.Contains(t, body, `ramdb_connections_active{endpoint="tcp"} 1`)
+	assert.Contains(t, body, "ramdb_wal_compactions_total 1")
+	// counters without labels are exposed before they are updated
+	assert.Contains(t, body, "ramdb_wal_write_errors_total 0")
+	assert.Contains(t, body, "# TYPE ramdb_wal_flush_duration_seconds histogram")
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func TestRegisterSegments(t *testing.T) {
+	RegisterSegments(func() float64 { return 2 }, func() float64 { return 100 })
+	// registering again replaces the functions instead of panicking
+	RegisterSegments(func() float64 { return 3 }, func() float64 { return 200 })
+
+	body := scrape(t)
+	assert.Contains(t, body, "ramdb_wal_segments 3")
+	assert.Contains(t, body, "ramdb_wal_segments_bytes 200")
+	assert.NotContains(t, body, "ramdb_wal_segments 2")
+}
@@ -0,0 +1,65 @@
+package network
+
+import "sync"
+
+// ConnMeter limits the number of commands and http requests served at once.
+// The limit can be changed at runtime, zero means no limit
+type ConnMeter struct {
+	currConn int
+	maxConn  int
+	mtx      sync.Mutex
+	cond     *sync.Cond
+}
+
+func NewConnMeter(maxConn int) *ConnMeter {
+	c := ConnMeter{maxConn: maxConn}
+	c.cond = sync.NewCond(&c.mtx)
+	return &c
+}
+
+// Inc only allows further execution if the number of commands being served is less than maxConn
+func (c *ConnMeter) Inc() {
+	c.cond.L.Lock()
+	defer c.cond.L.Unlock()
+
+	// maxConn might have been lowered below currConn
+	for c.maxConn > 0 && c.currConn >= c.maxConn {
+		c.cond.Wait()
+	}
+
+	c.currConn++
+}
+
+// Dec decrements the number of commands being served and wakes up a waiting one
+func (c *ConnMeter) Dec() {
+	c.cond.L.Lock()
+	defer c.cond.L.Unlock()
+
+	c.currConn--
+	c.cond.Signal()
+}
+
+// SetMax changes the limit. Connections being served are not interrupted if it is lowered
+func (c *ConnMeter) SetMax(maxConn int) {
+	c.cond.L.Lock()
+	defer c.cond.L.Unlock()
+
+	c.maxConn = maxConn
+	c.cond.Broadcast()
+}
+
+// Max returns the current limit
+func (c *ConnMeter) Max() int {
+	c.cond.L.Lock()
+	defer c.cond.L.Unlock()
+
+	return c.maxConn
+}
+
+// Count returns the number of commands and http requests being served
+func (c *ConnMeter) Count() int {
+	c.cond.L.Lock()
+	defer c.cond.L.Unlock()
+
+	return c.currConn
+}
@@ -0,0 +1,49 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const goMax = 100
+
+func TestConnMeter_New(t *testing.T) {
+	cm := NewConnMeter(goMax)
+
+	assert.Equal(t, goMax, cm.Max())
+	assert.NotEqual(t, nil, cm.cond)
+}
+
+func TestConnMeter_Unlimited(t *testing.T) {
+	cm := NewConnMeter(0)
+	for range goMax {
+		cm.Inc()
+	}
+	assert.Equal(t, goMax, cm.Count())
+}
+
+func TestConnMeter_SetMax(t *testing.T) {
+	cm := NewConnMeter(1)
+	cm.Inc()
+
+	done := make(chan struct{})
+	go func() {
+		cm.Inc()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Inc must block while the limit is reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cm.SetMax(2)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Inc must proceed once the limit is raised")
+	}
+	assert.Equal(t, 2, cm.Count())
+}
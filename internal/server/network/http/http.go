@@ -2,16 +2,30 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
+	"custom-in-memory-db/internal/server/acl"
+	"custom-in-memory-db/internal/server/auth"
+	"custom-in-memory-db/internal/server/backup"
 	"custom-in-memory-db/internal/server/cmd"
 	"custom-in-memory-db/internal/server/db/storage/wal"
+	"custom-in-memory-db/internal/server/health"
+	"custom-in-memory-db/internal/server/metrics"
 	"custom-in-memory-db/internal/server/network"
+	"custom-in-memory-db/internal/server/tracing"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
 	"log/slog"
+	"maps"
+	"net"
 	"net/http"
-	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,106 +34,235 @@ type payload struct {
 	Value string `json:"Value"`
 }
 
+// setting is the body of PUT /admin/config
+type setting struct {
+	Name  string `json:"name" binding:"required"`
+	Value string `json:"value" binding:"required"`
+}
+
+// backupDir is the body of POST /admin/backup, dir is a folder on the server
+type backupDir struct {
+	Dir string `json:"dir" binding:"required"`
+}
+
 type errMsg struct {
 	Error string `json:"error"`
 }
 
 type Server struct {
-	timeout time.Duration
+	timeout atomic.Int64
 	addr    string
 
 	lg     *slog.Logger
 	router *gin.Engine
+	// cmd groups routes behind the connection limiter and authentication
+	cmd    *gin.RouterGroup
 	server *http.Server
 }
 
-func (s *Server) New(conf cmd.Config, lg *slog.Logger) {
+// New initializes Server. Server uses TLS if tlsConf is not nil.
+// Requests must carry a bearer token if a is not nil. cm limits the number of requests served at once
+func (s *Server) New(conf cmd.Config, tlsConf *tls.Config, a *auth.Auth, cm *network.ConnMeter, lg *slog.Logger) {
 	s.addr = strings.Join([]string{conf.Network.Host, strconv.Itoa(conf.Network.Port)}, ":")
-	s.timeout = conf.Network.Timeout
+	s.timeout.Store(int64(conf.Network.Timeout))
 
 	s.lg = lg
-	s.initGin(conf)
+	s.initGin(cm, a)
 
 	s.server = &http.Server{
 		Addr:         s.addr,
 		Handler:      s.router,
-		ReadTimeout:  s.timeout,
-		WriteTimeout: s.timeout,
-		IdleTimeout:  s.timeout,
+		ReadTimeout:  conf.Network.Timeout,
+		WriteTimeout: conf.Network.Timeout,
+		IdleTimeout:  conf.Network.Timeout,
+		TLSConfig:    tlsConf,
 	}
 }
 
+// Timeout returns the current NET_TIMEOUT
+func (s *Server) Timeout() time.Duration {
+	return time.Duration(s.timeout.Load())
+}
+
+// SetTimeout changes NET_TIMEOUT at runtime. It applies to reading and writing of the next requests,
+// idle connections keep the timeout the server was started with
+func (s *Server) SetTimeout(d time.Duration) {
+	s.timeout.Store(int64(d))
+}
+
 func (s *Server) Close() error {
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout())
 	defer cancel()
 	return s.server.Shutdown(ctx)
 }
 
+// Shutdown stops accepting connections and waits for requests in flight until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
 func (s *Server) Listen(f network.Handler) {
 	s.initHandlers(f)
-	_ = s.server.ListenAndServe()
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		s.lg.Error("http server listen failed", "error", err.Error())
+		return
+	}
+	health.SetListening(true)
+	defer health.SetListening(false)
+
+	if s.server.TLSConfig != nil {
+		// certificates are provided by TLSConfig
+		_ = s.server.ServeTLS(listener, "", "")
+		return
+	}
+	_ = s.server.Serve(listener)
 }
 
-func (s *Server) initGin(conf cmd.Config) {
+func (s *Server) initGin(cm *network.ConnMeter, a *auth.Auth) {
 	s.router = gin.New()
-	s.router.Use(gin.Recovery())
-	s.router.Use(clientConnLimiter(conf))
+	s.router.Use(gin.Recovery(), s.deadline)
 	_ = s.router.SetTrustedProxies(nil)
+	// probes must respond even when all the connections are busy or the caller has no token
+	health.Routes(s.router)
+
+	s.cmd = s.router.Group("", clientConnLimiter(cm), clientInfo, traceRequest)
+	if a != nil {
+		s.cmd.Use(s.bearerAuth(a))
+	}
+}
+
+// deadline applies the current NET_TIMEOUT to the request, since http.Server timeouts can not be changed once it serves
+func (s *Server) deadline(c *gin.Context) {
+	t := time.Now().Add(s.Timeout())
+	rc := http.NewResponseController(c.Writer)
+	// not supported by test recorders
+	_ = rc.SetReadDeadline(t)
+	_ = rc.SetWriteDeadline(t)
+	c.Next()
+}
+
+// clientInfo attaches network.Client to the request context
+func clientInfo(c *gin.Context) {
+	client := network.Client{ID: uuid.New().String(), Addr: c.ClientIP()}
+	c.Request = c.Request.WithContext(network.WithClient(c.Request.Context(), &client))
+	c.Next()
+}
+
+// traceRequest starts the request span. It continues the trace of W3C traceparent header if the client sent one
+func traceRequest(c *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	client := network.ClientFrom(ctx)
+	ctx, span := tracing.Start(ctx, c.Request.Method+" "+c.FullPath(),
+		attribute.String("http.request.method", c.Request.Method),
+		attribute.String("http.route", c.FullPath()),
+		attribute.String("client.address", client.Addr),
+		attribute.String("ramdb.client.id", client.ID),
+	)
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+
+	span.SetAttributes(attribute.Int("http.response.status_code", c.Writer.Status()))
+	if c.Writer.Status() >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(c.Writer.Status()))
+	}
+	span.End()
+}
+
+// bearerAuth rejects requests without a valid "Authorization: Bearer <token>" header
+func (s *Server) bearerAuth(a *auth.Auth) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		client := network.ClientFrom(c.Request.Context())
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			s.lg.Warn("authentication failed", "ID", client.ID, "ClientIP", client.Addr, "error", auth.ErrAuthRequired.Error())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errMsg{auth.ErrAuthRequired.Error()})
+			return
+		}
+
+		user, err := a.Token(token)
+		if err != nil {
+			s.lg.Warn("authentication failed", "ID", client.ID, "ClientIP", client.Addr, "error", err.Error())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errMsg{err.Error()})
+			return
+		}
+		client.User = user
+		c.Next()
+	}
 }
 
 // clientConnLimiter limits the number of goroutines actually doing the job.
 // Neither gin nor http.Server allows to prevent goroutines from spawning, but we can hold them.
-func clientConnLimiter(conf cmd.Config) func(c *gin.Context) {
-	limiter := make(chan struct{}, conf.Network.MaxConn)
+func clientConnLimiter(cm *network.ConnMeter) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		for {
-			select {
-			case limiter <- struct{}{}:
-				c.Next()
-				<-limiter
-				return
-			default:
-				runtime.Gosched()
-			}
-		}
+		cm.Inc()
+		defer cm.Dec()
+		metrics.Connections.WithLabelValues("http").Inc()
+		defer metrics.Connections.WithLabelValues("http").Dec()
+		c.Next()
 	}
 }
 
 func (s *Server) initHandlers(clientHandler network.Handler) {
 	s.cmdHandlers(clientHandler)
+	s.adminHandlers(clientHandler)
 }
 
 // connLog inits logger for each request
 func (s *Server) connLog(c *gin.Context) *slog.Logger {
-	uid := uuid.New()
-	lg := s.lg.With("ID", uid,
-		"ClientIP", c.ClientIP(),
+	client := network.ClientFrom(c.Request.Context())
+	headers := c.Request.Header
+	if headers.Get("Authorization") != "" {
+		headers = maps.Clone(headers)
+		headers.Set("Authorization", "<redacted>")
+	}
+	lg := s.lg.With("ID", client.ID,
+		"ClientIP", client.Addr,
+		"User", client.User,
 		"Method", c.Request.Method,
 		"Path", c.Request.URL.Path,
 		"Proto", c.Request.Proto,
-		"Headers", c.Request.Header,
+		"Headers", headers,
 	)
 	lg.Info("connection accepted")
 	return lg
 }
 
-// cmdHandlers inits handlers for the /cmd path
-func (s *Server) cmdHandlers(clientHandler network.Handler) {
-	isError := func(c *gin.Context, err error) bool {
-		if err != nil {
-			if err == wal.ErrWalWriteFailed {
-				c.JSON(http.StatusInternalServerError, errMsg{err.Error()})
-				return true
-			}
-			c.JSON(http.StatusBadRequest, errMsg{err.Error()})
+// isError replies with the status matching err. Returns false if err is nil
+func isError(c *gin.Context, err error) bool {
+	if err != nil {
+		if errors.Is(err, wal.ErrWalWriteFailed) {
+			c.JSON(http.StatusInternalServerError, errMsg{err.Error()})
+			return true
+		}
+		// the server is shutting down, another one may take the request
+		if errors.Is(err, wal.ErrClosed) {
+			c.JSON(http.StatusServiceUnavailable, errMsg{err.Error()})
+			return true
+		}
+		if errors.Is(err, auth.ErrAuthRequired) || errors.Is(err, auth.ErrAuthFailed) {
+			c.JSON(http.StatusUnauthorized, errMsg{err.Error()})
+			return true
+		}
+		if errors.Is(err, acl.ErrPermissionDenied) {
+			c.JSON(http.StatusForbidden, errMsg{err.Error()})
+			return true
+		}
+		if errors.Is(err, wal.ErrReadOnly) || errors.Is(err, backup.ErrRunning) {
+			c.JSON(http.StatusConflict, errMsg{err.Error()})
 			return true
 		}
-		return false
+		c.JSON(http.StatusBadRequest, errMsg{err.Error()})
+		return true
 	}
+	return false
+}
 
-	s.router.GET("/cmd/:key", func(c *gin.Context) {
+// cmdHandlers inits handlers for the /cmd path
+func (s *Server) cmdHandlers(clientHandler network.Handler) {
+	s.cmd.GET("/cmd/:key", func(c *gin.Context) {
 		key := c.Param("key")
-		result, err := clientHandler(strings.NewReader(strings.Join([]string{"GET", key, "\n"}, " ")), s.connLog(c))
+		result, err := clientHandler(c.Request.Context(), strings.NewReader(strings.Join([]string{"GET", key, "\n"}, " ")), s.connLog(c))
 		if isError(c, err) {
 			return
 		}
@@ -128,26 +271,120 @@ func (s *Server) cmdHandlers(clientHandler network.Handler) {
 			Value: result,
 		})
 	})
-	s.router.DELETE("/cmd/:key", func(c *gin.Context) {
+	s.cmd.DELETE("/cmd/:key", func(c *gin.Context) {
 		key := c.Param("key")
-		_, err := clientHandler(strings.NewReader(strings.Join([]string{"DEL", key, "\n"}, " ")), s.connLog(c))
+		_, err := clientHandler(c.Request.Context(), strings.NewReader(strings.Join([]string{"DEL", key, "\n"}, " ")), s.connLog(c))
 		if isError(c, err) {
 			return
 		}
 		c.Status(http.StatusOK)
 	})
 
+	s.cmd.GET("/cmd", func(c *gin.Context) {
+		result, err := clientHandler(c.Request.Context(), strings.NewReader("DUMP\n"), s.connLog(c))
+		if isError(c, err) {
+			return
+		}
+		c.JSON(http.StatusOK, dumpToPayload(result))
+	})
+
 	f := func(c *gin.Context) {
 		var body payload
 		err := c.BindJSON(&body)
 		if err == nil {
-			_, err = clientHandler(strings.NewReader(strings.Join([]string{"SET", body.Key, body.Value, "\n"}, " ")), s.connLog(c))
+			_, err = clientHandler(c.Request.Context(), strings.NewReader(strings.Join([]string{"SET", body.Key, body.Value, "\n"}, " ")), s.connLog(c))
 			if isError(c, err) {
 				return
 			}
 			c.Status(http.StatusOK)
 		}
 	}
-	s.router.POST("/cmd", f)
-	s.router.PUT("/cmd", f)
+	s.cmd.POST("/cmd", f)
+	s.cmd.PUT("/cmd", f)
+}
+
+// adminHandlers inits handlers for the /admin path. They are executed as INFO, CONFIG and BACKUP commands,
+// so unlike the same routes of the admin server they are subject to auth and acl and allow changing settings
+func (s *Server) adminHandlers(clientHandler network.Handler) {
+	s.cmd.GET("/admin/info", func(c *gin.Context) {
+		result, err := clientHandler(c.Request.Context(), strings.NewReader(strings.Join([]string{"INFO", c.Query("section"), "\n"}, " ")), s.connLog(c))
+		if isError(c, err) {
+			return
+		}
+		c.JSON(http.StatusOK, infoToMap(result))
+	})
+	s.cmd.GET("/admin/config", func(c *gin.Context) {
+		result, err := clientHandler(c.Request.Context(), strings.NewReader("CONFIG GET *\n"), s.connLog(c))
+		if isError(c, err) {
+			return
+		}
+		c.JSON(http.StatusOK, fieldsToMap(result))
+	})
+	s.cmd.PUT("/admin/config", func(c *gin.Context) {
+		var body setting
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, errMsg{err.Error()})
+			return
+		}
+		_, err := clientHandler(c.Request.Context(), strings.NewReader(strings.Join([]string{"CONFIG SET", body.Name, body.Value, "\n"}, " ")), s.connLog(c))
+		if isError(c, err) {
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	s.cmd.POST("/admin/backup", func(c *gin.Context) {
+		var body backupDir
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, errMsg{err.Error()})
+			return
+		}
+		result, err := clientHandler(c.Request.Context(), strings.NewReader(strings.Join([]string{"BACKUP", body.Dir, "\n"}, " ")), s.connLog(c))
+		if isError(c, err) {
+			return
+		}
+		c.JSON(http.StatusOK, fieldsToMap(result))
+	})
+}
+
+// infoToMap converts "# section" headers and "key:value" lines returned by INFO to {"section": {"key": "value"}}
+func infoToMap(info string) map[string]map[string]string {
+	result := make(map[string]map[string]string)
+	var section map[string]string
+	for _, line := range strings.Split(info, "\n") {
+		if name, ok := strings.CutPrefix(line, "# "); ok {
+			section = make(map[string]string)
+			result[name] = section
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || section == nil {
+			continue
+		}
+		section[key] = value
+	}
+	return result
+}
+
+// fieldsToMap converts "key:value" lines returned by CONFIG GET to {"key": "value"}
+func fieldsToMap(fields string) map[string]string {
+	result := make(map[string]string)
+	for _, line := range strings.Split(fields, "\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// dumpToPayload converts "key value" lines returned by DUMP to the list of payloads
+func dumpToPayload(dump string) []payload {
+	result := make([]payload, 0)
+	for _, line := range strings.Split(dump, "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		result = append(result, payload{Key: key, Value: value})
+	}
+	return result
 }
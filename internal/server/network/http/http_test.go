@@ -0,0 +1,184 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"custom-in-memory-db/internal/server/acl"
+	"custom-in-memory-db/internal/server/auth"
+	"custom-in-memory-db/internal/server/backup"
+	"custom-in-memory-db/internal/server/cmd"
+	"custom-in-memory-db/internal/server/db/parser"
+	"custom-in-memory-db/internal/server/db/storage/wal"
+	"custom-in-memory-db/internal/server/health"
+	"custom-in-memory-db/internal/server/network"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var nilLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+const (
+	adminToken     = "admin-token"
+	reportingToken = "reporting-token"
+)
+
+// errKeys are keys the handler fails commands with
+var errKeys = map[string]error{
+	"failed":   wal.ErrWalWriteFailed,
+	"closed":   wal.ErrClosed,
+	"readonly": wal.ErrReadOnly,
+	"missing":  errors.New("key missing not found"),
+}
+
+// handler checks acl like the database does and answers commands with canned results or errors of errKeys
+func handler(l *acl.ACL) network.Handler {
+	pr := parser.New()
+	return func(ctx context.Context, r io.Reader, lg *slog.Logger) (string, error) {
+		c, err := pr.Read(r, lg)
+		if err != nil {
+			return "", err
+		}
+		user := acl.DefaultUser
+		if client := network.ClientFrom(ctx); client != nil && client.User != "" {
+			user = client.User
+		}
+		if err = l.Check(user, c); err != nil {
+			return "", err
+		}
+		if err = errKeys[c.Arg1]; err != nil {
+			if c.Command == "DEL" {
+				return "", fmt.Errorf("error deleting value: %w", err)
+			}
+			return "", err
+		}
+		switch c.Command {
+		case "GET":
+			return "value", nil
+		case "INFO":
+			return "# wal\nlsn:7\n", nil
+		case "CONFIG":
+			return "log_level:info\n", nil
+		case "BACKUP":
+			return "", backup.ErrRunning
+		}
+		return "OK", nil
+	}
+}
+
+// newTestServer serves the http endpoint with users admin and reporting authenticated by their tokens
+func newTestServer(t *testing.T) *httptest.Server {
+	dir := t.TempDir()
+	var users strings.Builder
+	users.WriteString("users:\n")
+	for user, token := range map[string]string{"admin": adminToken, "reporting": reportingToken} {
+		sum := sha256.Sum256([]byte(token))
+		fmt.Fprintf(&users, "  - name: %s\n    tokens:\n      - '%s'\n", user, hex.EncodeToString(sum[:]))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "users.yaml"), []byte(users.String()), 0600))
+	a, err := auth.New(filepath.Join(dir, "users.yaml"))
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "acl.json"), []byte(`{"users": [
+		{"name": "admin", "commands": ["read", "write", "admin"], "keys": ["*"]},
+		{"name": "reporting", "commands": ["read"], "keys": ["*"]}
+	]}`), 0600))
+	l, err := acl.New(filepath.Join(dir, "acl.json"))
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	var conf cmd.Config
+	conf.Network.Timeout = time.Second
+	var s Server
+	s.New(conf, nil, a, network.NewConnMeter(10), nilLogger)
+	s.initHandlers(handler(l))
+	srv := httptest.NewServer(s.router)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestServer_Statuses(t *testing.T) {
+	srv := newTestServer(t)
+	health.SetRecovered()
+	health.SetListening(true)
+	defer health.SetListening(false)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		// auth is the Authorization header
+		auth   string
+		status int
+		resp   string
+	}{
+		{name: "no token", method: http.MethodGet, path: "/cmd/a", status: http.StatusUnauthorized,
+			resp: `{"error":"authentication required"}`},
+		{name: "empty token", method: http.MethodGet, path: "/cmd/a", auth: "Bearer ", status: http.StatusUnauthorized,
+			resp: `{"error":"authentication required"}`},
+		{name: "not bearer", method: http.MethodGet, path: "/cmd/a", auth: "Basic " + adminToken, status: http.StatusUnauthorized,
+			resp: `{"error":"authentication required"}`},
+		{name: "wrong token", method: http.MethodGet, path: "/cmd/a", auth: "Bearer bogus", status: http.StatusUnauthorized,
+			resp: `{"error":"authentication failed"}`},
+		{name: "admin without token", method: http.MethodGet, path: "/admin/config", status: http.StatusUnauthorized,
+			resp: `{"error":"authentication required"}`},
+		{name: "readyz bypasses auth", method: http.MethodGet, path: "/readyz", status: http.StatusOK},
+		{name: "healthz bypasses auth", method: http.MethodGet, path: "/healthz", status: http.StatusOK},
+		{name: "get", method: http.MethodGet, path: "/cmd/a", auth: "Bearer " + reportingToken, status: http.StatusOK,
+			resp: `{"Key":"a","Value":"value"}`},
+		{name: "write denied", method: http.MethodDelete, path: "/cmd/a", auth: "Bearer " + reportingToken, status: http.StatusForbidden,
+			resp: `{"error":"permission denied"}`},
+		{name: "admin config denied", method: http.MethodGet, path: "/admin/config", auth: "Bearer " + reportingToken,
+			status: http.StatusForbidden, resp: `{"error":"permission denied"}`},
+		{name: "admin config", method: http.MethodGet, path: "/admin/config", auth: "Bearer " + adminToken, status: http.StatusOK,
+			resp: `{"log_level":"info"}`},
+		{name: "admin info", method: http.MethodGet, path: "/admin/info?section=wal", auth: "Bearer " + adminToken, status: http.StatusOK,
+			resp: `{"wal":{"lsn":"7"}}`},
+		{name: "bad request", method: http.MethodGet, path: "/cmd/missing", auth: "Bearer " + adminToken, status: http.StatusBadRequest,
+			resp: `{"error":"key missing not found"}`},
+		{name: "bad body", method: http.MethodPut, path: "/admin/config", body: `{"name":"log_level"}`, auth: "Bearer " + adminToken,
+			status: http.StatusBadRequest},
+		{name: "wal write failed", method: http.MethodGet, path: "/cmd/failed", auth: "Bearer " + adminToken,
+			status: http.StatusInternalServerError, resp: `{"error":"wal write failed"}`},
+		{name: "wal closed", method: http.MethodPut, path: "/cmd", body: `{"Key":"closed","Value":"1"}`, auth: "Bearer " + adminToken,
+			status: http.StatusServiceUnavailable, resp: `{"error":"wal closed"}`},
+		{name: "wal closed wrapped", method: http.MethodDelete, path: "/cmd/closed", auth: "Bearer " + adminToken,
+			status: http.StatusServiceUnavailable, resp: `{"error":"error deleting value: wal closed"}`},
+		{name: "read only", method: http.MethodDelete, path: "/cmd/readonly", auth: "Bearer " + adminToken,
+			status: http.StatusConflict},
+		{name: "backup running", method: http.MethodPost, path: "/admin/backup", body: `{"dir":"/tmp/b"}`, auth: "Bearer " + adminToken,
+			status: http.StatusConflict, resp: `{"error":"another backup is in progress"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, srv.URL+test.path, strings.NewReader(test.body))
+			require.NoError(t, err)
+			if test.auth != "" {
+				req.Header.Set("Authorization", test.auth)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, test.status, resp.StatusCode, string(body))
+			if test.resp != "" {
+				assert.JSONEq(t, test.resp, string(body))
+			}
+		})
+	}
+}
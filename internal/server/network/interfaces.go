@@ -1,13 +1,49 @@
 package network
 
 import (
+	"context"
 	"io"
 	"log/slog"
+	"time"
 )
 
-type Handler func(r io.Reader, lg *slog.Logger) (string, error)
+type Handler func(ctx context.Context, r io.Reader, lg *slog.Logger) (string, error)
 
 type Endpoint interface {
 	Listen(f Handler)
 	Close() error
 }
+
+// Shutdowner is implemented by endpoints which let requests in flight finish before they close
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// TimeoutSetter is implemented by endpoints whose NET_TIMEOUT can be changed at runtime
+type TimeoutSetter interface {
+	Timeout() time.Duration
+	SetTimeout(d time.Duration)
+}
+
+// Client describes the connection a request came from.
+// It lives as long as the connection does
+type Client struct {
+	// ID is the same as the one connection logger uses
+	ID   string
+	Addr string
+	// User is set after successful authentication
+	User string
+}
+
+type clientKey struct{}
+
+// WithClient returns ctx carrying c
+func WithClient(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// ClientFrom returns Client stored in ctx or nil if there is none
+func ClientFrom(ctx context.Context) *Client {
+	c, _ := ctx.Value(clientKey{}).(*Client)
+	return c
+}
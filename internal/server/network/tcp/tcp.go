@@ -1,13 +1,23 @@
 package tcp
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"custom-in-memory-db/internal/server/auth"
+	"custom-in-memory-db/internal/server/health"
+	"custom-in-memory-db/internal/server/metrics"
 	"custom-in-memory-db/internal/server/network"
+	"custom-in-memory-db/internal/server/tracing"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 	"log/slog"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,114 +25,223 @@ import (
 // The network must be "tcp", "tcp4", "tcp6", "unix" or "unixpacket".
 const listenNetwork = "tcp4"
 
+// endpoint labels connection metrics
+const endpoint = "tcp"
+
+// Every response starts with a status line: statusOk followed by the result lines,
+// or statusErr followed by the error message. Result lines are never taken for the status
+const (
+	statusOk  = "OK"
+	statusErr = "ERR"
+)
+
 type Server struct {
 	listener net.Listener
-	deadline time.Duration
-	maxConn  int
+	deadline atomic.Int64
+	cm       *network.ConnMeter
 	lg       *slog.Logger
-}
 
-func (c *connMeter) new(maxConn int) {
-	c.maxConn = maxConn
-	c.cond = sync.NewCond(&c.mtx)
+	// connections being served, so that Shutdown can close them
+	mtx     sync.Mutex
+	conns   map[net.Conn]struct{}
+	closing bool
+	wg      sync.WaitGroup
 }
 
-func New(host, port string, deadline time.Duration, maxConn int, lg *slog.Logger) (network.Endpoint, error) {
+// New starts listening on host:port. Connections are wrapped with TLS if tlsConf is not nil.
+// cm limits the number of commands served at once, idle connections do not count
+func New(host, port string, deadline time.Duration, cm *network.ConnMeter, tlsConf *tls.Config, lg *slog.Logger) (network.Endpoint, error) {
 	const suf = "TcpServer.New()"
 	var err error
-	s := Server{}
+	s := &Server{}
 	address := strings.Join([]string{host, port}, ":")
 	s.listener, err = net.Listen(listenNetwork, address)
 	if err != nil {
 		return nil, fmt.Errorf("%s failed: %w", suf, err)
 	}
-	s.deadline = deadline
-	s.maxConn = maxConn
+	if tlsConf != nil {
+		s.listener = tls.NewListener(s.listener, tlsConf)
+	}
+	s.deadline.Store(int64(deadline))
+	s.cm = cm
 	s.lg = lg
+	s.conns = make(map[net.Conn]struct{})
+
+	return s, nil
+}
 
-	return &s, nil
+// Timeout returns the current NET_TIMEOUT
+func (s *Server) Timeout() time.Duration {
+	return time.Duration(s.deadline.Load())
+}
+
+// SetTimeout changes NET_TIMEOUT at runtime. It applies to the next command of each connection
+func (s *Server) SetTimeout(d time.Duration) {
+	s.deadline.Store(int64(d))
 }
 
 func (s *Server) Close() error {
 	return s.listener.Close()
 }
 
+// Shutdown stops accepting connections and closes the idle ones. Commands in flight are answered
+// before their connections are closed. Connections still open when ctx is done are closed at once
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mtx.Lock()
+	s.closing = true
+	err := s.listener.Close()
+	for conn := range s.conns {
+		// wakes up connections waiting for the next command, the ones in flight have read theirs already
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	s.mtx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.mtx.Lock()
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		s.mtx.Unlock()
+		return errors.Join(err, ctx.Err())
+	}
+}
+
+// track adds conn to the ones Shutdown waits for. Returns false if the server is shutting down
+func (s *Server) track(conn net.Conn) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closing {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	delete(s.conns, conn)
+	s.wg.Done()
+}
+
+// arm sets the deadline of the next command of conn. Returns false if the server is shutting down,
+// the check and the deadline are atomic, so that Shutdown does not miss a connection about to wait
+func (s *Server) arm(conn net.Conn) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closing {
+		return false, nil
+	}
+	return true, conn.SetDeadline(time.Now().Add(s.Timeout()))
+}
+
 func (s *Server) Listen(f network.Handler) {
 	var msg string
-	var cm connMeter
-	if s.maxConn > 0 {
-		cm.new(s.maxConn)
-	}
 
+	// the listener is bound in New already
+	health.SetListening(true)
 	for {
 		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			health.SetListening(false)
+			return
+		}
 		if err != nil {
 			msg = "cannot accept connection"
 			s.lg.Error(msg, "error", err.Error())
+			continue
 		}
 
-		cm.incConnCount()
-		go s.handleClient(conn, &cm, f, s.lg)
+		if !s.track(conn) {
+			_ = conn.Close()
+			continue
+		}
+		go s.handleClient(conn, f, s.lg)
 	}
 }
 
-func (s *Server) handleClient(conn net.Conn, cm *connMeter, handler network.Handler, lg *slog.Logger) {
+// handleClient serves commands sent over conn one by one until the client closes its side
+// or stays idle longer than the deadline. A command waits for cm once it has arrived, the same way
+// http requests do, so idle connections never hold back the others.
+// Each response starts with a status line and is followed by an empty line, so that clients know where it ends
+func (s *Server) handleClient(conn net.Conn, handler network.Handler, lg *slog.Logger) {
 	const suf = "server.handleClient()"
-	defer cm.decConnCount()
+	defer s.untrack(conn)
 	defer conn.Close()
+	metrics.Connections.WithLabelValues(endpoint).Inc()
+	defer metrics.Connections.WithLabelValues(endpoint).Dec()
 
-	ilg := lg.With("ID", uuid.New(), "remoteAddr", conn.RemoteAddr().String())
-
-	err := conn.SetDeadline(time.Now().Add(s.deadline))
-	// how to unit-test this????
-	if err != nil {
-		ilg.Error(fmt.Sprintf("%s.SetDeadline()", suf), "error", err.Error())
-	}
+	uid := uuid.New()
+	ilg := lg.With("ID", uid, "remoteAddr", conn.RemoteAddr().String())
+	ctx := network.WithClient(context.Background(), &network.Client{ID: uid.String(), Addr: conn.RemoteAddr().String()})
 
 	ilg.Debug(fmt.Sprintf("%s conn opened", suf))
 
-	result, err := handler(conn, ilg)
-	ilg.Debug(fmt.Sprintf("%s", suf), "handlerResult", result)
-	if err != nil {
-		_, err = conn.Write([]byte(err.Error()))
-		if err != nil {
-			ilg.Error(fmt.Sprintf("%s.conn.Write()", suf), "error", err.Error())
+	// handler reads exactly one line, the rest stays buffered for the next command
+	r := bufio.NewReader(conn)
+	for {
+		ok, err := s.arm(conn)
+		if !ok {
+			ilg.Debug(fmt.Sprintf("%s conn closed", suf), "reason", "shutdown")
 			return
 		}
-		return
-	}
-
-	_, err = conn.Write([]byte(result))
-	if err != nil {
-		ilg.Error(fmt.Sprintf("%s.conn.Write()", suf), "error", err.Error())
-	}
-	ilg.Debug(fmt.Sprintf("%s", suf), "respondedToClient", "done")
-}
+		// how to unit-test this????
+		if err != nil {
+			ilg.Error(fmt.Sprintf("%s.SetDeadline()", suf), "error", err.Error())
+		}
 
-type connMeter struct {
-	currConn int
-	maxConn  int
-	mtx      sync.Mutex
-	cond     *sync.Cond
-}
+		if _, err = r.Peek(1); err != nil {
+			ilg.Debug(fmt.Sprintf("%s conn closed", suf), "reason", err.Error())
+			return
+		}
 
-// incConnCount only allows further execution if maxConn is less than goMax
-func (c *connMeter) incConnCount() {
-	c.cond.L.Lock()
-	defer c.cond.L.Unlock()
+		s.cm.Inc()
+		// time spent waiting for cm does not count against the command. Shutdown waits for it anyway,
+		// the connection is closed by arm once the command is answered
+		if err = conn.SetDeadline(time.Now().Add(s.Timeout())); err != nil {
+			ilg.Error(fmt.Sprintf("%s.SetDeadline()", suf), "error", err.Error())
+		}
+		// each command is a separate trace, tcp has no way to carry the client's trace context
+		cmdCtx, span := tracing.Start(ctx, "tcp.command",
+			attribute.String("client.address", conn.RemoteAddr().String()), attribute.String("ramdb.client.id", uid.String()))
+		result, err := handler(cmdCtx, r, ilg)
+		s.cm.Dec()
+		ilg.Debug(fmt.Sprintf("%s", suf), "handlerResult", result)
+		_, werr := conn.Write(frame(result, err))
+		tracing.End(span, errors.Join(err, werr))
+		if werr != nil {
+			ilg.Error(fmt.Sprintf("%s.conn.Write()", suf), "error", werr.Error())
+			return
+		}
+		ilg.Debug(fmt.Sprintf("%s", suf), "respondedToClient", "done")
 
-	for c.currConn == c.maxConn {
-		c.cond.Wait()
+		if errors.Is(err, auth.ErrAuthRequired) || errors.Is(err, auth.ErrAuthFailed) {
+			return
+		}
 	}
-
-	c.currConn++
 }
 
-// decConnCount decrements maxConn and calls waiting Server
-func (c *connMeter) decConnCount() {
-	c.cond.L.Lock()
-	defer c.cond.L.Unlock()
-
-	c.currConn--
-	c.cond.Signal()
+// frame puts the status line before the result and terminates response with an empty line.
+// An error response is the status line only
+func frame(result string, err error) []byte {
+	if err != nil {
+		return []byte(statusErr + " " + strings.ReplaceAll(err.Error(), "\n", " ") + "\n\n")
+	}
+	if result != "" && !strings.HasSuffix(result, "\n") {
+		result += "\n"
+	}
+	return []byte(statusOk + "\n" + result + "\n")
 }
@@ -1,9 +1,22 @@
 package tcp
 
 import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"custom-in-memory-db/internal/server/auth"
+	"custom-in-memory-db/internal/server/network"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"log/slog"
+	"math/big"
+	"net"
+	"strings"
 	"testing"
 	"time"
 )
@@ -16,7 +29,7 @@ const goMax = 100
 var nilLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
 
 func TestServer_NewAndClose(t *testing.T) {
-	srv, err := New(ip, port, timeout*time.Second, goMax, nilLogger)
+	srv, err := New(ip, port, timeout*time.Second, network.NewConnMeter(goMax), nil, nilLogger)
 	assert.NoError(t, err)
 	assert.NotNil(t, srv)
 
@@ -24,10 +37,273 @@ func TestServer_NewAndClose(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestConnMeter_New(t *testing.T) {
-	var cm connMeter
-	cm.new(goMax)
+// selfSigned generates a certificate for 127.0.0.1 on the fly
+func selfSigned(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServer_Listen_TLS(t *testing.T) {
+	cert := selfSigned(t)
+	srv, err := New("127.0.0.1", "0", timeout*time.Second, network.NewConnMeter(goMax), &tls.Config{Certificates: []tls.Certificate{cert}}, nilLogger)
+	assert.NoError(t, err)
+	defer srv.Close()
+
+	go srv.Listen(func(ctx context.Context, r io.Reader, lg *slog.Logger) (string, error) {
+		_, err := bufio.NewReader(r).ReadString('\n')
+		return "OK\n", err
+	})
+
+	pool := x509.NewCertPool()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	pool.AddCert(leaf)
+
+	conn, err := tls.Dial("tcp4", srv.(*Server).listener.Addr().String(), &tls.Config{RootCAs: pool})
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET 1\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, conn.CloseWrite())
+	resp, err := io.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\nOK\n\n", string(resp))
+}
+
+func TestServer_Listen_Persistent(t *testing.T) {
+	srv, err := New("127.0.0.1", "0", timeout*time.Second, network.NewConnMeter(goMax), nil, nilLogger)
+	assert.NoError(t, err)
+	defer srv.Close()
+
+	go srv.Listen(func(ctx context.Context, r io.Reader, lg *slog.Logger) (string, error) {
+		line, err := bufio.NewReader(r).ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		switch strings.TrimSpace(line) {
+		case "AUTH":
+			network.ClientFrom(ctx).User = "admin"
+			return "OK\n", nil
+		case "WHO":
+			return network.ClientFrom(ctx).User, nil
+		case "DUMP":
+			return "", nil
+		case "DUMP ERR":
+			// dump lines are sorted by key, and ERR is a valid one
+			return "ERR 1\nb 2\n", nil
+		default:
+			return "", auth.ErrAuthRequired
+		}
+	})
+
+	conn, err := net.Dial("tcp4", srv.(*Server).listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// commands are pipelined, client state survives between them
+	_, err = conn.Write([]byte("AUTH\nWHO\nDUMP\nDUMP ERR\nBOGUS\nWHO\n"))
+	assert.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	assert.NoError(t, err)
+	// connection is closed after the auth error, so the last WHO is never answered
+	assert.Equal(t, "OK\nOK\n\nOK\nadmin\n\nOK\n\nOK\nERR 1\nb 2\n\nERR authentication required\n\n", string(resp))
+}
+
+// readResponse reads lines of a response up to the empty one ending it
+func readResponse(r *bufio.Reader) (string, error) {
+	var resp strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil || line == "\n" {
+			return resp.String(), err
+		}
+		resp.WriteString(line)
+	}
+}
+
+func TestServer_Listen_IdleConnections(t *testing.T) {
+	cm := network.NewConnMeter(1)
+	srv, err := New("127.0.0.1", "0", timeout*time.Second, cm, nil, nilLogger)
+	assert.NoError(t, err)
+	defer srv.Close()
+
+	release := make(chan struct{})
+	go srv.Listen(func(ctx context.Context, r io.Reader, lg *slog.Logger) (string, error) {
+		line, err := bufio.NewReader(r).ReadString('\n')
+		if line == "SLOW\n" {
+			<-release
+		}
+		return "OK\n", err
+	})
+	addr := srv.(*Server).listener.Addr().String()
+
+	// idle connections do not hold the meter, nor the accept loop
+	for range 3 {
+		idle, err := net.Dial("tcp4", addr)
+		assert.NoError(t, err)
+		defer idle.Close()
+	}
+	conn, err := net.Dial("tcp4", addr)
+	assert.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("GET 1\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(timeout*time.Second/2)))
+	resp, err := readResponse(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\nOK\n", resp)
+	assert.Eventually(t, func() bool { return cm.Count() == 0 }, time.Second, time.Millisecond)
+
+	// a command over the limit waits for the one in flight
+	slow, err := net.Dial("tcp4", addr)
+	assert.NoError(t, err)
+	defer slow.Close()
+	_, err = slow.Write([]byte("SLOW\n"))
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return cm.Count() == 1 }, time.Second, time.Millisecond)
+	_, err = conn.Write([]byte("GET 1\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err = r.ReadString('\n')
+	assert.Error(t, err)
+	close(release)
+	assert.NoError(t, conn.SetReadDeadline(time.Time{}))
+	resp, err = r.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", resp)
+}
+
+func TestServer_SetTimeout(t *testing.T) {
+	srv, err := New("127.0.0.1", "0", timeout*time.Second, network.NewConnMeter(goMax), nil, nilLogger)
+	assert.NoError(t, err)
+	defer srv.Close()
+
+	ts, ok := srv.(network.TimeoutSetter)
+	assert.True(t, ok)
+	ts.SetTimeout(50 * time.Millisecond)
+	assert.Equal(t, 50*time.Millisecond, ts.Timeout())
+
+	go srv.Listen(func(ctx context.Context, r io.Reader, lg *slog.Logger) (string, error) {
+		_, err := bufio.NewReader(r).ReadString('\n')
+		return "OK\n", err
+	})
+
+	conn, err := net.Dial("tcp4", srv.(*Server).listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// idle connection is closed at the new timeout instead of the one the server started with
+	start := time.Now()
+	_, err = io.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), timeout*time.Second)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	srv, err := New("127.0.0.1", "0", timeout*time.Second, network.NewConnMeter(goMax), nil, nilLogger)
+	assert.NoError(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go srv.Listen(func(ctx context.Context, r io.Reader, lg *slog.Logger) (string, error) {
+		line, err := bufio.NewReader(r).ReadString('\n')
+		if strings.TrimSpace(line) == "SLOW" {
+			close(started)
+			<-release
+		}
+		return "OK\n", err
+	})
+
+	addr := srv.(*Server).listener.Addr().String()
+	idle, err := net.Dial("tcp4", addr)
+	assert.NoError(t, err)
+	defer idle.Close()
+	busy, err := net.Dial("tcp4", addr)
+	assert.NoError(t, err)
+	defer busy.Close()
+	_, err = busy.Write([]byte("SLOW\nGET 1\n"))
+	assert.NoError(t, err)
+	<-started
+
+	done := make(chan error)
+	go func() { done <- srv.(network.Shutdowner).Shutdown(context.Background()) }()
+
+	// idle connection is closed at once, no new connections are accepted
+	resp, err := io.ReadAll(idle)
+	assert.NoError(t, err)
+	assert.Equal(t, "", string(resp))
+	assert.Eventually(t, func() bool {
+		_, err := net.Dial("tcp4", addr)
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	// command in flight is answered, the pipelined one is not
+	close(release)
+	resp, err = io.ReadAll(busy)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\nOK\n\n", string(resp))
+	assert.NoError(t, <-done)
+}
+
+func TestServer_Shutdown_Deadline(t *testing.T) {
+	srv, err := New("127.0.0.1", "0", timeout*time.Second, network.NewConnMeter(goMax), nil, nilLogger)
+	assert.NoError(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	go srv.Listen(func(ctx context.Context, r io.Reader, lg *slog.Logger) (string, error) {
+		_, err := bufio.NewReader(r).ReadString('\n')
+		close(started)
+		<-release
+		return "OK\n", err
+	})
+
+	busy, err := net.Dial("tcp4", srv.(*Server).listener.Addr().String())
+	assert.NoError(t, err)
+	defer busy.Close()
+	_, err = busy.Write([]byte("SLOW\n"))
+	assert.NoError(t, err)
+	<-started
+
+	// connection of the command which did not finish in time is closed
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, srv.(network.Shutdowner).Shutdown(ctx), context.DeadlineExceeded)
+	resp, err := io.ReadAll(busy)
+	assert.NoError(t, err)
+	assert.Equal(t, "", string(resp))
+}
 
-	assert.Equal(t, goMax, cm.maxConn)
-	assert.NotEqual(t, nil, cm.cond)
+func TestFrame(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		err    error
+		want   string
+	}{
+		{name: "ok", result: "OK\n", want: "OK\nOK\n\n"},
+		{name: "empty", result: "", want: "OK\n\n"},
+		{name: "no trailing line break", result: "admin", want: "OK\nadmin\n\n"},
+		{name: "dump of key ERR", result: "ERR 1\nb 2\n", want: "OK\nERR 1\nb 2\n\n"},
+		{name: "error", err: auth.ErrAuthRequired, want: "ERR authentication required\n\n"},
+		{name: "multiline error", err: errors.New("a\nb"), want: "ERR a b\n\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, string(frame(test.result, test.err)))
+		})
+	}
 }
@@ -0,0 +1,101 @@
+package tlsconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"custom-in-memory-db/internal/server/cmd"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+var clientAuthMap = map[string]tls.ClientAuthType{
+	"none":     tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"require":  tls.RequireAndVerifyClientCert,
+}
+
+// Loader keeps certificates used by network endpoints.
+// Certificates can be reloaded at runtime without restarting endpoints
+type Loader struct {
+	certFile   string
+	keyFile    string
+	caFile     string
+	clientAuth tls.ClientAuthType
+
+	state atomic.Pointer[state]
+}
+
+type state struct {
+	cert   tls.Certificate
+	caPool *x509.CertPool
+}
+
+// New loads certificates according to conf.
+// Returns nil Loader if TLS is not enabled
+func New(conf cmd.Config) (*Loader, error) {
+	if conf.Network.TlsCert == "" {
+		return nil, nil
+	}
+
+	clientAuth, ok := clientAuthMap[conf.Network.TlsClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("unknown client auth %q", conf.Network.TlsClientAuth)
+	}
+
+	l := Loader{
+		certFile:   conf.Network.TlsCert,
+		keyFile:    conf.Network.TlsKey,
+		caFile:     conf.Network.TlsCA,
+		clientAuth: clientAuth,
+	}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// Reload reads certificate files again. Keeps the previous certificates on error
+func (l *Loader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair failed: %w", err)
+	}
+
+	st := state{cert: cert}
+	if l.caFile != "" {
+		pem, err := os.ReadFile(l.caFile)
+		if err != nil {
+			return fmt.Errorf("read CA failed: %w", err)
+		}
+		st.caPool = x509.NewCertPool()
+		if !st.caPool.AppendCertsFromPEM(pem) {
+			return errors.New("read CA failed: no PEM certificates found")
+		}
+	}
+
+	l.state.Store(&st)
+	return nil
+}
+
+// Config returns tls.Config for server side.
+// Each handshake uses certificates loaded by the latest successful Reload
+func (l *Loader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &l.state.Load().cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			st := l.state.Load()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{st.cert},
+				ClientCAs:    st.caPool,
+				ClientAuth:   l.clientAuth,
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,208 @@
+package tlsconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"custom-in-memory-db/internal/server/cmd"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCA(t *testing.T) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ramdb test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns PEM encoded certificate and key signed by ca
+func (ca testCA) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	pth := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(pth, data, 0600))
+	return pth
+}
+
+// serverConf writes server certificate signed by ca to a temp dir
+func serverConf(t *testing.T, ca testCA, clientAuth string) cmd.Config {
+	dir := t.TempDir()
+	certPem, keyPem := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+
+	conf := cmd.Config{}
+	conf.Network.TlsCert = writeFile(t, dir, "cert.pem", certPem)
+	conf.Network.TlsKey = writeFile(t, dir, "key.pem", keyPem)
+	conf.Network.TlsCA = writeFile(t, dir, "ca.pem", ca.pem)
+	conf.Network.TlsClientAuth = clientAuth
+	return conf
+}
+
+// handshake connects to a server using l and returns the serial of the server certificate
+func handshake(t *testing.T, l *Loader, clientConf *tls.Config) (int64, error) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", l.Config())
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+		// wait for client to read the handshake result
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientConf)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	// TLS 1.3 reports client certificate rejection on the first read
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err = conn.Read(make([]byte, 1)); err != nil && !os.IsTimeout(err) {
+		return 0, err
+	}
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestNew_Disabled(t *testing.T) {
+	l, err := New(cmd.Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, l)
+}
+
+func TestNew_Negative_MissingKey(t *testing.T) {
+	conf := serverConf(t, newCA(t), "none")
+	conf.Network.TlsKey = filepath.Join(t.TempDir(), "missing.pem")
+
+	l, err := New(conf)
+	assert.Error(t, err)
+	assert.Nil(t, l)
+}
+
+func TestNew_Negative_BogusCA(t *testing.T) {
+	conf := serverConf(t, newCA(t), "require")
+	conf.Network.TlsCA = writeFile(t, t.TempDir(), "ca.pem", []byte("qwe"))
+
+	l, err := New(conf)
+	assert.EqualError(t, err, "read CA failed: no PEM certificates found")
+	assert.Nil(t, l)
+}
+
+func TestLoader_Handshake_ServerOnly(t *testing.T) {
+	ca := newCA(t)
+	l, err := New(serverConf(t, ca, "none"))
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	serial, err := handshake(t, l, &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), serial)
+}
+
+func TestLoader_Handshake_MutualTLS(t *testing.T) {
+	ca := newCA(t)
+	l, err := New(serverConf(t, ca, "require"))
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	certPem, keyPem := ca.issue(t, 3, x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(certPem, keyPem)
+	require.NoError(t, err)
+
+	_, err = handshake(t, l, &tls.Config{RootCAs: pool, ServerName: "localhost", Certificates: []tls.Certificate{clientCert}})
+	assert.NoError(t, err)
+
+	_, err = handshake(t, l, &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	assert.Error(t, err)
+}
+
+func TestLoader_Reload(t *testing.T) {
+	ca := newCA(t)
+	conf := serverConf(t, ca, "none")
+	l, err := New(conf)
+	require.NoError(t, err)
+
+	// rotate certificate
+	certPem, keyPem := ca.issue(t, 4, x509.ExtKeyUsageServerAuth)
+	require.NoError(t, os.WriteFile(conf.Network.TlsCert, certPem, 0600))
+	require.NoError(t, os.WriteFile(conf.Network.TlsKey, keyPem, 0600))
+	require.NoError(t, l.Reload())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	serial, err := handshake(t, l, &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), serial)
+}
+
+func TestLoader_Reload_Negative_KeepsCertificate(t *testing.T) {
+	ca := newCA(t)
+	conf := serverConf(t, ca, "none")
+	l, err := New(conf)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(conf.Network.TlsKey, []byte("qwe"), 0600))
+	assert.Error(t, l.Reload())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	serial, err := handshake(t, l, &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), serial)
+}
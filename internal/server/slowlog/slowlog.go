@@ -0,0 +1,166 @@
+// Package slowlog keeps the most recent commands which took longer than a threshold to execute
+package slowlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxArgs is the number of args kept in Entry, the rest are replaced by a counter
+	maxArgs = 4
+	// maxArgLen is the number of bytes kept of each arg
+	maxArgLen = 32
+)
+
+// Entry is a single slow command
+type Entry struct {
+	ID       int64         `json:"id"`
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration_us"`
+	Command  string        `json:"command"`
+	Args     []string      `json:"args"`
+	// ClientAddr is the address of the client
+	ClientAddr string `json:"client_addr"`
+	// ClientID is the connection ID for tcp and the request ID for http
+	ClientID string `json:"client_id"`
+}
+
+// MarshalJSON reports Duration in microseconds
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry
+	return json.Marshal(struct {
+		entry
+		Duration int64 `json:"duration_us"`
+	}{entry: entry(e), Duration: e.Duration.Microseconds()})
+}
+
+// String formats Entry as a single line the way SLOWLOG GET replies
+func (e Entry) String() string {
+	return fmt.Sprintf("id=%d time=%s duration=%s client=%s client_id=%s cmd=%q",
+		e.ID, e.Time.UTC().Format(time.RFC3339Nano), e.Duration, e.ClientAddr, e.ClientID,
+		strings.Join(append([]string{e.Command}, e.Args...), " "))
+}
+
+// Log is a bounded ring buffer of entries. Log is thread-safe
+type Log struct {
+	// threshold is time.Duration, zero disables the Log
+	threshold atomic.Int64
+
+	mtx     sync.Mutex
+	entries []Entry
+	// next is the index the next entry is written to
+	next   int
+	size   int
+	lastID int64
+}
+
+// New returns Log keeping up to maxLen commands which took longer than threshold
+func New(threshold time.Duration, maxLen int) *Log {
+	l := Log{entries: make([]Entry, maxLen)}
+	l.threshold.Store(int64(threshold))
+	return &l
+}
+
+// Threshold returns the current threshold
+func (l *Log) Threshold() time.Duration {
+	return time.Duration(l.threshold.Load())
+}
+
+// SetThreshold changes the threshold. Zero disables the Log
+func (l *Log) SetThreshold(d time.Duration) {
+	l.threshold.Store(int64(d))
+}
+
+// Record adds the command to the Log if elapsed exceeds the threshold.
+// args are truncated, so that large values do not occupy memory
+func (l *Log) Record(command string, args []string, clientAddr, clientID string, start time.Time, elapsed time.Duration) {
+	threshold := l.Threshold()
+	if threshold <= 0 || elapsed < threshold {
+		return
+	}
+	e := Entry{
+		Time:       start,
+		Duration:   elapsed,
+		Command:    command,
+		Args:       truncate(args),
+		ClientAddr: clientAddr,
+		ClientID:   clientID,
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.lastID++
+	e.ID = l.lastID
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	if l.size < len(l.entries) {
+		l.size++
+	}
+}
+
+// Get returns up to n most recent entries, the newest first. n < 0 returns all of them
+func (l *Log) Get(n int) []Entry {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if n < 0 || n > l.size {
+		n = l.size
+	}
+	result := make([]Entry, 0, n)
+	for i := 1; i <= n; i++ {
+		result = append(result, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return result
+}
+
+// Len returns the number of entries in the Log
+func (l *Log) Len() int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.size
+}
+
+// Reset removes all entries. IDs keep growing
+func (l *Log) Reset() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	clear(l.entries)
+	l.next, l.size = 0, 0
+}
+
+func truncate(args []string) []string {
+	result := make([]string, 0, min(len(args), maxArgs+1))
+	for i, arg := range args {
+		if i == maxArgs {
+			result = append(result, fmt.Sprintf("... (%d more args)", len(args)-maxArgs))
+			break
+		}
+		if len(arg) > maxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:maxArgLen], len(arg)-maxArgLen)
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+// Routes adds GET /admin/slowlog. ?n= limits the number of entries, all of them are returned by default
+func Routes(r gin.IRoutes, l *Log) {
+	r.GET("/admin/slowlog", func(c *gin.Context) {
+		n := -1
+		if s := c.Query("n"); s != "" {
+			var err error
+			if n, err = strconv.Atoi(s); err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid n %q, non-negative number expected", s)})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"len": l.Len(), "threshold_us": l.Threshold().Microseconds(), "entries": l.Get(n)})
+	})
+}
@@ -0,0 +1,109 @@
+package slowlog
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLog_Record(t *testing.T) {
+	l := New(10*time.Millisecond, 2)
+	start := time.Now()
+
+	l.Record("GET", []string{"a"}, "127.0.0.1:1", "1", start, time.Millisecond)
+	assert.Equal(t, 0, l.Len())
+
+	l.Record("SET", []string{"a", "1"}, "127.0.0.1:1", "1", start, 10*time.Millisecond)
+	l.Record("DEL", []string{"a"}, "127.0.0.1:2", "2", start, time.Second)
+	l.Record("DUMP", nil, "127.0.0.1:3", "3", start, time.Second)
+	assert.Equal(t, 2, l.Len())
+
+	entries := l.Get(-1)
+	require.Len(t, entries, 2)
+	// the oldest entry is overwritten, the newest goes first
+	assert.Equal(t, int64(3), entries[0].ID)
+	assert.Equal(t, "DUMP", entries[0].Command)
+	assert.Equal(t, "DEL", entries[1].Command)
+	assert.Equal(t, []string{"a"}, entries[1].Args)
+	assert.Equal(t, "127.0.0.1:2", entries[1].ClientAddr)
+
+	assert.Len(t, l.Get(1), 1)
+	assert.Len(t, l.Get(10), 2)
+
+	l.Reset()
+	assert.Equal(t, 0, l.Len())
+	assert.Empty(t, l.Get(-1))
+	l.Record("GET", []string{"a"}, "", "", start, time.Second)
+	assert.Equal(t, int64(4), l.Get(1)[0].ID)
+}
+
+func TestLog_SetThreshold(t *testing.T) {
+	l := New(time.Second, 8)
+	l.Record("GET", []string{"a"}, "", "", time.Now(), 10*time.Millisecond)
+	assert.Equal(t, 0, l.Len())
+
+	l.SetThreshold(5 * time.Millisecond)
+	assert.Equal(t, 5*time.Millisecond, l.Threshold())
+	l.Record("GET", []string{"a"}, "", "", time.Now(), 10*time.Millisecond)
+	assert.Equal(t, 1, l.Len())
+
+	// zero disables the log
+	l.SetThreshold(0)
+	l.Record("GET", []string{"a"}, "", "", time.Now(), time.Hour)
+	assert.Equal(t, 1, l.Len())
+}
+
+func TestTruncate(t *testing.T) {
+	long := strings.Repeat("v", maxArgLen+8)
+	args := truncate([]string{"u", long, "3", "4", "5", "6"})
+
+	assert.Equal(t, []string{"u", strings.Repeat("v", maxArgLen) + "... (8 more bytes)", "3", "4", "... (2 more args)"}, args)
+	assert.Equal(t, []string{}, truncate(nil))
+}
+
+func TestEntry_String(t *testing.T) {
+	e := Entry{
+		ID:         7,
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Duration:   1500 * time.Millisecond,
+		Command:    "SET",
+		Args:       []string{"a", "1"},
+		ClientAddr: "127.0.0.1:5000",
+		ClientID:   "42",
+	}
+	assert.Equal(t, `id=7 time=2024-01-02T03:04:05Z duration=1.5s client=127.0.0.1:5000 client_id=42 cmd="SET a 1"`, e.String())
+
+	b, err := json.Marshal(e)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"duration_us":1500000`)
+}
+
+func TestRoutes(t *testing.T) {
+	l := New(time.Millisecond, 8)
+	l.Record("GET", []string{"a"}, "", "", time.Now(), time.Second)
+	l.Record("GET", []string{"b"}, "", "", time.Now(), time.Second)
+	r := gin.New()
+	Routes(r, l)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/slowlog?n=1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Len     int     `json:"len"`
+		Entries []Entry `json:"entries"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, 2, body.Len)
+	require.Len(t, body.Entries, 1)
+	assert.Equal(t, []string{"b"}, body.Entries[0].Args)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/slowlog?n=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
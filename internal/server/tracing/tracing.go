@@ -0,0 +1,113 @@
+// Package tracing sets up OpenTelemetry and starts spans of the request path.
+// Until Init is called spans are no-op, so packages instrument their code without checking whether tracing is enabled
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"os"
+)
+
+// instrumentation is the name of the tracer
+const instrumentation = "custom-in-memory-db"
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOtlp   = "otlp"
+)
+
+type Config struct {
+	// Exporter is one of ExporterNone, ExporterStdout, ExporterFile or ExporterOtlp
+	Exporter string
+	// File spans are appended to by ExporterFile
+	File string
+	// OtlpEndpoint is host:port of OTLP/HTTP collector
+	OtlpEndpoint string
+	// OtlpInsecure disables TLS of OtlpEndpoint
+	OtlpInsecure bool
+	// SampleRatio of traces started by the server. Traces started by clients follow their sampling decision
+	SampleRatio float64
+	// Version of the server reported as service.version
+	Version string
+}
+
+// Init sets the global tracer provider and W3C trace context propagator.
+// The returned func flushes spans and must be called on shutdown
+func Init(ctx context.Context, conf Config) (func(context.Context) error, error) {
+	const suf = "tracing.Init()"
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var exp sdktrace.SpanExporter
+	var closer io.Closer
+	var err error
+	switch conf.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		var file *os.File
+		file, err = os.OpenFile(conf.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("%s failed: %w", suf, err)
+		}
+		closer = file
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	case ExporterOtlp:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(conf.OtlpEndpoint)}
+		if conf.OtlpInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exp, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("%s failed: unknown exporter %q", suf, conf.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", suf, err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "ramdb"),
+			attribute.String("service.version", conf.Version),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+// Start starts a span named name as a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err, if any, and ends span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
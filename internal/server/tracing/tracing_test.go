@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInit_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Init(context.Background(), Config{Exporter: ExporterFile, File: path, SampleRatio: 1, Version: "test"})
+	require.NoError(t, err)
+
+	_, span := Start(context.Background(), "test.span")
+	End(span, nil)
+	require.NoError(t, shutdown(context.Background()))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"Name":"test.span"`)
+	assert.Contains(t, string(b), `"Value":"test"`)
+}
+
+func TestInit_Negative(t *testing.T) {
+	_, err := Init(context.Background(), Config{Exporter: "jaeger"})
+	assert.EqualError(t, err, `tracing.Init() failed: unknown exporter "jaeger"`)
+
+	_, err = Init(context.Background(), Config{Exporter: ExporterFile, File: t.TempDir()})
+	assert.Error(t, err)
+}
+
+func TestEnd(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	// the remote parent comes from traceparent header
+	carrier := propagation.MapCarrier{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), carrier)
+
+	ctx, parent := Start(ctx, "parent")
+	_, child := Start(ctx, "child")
+	End(child, errors.New("test error"))
+	End(parent, nil)
+
+	spans := exp.GetSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, "test error", spans[0].Status.Description)
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[1].SpanContext.TraceID().String())
+	assert.Equal(t, codes.Unset, spans[1].Status.Code)
+}